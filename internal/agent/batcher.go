@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync/atomic"
+
+	"github.com/am0xff/metrics/internal/models"
+)
+
+// SendFunc отправляет пакет метрик на сервер.
+type SendFunc func(ctx context.Context, metrics []models.Metrics) error
+
+// ErrSpooled возвращается SendFunc, если пакет не отправлен сразу,
+// а сохранен в спул для последующей отправки.
+var ErrSpooled = errors.New("batch saved to spool")
+
+// BatchStats - счетчики отправленных и неотправленных пакетов.
+// Безопасен для одновременного использования из нескольких горутин.
+type BatchStats struct {
+	SentBatches    atomic.Int64 // успешно отправленные пакеты
+	SentMetrics    atomic.Int64 // метрики в успешно отправленных пакетах
+	FailedBatches  atomic.Int64 // пакеты, которые не удалось отправить
+	FailedMetrics  atomic.Int64 // метрики в пакетах, которые не удалось отправить
+	SpooledBatches atomic.Int64 // пакеты, сохраненные в спул
+	SpooledMetrics atomic.Int64 // метрики в пакетах, сохраненных в спул
+}
+
+// Batcher накапливает метрики и отправляет их пакетами.
+// Пакет отправляется, когда количество метрик достигает maxCount или
+// размер пакета в JSON превысил бы maxBytes, а также при вызове Flush.
+// Нулевое или отрицательное ограничение не применяется.
+//
+// Batcher не безопасен для одновременного использования:
+// каждый обработчик очереди использует собственный экземпляр.
+//
+// Пример использования:
+//
+//	b := NewBatcher(100, 1<<20, reporter.SendBatch, &stats)
+//	b.Add(ctx, m)
+//	b.Flush(ctx)
+type Batcher struct {
+	maxCount int
+	maxBytes int
+	send     SendFunc
+	stats    *BatchStats
+
+	metrics []models.Metrics
+	size    int
+}
+
+// NewBatcher создает Batcher. Если stats равен nil, учет пакетов не ведется.
+func NewBatcher(maxCount, maxBytes int, send SendFunc, stats *BatchStats) *Batcher {
+	if stats == nil {
+		stats = &BatchStats{}
+	}
+	return &Batcher{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		send:     send,
+		stats:    stats,
+	}
+}
+
+// Add добавляет метрику в текущий пакет. Если метрика не помещается
+// в пакет по размеру, текущий пакет предварительно отправляется.
+// Если после добавления пакет заполнен по количеству, он отправляется.
+func (b *Batcher) Add(ctx context.Context, m models.Metrics) {
+	n := metricSize(m)
+	// +1 - открывающая скобка массива, закрывающая учтена в размере последней метрики
+	if b.maxBytes > 0 && len(b.metrics) > 0 && b.size+n+1 > b.maxBytes {
+		b.Flush(ctx)
+	}
+
+	b.metrics = append(b.metrics, m)
+	b.size += n
+
+	if b.maxCount > 0 && len(b.metrics) >= b.maxCount {
+		b.Flush(ctx)
+	}
+}
+
+// Len возвращает количество метрик в текущем пакете.
+func (b *Batcher) Len() int {
+	return len(b.metrics)
+}
+
+// Flush отправляет текущий пакет, если он не пуст, и учитывает результат
+// отправки в статистике. Пакет очищается независимо от результата.
+func (b *Batcher) Flush(ctx context.Context) {
+	if len(b.metrics) == 0 {
+		return
+	}
+
+	metrics := b.metrics
+	b.metrics = nil
+	b.size = 0
+
+	err := b.send(ctx, metrics)
+	if errors.Is(err, ErrSpooled) {
+		b.stats.SpooledBatches.Add(1)
+		b.stats.SpooledMetrics.Add(int64(len(metrics)))
+		return
+	}
+	if err != nil {
+		b.stats.FailedBatches.Add(1)
+		b.stats.FailedMetrics.Add(int64(len(metrics)))
+		log.Printf("send batch of %d metrics: %v", len(metrics), err)
+		return
+	}
+
+	b.stats.SentBatches.Add(1)
+	b.stats.SentMetrics.Add(int64(len(metrics)))
+}
+
+// metricSize возвращает размер метрики в JSON массиве пакета вместе
+// со следующим за ней разделителем.
+func metricSize(m models.Metrics) int {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return 0
+	}
+	return len(data) + 1
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeMetric(name string, v float64) models.Metrics {
+	return models.Metrics{ID: name, MType: storage.MetricTypeGauge, Value: &v}
+}
+
+func TestBatcher_MaxCount(t *testing.T) {
+	var batches [][]models.Metrics
+	send := func(_ context.Context, metrics []models.Metrics) error {
+		batches = append(batches, metrics)
+		return nil
+	}
+
+	var stats BatchStats
+	b := NewBatcher(3, 0, send, &stats)
+	for i := 0; i < 7; i++ {
+		b.Add(context.Background(), gaugeMetric(fmt.Sprintf("m%d", i), float64(i)))
+	}
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 3)
+	assert.Len(t, batches[1], 3)
+	assert.Equal(t, 1, b.Len())
+
+	b.Flush(context.Background())
+	require.Len(t, batches, 3)
+	assert.Equal(t, "m6", batches[2][0].ID)
+	assert.Equal(t, 0, b.Len())
+
+	assert.Equal(t, int64(3), stats.SentBatches.Load())
+	assert.Equal(t, int64(7), stats.SentMetrics.Load())
+	assert.Equal(t, int64(0), stats.FailedBatches.Load())
+}
+
+func TestBatcher_MaxBytes(t *testing.T) {
+	m := gaugeMetric("metric", 1)
+	data, err := json.Marshal([]models.Metrics{m, m})
+	require.NoError(t, err)
+
+	var batches [][]models.Metrics
+	send := func(_ context.Context, metrics []models.Metrics) error {
+		encoded, err := json.Marshal(metrics)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(encoded), len(data))
+
+		batches = append(batches, metrics)
+		return nil
+	}
+
+	// В пакет помещаются ровно две метрики
+	b := NewBatcher(0, len(data), send, nil)
+	for i := 0; i < 5; i++ {
+		b.Add(context.Background(), m)
+	}
+	b.Flush(context.Background())
+
+	require.Len(t, batches, 3)
+	assert.Len(t, batches[0], 2)
+	assert.Len(t, batches[1], 2)
+	assert.Len(t, batches[2], 1)
+}
+
+func TestBatcher_OversizedMetric(t *testing.T) {
+	var batches [][]models.Metrics
+	send := func(_ context.Context, metrics []models.Metrics) error {
+		batches = append(batches, metrics)
+		return nil
+	}
+
+	b := NewBatcher(0, 10, send, nil)
+	b.Add(context.Background(), gaugeMetric("long_metric_name", 1))
+	b.Add(context.Background(), gaugeMetric("another_long_metric_name", 2))
+	b.Flush(context.Background())
+
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], 1)
+	assert.Len(t, batches[1], 1)
+}
+
+func TestBatcher_Failure(t *testing.T) {
+	send := func(_ context.Context, metrics []models.Metrics) error {
+		return errors.New("connection refused")
+	}
+
+	var stats BatchStats
+	b := NewBatcher(2, 0, send, &stats)
+	for i := 0; i < 3; i++ {
+		b.Add(context.Background(), gaugeMetric("m", 1))
+	}
+	b.Flush(context.Background())
+
+	assert.Equal(t, int64(0), stats.SentBatches.Load())
+	assert.Equal(t, int64(2), stats.FailedBatches.Load())
+	assert.Equal(t, int64(3), stats.FailedMetrics.Load())
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestBatcher_FlushEmpty(t *testing.T) {
+	called := false
+	send := func(_ context.Context, metrics []models.Metrics) error {
+		called = true
+		return nil
+	}
+
+	b := NewBatcher(10, 0, send, nil)
+	b.Flush(context.Background())
+	assert.False(t, called)
+}
@@ -3,7 +3,10 @@ package agent
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -17,6 +20,14 @@ type Config struct {
 	RateLimit      int    `env:"RATE_LIMIT" envDefault:"1"`
 	CryptoKey      string `env:"CRYPTO_KEY" envDefault:""`
 	ConfigFile     string `env:"CONFIG" envDefault:""`
+	Labels         string `env:"LABELS" envDefault:""`
+	BatchSize      int    `env:"BATCH_SIZE" envDefault:"100"`
+	BatchBytes     int    `env:"BATCH_BYTES" envDefault:"1048576"`
+	FlushInterval  int    `env:"FLUSH_INTERVAL" envDefault:"1"`
+	SpoolDir       string `env:"SPOOL_DIR" envDefault:""`
+	SpoolMaxBytes  int64  `env:"SPOOL_MAX_BYTES" envDefault:"67108864"`
+	RetryMaxTime   int    `env:"RETRY_MAX_ELAPSED" envDefault:"30"`
+	Transport      string `env:"TRANSPORT" envDefault:"http"`
 }
 
 func LoadConfig() (Config, error) {
@@ -34,6 +45,14 @@ func LoadConfig() (Config, error) {
 	fRateLimit := flag.Int("l", cfg.RateLimit, "Количество одновременно исходящих запросов на сервер")
 	fCryptoKey := flag.String("crypto-key", cfg.CryptoKey, "Путь к файлу с публичным ключом для шифрования")
 	fConfigFile := flag.String("c", cfg.ConfigFile, "Путь к файлу конфигурации")
+	fLabels := flag.String("labels", cfg.Labels, "Метки метрик в формате host=web-1,dc=eu")
+	fBatchSize := flag.Int("batch-size", cfg.BatchSize, "Максимальное количество метрик в одном пакете")
+	fBatchBytes := flag.Int("batch-bytes", cfg.BatchBytes, "Максимальный размер пакета метрик в байтах")
+	fFlushInterval := flag.Int("flush-interval", cfg.FlushInterval, "Интервал отправки неполных пакетов метрик (сек)")
+	fSpoolDir := flag.String("spool-dir", cfg.SpoolDir, "Каталог для недоставленных пакетов метрик (пусто - не сохранять)")
+	fTransport := flag.String("transport", cfg.Transport, "Транспорт отправки метрик: http или grpc (адрес -a указывает на gRPC сервер)")
+	fRetryMaxTime := flag.Int("retry-max-elapsed", cfg.RetryMaxTime, "Максимальное время повторных попыток отправки пакета (сек), 0 - без повторов")
+	fSpoolMaxBytes := flag.Int64("spool-max-bytes", cfg.SpoolMaxBytes, "Максимальный размер каталога недоставленных пакетов в байтах")
 	flag.Parse()
 
 	cfg.ServerAddr = *fAddr
@@ -43,24 +62,74 @@ func LoadConfig() (Config, error) {
 	cfg.RateLimit = *fRateLimit
 	cfg.CryptoKey = *fCryptoKey
 	cfg.ConfigFile = *fConfigFile
-
-	if *fConfigFile != "" && *fConfigFile != cfg.ConfigFile {
+	cfg.Labels = *fLabels
+	cfg.BatchSize = *fBatchSize
+	cfg.BatchBytes = *fBatchBytes
+	cfg.FlushInterval = *fFlushInterval
+	cfg.SpoolDir = *fSpoolDir
+	cfg.SpoolMaxBytes = *fSpoolMaxBytes
+	cfg.RetryMaxTime = *fRetryMaxTime
+	cfg.Transport = *fTransport
+
+	// Значения из файла конфигурации применяются только к параметрам,
+	// которые не заданы переменными окружения или флагами.
+	if cfg.ConfigFile != "" {
 		tempCfg := cfg
-		if err := loadFromJSON(*fConfigFile, &tempCfg); err != nil {
+		if err := loadFromJSON(cfg.ConfigFile, &tempCfg); err != nil {
 			return cfg, err
 		}
 
-		tempCfg.ServerAddr = *fAddr
-		tempCfg.PollInterval = *fPoll
-		tempCfg.ReportInterval = *fReport
-		tempCfg.Key = *fKey
-		tempCfg.RateLimit = *fRateLimit
-		tempCfg.CryptoKey = *fCryptoKey
-		tempCfg.ConfigFile = *fConfigFile
+		isSet := explicitlySet()
+		if isSet("a", "ADDRESS") {
+			tempCfg.ServerAddr = cfg.ServerAddr
+		}
+		if isSet("p", "POLL_INTERVAL") {
+			tempCfg.PollInterval = cfg.PollInterval
+		}
+		if isSet("r", "REPORT_INTERVAL") {
+			tempCfg.ReportInterval = cfg.ReportInterval
+		}
+		if isSet("crypto-key", "CRYPTO_KEY") {
+			tempCfg.CryptoKey = cfg.CryptoKey
+		}
+		if isSet("labels", "LABELS") {
+			tempCfg.Labels = cfg.Labels
+		}
+		if isSet("batch-size", "BATCH_SIZE") {
+			tempCfg.BatchSize = cfg.BatchSize
+		}
+		if isSet("batch-bytes", "BATCH_BYTES") {
+			tempCfg.BatchBytes = cfg.BatchBytes
+		}
+		if isSet("flush-interval", "FLUSH_INTERVAL") {
+			tempCfg.FlushInterval = cfg.FlushInterval
+		}
+		if isSet("spool-dir", "SPOOL_DIR") {
+			tempCfg.SpoolDir = cfg.SpoolDir
+		}
+		if isSet("spool-max-bytes", "SPOOL_MAX_BYTES") {
+			tempCfg.SpoolMaxBytes = cfg.SpoolMaxBytes
+		}
+		if isSet("retry-max-elapsed", "RETRY_MAX_ELAPSED") {
+			tempCfg.RetryMaxTime = cfg.RetryMaxTime
+		}
+		if isSet("transport", "TRANSPORT") {
+			tempCfg.Transport = cfg.Transport
+		}
 
 		cfg = tempCfg
 	}
 
+	if _, err := ParseLabels(cfg.Labels); err != nil {
+		return cfg, err
+	}
+	if cfg.Transport != TransportHTTP && cfg.Transport != TransportGRPC {
+		return cfg, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+	if cfg.FlushInterval <= 0 {
+		return cfg, fmt.Errorf("invalid flush interval %d", cfg.FlushInterval)
+	}
+
 	return cfg, nil
 }
 
@@ -75,10 +144,18 @@ func loadFromJSON(configPath string, cfg *Config) error {
 	}
 
 	var jsonConfig struct {
-		Address        string `json:"address"`
-		ReportInterval string `json:"report_interval"`
-		PollInterval   string `json:"poll_interval"`
-		CryptoKey      string `json:"crypto_key"`
+		Address        string            `json:"address"`
+		ReportInterval string            `json:"report_interval"`
+		PollInterval   string            `json:"poll_interval"`
+		CryptoKey      string            `json:"crypto_key"`
+		Labels         map[string]string `json:"labels"`
+		BatchSize      int               `json:"batch_size"`
+		BatchBytes     int               `json:"batch_bytes"`
+		FlushInterval  string            `json:"flush_interval"`
+		SpoolDir       string            `json:"spool_dir"`
+		SpoolMaxBytes  int64             `json:"spool_max_bytes"`
+		RetryMaxTime   string            `json:"retry_max_elapsed"`
+		Transport      string            `json:"transport"`
 	}
 
 	if err := json.Unmarshal(data, &jsonConfig); err != nil {
@@ -91,6 +168,39 @@ func loadFromJSON(configPath string, cfg *Config) error {
 	if jsonConfig.CryptoKey != "" {
 		cfg.CryptoKey = jsonConfig.CryptoKey
 	}
+	if len(jsonConfig.Labels) > 0 {
+		pairs := make([]string, 0, len(jsonConfig.Labels))
+		for k, v := range jsonConfig.Labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		cfg.Labels = strings.Join(pairs, ",")
+	}
+	if jsonConfig.BatchSize > 0 {
+		cfg.BatchSize = jsonConfig.BatchSize
+	}
+	if jsonConfig.BatchBytes > 0 {
+		cfg.BatchBytes = jsonConfig.BatchBytes
+	}
+	if jsonConfig.FlushInterval != "" {
+		if duration, err := time.ParseDuration(jsonConfig.FlushInterval); err == nil {
+			cfg.FlushInterval = int(duration.Seconds())
+		}
+	}
+	if jsonConfig.SpoolDir != "" {
+		cfg.SpoolDir = jsonConfig.SpoolDir
+	}
+	if jsonConfig.SpoolMaxBytes > 0 {
+		cfg.SpoolMaxBytes = jsonConfig.SpoolMaxBytes
+	}
+	if jsonConfig.Transport != "" {
+		cfg.Transport = jsonConfig.Transport
+	}
+	if jsonConfig.RetryMaxTime != "" {
+		if duration, err := time.ParseDuration(jsonConfig.RetryMaxTime); err == nil {
+			cfg.RetryMaxTime = int(duration.Seconds())
+		}
+	}
 	if jsonConfig.ReportInterval != "" {
 		if duration, err := time.ParseDuration(jsonConfig.ReportInterval); err == nil {
 			cfg.ReportInterval = int(duration.Seconds())
@@ -104,3 +214,39 @@ func loadFromJSON(configPath string, cfg *Config) error {
 
 	return nil
 }
+
+// explicitlySet возвращает функцию, которая сообщает, задан ли параметр
+// флагом flagName или переменной окружения envName.
+func explicitlySet() func(flagName, envName string) bool {
+	flags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+
+	return func(flagName, envName string) bool {
+		if flags[flagName] {
+			return true
+		}
+		_, ok := os.LookupEnv(envName)
+		return ok
+	}
+}
+
+// ParseLabels разбирает метки в формате "host=web-1,dc=eu".
+// Для пустой строки возвращает nil.
+func ParseLabels(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+		labels[k] = strings.TrimSpace(v)
+	}
+	return labels, nil
+}
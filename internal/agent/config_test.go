@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("")
+	require.NoError(t, err)
+	assert.Nil(t, labels)
+
+	labels, err = ParseLabels("host=web-1, dc=eu,empty=")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"host": "web-1", "dc": "eu", "empty": ""}, labels)
+
+	_, err = ParseLabels("host")
+	assert.Error(t, err)
+
+	_, err = ParseLabels("=web-1")
+	assert.Error(t, err)
+}
+
+// loadConfig вызывает LoadConfig с аргументами командной строки args
+// и отдельным набором флагов.
+func loadConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = oldArgs, oldFlags
+	})
+	os.Args = append([]string{"agent"}, args...)
+	flag.CommandLine = flag.NewFlagSet("agent", flag.ContinueOnError)
+	return LoadConfig()
+}
+
+// unsetenv удаляет переменную окружения на время теста.
+func unsetenv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+		require.NoError(t, os.Unsetenv(key))
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	unsetenv(t, "ADDRESS", "CONFIG", "LABELS", "BATCH_SIZE", "BATCH_BYTES", "FLUSH_INTERVAL",
+		"SPOOL_DIR", "SPOOL_MAX_BYTES", "RETRY_MAX_ELAPSED", "TRANSPORT", "REPORT_INTERVAL")
+	path := filepath.Join(t.TempDir(), "agent.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"address": "json:1",
+		"report_interval": "30s",
+		"labels": {"host": "web-1", "dc": "eu"},
+		"batch_size": 50,
+		"batch_bytes": 4096,
+		"flush_interval": "5s",
+		"spool_dir": "/tmp/spool",
+		"spool_max_bytes": 1024,
+		"retry_max_elapsed": "1m",
+		"transport": "grpc"
+	}`), 0600))
+
+	// Параметры, заданные только в файле конфигурации
+	cfg, err := loadConfig(t, "-c", path)
+	require.NoError(t, err)
+	assert.Equal(t, path, cfg.ConfigFile)
+	assert.Equal(t, "json:1", cfg.ServerAddr)
+	assert.Equal(t, 30, cfg.ReportInterval)
+	assert.Equal(t, "dc=eu,host=web-1", cfg.Labels)
+	assert.Equal(t, 50, cfg.BatchSize)
+	assert.Equal(t, 4096, cfg.BatchBytes)
+	assert.Equal(t, 5, cfg.FlushInterval)
+	assert.Equal(t, "/tmp/spool", cfg.SpoolDir)
+	assert.Equal(t, int64(1024), cfg.SpoolMaxBytes)
+	assert.Equal(t, 60, cfg.RetryMaxTime)
+	assert.Equal(t, TransportGRPC, cfg.Transport)
+
+	// Переменные окружения перекрывают файл
+	t.Setenv("ADDRESS", "env:2")
+	t.Setenv("BATCH_SIZE", "20")
+	cfg, err = loadConfig(t, "-c", path)
+	require.NoError(t, err)
+	assert.Equal(t, "env:2", cfg.ServerAddr)
+	assert.Equal(t, 20, cfg.BatchSize)
+	assert.Equal(t, 4096, cfg.BatchBytes)
+
+	// Флаги перекрывают переменные окружения и файл
+	cfg, err = loadConfig(t, "-c", path, "-a", "flag:3", "-transport", "http")
+	require.NoError(t, err)
+	assert.Equal(t, "flag:3", cfg.ServerAddr)
+	assert.Equal(t, TransportHTTP, cfg.Transport)
+	assert.Equal(t, "/tmp/spool", cfg.SpoolDir)
+
+	// Путь к файлу из переменной окружения
+	t.Setenv("CONFIG", path)
+	cfg, err = loadConfig(t)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/spool", cfg.SpoolDir)
+}
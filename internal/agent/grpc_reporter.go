@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/pb"
+	"github.com/am0xff/metrics/internal/rpc"
+	"github.com/am0xff/metrics/internal/utils"
+)
+
+// Transport отправляет пакеты метрик на сервер.
+type Transport interface {
+	SendBatch(ctx context.Context, metrics []models.Metrics) error
+}
+
+const (
+	// TransportHTTP - отправка метрик JSON запросами на /updates/.
+	TransportHTTP = "http"
+	// TransportGRPC - отправка метрик вызовом UpdateMetrics gRPC сервиса.
+	TransportGRPC = "grpc"
+)
+
+// GRPCReporter отправляет пакеты метрик на gRPC сервер.
+// Ключ подписи и публичный ключ применяются клиентскими перехватчиками,
+// адрес агента передается в метаданных x-real-ip.
+type GRPCReporter struct {
+	cfg    *ReporterConfig
+	conn   *grpc.ClientConn
+	client pb.MetricsClient
+	realIP realIP
+}
+
+// NewGRPCReporter создает GRPCReporter для сервера cfg.ServerAddr.
+// Соединение устанавливается при первой отправке.
+func NewGRPCReporter(cfg *ReporterConfig) (*GRPCReporter, error) {
+	var (
+		unary  []grpc.UnaryClientInterceptor
+		stream []grpc.StreamClientInterceptor
+	)
+
+	// Сначала шифруем метрики, затем подписываем сообщение
+	if cfg.CryptoKey != "" {
+		publicKey, err := utils.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load public key: %w", err)
+		}
+		unary = append(unary, rpc.EncryptUnaryClientInterceptor(publicKey))
+		stream = append(stream, rpc.EncryptStreamClientInterceptor(publicKey))
+	}
+	if cfg.Key != "" {
+		unary = append(unary, rpc.SignUnaryClientInterceptor(cfg.Key))
+		stream = append(stream, rpc.SignStreamClientInterceptor(cfg.Key))
+	}
+
+	conn, err := grpc.NewClient(cfg.ServerAddr,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GRPCReporter{
+		cfg:    cfg,
+		conn:   conn,
+		client: pb.NewMetricsClient(conn),
+	}, nil
+}
+
+// SendBatch отправляет метрики одним вызовом UpdateMetrics.
+// Пустой пакет не отправляется. Метрикам без меток назначаются метки из конфигурации.
+// Неудачные попытки повторяются согласно политике повторов.
+func (r *GRPCReporter) SendBatch(ctx context.Context, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	return r.cfg.Retry.Do(ctx, func() error {
+		// Перехватчики изменяют сообщение, поэтому для каждой попытки оно создается заново
+		req := &pb.UpdateMetricsRequest{Metrics: rpc.MetricsToProto(metrics)}
+		for _, m := range req.Metrics {
+			if len(m.Labels) == 0 {
+				m.Labels = r.cfg.Labels
+			}
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, defaultRequestTimeout)
+		defer cancel()
+		if ip := r.realIP.get(r.cfg.ServerAddr); ip != "" {
+			attemptCtx = metadata.AppendToOutgoingContext(attemptCtx, rpc.RealIPKey, ip)
+		}
+
+		_, err := r.client.UpdateMetrics(attemptCtx, req)
+		return err
+	})
+}
+
+// Close закрывает соединение с сервером.
+func (r *GRPCReporter) Close() error {
+	return r.conn.Close()
+}
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/am0xff/metrics/internal/rpc"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+)
+
+func TestGRPCReporter_SendBatch(t *testing.T) {
+	ms := memstorage.NewStorage()
+	srv, err := rpc.NewGRPCServer(ms, "secret", "", nil)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	reporter, err := NewGRPCReporter(&ReporterConfig{
+		ServerAddr: lis.Addr().String(),
+		Key:        "secret",
+		Labels:     map[string]string{"host": "web-1"},
+	})
+	require.NoError(t, err)
+	defer reporter.Close()
+
+	ctx := context.Background()
+	require.NoError(t, reporter.SendBatch(ctx, spoolBatch(1)))
+	assert.NoError(t, reporter.SendBatch(ctx, nil))
+
+	v, err := ms.GetGauge(ctx, storage.SeriesKey("m1", map[string]string{"host": "web-1"}))
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1), v)
+}
+
+func TestGRPCReporter_Unavailable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := lis.Addr().String()
+	require.NoError(t, lis.Close())
+
+	reporter, err := NewGRPCReporter(&ReporterConfig{ServerAddr: addr})
+	require.NoError(t, err)
+	defer reporter.Close()
+
+	err = reporter.SendBatch(context.Background(), spoolBatch(1))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.True(t, IsRetryable(context.Background(), err))
+}
+
+func TestNewAgent_Transport(t *testing.T) {
+	agent, err := NewAgent(Config{ServerAddr: "localhost:3200", Transport: TransportGRPC, RateLimit: 1})
+	require.NoError(t, err)
+	assert.IsType(t, &GRPCReporter{}, agent.reporter)
+
+	agent, err = NewAgent(Config{ServerAddr: "localhost:8080", Transport: TransportHTTP, RateLimit: 1})
+	require.NoError(t, err)
+	assert.IsType(t, &Reporter{}, agent.reporter)
+
+	_, err = NewAgent(Config{Transport: "udp"})
+	assert.Error(t, err)
+}
+
+func TestGRPCReporter_TrustedSubnet(t *testing.T) {
+	for _, tc := range []struct {
+		subnet string
+		code   codes.Code
+	}{
+		// Тестовый сервер слушает loopback, поэтому адрес агента - 127.0.0.1
+		{"127.0.0.0/8", codes.OK},
+		{"10.0.0.0/8", codes.PermissionDenied},
+	} {
+		t.Run(tc.subnet, func(t *testing.T) {
+			_, subnet, err := net.ParseCIDR(tc.subnet)
+			require.NoError(t, err)
+			srv, err := rpc.NewGRPCServer(memstorage.NewStorage(), "", "", subnet)
+			require.NoError(t, err)
+
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			go srv.Serve(lis)
+			defer srv.Stop()
+
+			reporter, err := NewGRPCReporter(&ReporterConfig{ServerAddr: lis.Addr().String()})
+			require.NoError(t, err)
+			defer reporter.Close()
+
+			err = reporter.SendBatch(context.Background(), spoolBatch(1))
+			assert.Equal(t, tc.code, status.Code(err))
+		})
+	}
+}
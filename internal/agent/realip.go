@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"net"
+	"sync"
+)
+
+// realIP - адрес исходящего интерфейса агента для заголовка X-Real-IP
+// и метаданных x-real-ip. Адрес определяется один раз при первом запросе.
+type realIP struct {
+	once sync.Once
+	addr string
+}
+
+// get возвращает адрес исходящего интерфейса для сервера serverAddr.
+func (ip *realIP) get(serverAddr string) string {
+	ip.once.Do(func() {
+		ip.addr = outboundIP(serverAddr)
+	})
+	return ip.addr
+}
+
+// outboundIP возвращает адрес сетевого интерфейса, через который агент
+// обращается к серверу serverAddr. Для UDP "соединения" пакеты не отправляются,
+// система только выбирает маршрут и локальный адрес. Если определить адрес
+// не удалось, возвращается первый адрес не loopback интерфейса или пустая строка.
+func outboundIP(serverAddr string) string {
+	if conn, err := net.Dial("udp", serverAddr); err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && !addr.IP.IsUnspecified() {
+			return addr.IP.String()
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ""
+	}
+	for _, a := range addrs {
+		if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
+			return ipNet.IP.String()
+		}
+	}
+	return ""
+}
@@ -3,257 +3,144 @@ package agent
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/am0xff/metrics/internal/models"
-	"github.com/am0xff/metrics/internal/storage"
 	"github.com/am0xff/metrics/internal/utils"
 )
 
+// defaultRequestTimeout - таймаут одного запроса к серверу.
+const defaultRequestTimeout = 10 * time.Second
+
 type ReporterConfig struct {
 	ServerAddr string
 	Key        string
 	CryptoKey  string
+	Labels     map[string]string
+	Retry      RetryPolicy // политика повторов; нулевое значение - без повторов
 }
 
 type Reporter struct {
 	client *http.Client
 	cfg    *ReporterConfig
+
+	realIP realIP // адрес для заголовка X-Real-IP
 }
 
 func NewReporter(cfg *ReporterConfig) *Reporter {
 	return &Reporter{
 		cfg:    cfg,
-		client: http.DefaultClient,
-	}
-}
-
-func (r *Reporter) Report(gauges map[string]float64, counters map[string]int64) {
-	for name, v := range gauges {
-		r.send(storage.MetricTypeGauge, name, strconv.FormatFloat(v, 'f', -1, 64))
+		client: &http.Client{Timeout: defaultRequestTimeout},
 	}
-	for name, v := range counters {
-		r.send(storage.MetricTypeCounter, name, strconv.FormatInt(v, 10))
-	}
-}
-
-func (r *Reporter) ReportBatch(gauges map[string]float64, counters map[string]int64) {
-	r.sendBatch(gauges, counters)
 }
 
-func (r *Reporter) send(metricType storage.MetricType, name, value string) {
-	m := models.Metrics{
-		ID:    name,
-		MType: metricType,
-	}
-
-	switch metricType {
-	case storage.MetricTypeGauge:
-		v, err := strconv.ParseFloat(value, 64)
-		if err != nil {
-			log.Println("invalid gauge value:", err)
-			return
-		}
-		m.Value = &v
-	case storage.MetricTypeCounter:
-		d, err := strconv.ParseInt(value, 10, 64)
-		if err != nil {
-			log.Println("invalid counter value:", err)
-			return
-		}
-		m.Delta = &d
-	default:
-		log.Printf("unsupported metric type: %s\n", metricType)
-		return
-	}
-
-	data, err := json.Marshal(m)
-	if err != nil {
-		log.Println("json marshal failed:", err)
-		return
-	}
-
-	var buf bytes.Buffer
-	gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
-	if err != nil {
-		log.Println("gzip compression failed:", err)
-		return
-	}
-
-	if _, err := gz.Write(data); err != nil {
-		log.Println("gzip write failed:", err)
-		return
-	}
-	if err := gz.Close(); err != nil {
-		log.Println("gzip close failed:", err)
-		return
-	}
-
-	compressed := buf.Bytes()
-
-	encryptedData := compressed
-	if r.cfg.CryptoKey != "" {
-		publicKey, err := utils.LoadPublicKey(r.cfg.CryptoKey)
-		if err != nil {
-			log.Printf("failed to load public key: %v", err)
-			return
+// SendBatch отправляет метрики одним запросом на /updates/.
+// Пустой пакет не отправляется. Метрикам без меток назначаются метки из конфигурации.
+// Неудачные попытки повторяются согласно политике повторов.
+// Ошибка возвращается, если запрос не удалось выполнить или сервер
+// ответил статусом, отличным от 200.
+func (r *Reporter) SendBatch(ctx context.Context, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if len(r.cfg.Labels) > 0 {
+		labeled := make([]models.Metrics, len(metrics))
+		for i, m := range metrics {
+			if m.Labels == nil {
+				m.Labels = r.cfg.Labels
+			}
+			labeled[i] = m
 		}
-
-		encrypted, err := utils.EncryptRSA(compressed, publicKey)
-		if err != nil {
-			log.Printf("failed to encrypt data: %v", err)
-			return
-		}
-
-		encryptedData = encrypted
+		metrics = labeled
 	}
 
-	url := fmt.Sprintf("http://%s/update/", r.cfg.ServerAddr)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(compressed))
-	if err != nil {
-		log.Println("create request:", err)
-		return
-	}
-	req.Header.Set("Content-Type", "application/json")
-
-	// Устанавливаем заголовки в зависимости от шифрования
-	if r.cfg.CryptoKey != "" {
-		// Данные зашифрованы - не устанавливаем Content-Encoding
-		// Хеш считаем от зашифрованных данных
-		if r.cfg.Key != "" {
-			req.Header.Set("HashSHA256", utils.CreateHash(encryptedData, r.cfg.Key))
-		}
-	} else {
-		// Данные только сжаты - устанавливаем Content-Encoding
-		req.Header.Set("Content-Encoding", "gzip")
-		if r.cfg.Key != "" {
-			req.Header.Set("HashSHA256", utils.CreateHash(encryptedData, r.cfg.Key))
-		}
-	}
-
-	resp, err := r.client.Do(req)
-	if err != nil {
-		log.Println("send metric:", err)
-		return
-	}
-
-	if err := resp.Body.Close(); err != nil {
-		log.Println("close response body:", err)
-		return
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		log.Printf("bad status %d for %s/%s\n", resp.StatusCode, metricType, name)
-	}
+	return r.postWithRetry(ctx, "/updates/", metrics)
 }
 
-func (r *Reporter) sendBatch(gauges map[string]float64, counters map[string]int64) {
-	var metrics []models.Metrics
-
-	for name, v := range gauges {
-		value, err := strconv.ParseFloat(strconv.FormatFloat(v, 'f', -1, 64), 64)
-		if err != nil {
-			log.Println("invalid gauge value:", err)
-			return
-		}
-		metrics = append(metrics, models.Metrics{
-			ID:    name,
-			MType: storage.MetricTypeGauge,
-			Value: &value,
-		})
-	}
-
-	for name, d := range counters {
-		delta, err := strconv.ParseInt(strconv.FormatInt(d, 10), 10, 64)
-		if err != nil {
-			log.Println("invalid counter value:", err)
-			return
-		}
-		metrics = append(metrics, models.Metrics{
-			ID:    name,
-			MType: storage.MetricTypeCounter,
-			Delta: &delta,
-		})
-	}
+// postWithRetry вызывает post с повторами согласно политике повторов.
+func (r *Reporter) postWithRetry(ctx context.Context, path string, v any) error {
+	return r.cfg.Retry.Do(ctx, func() error {
+		return r.post(ctx, path, v)
+	})
+}
 
-	data, err := json.Marshal(metrics)
+// post сериализует v в JSON, сжимает, при необходимости шифрует
+// и отправляет POST запросом на path.
+func (r *Reporter) post(ctx context.Context, path string, v any) error {
+	data, err := json.Marshal(v)
 	if err != nil {
-		log.Println("json marshal failed:", err)
-		return
+		return fmt.Errorf("json marshal failed: %w", err)
 	}
 
 	var buf bytes.Buffer
 	gz, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
 	if err != nil {
-		log.Println("gzip compression failed:", err)
-		return
+		return fmt.Errorf("gzip compression failed: %w", err)
 	}
 
 	if _, err := gz.Write(data); err != nil {
-		log.Println("gzip write failed:", err)
-		return
+		return fmt.Errorf("gzip write failed: %w", err)
 	}
 	if err := gz.Close(); err != nil {
-		log.Println("gzip close failed:", err)
-		return
+		return fmt.Errorf("gzip close failed: %w", err)
 	}
-	compressed := buf.Bytes()
 
-	encryptedData := compressed
+	body := buf.Bytes()
 	if r.cfg.CryptoKey != "" {
 		publicKey, err := utils.LoadPublicKey(r.cfg.CryptoKey)
 		if err != nil {
-			log.Printf("failed to load public key: %v", err)
-			return
+			return fmt.Errorf("failed to load public key: %w", err)
 		}
 
-		encrypted, err := utils.EncryptRSA(compressed, publicKey)
+		encrypted, err := utils.EncryptEnvelope(body, publicKey)
 		if err != nil {
-			log.Printf("failed to encrypt data: %v", err)
-			return
+			return fmt.Errorf("failed to encrypt data: %w", err)
 		}
-		encryptedData = encrypted
+		body = encrypted
 	}
 
-	url := fmt.Sprintf("http://%s/updates/", r.cfg.ServerAddr)
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(compressed))
+	url := fmt.Sprintf("http://%s%s", r.cfg.ServerAddr, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	if err != nil {
-		log.Println("create request:", err)
-		return
+		return fmt.Errorf("create request: %w", err)
 	}
-
 	req.Header.Set("Content-Type", "application/json")
+	if ip := r.realIP.get(r.cfg.ServerAddr); ip != "" {
+		req.Header.Set("X-Real-IP", ip)
+	}
 
-	// Устанавливаем заголовки в зависимости от шифрования
-	if r.cfg.CryptoKey != "" {
-		// Данные зашифрованы - не устанавливаем Content-Encoding
-		if r.cfg.Key != "" {
-			req.Header.Set("HashSHA256", utils.CreateHash(encryptedData, r.cfg.Key))
-		}
-	} else {
-		// Данные только сжаты - устанавливаем Content-Encoding
+	// Данные зашифрованы - не устанавливаем Content-Encoding,
+	// данные только сжаты - устанавливаем Content-Encoding: gzip.
+	// Хеш считаем от тела запроса в том виде, в котором оно отправляется.
+	if r.cfg.CryptoKey == "" {
 		req.Header.Set("Content-Encoding", "gzip")
-		if r.cfg.Key != "" {
-			req.Header.Set("HashSHA256", utils.CreateHash(encryptedData, r.cfg.Key))
-		}
+	} else {
+		req.Header.Set("X-Encryption-Version", strconv.Itoa(utils.EnvelopeVersion))
+	}
+	if r.cfg.Key != "" {
+		req.Header.Set("HashSHA256", utils.CreateHash(body, r.cfg.Key))
 	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
-		log.Println("send metric:", err)
-		return
+		return err
 	}
 
 	if err := resp.Body.Close(); err != nil {
-		log.Println("close response body:", err)
-		return
+		return fmt.Errorf("close response body: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		log.Printf("bad status %d when batch", resp.StatusCode)
+		return &StatusError{
+			Code:       resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+		}
 	}
+	return nil
 }
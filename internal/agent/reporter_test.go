@@ -1,15 +1,27 @@
 package agent
 
 import (
+	"bytes"
 	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
+	"github.com/am0xff/metrics/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,252 +39,207 @@ func TestNewReporter(t *testing.T) {
 	assert.NotNil(t, reporter.client)
 }
 
-func TestReporter_Report(t *testing.T) {
-	// Создаем тестовый HTTP сервер
+func TestReporter_SendBatchRequest(t *testing.T) {
+	var received []models.Metrics
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Проверяем заголовки
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/updates/", r.URL.Path)
 		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
 		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
-		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Empty(t, r.Header.Get("HashSHA256"))
 
-		// Проверяем путь
-		expectedPaths := []string{"/update/"}
-		found := false
-		for _, path := range expectedPaths {
-			if r.URL.Path == path {
-				found = true
-				break
-			}
-		}
-		assert.True(t, found, "Unexpected path: %s", r.URL.Path)
-
-		// Читаем и разжимаем тело запроса
 		gz, err := gzip.NewReader(r.Body)
 		require.NoError(t, err)
 		defer gz.Close()
+		require.NoError(t, json.NewDecoder(gz).Decode(&received))
 
-		body, err := io.ReadAll(gz)
-		require.NoError(t, err)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:]})
+	delta := int64(5)
+	metrics := []models.Metrics{
+		gaugeMetric("cpu_usage", 85.5),
+		{ID: "requests_total", MType: storage.MetricTypeCounter, Delta: &delta},
+	}
+	require.NoError(t, reporter.SendBatch(context.Background(), metrics))
+	assert.Equal(t, metrics, received)
+}
 
-		// Парсим JSON
-		var metric models.Metrics
-		err = json.Unmarshal(body, &metric)
+func TestReporter_SendBatchWithKey(t *testing.T) {
+	var hash string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
 		require.NoError(t, err)
+		hash = r.Header.Get("HashSHA256")
+		assert.Equal(t, utils.CreateHash(body, "secret_key"), hash)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
 
-		// Проверяем содержимое метрики
-		assert.NotEmpty(t, metric.ID)
-		assert.NotEmpty(t, metric.MType)
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:], Key: "secret_key"})
+	require.NoError(t, reporter.SendBatch(context.Background(), []models.Metrics{gaugeMetric("cpu", 85.5)}))
+	assert.NotEmpty(t, hash)
+}
 
+func TestReporter_SendBatchEmpty(t *testing.T) {
+	requestCount := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "",
-	}
-	reporter := NewReporter(cfg)
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:]})
+	require.NoError(t, reporter.SendBatch(context.Background(), nil))
+	require.NoError(t, reporter.SendBatch(context.Background(), []models.Metrics{}))
 
-	// Тестовые данные
-	gauges := map[string]float64{
-		"cpu_usage":    85.5,
-		"memory_usage": 67.2,
-	}
-	counters := map[string]int64{
-		"requests_total": 1000,
-		"errors_total":   5,
+	// Пустой пакет не отправляется
+	assert.Equal(t, 0, requestCount)
+}
+
+func TestReporterConfig(t *testing.T) {
+	// Тестируем структуру конфигурации
+	cfg := &ReporterConfig{
+		ServerAddr: "localhost:8080",
+		Key:        "secret_key",
 	}
 
-	// Вызываем Report - не должно быть паник или ошибок
-	reporter.Report(gauges, counters)
+	assert.Equal(t, "localhost:8080", cfg.ServerAddr)
+	assert.Equal(t, "secret_key", cfg.Key)
 }
 
-func TestReporter_ReportBatch(t *testing.T) {
-	// Создаем тестовый HTTP сервер для batch запросов
+func TestReporter_SendBatchLabels(t *testing.T) {
+	received := make(chan []models.Metrics, 1)
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Проверяем заголовки
-		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
-		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
-		assert.Equal(t, http.MethodPost, r.Method)
-		assert.Equal(t, "/updates/", r.URL.Path)
-
-		// Читаем и разжимаем тело запроса
 		gz, err := gzip.NewReader(r.Body)
 		require.NoError(t, err)
 		defer gz.Close()
 
-		body, err := io.ReadAll(gz)
-		require.NoError(t, err)
-
-		// Парсим JSON массив
 		var metrics []models.Metrics
-		err = json.Unmarshal(body, &metrics)
-		require.NoError(t, err)
-
-		// Проверяем, что получили массив метрик
-		assert.NotEmpty(t, metrics)
-
-		// Проверяем типы метрик
-		for _, metric := range metrics {
-			assert.NotEmpty(t, metric.ID)
-			assert.Contains(t, []storage.MetricType{storage.MetricTypeGauge, storage.MetricTypeCounter}, metric.MType)
-
-			if metric.MType == storage.MetricTypeGauge {
-				assert.NotNil(t, metric.Value)
-			} else if metric.MType == storage.MetricTypeCounter {
-				assert.NotNil(t, metric.Delta)
-			}
-		}
+		require.NoError(t, json.NewDecoder(gz).Decode(&metrics))
+		received <- metrics
 
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "",
-	}
-	reporter := NewReporter(cfg)
-
-	// Тестовые данные
-	gauges := map[string]float64{
-		"cpu_usage":    85.5,
-		"memory_usage": 67.2,
-	}
-	counters := map[string]int64{
-		"requests_total": 1000,
-		"errors_total":   5,
-	}
-
-	// Вызываем ReportBatch
-	reporter.ReportBatch(gauges, counters)
+	labels := map[string]string{"host": "web-1"}
+	reporter := NewReporter(&ReporterConfig{
+		ServerAddr: server.URL[7:],
+		Labels:     labels,
+	})
+
+	own := gaugeMetric("disk", 3)
+	own.Labels = map[string]string{"device": "sda"}
+	require.NoError(t, reporter.SendBatch(context.Background(), []models.Metrics{gaugeMetric("cpu", 1), own}))
+
+	// Метки из конфигурации назначаются только метрикам без меток
+	metrics := <-received
+	require.Len(t, metrics, 2)
+	assert.Equal(t, labels, metrics[0].Labels)
+	assert.Equal(t, own.Labels, metrics[1].Labels)
 }
 
-func TestReporter_ReportWithKey(t *testing.T) {
-	// Создаем тестовый HTTP сервер
+func TestReporter_SendBatch(t *testing.T) {
+	status := http.StatusOK
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Проверяем наличие хеша в заголовке
-		hash := r.Header.Get("HashSHA256")
-		assert.NotEmpty(t, hash, "Expected hash in header when key is provided")
-
-		w.WriteHeader(http.StatusOK)
+		assert.Equal(t, "/updates/", r.URL.Path)
+		w.WriteHeader(status)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter с ключом
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "secret_key",
-	}
-	reporter := NewReporter(cfg)
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:]})
+	metrics := []models.Metrics{gaugeMetric("cpu", 1)}
 
-	// Тестовые данные
-	gauges := map[string]float64{"cpu": 85.5}
-	counters := map[string]int64{}
+	assert.NoError(t, reporter.SendBatch(context.Background(), metrics))
+	assert.NoError(t, reporter.SendBatch(context.Background(), nil))
 
-	// Вызываем Report с ключом
-	reporter.Report(gauges, counters)
+	status = http.StatusInternalServerError
+	err := reporter.SendBatch(context.Background(), metrics)
+	var statusErr *StatusError
+	require.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusInternalServerError, statusErr.Code)
+
+	server.Close()
+	assert.Error(t, reporter.SendBatch(context.Background(), metrics))
 }
 
-func TestReporter_ReportEmpty(t *testing.T) {
-	// Создаем тестовый HTTP сервер
-	requestCount := 0
+func TestReporter_RealIPHeader(t *testing.T) {
+	var realIP string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestCount++
+		realIP = r.Header.Get("X-Real-IP")
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "",
-	}
-	reporter := NewReporter(cfg)
-
-	// Пустые данные
-	gauges := map[string]float64{}
-	counters := map[string]int64{}
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:]})
+	value := 1.0
+	err := reporter.SendBatch(context.Background(), []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &value},
+	})
+	require.NoError(t, err)
 
-	// Вызываем Report с пустыми данными
-	reporter.Report(gauges, counters)
-
-	// Не должно быть запросов при пустых данных
-	assert.Equal(t, 0, requestCount)
+	// Тестовый сервер слушает loopback, поэтому исходящий адрес - 127.0.0.1
+	assert.Equal(t, "127.0.0.1", realIP)
 }
 
-func TestReporter_ReportBatchEmpty(t *testing.T) {
-	// Создаем тестовый HTTP сервер
-	requestCount := 0
+func TestReporter_SendBatchEncrypted(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKeyBytes}), 0600))
+
+	var received []models.Metrics
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		requestCount++
+		assert.Equal(t, "1", r.Header.Get("X-Encryption-Version"))
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
 
-		// Если запрос все же пришел, проверим что это пустой массив
-		gz, err := gzip.NewReader(r.Body)
+		body, err := io.ReadAll(r.Body)
 		require.NoError(t, err)
-		defer gz.Close()
-
-		body, err := io.ReadAll(gz)
+		data, err := utils.DecryptEnvelope(body, privateKey)
 		require.NoError(t, err)
 
-		var metrics []models.Metrics
-		err = json.Unmarshal(body, &metrics)
+		gz, err := gzip.NewReader(bytes.NewReader(data))
 		require.NoError(t, err)
-		assert.Empty(t, metrics)
-
+		require.NoError(t, json.NewDecoder(gz).Decode(&received))
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "",
-	}
-	reporter := NewReporter(cfg)
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:], CryptoKey: keyPath})
 
-	// Пустые данные
-	gauges := map[string]float64{}
-	counters := map[string]int64{}
-
-	// Вызываем ReportBatch с пустыми данными
-	reporter.ReportBatch(gauges, counters)
-
-	// Запрос все равно должен отправиться (даже с пустым массивом)
-	assert.Equal(t, 1, requestCount)
+	// Пакет заметно больше предела RSA-OAEP для ключа 2048 бит
+	metrics := make([]models.Metrics, 100)
+	for i := range metrics {
+		value := float64(i)
+		metrics[i] = models.Metrics{ID: fmt.Sprintf("metric_%d", i), MType: storage.MetricTypeGauge, Value: &value}
+	}
+	require.NoError(t, reporter.SendBatch(context.Background(), metrics))
+	assert.Len(t, received, 100)
 }
 
-func TestReporter_ServerError(t *testing.T) {
-	// Создаем тестовый HTTP сервер, возвращающий ошибку
+func TestReporter_RetriesClientTimeout(t *testing.T) {
+	var calls atomic.Int32
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
+		// Первый запрос отвечает дольше тайм-аута клиента
+		if calls.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	// Настраиваем reporter
-	cfg := &ReporterConfig{
-		ServerAddr: server.URL[7:], // убираем "http://"
-		Key:        "",
-	}
-	reporter := NewReporter(cfg)
+	reporter := NewReporter(&ReporterConfig{ServerAddr: server.URL[7:], Retry: testRetryPolicy})
+	reporter.client.Timeout = 50 * time.Millisecond
 
-	// Тестовые данные
-	gauges := map[string]float64{"cpu": 85.5}
-	counters := map[string]int64{}
-
-	// Вызываем Report - не должно быть паник, только логи
-	reporter.Report(gauges, counters)
-}
-
-func TestReporterConfig(t *testing.T) {
-	// Тестируем структуру конфигурации
-	cfg := &ReporterConfig{
-		ServerAddr: "localhost:8080",
-		Key:        "secret_key",
-	}
-
-	assert.Equal(t, "localhost:8080", cfg.ServerAddr)
-	assert.Equal(t, "secret_key", cfg.Key)
+	require.NoError(t, reporter.SendBatch(context.Background(), spoolBatch(1)))
+	assert.Equal(t, int32(2), calls.Load())
 }
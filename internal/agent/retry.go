@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StatusError возвращается, если сервер ответил статусом, отличным от 200.
+type StatusError struct {
+	Code       int           // HTTP статус ответа
+	RetryAfter time.Duration // значение заголовка Retry-After, 0 если заголовок не указан
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("bad status %d", e.Code)
+}
+
+// RetryPolicy - политика повторных попыток отправки метрик.
+//
+// Паузы между попытками растут экспоненциально от InitialInterval до MaxInterval
+// с множителем Multiplier, фактическая пауза выбирается случайно в пределах
+// от нуля до текущего значения (full jitter). Если сервер указал Retry-After,
+// пауза равна указанному времени. Попытки прекращаются, если с начала первой
+// попытки прошло больше MaxElapsedTime, ошибка не подлежит повтору или
+// отменен контекст.
+type RetryPolicy struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	MaxElapsedTime  time.Duration
+}
+
+// DefaultRetryPolicy - политика повторных попыток по умолчанию.
+var DefaultRetryPolicy = RetryPolicy{
+	InitialInterval: 500 * time.Millisecond,
+	MaxInterval:     10 * time.Second,
+	Multiplier:      2,
+	MaxElapsedTime:  30 * time.Second,
+}
+
+// Do вызывает f, пока она не выполнится успешно или пока повторы
+// допускаются политикой. Возвращает последнюю ошибку f или ошибку контекста.
+func (p RetryPolicy) Do(ctx context.Context, f func() error) error {
+	start := time.Now()
+	interval := p.InitialInterval
+
+	for {
+		err := f()
+		if err == nil || !IsRetryable(ctx, err) {
+			return err
+		}
+
+		delay := jitter(interval)
+		var se *StatusError
+		if errors.As(err, &se) && se.RetryAfter > 0 {
+			delay = se.RetryAfter
+		}
+
+		if time.Since(start)+delay > p.MaxElapsedTime {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Join(err, ctx.Err())
+		case <-timer.C:
+		}
+
+		interval = time.Duration(float64(interval) * p.Multiplier)
+		if interval > p.MaxInterval {
+			interval = p.MaxInterval
+		}
+	}
+}
+
+// IsRetryable сообщает, имеет ли смысл повторить отправку в контексте ctx
+// после ошибки err. Повторяются ошибки соединения (в том числе истечение
+// тайм-аута запроса), ответы 5xx и 429, а для gRPC - коды Unavailable,
+// ResourceExhausted, Aborted и DeadlineExceeded. Остальные ответы 4xx и коды
+// gRPC, ошибки подготовки запроса, а также любые ошибки после отмены или
+// истечения ctx не повторяются.
+func IsRetryable(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code == http.StatusTooManyRequests || se.Code >= http.StatusInternalServerError
+	}
+
+	if st, ok := status.FromError(err); ok {
+		switch st.Code() {
+		case codes.Unavailable, codes.ResourceExhausted, codes.Aborted, codes.DeadlineExceeded:
+			return true
+		}
+		return false
+	}
+
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// isRejected сообщает, что сервер отклонил пакет и повторная отправка
+// того же пакета не поможет: сервер ответил статусом или кодом gRPC,
+// не подлежащим повтору (см. IsRetryable). Ошибки соединения, подготовки
+// запроса и отмена контекста отклонением не считаются.
+func isRejected(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return !IsRetryable(ctx, err)
+	}
+	if st, ok := status.FromError(err); ok && st.Code() != codes.Canceled {
+		return !IsRetryable(ctx, err)
+	}
+	return false
+}
+
+// parseRetryAfter разбирает заголовок Retry-After, заданный числом секунд
+// или датой HTTP. Для пустого или некорректного значения возвращает 0.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(v); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// jitter возвращает случайную паузу в пределах [0, d).
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d)))
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var testRetryPolicy = RetryPolicy{
+	InitialInterval: time.Millisecond,
+	MaxInterval:     5 * time.Millisecond,
+	Multiplier:      2,
+	MaxElapsedTime:  time.Second,
+}
+
+func TestIsRetryable(t *testing.T) {
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	expired, cancel := context.WithDeadline(ctx, time.Now())
+	defer cancel()
+
+	testCases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{"nil", ctx, nil, false},
+		{"internal_error", ctx, &StatusError{Code: http.StatusInternalServerError}, true},
+		{"unavailable", ctx, &StatusError{Code: http.StatusServiceUnavailable}, true},
+		{"too_many_requests", ctx, &StatusError{Code: http.StatusTooManyRequests}, true},
+		{"bad_request", ctx, &StatusError{Code: http.StatusBadRequest}, false},
+		{"not_found", ctx, &StatusError{Code: http.StatusNotFound}, false},
+		{"wrapped_status", ctx, fmt.Errorf("send: %w", &StatusError{Code: http.StatusBadGateway}), true},
+		{"connection", ctx, &url.Error{Op: "Post", URL: "http://localhost", Err: errors.New("connection refused")}, true},
+		{"client_timeout", ctx, &url.Error{Op: "Post", URL: "http://localhost", Err: context.DeadlineExceeded}, true},
+		{"canceled", canceled, &url.Error{Op: "Post", URL: "http://localhost", Err: context.Canceled}, false},
+		{"deadline", expired, context.DeadlineExceeded, false},
+		{"canceled_status", canceled, &StatusError{Code: http.StatusServiceUnavailable}, false},
+		{"other", ctx, errors.New("json marshal failed"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsRetryable(tc.ctx, tc.err))
+		})
+	}
+}
+
+func TestIsRejected(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, isRejected(ctx, nil))
+	assert.True(t, isRejected(ctx, &StatusError{Code: http.StatusBadRequest}))
+	assert.True(t, isRejected(ctx, fmt.Errorf("send: %w", &StatusError{Code: http.StatusForbidden})))
+	assert.True(t, isRejected(ctx, status.Error(codes.InvalidArgument, "bad metric")))
+	assert.False(t, isRejected(ctx, &StatusError{Code: http.StatusServiceUnavailable}))
+	assert.False(t, isRejected(ctx, status.Error(codes.Unavailable, "down")))
+	assert.False(t, isRejected(ctx, status.Error(codes.Canceled, "canceled")))
+	assert.False(t, isRejected(ctx, errors.New("failed to load public key")))
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.False(t, isRejected(canceled, &StatusError{Code: http.StatusBadRequest}))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+	assert.Equal(t, 90*time.Second, parseRetryAfter("Mon, 01 Jan 2024 10:01:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Mon, 01 Jan 2024 09:00:00 GMT", now))
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	calls := 0
+	err := testRetryPolicy.Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &StatusError{Code: http.StatusServiceUnavailable}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestRetryPolicy_DoNotRetryable(t *testing.T) {
+	calls := 0
+	err := testRetryPolicy.Do(context.Background(), func() error {
+		calls++
+		return &StatusError{Code: http.StatusBadRequest}
+	})
+
+	var se *StatusError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, http.StatusBadRequest, se.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_MaxElapsedTime(t *testing.T) {
+	policy := testRetryPolicy
+	policy.MaxElapsedTime = 20 * time.Millisecond
+
+	start := time.Now()
+	calls := 0
+	err := policy.Do(context.Background(), func() error {
+		calls++
+		return &StatusError{Code: http.StatusBadGateway}
+	})
+
+	assert.Error(t, err)
+	assert.Greater(t, calls, 1)
+	assert.Less(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestRetryPolicy_RetryAfterExceedsMaxElapsed(t *testing.T) {
+	calls := 0
+	err := testRetryPolicy.Do(context.Background(), func() error {
+		calls++
+		return &StatusError{Code: http.StatusTooManyRequests, RetryAfter: time.Minute}
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryPolicy_ContextCanceled(t *testing.T) {
+	policy := testRetryPolicy
+	policy.InitialInterval = time.Minute
+	policy.MaxInterval = time.Minute
+	policy.MaxElapsedTime = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err := policy.Do(ctx, func() error {
+		return &StatusError{Code: http.StatusServiceUnavailable}
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReporter_SendBatchRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	reporter := NewReporter(&ReporterConfig{
+		ServerAddr: server.URL[7:],
+		Retry:      testRetryPolicy,
+	})
+
+	require.NoError(t, reporter.SendBatch(context.Background(), spoolBatch(0)))
+	assert.Equal(t, 3, calls)
+}
@@ -3,6 +3,7 @@ package agent
 import (
 	"context"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
@@ -16,22 +17,51 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 )
 
+// shutdownFlushTimeout - время на отправку накопленных пакетов при остановке агента.
+const shutdownFlushTimeout = 5 * time.Second
+
 type Agent struct {
 	cfg      Config
-	reporter *Reporter
+	reporter Transport
 	jobs     chan models.Metrics
+	stats    BatchStats
+	spool    *Spool
 }
 
-func NewAgent(cfg Config) *Agent {
-	return &Agent{
-		cfg: cfg,
-		reporter: NewReporter(&ReporterConfig{
-			ServerAddr: cfg.ServerAddr,
-			Key:        cfg.Key,
-			CryptoKey:  cfg.CryptoKey,
-		}),
-		jobs: make(chan models.Metrics, cfg.RateLimit),
+func NewAgent(cfg Config) (*Agent, error) {
+	// Метки проверяются при загрузке конфигурации в LoadConfig
+	labels, _ := ParseLabels(cfg.Labels)
+
+	retry := DefaultRetryPolicy
+	retry.MaxElapsedTime = time.Duration(cfg.RetryMaxTime) * time.Second
+
+	reporterCfg := &ReporterConfig{
+		ServerAddr: cfg.ServerAddr,
+		Key:        cfg.Key,
+		CryptoKey:  cfg.CryptoKey,
+		Labels:     labels,
+		Retry:      retry,
 	}
+
+	var reporter Transport
+	switch cfg.Transport {
+	case "", TransportHTTP:
+		reporter = NewReporter(reporterCfg)
+	case TransportGRPC:
+		r, err := NewGRPCReporter(reporterCfg)
+		if err != nil {
+			return nil, fmt.Errorf("create grpc reporter: %w", err)
+		}
+		reporter = r
+	default:
+		return nil, fmt.Errorf("unknown transport %q", cfg.Transport)
+	}
+
+	return &Agent{
+		cfg:      cfg,
+		reporter: reporter,
+		jobs:     make(chan models.Metrics, cfg.RateLimit),
+	}, nil
 }
 
 func Run() error {
@@ -42,8 +72,27 @@ func Run() error {
 		log.Fatalf("load config: %v", err)
 	}
 
-	agent := NewAgent(cfg)
-	fmt.Println("Running agent on", cfg.ServerAddr)
+	agent, err := NewAgent(cfg)
+	if err != nil {
+		return err
+	}
+	if c, ok := agent.reporter.(io.Closer); ok {
+		defer c.Close()
+	}
+	fmt.Println("Running agent on", cfg.ServerAddr, "via", cfg.Transport)
+
+	if cfg.SpoolDir != "" {
+		spool, err := OpenSpool(cfg.SpoolDir, cfg.SpoolMaxBytes, DefaultSpoolSegmentBytes)
+		if err != nil {
+			return fmt.Errorf("open spool: %w", err)
+		}
+		defer spool.Close()
+		agent.spool = spool
+
+		if depth := spool.Depth(); depth > 0 {
+			log.Printf("spool: %d undelivered batches found", depth)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	sigChan := make(chan os.Signal, 1)
@@ -55,18 +104,19 @@ func Run() error {
 		cancel()
 	}()
 
+	if agent.spool != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			agent.replay(ctx)
+		}()
+	}
+
 	for i := 0; i < cfg.RateLimit; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for {
-				select {
-				case <-ctx.Done():
-					return
-				case m := <-agent.jobs:
-					agent.reporter.send(m.MType, m.ID, m.String())
-				}
-			}
+			agent.worker(ctx)
 		}()
 	}
 
@@ -127,6 +177,24 @@ func Run() error {
 				case agent.jobs <- models.Metrics{ID: "FreeMemory", MType: storage.MetricTypeGauge, Value: &free}:
 				}
 
+				if agent.spool != nil {
+					stats := agent.spool.Stats(time.Now())
+					depth := float64(stats.Depth)
+					age := stats.Age.Seconds()
+
+					select {
+					case <-ctx.Done():
+						return
+					case agent.jobs <- models.Metrics{ID: "SpoolDepth", MType: storage.MetricTypeGauge, Value: &depth}:
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case agent.jobs <- models.Metrics{ID: "SpoolAge", MType: storage.MetricTypeGauge, Value: &age}:
+					}
+				}
+
 				percents, _ := cpu.Percent(1*time.Second, true)
 				for idx, pct := range percents {
 					name := fmt.Sprintf("CPUutilization%d", idx+1)
@@ -148,5 +216,85 @@ func Run() error {
 	wg.Wait()
 	close(agent.jobs)
 
+	log.Printf("batches sent: %d (%d metrics), spooled: %d (%d metrics), failed: %d (%d metrics)",
+		agent.stats.SentBatches.Load(), agent.stats.SentMetrics.Load(),
+		agent.stats.SpooledBatches.Load(), agent.stats.SpooledMetrics.Load(),
+		agent.stats.FailedBatches.Load(), agent.stats.FailedMetrics.Load())
+
 	return nil
 }
+
+// worker собирает метрики из очереди в пакеты и отправляет их на сервер.
+// Неполный пакет отправляется раз в FlushInterval. При остановке агента
+// оставшиеся в очереди метрики отправляются последним пакетом.
+func (a *Agent) worker(ctx context.Context) {
+	batcher := NewBatcher(a.cfg.BatchSize, a.cfg.BatchBytes, a.sendBatch, &a.stats)
+
+	ticker := time.NewTicker(time.Duration(a.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+			defer cancel()
+			for {
+				select {
+				case m := <-a.jobs:
+					batcher.Add(flushCtx, m)
+				default:
+					batcher.Flush(flushCtx)
+					return
+				}
+			}
+		case m := <-a.jobs:
+			batcher.Add(ctx, m)
+		case <-ticker.C:
+			batcher.Flush(ctx)
+		}
+	}
+}
+
+// sendBatch отправляет пакет на сервер. Если спул включен, пакет, который
+// не удалось отправить, сохраняется в спул. Пока в спуле есть недоставленные
+// пакеты, новые пакеты сразу сохраняются в спул, чтобы сохранить порядок отправки.
+// Пакет, отклоненный сервером без возможности повтора, в спул не сохраняется:
+// ошибка возвращается, и пакет учитывается как неотправленный.
+func (a *Agent) sendBatch(ctx context.Context, metrics []models.Metrics) error {
+	if a.spool == nil {
+		return a.reporter.SendBatch(ctx, metrics)
+	}
+
+	if a.spool.Depth() == 0 {
+		err := a.reporter.SendBatch(ctx, metrics)
+		if err == nil || isRejected(ctx, err) {
+			return err
+		}
+		log.Printf("send batch of %d metrics: %v", len(metrics), err)
+	}
+
+	if err := a.spool.Append(metrics); err != nil {
+		return fmt.Errorf("append to spool: %w", err)
+	}
+	return ErrSpooled
+}
+
+// replay раз в FlushInterval отправляет недоставленные пакеты из спула.
+func (a *Agent) replay(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(a.cfg.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if a.spool.Depth() == 0 {
+				continue
+			}
+			if err := a.spool.Replay(ctx, a.reporter.SendBatch); err != nil && ctx.Err() == nil {
+				log.Printf("spool: replay: %v (%d batches pending)", err, a.spool.Depth())
+			}
+		}
+	}
+}
@@ -0,0 +1,529 @@
+package agent
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+)
+
+const (
+	// DefaultSpoolMaxBytes - максимальный размер спула по умолчанию.
+	DefaultSpoolMaxBytes = 64 << 20
+
+	// DefaultSpoolSegmentBytes - размер сегмента спула, после которого
+	// запись продолжается в новый сегмент.
+	DefaultSpoolSegmentBytes = 1 << 20
+
+	spoolSegmentExt  = ".seg"
+	spoolCursorFile  = "cursor"
+	spoolHeaderBytes = 16 // длина (4), CRC32 (4), время записи в наносекундах (8)
+
+	// spoolMaxRecordBytes ограничивает длину записи при чтении, чтобы
+	// поврежденный заголовок не приводил к выделению памяти под гигабайты.
+	spoolMaxRecordBytes = 256 << 20
+)
+
+// errSpoolCorrupted возвращается при чтении поврежденной записи спула.
+var errSpoolCorrupted = errors.New("spool record corrupted")
+
+// Spool - очередь пакетов метрик на диске, в которую агент откладывает
+// пакеты, не доставленные на сервер.
+//
+// Спул хранится в каталоге в виде сегментов - файлов, в которые записи
+// только дописываются. Каждая запись содержит заголовок (длина, CRC32,
+// время записи) и пакет метрик в JSON. Позиция первой недоставленной записи
+// хранится в файле cursor, поэтому после перезапуска агента доставка
+// продолжается с того же места.
+//
+// Если размер спула превышает maxBytes, удаляются самые старые сегменты.
+//
+// Пример использования:
+//
+//	spool, _ := OpenSpool("/var/lib/agent/spool", DefaultSpoolMaxBytes, DefaultSpoolSegmentBytes)
+//	defer spool.Close()
+//	spool.Append(metrics)
+//	spool.Replay(ctx, reporter.SendBatch)
+type Spool struct {
+	mu           sync.Mutex
+	replayMu     sync.Mutex
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	segments []*spoolSegment // от старых к новым, последний открыт на запись
+	active   *os.File
+
+	head     int64     // смещение первой недоставленной записи в segments[0]
+	consumed int       // количество доставленных записей в segments[0]
+	headTime time.Time // время записи первой недоставленной записи
+	depth    int       // количество недоставленных записей
+	size     int64     // суммарный размер сегментов
+	dropped  int64     // количество записей, удаленных при превышении размера, повреждении или отклоненных сервером
+}
+
+type spoolSegment struct {
+	id      uint64
+	size    int64
+	records int
+}
+
+// SpoolStats - состояние спула.
+type SpoolStats struct {
+	Depth   int           // количество недоставленных пакетов
+	Bytes   int64         // размер спула на диске
+	Age     time.Duration // возраст самого старого недоставленного пакета
+	Dropped int64         // количество пакетов, удаленных при превышении размера
+}
+
+// OpenSpool открывает спул в каталоге dir, создавая каталог при необходимости.
+// Поврежденный хвост последнего сегмента (например, после аварийного
+// завершения агента во время записи) отбрасывается.
+func OpenSpool(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultSpoolMaxBytes
+	}
+	if segmentBytes <= 0 {
+		segmentBytes = DefaultSpoolSegmentBytes
+	}
+	if segmentBytes > maxBytes {
+		segmentBytes = maxBytes
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: segmentBytes,
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append записывает пакет метрик в конец спула.
+func (s *Spool) Append(metrics []models.Metrics) error {
+	payload, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	record := make([]byte, spoolHeaderBytes+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint64(record[8:16], uint64(now.UnixNano()))
+	copy(record[spoolHeaderBytes:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+int64(len(record)) > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+
+	if _, err := s.active.Write(record); err != nil {
+		// Отбрасываем частично записанную запись
+		_ = s.active.Truncate(last.size)
+		return err
+	}
+	if err := s.active.Sync(); err != nil {
+		return err
+	}
+
+	last.size += int64(len(record))
+	last.records++
+	s.size += int64(len(record))
+	s.depth++
+	if s.depth == 1 {
+		s.headTime = now
+	}
+
+	s.evict()
+	return nil
+}
+
+// Replay отправляет недоставленные пакеты функцией send в порядке их записи.
+// Доставленные пакеты удаляются из спула. Пакет, отклоненный сервером
+// без возможности повтора (например, ответом 400), также удаляется, чтобы
+// не задерживать следующие пакеты. На прочей ошибке отправки Replay
+// останавливается и возвращает ее; оставшиеся пакеты будут отправлены
+// при следующем вызове.
+func (s *Spool) Replay(ctx context.Context, send SendFunc) error {
+	s.replayMu.Lock()
+	defer s.replayMu.Unlock()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		if s.depth == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		id, offset := s.segments[0].id, s.head
+		metrics, next, err := s.read(offset)
+		if err != nil {
+			// Поврежденную запись доставить невозможно, отбрасываем остаток сегмента
+			log.Printf("spool: segment %d: %v, dropping %d records", id, err, s.segments[0].records-s.consumed)
+			s.dropHead()
+			s.mu.Unlock()
+			continue
+		}
+		s.mu.Unlock()
+
+		err = send(ctx, metrics)
+		if err != nil && !isRejected(ctx, err) {
+			return err
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			log.Printf("spool: segment %d: batch of %d metrics rejected: %v, dropping", id, len(metrics), err)
+			s.dropped++
+		}
+		s.commit(id, offset, next)
+		s.mu.Unlock()
+	}
+}
+
+// Depth возвращает количество недоставленных пакетов.
+func (s *Spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// Stats возвращает состояние спула на момент времени now.
+func (s *Spool) Stats(now time.Time) SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := SpoolStats{
+		Depth:   s.depth,
+		Bytes:   s.size,
+		Dropped: s.dropped,
+	}
+	if s.depth > 0 && now.After(s.headTime) {
+		stats.Age = now.Sub(s.headTime)
+	}
+	return stats
+}
+
+// Close закрывает текущий сегмент спула.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active.Close()
+}
+
+// load читает сегменты и позицию доставки с диска.
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	cursorID, cursorOffset := s.readCursor()
+
+	for i, id := range ids {
+		// Сегменты до позиции доставки уже доставлены полностью
+		if id < cursorID {
+			_ = os.Remove(s.segmentPath(id))
+			continue
+		}
+
+		seg, err := s.scan(id, i == len(ids)-1)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+		s.depth += seg.records
+	}
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, &spoolSegment{id: cursorID + 1})
+	}
+
+	if s.segments[0].id == cursorID {
+		s.skipTo(cursorOffset)
+	}
+	s.refreshHeadTime()
+
+	last := s.segments[len(s.segments)-1]
+	s.active, err = os.OpenFile(s.segmentPath(last.id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	return err
+}
+
+// scan подсчитывает корректные записи сегмента. Для последнего сегмента
+// поврежденный хвост обрезается, у остальных он просто не учитывается.
+func (s *Spool) scan(id uint64, last bool) (*spoolSegment, error) {
+	f, err := os.Open(s.segmentPath(id))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	seg := &spoolSegment{id: id}
+	for {
+		n, _, err := readSpoolRecord(f)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("spool: segment %d: %v at offset %d", id, err, seg.size)
+			}
+			break
+		}
+		seg.size += n
+		seg.records++
+	}
+
+	if last {
+		if err := os.Truncate(s.segmentPath(id), seg.size); err != nil {
+			return nil, err
+		}
+	}
+	return seg, nil
+}
+
+// skipTo пропускает в первом сегменте записи, расположенные до offset.
+func (s *Spool) skipTo(offset int64) {
+	for s.head < offset && s.consumed < s.segments[0].records {
+		_, next, err := s.read(s.head)
+		if err != nil || next > offset {
+			return
+		}
+		s.head = next
+		s.consumed++
+		s.depth--
+	}
+}
+
+// read читает запись по смещению offset в первом сегменте и возвращает
+// метрики и смещение следующей записи.
+func (s *Spool) read(offset int64) ([]models.Metrics, int64, error) {
+	f, err := os.Open(s.segmentPath(s.segments[0].id))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+
+	n, payload, err := readSpoolRecord(f)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var metrics []models.Metrics
+	if err := json.Unmarshal(payload[spoolHeaderBytes:], &metrics); err != nil {
+		return nil, 0, fmt.Errorf("%w: %v", errSpoolCorrupted, err)
+	}
+	return metrics, offset + n, nil
+}
+
+// commit отмечает запись по смещению offset сегмента id доставленной.
+// Если сегмент был удален при превышении размера спула, ничего не делает.
+func (s *Spool) commit(id uint64, offset, next int64) {
+	if s.segments[0].id != id || s.head != offset {
+		return
+	}
+
+	s.head = next
+	s.consumed++
+	s.depth--
+
+	if s.consumed >= s.segments[0].records {
+		if len(s.segments) > 1 {
+			s.removeHead()
+		} else {
+			// Спул доставлен полностью, начинаем текущий сегмент заново
+			if err := s.active.Truncate(0); err != nil {
+				log.Printf("spool: truncate segment %d: %v", id, err)
+			} else {
+				s.size -= s.segments[0].size
+				s.segments[0].size = 0
+				s.segments[0].records = 0
+				s.head = 0
+				s.consumed = 0
+			}
+		}
+	}
+
+	s.writeCursor()
+	s.refreshHeadTime()
+}
+
+// evict удаляет самые старые сегменты, пока размер спула превышает maxBytes.
+func (s *Spool) evict() {
+	for s.size > s.maxBytes && len(s.segments) > 1 {
+		s.dropHead()
+	}
+}
+
+// dropHead удаляет первый сегмент вместе с недоставленными записями.
+func (s *Spool) dropHead() {
+	if len(s.segments) == 1 {
+		if err := s.rotate(); err != nil {
+			log.Printf("spool: rotate segment: %v", err)
+			return
+		}
+	}
+
+	pending := s.segments[0].records - s.consumed
+	s.depth -= pending
+	s.dropped += int64(pending)
+	s.removeHead()
+	s.writeCursor()
+	s.refreshHeadTime()
+}
+
+// removeHead удаляет файл первого сегмента.
+func (s *Spool) removeHead() {
+	seg := s.segments[0]
+	if err := os.Remove(s.segmentPath(seg.id)); err != nil {
+		log.Printf("spool: remove segment %d: %v", seg.id, err)
+	}
+	s.size -= seg.size
+	s.segments = s.segments[1:]
+	s.head = 0
+	s.consumed = 0
+}
+
+// rotate закрывает текущий сегмент и начинает новый.
+func (s *Spool) rotate() error {
+	id := s.segments[len(s.segments)-1].id + 1
+	f, err := os.OpenFile(s.segmentPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := s.active.Close(); err != nil {
+		log.Printf("spool: close segment: %v", err)
+	}
+	s.active = f
+	s.segments = append(s.segments, &spoolSegment{id: id})
+	return nil
+}
+
+func (s *Spool) refreshHeadTime() {
+	s.headTime = time.Time{}
+	if s.depth == 0 {
+		return
+	}
+
+	f, err := os.Open(s.segmentPath(s.segments[0].id))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	var header [spoolHeaderBytes]byte
+	if _, err := f.ReadAt(header[:], s.head); err != nil {
+		return
+	}
+	s.headTime = time.Unix(0, int64(binary.BigEndian.Uint64(header[8:16])))
+}
+
+func (s *Spool) readCursor() (uint64, int64) {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorFile))
+	if err != nil {
+		return 0, 0
+	}
+
+	var (
+		id     uint64
+		offset int64
+	)
+	if _, err := fmt.Sscanf(string(data), "%d %d", &id, &offset); err != nil {
+		return 0, 0
+	}
+	return id, offset
+}
+
+// writeCursor сохраняет позицию доставки через временный файл,
+// чтобы файл позиции не оказался поврежден при сбое во время записи.
+func (s *Spool) writeCursor() {
+	path := filepath.Join(s.dir, spoolCursorFile)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d\n", s.segments[0].id, s.head)
+
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		log.Printf("spool: write cursor: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("spool: write cursor: %v", err)
+	}
+}
+
+func (s *Spool) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, spoolSegmentExt))
+}
+
+// readSpoolRecord читает одну запись и возвращает ее размер вместе с заголовком
+// и содержимое записи вместе с заголовком. Для неполной записи в конце файла
+// или записи с неверной контрольной суммой возвращает errSpoolCorrupted.
+func readSpoolRecord(r io.Reader) (int64, []byte, error) {
+	var header [spoolHeaderBytes]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, errSpoolCorrupted
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > spoolMaxRecordBytes {
+		return 0, nil, errSpoolCorrupted
+	}
+	record := make([]byte, spoolHeaderBytes+int(length))
+	copy(record, header[:])
+	if _, err := io.ReadFull(r, record[spoolHeaderBytes:]); err != nil {
+		return 0, nil, errSpoolCorrupted
+	}
+	if crc32.ChecksumIEEE(record[spoolHeaderBytes:]) != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, errSpoolCorrupted
+	}
+
+	return int64(len(record)), record, nil
+}
@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func spoolBatch(i int) []models.Metrics {
+	return []models.Metrics{gaugeMetric(fmt.Sprintf("m%d", i), float64(i))}
+}
+
+// collect возвращает SendFunc, сохраняющую имена первых метрик отправленных пакетов.
+func collect(sent *[]string) SendFunc {
+	return func(_ context.Context, metrics []models.Metrics) error {
+		*sent = append(*sent, metrics[0].ID)
+		return nil
+	}
+}
+
+func TestSpool_AppendReplay(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, spool.Append(spoolBatch(i)))
+	}
+	assert.Equal(t, 3, spool.Depth())
+
+	var sent []string
+	require.NoError(t, spool.Replay(context.Background(), collect(&sent)))
+	assert.Equal(t, []string{"m0", "m1", "m2"}, sent)
+	assert.Equal(t, 0, spool.Depth())
+	assert.Equal(t, int64(0), spool.Stats(time.Now()).Bytes)
+}
+
+func TestSpool_ReplayStopsOnError(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, spool.Append(spoolBatch(i)))
+	}
+
+	var sent []string
+	failing := func(_ context.Context, metrics []models.Metrics) error {
+		if metrics[0].ID == "m1" {
+			return errors.New("server unavailable")
+		}
+		sent = append(sent, metrics[0].ID)
+		return nil
+	}
+
+	assert.Error(t, spool.Replay(context.Background(), failing))
+	assert.Equal(t, []string{"m0"}, sent)
+	assert.Equal(t, 2, spool.Depth())
+
+	require.NoError(t, spool.Replay(context.Background(), collect(&sent)))
+	assert.Equal(t, []string{"m0", "m1", "m2"}, sent)
+}
+
+func TestSpool_ReplayDropsRejected(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, spool.Append(spoolBatch(i)))
+	}
+
+	var sent []string
+	rejecting := func(_ context.Context, metrics []models.Metrics) error {
+		if metrics[0].ID == "m1" {
+			return &StatusError{Code: http.StatusBadRequest}
+		}
+		sent = append(sent, metrics[0].ID)
+		return nil
+	}
+
+	// Отклоненный пакет удаляется и не задерживает следующие
+	require.NoError(t, spool.Replay(context.Background(), rejecting))
+	assert.Equal(t, []string{"m0", "m2"}, sent)
+	assert.Equal(t, 0, spool.Depth())
+	assert.Equal(t, int64(1), spool.Stats(time.Now()).Dropped)
+}
+
+func TestSpool_ReplayKeepsOnCancel(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+	require.NoError(t, spool.Append(spoolBatch(0)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceling := func(ctx context.Context, _ []models.Metrics) error {
+		cancel()
+		return ctx.Err()
+	}
+	assert.ErrorIs(t, spool.Replay(ctx, canceling), context.Canceled)
+	assert.Equal(t, 1, spool.Depth())
+}
+
+func TestSpool_Reopen(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 0, 200)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		require.NoError(t, spool.Append(spoolBatch(i)))
+	}
+
+	// Доставляем два пакета и останавливаемся
+	var sent []string
+	n := 0
+	limited := func(_ context.Context, metrics []models.Metrics) error {
+		if n == 2 {
+			return errors.New("server unavailable")
+		}
+		n++
+		sent = append(sent, metrics[0].ID)
+		return nil
+	}
+	assert.Error(t, spool.Replay(context.Background(), limited))
+	require.NoError(t, spool.Close())
+
+	spool, err = OpenSpool(dir, 0, 200)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	assert.Equal(t, 4, spool.Depth())
+	require.NoError(t, spool.Replay(context.Background(), collect(&sent)))
+	assert.Equal(t, []string{"m0", "m1", "m2", "m3", "m4", "m5"}, sent)
+}
+
+func TestSpool_EvictOldest(t *testing.T) {
+	dir := t.TempDir()
+
+	// В сегмент помещаются две записи, в спул - четыре
+	spool, err := OpenSpool(dir, 240, 120)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, spool.Append(spoolBatch(i)))
+	}
+
+	stats := spool.Stats(time.Now())
+	assert.LessOrEqual(t, stats.Bytes, int64(240))
+	assert.Equal(t, int64(10-stats.Depth), stats.Dropped)
+
+	var sent []string
+	require.NoError(t, spool.Replay(context.Background(), collect(&sent)))
+	require.NotEmpty(t, sent)
+	assert.Equal(t, "m9", sent[len(sent)-1])
+	assert.NotEqual(t, "m0", sent[0])
+}
+
+func TestSpool_TornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := OpenSpool(dir, 0, 0)
+	require.NoError(t, err)
+	require.NoError(t, spool.Append(spoolBatch(0)))
+	require.NoError(t, spool.Append(spoolBatch(1)))
+	require.NoError(t, spool.Close())
+
+	// Имитируем запись, прерванную аварийным завершением
+	segments, err := filepath.Glob(filepath.Join(dir, "*"+spoolSegmentExt))
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+	f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 100, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	spool, err = OpenSpool(dir, 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	assert.Equal(t, 2, spool.Depth())
+	require.NoError(t, spool.Append(spoolBatch(2)))
+
+	var sent []string
+	require.NoError(t, spool.Replay(context.Background(), collect(&sent)))
+	assert.Equal(t, []string{"m0", "m1", "m2"}, sent)
+}
+
+func TestSpool_Stats(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	assert.Equal(t, SpoolStats{}, spool.Stats(time.Now()))
+
+	require.NoError(t, spool.Append(spoolBatch(0)))
+	stats := spool.Stats(time.Now().Add(time.Minute))
+	assert.Equal(t, 1, stats.Depth)
+	assert.Greater(t, stats.Bytes, int64(0))
+	assert.GreaterOrEqual(t, stats.Age, 59*time.Second)
+}
+
+func TestAgent_SendBatchSpool(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == http.StatusOK {
+			received++
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	agent, err := NewAgent(Config{ServerAddr: server.URL[7:], RateLimit: 1})
+	require.NoError(t, err)
+	agent.spool = spool
+
+	ctx := context.Background()
+	assert.ErrorIs(t, agent.sendBatch(ctx, spoolBatch(0)), ErrSpooled)
+	assert.Equal(t, 1, spool.Depth())
+
+	// Пока спул не пуст, новые пакеты сохраняются в спул
+	status = http.StatusOK
+	assert.ErrorIs(t, agent.sendBatch(ctx, spoolBatch(1)), ErrSpooled)
+	assert.Equal(t, 0, received)
+
+	require.NoError(t, spool.Replay(ctx, agent.reporter.SendBatch))
+	assert.Equal(t, 2, received)
+
+	assert.NoError(t, agent.sendBatch(ctx, spoolBatch(2)))
+	assert.Equal(t, 3, received)
+}
+
+func TestAgent_SendBatchRejected(t *testing.T) {
+	var requests, received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received++
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	spool, err := OpenSpool(t.TempDir(), 0, 0)
+	require.NoError(t, err)
+	defer spool.Close()
+
+	agent, err := NewAgent(Config{ServerAddr: server.URL[7:], RateLimit: 1})
+	require.NoError(t, err)
+	agent.spool = spool
+
+	// Отклоненный пакет не сохраняется в спул и не задерживает следующие
+	ctx := context.Background()
+	var statusErr *StatusError
+	require.ErrorAs(t, agent.sendBatch(ctx, spoolBatch(0)), &statusErr)
+	assert.Equal(t, http.StatusBadRequest, statusErr.Code)
+	assert.Equal(t, 0, spool.Depth())
+
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, agent.sendBatch(ctx, spoolBatch(i)))
+	}
+	assert.Equal(t, 3, received)
+	assert.Equal(t, 0, spool.Depth())
+}
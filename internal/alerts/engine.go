@@ -0,0 +1,262 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// State - состояние оповещения.
+type State string
+
+const (
+	// StateInactive - условие правила не выполняется.
+	StateInactive State = "inactive"
+	// StatePending - условие выполняется, но меньше времени, указанного в for.
+	StatePending State = "pending"
+	// StateFiring - условие выполняется дольше времени, указанного в for.
+	StateFiring State = "firing"
+	// StateResolved - условие перестало выполняться после срабатывания.
+	StateResolved State = "resolved"
+)
+
+// Alert описывает текущее состояние оповещения по правилу.
+type Alert struct {
+	Name       string     `json:"name"`                  // имя правила
+	Expr       string     `json:"expr"`                  // выражение правила
+	State      State      `json:"state"`                 // текущее состояние
+	Value      *float64   `json:"value,omitempty"`       // последнее вычисленное значение
+	ActiveAt   *time.Time `json:"active_at,omitempty"`   // когда условие начало выполняться
+	FiredAt    *time.Time `json:"fired_at,omitempty"`    // когда оповещение сработало
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"` // когда оповещение было снято
+}
+
+// Engine периодически вычисляет правила по данным хранилища метрик,
+// отслеживает состояние оповещений и отправляет уведомления
+// при срабатывании и снятии оповещений.
+//
+// Пример использования:
+//
+//	rules, _ := LoadRules("alerts.rules")
+//	engine := NewEngine(storage, rules, NewWebhookNotifier([]string{"http://hooks/alert"}), 15*time.Second)
+//	go engine.Run(ctx)
+type Engine struct {
+	mu       sync.RWMutex
+	sp       storage.StorageProvider
+	rules    []Rule
+	alerts   []Alert
+	notifier Notifier
+	interval time.Duration
+}
+
+// NewEngine создает движок оповещений. Если notifier равен nil,
+// уведомления не отправляются.
+func NewEngine(sp storage.StorageProvider, rules []Rule, notifier Notifier, interval time.Duration) *Engine {
+	alerts := make([]Alert, len(rules))
+	for i, rule := range rules {
+		alerts[i] = Alert{Name: rule.Name, Expr: rule.Expr, State: StateInactive}
+	}
+
+	return &Engine{
+		sp:       sp,
+		rules:    rules,
+		alerts:   alerts,
+		notifier: notifier,
+		interval: interval,
+	}
+}
+
+// Run вычисляет правила с интервалом, заданным при создании движка,
+// до отмены контекста.
+func (e *Engine) Run(ctx context.Context) {
+	if len(e.rules) == 0 || e.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			e.Evaluate(ctx, now)
+		}
+	}
+}
+
+// Evaluate однократно вычисляет все правила на момент времени now,
+// обновляет состояние оповещений и отправляет уведомления об их изменении.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) {
+	var notifications []Notification
+
+	e.mu.Lock()
+	for i, rule := range e.rules {
+		value, ok, err := e.value(ctx, rule, now)
+		if err != nil {
+			log.Printf("alerts: evaluate rule %q: %v", rule.Name, err)
+			continue
+		}
+
+		a := &e.alerts[i]
+		if ok {
+			v := value
+			a.Value = &v
+		} else {
+			a.Value = nil
+		}
+
+		if ok && rule.Op.Compare(value, rule.Threshold) {
+			if a.State == StateInactive || a.State == StateResolved {
+				a.State = StatePending
+				a.ActiveAt = timePtr(now)
+				a.FiredAt = nil
+				a.ResolvedAt = nil
+			}
+			if a.State == StatePending && now.Sub(*a.ActiveAt) >= rule.For {
+				a.State = StateFiring
+				a.FiredAt = timePtr(now)
+				notifications = append(notifications, Notification{Status: StateFiring, Alert: *a})
+			}
+			continue
+		}
+
+		switch a.State {
+		case StatePending:
+			a.State = StateInactive
+			a.ActiveAt = nil
+		case StateFiring:
+			a.State = StateResolved
+			a.ResolvedAt = timePtr(now)
+			notifications = append(notifications, Notification{Status: StateResolved, Alert: *a})
+		}
+	}
+	e.mu.Unlock()
+
+	if e.notifier == nil {
+		return
+	}
+	for _, n := range notifications {
+		if err := e.notifier.Notify(ctx, n); err != nil {
+			log.Printf("alerts: notify %q: %v", n.Alert.Name, err)
+		}
+	}
+}
+
+// Alerts возвращает копию текущего состояния всех оповещений.
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	alerts := make([]Alert, len(e.alerts))
+	copy(alerts, e.alerts)
+	return alerts
+}
+
+// ServeHTTP возвращает текущее состояние оповещений в формате JSON.
+//
+// URL: /api/v1/alerts
+//
+// Формат ответа:
+//
+//	[
+//		{
+//			"name": "HighHeap",
+//			"expr": "HeapAlloc > 5e8 for 2m",
+//			"state": "firing",
+//			"value": 612345678,
+//			"active_at": "2024-01-01T10:00:00Z",
+//			"fired_at": "2024-01-01T10:02:00Z"
+//		}
+//	]
+//
+// HTTP статусы:
+//   - 200: состояние успешно возвращено
+//   - 405: неверный HTTP метод (ожидается GET)
+func (e *Engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(e.Alerts()); err != nil {
+		return
+	}
+}
+
+// value вычисляет значение левой части правила. Второе возвращаемое значение
+// равно false, если метрика отсутствует в хранилище.
+func (e *Engine) value(ctx context.Context, rule Rule, now time.Time) (float64, bool, error) {
+	mtype, current, ok, err := e.lookup(ctx, rule.Metric)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if !rule.Rate {
+		return current, true, nil
+	}
+
+	samples, err := e.sp.QueryRange(ctx, mtype, rule.Metric, now.Add(-rule.Window), now)
+	if err != nil {
+		return 0, false, err
+	}
+	return rate(samples), true, nil
+}
+
+// lookup ищет метрику name среди gauge, затем среди counter метрик.
+// Третье возвращаемое значение равно false, если метрика не найдена.
+func (e *Engine) lookup(ctx context.Context, name string) (storage.MetricType, float64, bool, error) {
+	g, err := e.sp.GetGauge(ctx, name)
+	if err == nil {
+		return storage.MetricTypeGauge, float64(g), true, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return "", 0, false, err
+	}
+
+	c, err := e.sp.GetCounter(ctx, name)
+	if err == nil {
+		return storage.MetricTypeCounter, float64(c), true, nil
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return "", 0, false, err
+	}
+	return "", 0, false, nil
+}
+
+// rate вычисляет скорость роста значений в секунду. Уменьшение значения
+// считается сбросом счетчика. Если значений меньше двух, скорость равна нулю.
+func rate(samples []storage.Sample) float64 {
+	if len(samples) < 2 {
+		return 0
+	}
+
+	var increase float64
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i].Value - samples[i-1].Value
+		if delta < 0 {
+			delta = samples[i].Value
+		}
+		increase += delta
+	}
+
+	elapsed := samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return increase / elapsed
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
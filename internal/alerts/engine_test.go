@@ -0,0 +1,204 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type webhookStub struct {
+	mu            sync.Mutex
+	notifications []Notification
+	server        *httptest.Server
+}
+
+func newWebhookStub(t *testing.T) *webhookStub {
+	stub := &webhookStub{}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		var n Notification
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+
+		stub.mu.Lock()
+		stub.notifications = append(stub.notifications, n)
+		stub.mu.Unlock()
+
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(stub.server.Close)
+	return stub
+}
+
+func (s *webhookStub) received() []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Notification(nil), s.notifications...)
+}
+
+func TestEngine_ThresholdLifecycle(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	stub := newWebhookStub(t)
+
+	rule, err := ParseRule("HighHeap: HeapAlloc > 100 for 2m")
+	require.NoError(t, err)
+
+	engine := NewEngine(ms, []Rule{rule}, NewWebhookNotifier([]string{stub.server.URL}), time.Second)
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// Метрики нет - правило неактивно
+	engine.Evaluate(ctx, now)
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+	assert.Nil(t, engine.Alerts()[0].Value)
+
+	// Условие выполнено - ожидание
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(200))
+	engine.Evaluate(ctx, now)
+	alert := engine.Alerts()[0]
+	assert.Equal(t, StatePending, alert.State)
+	require.NotNil(t, alert.Value)
+	assert.Equal(t, 200.0, *alert.Value)
+	assert.Equal(t, now, *alert.ActiveAt)
+
+	engine.Evaluate(ctx, now.Add(time.Minute))
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+	assert.Empty(t, stub.received())
+
+	// Условие выполняется дольше for - срабатывание
+	engine.Evaluate(ctx, now.Add(2*time.Minute))
+	alert = engine.Alerts()[0]
+	assert.Equal(t, StateFiring, alert.State)
+	assert.Equal(t, now.Add(2*time.Minute), *alert.FiredAt)
+
+	engine.Evaluate(ctx, now.Add(3*time.Minute))
+	require.Len(t, stub.received(), 1)
+	assert.Equal(t, StateFiring, stub.received()[0].Status)
+	assert.Equal(t, "HighHeap", stub.received()[0].Alert.Name)
+
+	// Условие перестало выполняться - снятие
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(50))
+	engine.Evaluate(ctx, now.Add(4*time.Minute))
+	alert = engine.Alerts()[0]
+	assert.Equal(t, StateResolved, alert.State)
+	assert.Equal(t, now.Add(4*time.Minute), *alert.ResolvedAt)
+
+	notifications := stub.received()
+	require.Len(t, notifications, 2)
+	assert.Equal(t, StateResolved, notifications[1].Status)
+}
+
+func TestEngine_PendingResetsWithoutNotification(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	stub := newWebhookStub(t)
+
+	rule, err := ParseRule("HeapAlloc > 100 for 2m")
+	require.NoError(t, err)
+
+	engine := NewEngine(ms, []Rule{rule}, NewWebhookNotifier([]string{stub.server.URL}), time.Second)
+	now := time.Now()
+
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(200))
+	engine.Evaluate(ctx, now)
+	assert.Equal(t, StatePending, engine.Alerts()[0].State)
+
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(10))
+	engine.Evaluate(ctx, now.Add(time.Minute))
+	assert.Equal(t, StateInactive, engine.Alerts()[0].State)
+	assert.Nil(t, engine.Alerts()[0].ActiveAt)
+	assert.Empty(t, stub.received())
+}
+
+func TestEngine_Rate(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	ms.Counters.Set("PollCount", 30)
+	ms.CountersHistory.Append("PollCount", now.Add(-50*time.Second), 10)
+	ms.CountersHistory.Append("PollCount", now.Add(-30*time.Second), 20)
+	ms.CountersHistory.Append("PollCount", now.Add(-10*time.Second), 30)
+
+	rule, err := ParseRule("rate(PollCount) > 0.4")
+	require.NoError(t, err)
+
+	engine := NewEngine(ms, []Rule{rule}, nil, time.Second)
+	engine.Evaluate(ctx, now)
+
+	alert := engine.Alerts()[0]
+	require.NotNil(t, alert.Value)
+	assert.InDelta(t, 0.5, *alert.Value, 1e-9)
+	assert.Equal(t, StateFiring, alert.State)
+
+	// Новых значений нет - скорость роста равна нулю
+	rule, err = ParseRule("rate(PollCount) == 0")
+	require.NoError(t, err)
+
+	engine = NewEngine(ms, []Rule{rule}, nil, time.Second)
+	engine.Evaluate(ctx, now.Add(5*time.Minute))
+	assert.Equal(t, StateFiring, engine.Alerts()[0].State)
+}
+
+func TestRate_CounterReset(t *testing.T) {
+	base := time.Now()
+	samples := []storage.Sample{
+		{Timestamp: base, Value: 10},
+		{Timestamp: base.Add(10 * time.Second), Value: 20},
+		{Timestamp: base.Add(20 * time.Second), Value: 5},
+	}
+	assert.InDelta(t, 0.75, rate(samples), 1e-9)
+	assert.Equal(t, 0.0, rate(samples[:1]))
+}
+
+func TestEngine_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(200))
+
+	rule, err := ParseRule("HighHeap: HeapAlloc > 100")
+	require.NoError(t, err)
+
+	engine := NewEngine(ms, []Rule{rule}, nil, time.Second)
+	engine.Evaluate(ctx, time.Now())
+
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/alerts", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var alerts []Alert
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &alerts))
+	require.Len(t, alerts, 1)
+	assert.Equal(t, "HighHeap", alerts[0].Name)
+	assert.Equal(t, StateFiring, alerts[0].State)
+
+	w = httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/alerts", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestWebhookNotifier_Errors(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	stub := newWebhookStub(t)
+
+	notifier := NewWebhookNotifier([]string{failing.URL, stub.server.URL})
+	err := notifier.Notify(context.Background(), Notification{Status: StateFiring, Alert: Alert{Name: "test"}})
+
+	assert.ErrorContains(t, err, "bad status 500")
+	assert.Len(t, stub.received(), 1)
+}
@@ -0,0 +1,90 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// defaultWebhookTimeout - таймаут одного запроса к вебхуку.
+const defaultWebhookTimeout = 5 * time.Second
+
+// Notification - уведомление об изменении состояния оповещения.
+//
+// Формат JSON:
+//
+//	{
+//		"status": "firing",
+//		"alert": {
+//			"name": "HighHeap",
+//			"expr": "HeapAlloc > 5e8 for 2m",
+//			"state": "firing",
+//			"value": 612345678,
+//			"active_at": "2024-01-01T10:00:00Z",
+//			"fired_at": "2024-01-01T10:02:00Z"
+//		}
+//	}
+type Notification struct {
+	Status State `json:"status"` // firing или resolved
+	Alert  Alert `json:"alert"`  // состояние оповещения на момент уведомления
+}
+
+// Notifier отправляет уведомления об изменении состояния оповещений.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier отправляет уведомления POST запросами с телом в формате JSON
+// на каждый из настроенных URL.
+type WebhookNotifier struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewWebhookNotifier создает WebhookNotifier для указанных URL.
+func NewWebhookNotifier(urls []string) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:   urls,
+		client: &http.Client{Timeout: defaultWebhookTimeout},
+	}
+}
+
+// Notify отправляет уведомление на все вебхуки. Ошибка доставки на один из URL
+// не прерывает отправку на остальные; возвращаются все накопленные ошибки.
+func (n *WebhookNotifier) Notify(ctx context.Context, notification Notification) error {
+	data, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, url := range n.urls {
+		if err := n.post(ctx, url, data); err != nil {
+			errs = append(errs, fmt.Errorf("webhook %s: %w", url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *WebhookNotifier) post(ctx context.Context, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("bad status %d", resp.StatusCode)
+	}
+	return nil
+}
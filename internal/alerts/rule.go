@@ -0,0 +1,189 @@
+// Package alerts реализует подсистему оповещений по пороговым правилам.
+// Правила загружаются из файла, периодически вычисляются по данным хранилища
+// метрик, а изменения состояния оповещений отправляются на вебхуки в формате JSON.
+package alerts
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// DefaultRateWindow - окно вычисления rate(), если оно не указано в правиле явно.
+const DefaultRateWindow = time.Minute
+
+// Operator - оператор сравнения значения метрики с порогом.
+type Operator string
+
+const (
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpEqual        Operator = "=="
+	OpNotEqual     Operator = "!="
+)
+
+// Compare применяет оператор к значению и порогу.
+func (op Operator) Compare(value, threshold float64) bool {
+	switch op {
+	case OpGreater:
+		return value > threshold
+	case OpGreaterEqual:
+		return value >= threshold
+	case OpLess:
+		return value < threshold
+	case OpLessEqual:
+		return value <= threshold
+	case OpEqual:
+		return value == threshold
+	case OpNotEqual:
+		return value != threshold
+	}
+	return false
+}
+
+// Rule описывает пороговое правило оповещения.
+//
+// Текстовый формат правила:
+//
+//	[<имя>: ]<метрика> <оператор> <порог> [for <длительность>]
+//	[<имя>: ]rate(<метрика>[<окно>]) <оператор> <порог> [for <длительность>]
+//
+// Метрика может быть указана с метками: HeapAlloc{host="web-1"}.
+//
+// Примеры:
+//
+//	HeapAlloc > 5e8 for 2m
+//	AgentDown: rate(PollCount) == 0 for 1m
+//	rate(PollCount[5m]) < 0.1
+//	HeapAlloc{host="web-1"} > 5e8
+//
+// Метрика ищется сначала среди gauge, затем среди counter.
+// rate() вычисляет скорость роста counter в секунду по истории значений за окно
+// (по умолчанию DefaultRateWindow).
+type Rule struct {
+	Name      string        // имя правила, по умолчанию совпадает с выражением
+	Expr      string        // исходное выражение правила
+	Metric    string        // ключ серии метрики (имя с метками)
+	Rate      bool          // вычислять ли скорость роста метрики
+	Window    time.Duration // окно вычисления rate()
+	Op        Operator      // оператор сравнения
+	Threshold float64       // порог
+	For       time.Duration // сколько условие должно выполняться до срабатывания
+}
+
+// metricPattern - имя метрики с необязательными метками: HeapAlloc{host="web-1"}.
+const metricPattern = `[^\s()\[\]<>=!{}]+(?:\{[^}]*\})?`
+
+var (
+	ruleNameRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*):\s+(.+)$`)
+	ruleRe     = regexp.MustCompile(`^(?:(rate)\(\s*(` + metricPattern + `)\s*(?:\[([^\]]+)\])?\s*\)|(` + metricPattern + `))\s*(>=|<=|==|!=|>|<)\s*(\S+)(?:\s+for\s+(\S+))?$`)
+)
+
+// ParseRule разбирает правило из текстового формата, описанного в Rule.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+
+	var rule Rule
+	if m := ruleNameRe.FindStringSubmatch(s); m != nil {
+		rule.Name = m[1]
+		s = strings.TrimSpace(m[2])
+	}
+	rule.Expr = s
+	if rule.Name == "" {
+		rule.Name = s
+	}
+
+	m := ruleRe.FindStringSubmatch(s)
+	if m == nil {
+		return Rule{}, fmt.Errorf("invalid rule %q", s)
+	}
+
+	metric := m[4]
+	if m[1] != "" {
+		metric = m[2]
+		rule.Rate = true
+		rule.Window = DefaultRateWindow
+		if m[3] != "" {
+			w, err := time.ParseDuration(m[3])
+			if err != nil || w <= 0 {
+				return Rule{}, fmt.Errorf("invalid rate window in rule %q", s)
+			}
+			rule.Window = w
+		}
+	}
+
+	name, labels, err := storage.ParseSeriesKey(metric)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid metric in rule %q: %w", s, err)
+	}
+	if err := storage.ValidateSeries(name, labels); err != nil {
+		return Rule{}, fmt.Errorf("invalid metric in rule %q: %w", s, err)
+	}
+	rule.Metric = storage.SeriesKey(name, labels)
+
+	rule.Op = Operator(m[5])
+
+	threshold, err := strconv.ParseFloat(m[6], 64)
+	if err != nil {
+		return Rule{}, fmt.Errorf("invalid threshold in rule %q: %w", s, err)
+	}
+	rule.Threshold = threshold
+
+	if m[7] != "" {
+		d, err := time.ParseDuration(m[7])
+		if err != nil || d < 0 {
+			return Rule{}, fmt.Errorf("invalid duration in rule %q", s)
+		}
+		rule.For = d
+	}
+
+	return rule, nil
+}
+
+// ParseRules читает правила из r, по одному на строку.
+// Пустые строки и строки, начинающиеся с "#", пропускаются.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		rule, err := ParseRule(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// LoadRules загружает правила из файла.
+func LoadRules(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseRules(f)
+}
@@ -0,0 +1,120 @@
+package alerts
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Rule
+	}{
+		{
+			name:  "gauge_with_for",
+			input: "HeapAlloc > 5e8 for 2m",
+			expected: Rule{
+				Name: "HeapAlloc > 5e8 for 2m", Expr: "HeapAlloc > 5e8 for 2m",
+				Metric: "HeapAlloc", Op: OpGreater, Threshold: 5e8, For: 2 * time.Minute,
+			},
+		},
+		{
+			name:  "named_rate",
+			input: "AgentDown: rate(PollCount) == 0 for 1m",
+			expected: Rule{
+				Name: "AgentDown", Expr: "rate(PollCount) == 0 for 1m",
+				Metric: "PollCount", Rate: true, Window: DefaultRateWindow,
+				Op: OpEqual, Threshold: 0, For: time.Minute,
+			},
+		},
+		{
+			name:  "rate_with_window",
+			input: "rate(PollCount[5m]) <= 0.1",
+			expected: Rule{
+				Name: "rate(PollCount[5m]) <= 0.1", Expr: "rate(PollCount[5m]) <= 0.1",
+				Metric: "PollCount", Rate: true, Window: 5 * time.Minute,
+				Op: OpLessEqual, Threshold: 0.1,
+			},
+		},
+		{
+			name:  "with_labels",
+			input: `rate(PollCount{host="web-1",dc="eu"}[2m]) > 1`,
+			expected: Rule{
+				Name: `rate(PollCount{host="web-1",dc="eu"}[2m]) > 1`, Expr: `rate(PollCount{host="web-1",dc="eu"}[2m]) > 1`,
+				Metric: `PollCount{dc="eu",host="web-1"}`, Rate: true, Window: 2 * time.Minute,
+				Op: OpGreater, Threshold: 1,
+			},
+		},
+		{
+			name:  "no_spaces",
+			input: "RandomValue!=1",
+			expected: Rule{
+				Name: "RandomValue!=1", Expr: "RandomValue!=1",
+				Metric: "RandomValue", Op: OpNotEqual, Threshold: 1,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := ParseRule(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, rule)
+		})
+	}
+}
+
+func TestParseRule_Invalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"HeapAlloc",
+		"HeapAlloc >",
+		"HeapAlloc > high",
+		"HeapAlloc > 1 for ever",
+		"HeapAlloc => 1",
+		"rate(PollCount[abc]) > 1",
+		"avg(PollCount) > 1",
+		`HeapAlloc{1host="web-1"} > 1`,
+		`HeapAlloc{host=web-1} > 1`,
+	}
+
+	for _, s := range invalid {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseRule(s)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	input := `
+# Память
+HighHeap: HeapAlloc > 5e8 for 2m
+
+AgentDown: rate(PollCount) == 0 for 1m
+`
+	rules, err := ParseRules(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, "HighHeap", rules[0].Name)
+	assert.Equal(t, "AgentDown", rules[1].Name)
+
+	_, err = ParseRules(strings.NewReader("HeapAlloc > 1\nbroken rule\n"))
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestOperator_Compare(t *testing.T) {
+	assert.True(t, OpGreater.Compare(2, 1))
+	assert.False(t, OpGreater.Compare(1, 1))
+	assert.True(t, OpGreaterEqual.Compare(1, 1))
+	assert.True(t, OpLess.Compare(0, 1))
+	assert.True(t, OpLessEqual.Compare(1, 1))
+	assert.True(t, OpEqual.Compare(1, 1))
+	assert.True(t, OpNotEqual.Compare(1, 2))
+	assert.False(t, Operator("~").Compare(1, 1))
+}
@@ -0,0 +1,230 @@
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// DefaultIdleTimeout - время по умолчанию, после которого закрывается
+// соединение без данных.
+const DefaultIdleTimeout = time.Minute
+
+const (
+	// maxLineSize - размер буфера чтения соединения и максимальная длина строки.
+	maxLineSize = 64 * 1024
+
+	// maxBatchSize - максимальное число метрик в одной записи в хранилище.
+	maxBatchSize = 1000
+)
+
+// Listener принимает метрики Graphite по TCP и записывает их в хранилище.
+// Метрики соединения записываются пакетом, когда прочитаны все полученные
+// данные (но не более maxBatchSize за раз). Время значений проверяется,
+// но в хранилище, как и для остальных способов записи, сохраняется время
+// получения.
+//
+// Пример использования:
+//
+//	l := graphite.NewListener(storage, ":2003", nil, time.Minute)
+//	if err := l.Listen(); err != nil {
+//		return err
+//	}
+//	go l.Serve(ctx)
+type Listener struct {
+	sp          storage.StorageProvider
+	addr        string
+	templates   []Template
+	idleTimeout time.Duration
+
+	ln      net.Listener
+	conns   sync.WaitGroup
+	connsMu sync.Mutex
+	active  map[net.Conn]struct{}
+
+	parseErrors atomic.Int64
+}
+
+// NewListener создает Listener, принимающий метрики на адресе addr
+// и разбирающий пути по правилам templates. Соединение, по которому
+// за idleTimeout (по умолчанию DefaultIdleTimeout) не пришло данных,
+// закрывается.
+func NewListener(sp storage.StorageProvider, addr string, templates []Template, idleTimeout time.Duration) *Listener {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	return &Listener{
+		sp:          sp,
+		addr:        addr,
+		templates:   templates,
+		idleTimeout: idleTimeout,
+		active:      make(map[net.Conn]struct{}),
+	}
+}
+
+// Listen открывает TCP сокет на адресе, заданном при создании.
+func (l *Listener) Listen() error {
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return fmt.Errorf("listen tcp: %w", err)
+	}
+	l.ln = ln
+	return nil
+}
+
+// Addr возвращает адрес открытого сокета.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// Serve принимает соединения до отмены контекста, после чего закрывает
+// сокет и открытые соединения и дожидается записи полученных по ним
+// метрик. Listen должен быть вызван заранее.
+func (l *Listener) Serve(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.accept()
+	}()
+
+	<-ctx.Done()
+	l.ln.Close()
+	<-done
+
+	l.connsMu.Lock()
+	for conn := range l.active {
+		conn.Close()
+	}
+	l.connsMu.Unlock()
+	l.conns.Wait()
+}
+
+// ParseErrors возвращает количество строк, которые не удалось разобрать.
+func (l *Listener) ParseErrors() int64 {
+	return l.parseErrors.Load()
+}
+
+func (l *Listener) accept() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("graphite: accept tcp: %v", err)
+			}
+			return
+		}
+
+		l.connsMu.Lock()
+		l.active[conn] = struct{}{}
+		l.connsMu.Unlock()
+
+		l.conns.Add(1)
+		go func() {
+			defer l.conns.Done()
+			defer func() {
+				l.connsMu.Lock()
+				delete(l.active, conn)
+				l.connsMu.Unlock()
+				conn.Close()
+			}()
+			l.handleConn(conn)
+		}()
+	}
+}
+
+// handleConn читает строки соединения до его закрытия, ошибки или
+// истечения времени ожидания. Строка длиннее maxLineSize закрывает
+// соединение.
+func (l *Listener) handleConn(conn net.Conn) {
+	var batch []models.Metrics
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.Write(context.Background(), batch); err != nil {
+			log.Printf("graphite: write: %v", err)
+		}
+		batch = nil
+	}
+	defer flush()
+
+	r := bufio.NewReaderSize(conn, maxLineSize)
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(l.idleTimeout)); err != nil {
+			return
+		}
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			l.parseErrors.Add(1)
+			log.Printf("graphite: %s: line exceeds %d bytes, closing connection", conn.RemoteAddr(), maxLineSize)
+			return
+		}
+
+		if s := strings.TrimSpace(string(line)); s != "" {
+			m, perr := l.Metric(s)
+			if perr != nil {
+				l.parseErrors.Add(1)
+			} else {
+				batch = append(batch, m)
+			}
+		}
+		if err != nil {
+			return
+		}
+		if len(batch) >= maxBatchSize || r.Buffered() == 0 {
+			flush()
+		}
+	}
+}
+
+// Metric разбирает строку Graphite и возвращает метрику для записи
+// в хранилище. Значение counter метрики - прирост, оно округляется до целого.
+func (l *Listener) Metric(line string) (models.Metrics, error) {
+	parsed, err := ParseLine(line)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	name, labels, mtype, err := Metric(l.templates, parsed.Path)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	m := models.Metrics{ID: name, MType: mtype, Labels: labels}
+	switch mtype {
+	case storage.MetricTypeCounter:
+		if math.Abs(parsed.Value) >= math.MaxInt64 {
+			return models.Metrics{}, fmt.Errorf("%w: value %v is out of counter range", ErrParse, parsed.Value)
+		}
+		delta := int64(math.Round(parsed.Value))
+		m.Delta = &delta
+	default:
+		value := parsed.Value
+		m.Value = &value
+	}
+	return m, nil
+}
+
+// Write записывает метрики в хранилище одним пакетом. Если пакет отклонен
+// хранилищем как некорректный, метрики записываются по одной, чтобы ошибка
+// одной серии не отбросила остальные.
+func (l *Listener) Write(ctx context.Context, metrics []models.Metrics) error {
+	var errs []error
+	err := storage.UpdateBatchOrEach(ctx, l.sp, metrics, func(m models.Metrics, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", storage.SeriesKey(m.ID, m.Labels), err))
+	})
+	if err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,110 @@
+package graphite
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener_Metric(t *testing.T) {
+	templates, err := ParseTemplates("jobs.* .name counter")
+	require.NoError(t, err)
+	l := NewListener(memstorage.NewStorage(), "", templates, time.Minute)
+
+	m, err := l.Metric("jobs.processed 2.6 1700000000")
+	require.NoError(t, err)
+	delta := int64(3)
+	assert.Equal(t, models.Metrics{ID: "processed", MType: storage.MetricTypeCounter, Delta: &delta}, m)
+
+	m, err = l.Metric("servers.web-1.load 0.5")
+	require.NoError(t, err)
+	value := 0.5
+	assert.Equal(t, models.Metrics{ID: "servers.web-1.load", MType: storage.MetricTypeGauge, Value: &value}, m)
+
+	_, err = l.Metric("jobs.processed 1e30")
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestListener_Serve(t *testing.T) {
+	ms := memstorage.NewStorage()
+	templates, err := ParseTemplates("servers.* .host.name; jobs.* .name counter")
+	require.NoError(t, err)
+	l := NewListener(ms, "127.0.0.1:0", templates, time.Minute)
+	require.NoError(t, l.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Serve(ctx)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("servers.web-1.load 0.5 1700000000\njobs.processed 2\nbad line\njobs.processed 3\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		c, err := ms.GetCounter(context.Background(), "processed")
+		return err == nil && c == 5
+	}, time.Second, 10*time.Millisecond)
+
+	g, err := ms.GetGauge(context.Background(), `load{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(0.5), g)
+	assert.Equal(t, int64(1), l.ParseErrors())
+
+	// Строка без перевода строки записывается при закрытии соединения
+	// во время остановки
+	_, err = conn.Write([]byte("servers.web-2.load 1.5"))
+	require.NoError(t, err)
+	time.Sleep(50 * time.Millisecond)
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not stop")
+	}
+
+	g, err = ms.GetGauge(context.Background(), `load{host="web-2"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1.5), g)
+}
+
+func TestListener_Limits(t *testing.T) {
+	ms := memstorage.NewStorage()
+	l := NewListener(ms, "127.0.0.1:0", nil, 100*time.Millisecond)
+	require.NoError(t, l.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go l.Serve(ctx)
+
+	// Соединение без данных закрывается по истечении времени ожидания
+	idle, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+	require.NoError(t, idle.SetReadDeadline(time.Now().Add(time.Second)))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Слишком длинная строка закрывает соединение
+	long, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer long.Close()
+	_, _ = long.Write([]byte("a." + strings.Repeat("b", maxLineSize) + " 1\n"))
+	require.Eventually(t, func() bool {
+		return l.ParseErrors() == 1
+	}, time.Second, 10*time.Millisecond)
+}
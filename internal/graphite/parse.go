@@ -0,0 +1,66 @@
+// Package graphite реализует прием метрик в текстовом формате Graphite
+// (plaintext protocol) по TCP.
+//
+// Формат строки:
+//
+//	<путь> <значение> [<время>]
+//
+// Пример:
+//
+//	servers.web-1.cpu.idle 98.5 1700000000
+//
+// Путь по умолчанию становится именем gauge метрики. Шаблоны (см. Template)
+// переносят сегменты пути в метки и задают тип метрики.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrParse возвращается для строки, не соответствующей формату Graphite.
+var ErrParse = errors.New("graphite parse error")
+
+// Line - значение из одной строки Graphite.
+type Line struct {
+	Path  string
+	Value float64
+
+	// Time - время значения; нулевое, если оно не указано
+	// или равно -1.
+	Time time.Time
+}
+
+// ParseLine разбирает строку формата Graphite. Время задается
+// Unix-временем в секундах.
+func ParseLine(s string) (Line, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Line{}, fmt.Errorf("%w: expected path, value and optional timestamp in %q", ErrParse, s)
+	}
+
+	l := Line{Path: fields[0]}
+	if strings.HasPrefix(l.Path, ".") || strings.HasSuffix(l.Path, ".") || strings.Contains(l.Path, "..") {
+		return Line{}, fmt.Errorf("%w: invalid path %q", ErrParse, l.Path)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return Line{}, fmt.Errorf("%w: invalid value %q in %q", ErrParse, fields[1], s)
+	}
+	l.Value = value
+
+	if len(fields) == 3 && fields[2] != "-1" {
+		ts, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || ts < 0 || ts > math.MaxInt64/float64(time.Second) {
+			return Line{}, fmt.Errorf("%w: invalid timestamp %q in %q", ErrParse, fields[2], s)
+		}
+		sec, frac := math.Modf(ts)
+		l.Time = time.Unix(int64(sec), int64(frac*float64(time.Second))).UTC()
+	}
+	return l, nil
+}
@@ -0,0 +1,65 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Line
+	}{
+		{
+			name:     "with_time",
+			input:    "servers.web-1.cpu.idle 98.5 1700000000",
+			expected: Line{Path: "servers.web-1.cpu.idle", Value: 98.5, Time: time.Unix(1700000000, 0).UTC()},
+		},
+		{
+			name:     "without_time",
+			input:    "jobs.processed 5",
+			expected: Line{Path: "jobs.processed", Value: 5},
+		},
+		{
+			name:     "unknown_time",
+			input:    "jobs.processed  -2  -1",
+			expected: Line{Path: "jobs.processed", Value: -2},
+		},
+		{
+			name:     "fractional_time",
+			input:    "load 0.5 1700000000.5",
+			expected: Line{Path: "load", Value: 0.5, Time: time.Unix(1700000000, 5e8).UTC()},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			l, err := ParseLine(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, l)
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	invalid := []string{
+		"jobs.processed",
+		"jobs.processed x",
+		"jobs.processed NaN",
+		"jobs.processed 1 x",
+		"jobs.processed 1 -5",
+		"jobs.processed 1 2 3",
+		".jobs 1",
+		"jobs. 1",
+		"jobs..processed 1",
+	}
+
+	for _, s := range invalid {
+		_, err := ParseLine(s)
+		assert.ErrorIs(t, err, ErrParse, s)
+	}
+}
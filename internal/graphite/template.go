@@ -0,0 +1,135 @@
+package graphite
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Template - правило разбора пути. Правило применяется к путям, сегменты
+// которых соответствуют сегментам фильтра (шаблоны path.Match, например *
+// или web-?); путь может быть длиннее фильтра.
+//
+// Сегменты шаблона сопоставляются сегментам пути по порядку: name - часть
+// имени метрики, пустой сегмент - сегмент отбрасывается, любое другое
+// слово - имя метки, значением которой становится сегмент пути. Части имени
+// и значения повторяющейся метки объединяются через точку, сегменты пути
+// за пределами шаблона добавляются к имени.
+//
+// Пример: правило с фильтром servers.*.cpu и шаблоном .host.name.name
+// превращает путь servers.web-1.cpu.idle в метрику cpu.idle с меткой
+// host="web-1".
+type Template struct {
+	Filter   []string
+	Segments []string
+	Type     storage.MetricType
+}
+
+// ParseTemplates разбирает правила, разделенные точкой с запятой, в формате
+// "<фильтр> <шаблон> [gauge|counter]", например
+// "servers.*.cpu .host.name.name; jobs.* .name counter". Тип по умолчанию -
+// gauge. Пустая строка означает отсутствие правил.
+func ParseTemplates(s string) ([]Template, error) {
+	var templates []Template
+	for _, rule := range strings.Split(s, ";") {
+		fields := strings.Fields(rule)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid graphite template %q", rule)
+		}
+
+		t := Template{
+			Filter:   strings.Split(fields[0], "."),
+			Segments: strings.Split(fields[1], "."),
+			Type:     storage.MetricTypeGauge,
+		}
+		for _, f := range t.Filter {
+			if _, err := path.Match(f, ""); err != nil || f == "" {
+				return nil, fmt.Errorf("invalid filter in graphite template %q", rule)
+			}
+		}
+		for _, seg := range t.Segments {
+			if seg == "" || seg == "name" {
+				continue
+			}
+			if err := storage.ValidateSeries("graphite", map[string]string{seg: ""}); err != nil {
+				return nil, fmt.Errorf("invalid label in graphite template %q: %w", rule, err)
+			}
+		}
+		if len(fields) == 3 {
+			t.Type = storage.MetricType(fields[2])
+			if t.Type != storage.MetricTypeGauge && t.Type != storage.MetricTypeCounter {
+				return nil, fmt.Errorf("invalid metric type %q in graphite template %q", t.Type, rule)
+			}
+		}
+		templates = append(templates, t)
+	}
+	return templates, nil
+}
+
+// Match сообщает, применяется ли правило к пути, разбитому на сегменты.
+func (t Template) Match(segments []string) bool {
+	if len(segments) < len(t.Filter) {
+		return false
+	}
+	for i, f := range t.Filter {
+		if ok, _ := path.Match(f, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply возвращает имя и метки метрики для пути, разбитого на сегменты.
+func (t Template) Apply(segments []string) (string, map[string]string) {
+	var (
+		name   []string
+		labels map[string]string
+	)
+	for i, seg := range segments {
+		if i >= len(t.Segments) {
+			name = append(name, seg)
+			continue
+		}
+		switch key := t.Segments[i]; key {
+		case "":
+		case "name":
+			name = append(name, seg)
+		default:
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			if v, ok := labels[key]; ok {
+				seg = v + "." + seg
+			}
+			labels[key] = seg
+		}
+	}
+	return strings.Join(name, "."), labels
+}
+
+// Metric возвращает имя, метки и тип метрики для пути p по первому
+// подходящему правилу из templates. Если подходящих правил нет, путь
+// становится именем gauge метрики.
+func Metric(templates []Template, p string) (string, map[string]string, storage.MetricType, error) {
+	segments := strings.Split(p, ".")
+	for _, t := range templates {
+		if !t.Match(segments) {
+			continue
+		}
+		name, labels := t.Apply(segments)
+		if err := storage.ValidateSeries(name, labels); err != nil {
+			return "", nil, "", fmt.Errorf("%w: path %s: %w", ErrParse, p, err)
+		}
+		return name, labels, t.Type, nil
+	}
+
+	if err := storage.ValidateSeries(p, nil); err != nil {
+		return "", nil, "", fmt.Errorf("%w: %w", ErrParse, err)
+	}
+	return p, nil, storage.MetricTypeGauge, nil
+}
@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetric(t *testing.T) {
+	templates, err := ParseTemplates("servers.*.cpu .host.name.name; jobs.* .name counter; dc.* .dc.dc.name")
+	require.NoError(t, err)
+	require.Len(t, templates, 3)
+
+	testCases := []struct {
+		name   string
+		path   string
+		id     string
+		labels map[string]string
+		mtype  storage.MetricType
+	}{
+		{"labels", "servers.web-1.cpu.idle", "cpu.idle", map[string]string{"host": "web-1"}, storage.MetricTypeGauge},
+		{"extra_segments", "servers.web-1.cpu.core0.idle", "cpu.core0.idle", map[string]string{"host": "web-1"}, storage.MetricTypeGauge},
+		{"counter", "jobs.backup", "backup", nil, storage.MetricTypeCounter},
+		{"repeated_label", "dc.eu.west.load", "load", map[string]string{"dc": "eu.west"}, storage.MetricTypeGauge},
+		{"no_match", "servers.web-1.mem.used", "servers.web-1.mem.used", nil, storage.MetricTypeGauge},
+		{"shorter_than_filter", "jobs", "jobs", nil, storage.MetricTypeGauge},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			id, labels, mtype, err := Metric(templates, tc.path)
+			require.NoError(t, err)
+			assert.Equal(t, tc.id, id)
+			assert.Equal(t, tc.labels, labels)
+			assert.Equal(t, tc.mtype, mtype)
+		})
+	}
+
+	// Шаблон без сегментов имени
+	_, _, _, err = Metric(templates, "dc.eu")
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestParseTemplates_Invalid(t *testing.T) {
+	for _, s := range []string{
+		"servers.*",
+		"servers.* .host.name histogram",
+		"servers.* .host.name gauge extra",
+		"servers.[ .name",
+		"servers..cpu .name",
+		"servers.* .1host.name",
+	} {
+		_, err := ParseTemplates(s)
+		assert.Error(t, err, s)
+	}
+
+	templates, err := ParseTemplates(" ; ")
+	require.NoError(t, err)
+	assert.Empty(t, templates)
+}
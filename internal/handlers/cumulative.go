@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// cumulativeCounters преобразует накопленные значения counter метрик
+// (поля Influx, серии *_total Prometheus remote_write) в приросты
+// относительно предыдущего значения той же серии.
+//
+// Предыдущим значением считается последнее записанное накопленное значение
+// серии, а если его нет или серии нет в хранилище - текущее значение серии
+// в хранилище. Значение меньше предыдущего означает сброс счетчика
+// в источнике: прирост равен новому значению.
+//
+// Изменения вносятся в транзакции (см. begin) на основе storage.SeriesState:
+// серии транзакции блокируются до Commit или Rollback, поэтому запросы
+// с общими сериями выполняются по очереди и не учитывают один прирост дважды.
+type cumulativeCounters struct {
+	last *storage.SeriesState[int64]
+}
+
+func newCumulativeCounters() *cumulativeCounters {
+	return &cumulativeCounters{last: storage.NewSeriesState[int64]()}
+}
+
+// cumulativeTx - транзакция преобразования накопленных значений. Commit
+// вызывается после записи приростов в хранилище.
+type cumulativeTx struct {
+	*storage.SeriesStateTx[int64]
+	ctx context.Context
+	sp  storage.StorageProvider
+}
+
+// begin начинает транзакцию для серий keys и блокирует их. Текущие
+// значения серий читаются из хранилища sp.
+func (c *cumulativeCounters) begin(ctx context.Context, sp storage.StorageProvider, keys []string) *cumulativeTx {
+	return &cumulativeTx{
+		SeriesStateTx: c.last.Begin(keys...),
+		ctx:           ctx,
+		sp:            sp,
+	}
+}
+
+// delta возвращает прирост серии key, после которого ее значение станет
+// равным накопленному значению value. Серия должна входить в keys транзакции.
+func (tx *cumulativeTx) delta(key string, value int64) (int64, error) {
+	prev, ok := tx.Get(key)
+	if !tx.Changed(key) {
+		stored, err := tx.sp.GetCounter(tx.ctx, key)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			prev = 0
+		case err != nil:
+			return 0, err
+		case !ok:
+			prev = int64(stored)
+		}
+	}
+	tx.Set(key, value)
+
+	if value < prev {
+		return value, nil
+	}
+	return value - prev, nil
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCumulativeCounters(t *testing.T) {
+	ms := memstorage.NewStorage()
+	ctx := context.Background()
+	c := newCumulativeCounters()
+
+	write := func(key string, value int64) int64 {
+		tx := c.begin(ctx, ms, []string{key})
+		defer tx.Rollback()
+		delta, err := tx.delta(key, value)
+		require.NoError(t, err)
+		require.NoError(t, ms.SetCounter(ctx, key, storage.Counter(delta)))
+		tx.Commit()
+		return delta
+	}
+
+	// Первое значение серии считается от значения в хранилище
+	require.NoError(t, ms.SetCounter(ctx, "requests", 40))
+	assert.Equal(t, int64(2), write("requests", 42))
+	assert.Equal(t, int64(8), write("requests", 50))
+
+	// Сброс: прирост равен новому значению
+	assert.Equal(t, int64(5), write("requests", 5))
+	assert.Equal(t, int64(1), write("requests", 6))
+
+	// Отмененная транзакция не меняет предыдущее значение
+	tx := c.begin(ctx, ms, []string{"requests"})
+	delta, err := tx.delta("requests", 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), delta)
+	delta, err = tx.delta("requests", 12)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), delta)
+	tx.Rollback()
+	assert.Equal(t, int64(4), write("requests", 10))
+
+	// Удаленная серия начинается заново
+	require.NoError(t, ms.Delete(ctx, storage.MetricTypeCounter, "requests"))
+	assert.Equal(t, int64(12), write("requests", 12))
+}
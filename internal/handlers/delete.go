@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+// DELETEMetric обрабатывает DELETE запросы для удаления метрики вместе с ее историей.
+//
+// URL формат: /value/{type}/{name}
+// где:
+//   - type: "gauge" или "counter"
+//   - name: имя метрики
+//
+// Метки серии передаются в параметрах запроса.
+//
+// Пример запроса:
+//
+//	curl -X DELETE 'http://localhost:8080/value/gauge/Alloc?host=web-1'
+//
+// HTTP статусы:
+//   - 200: метрика удалена
+//   - 400: неверный тип метрики или метки
+//   - 404: метрика не найдена
+//   - 503: хранилище недоступно
+func (h *Handler) DELETEMetric(w http.ResponseWriter, r *http.Request) {
+	metricType := storage.MetricType(chi.URLParam(r, "type"))
+	name := chi.URLParam(r, "name")
+
+	if !slices.Contains(storage.MetricTypes(), metricType) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, ok := seriesKey(name, queryLabels(r))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storageProvider.Delete(r.Context(), metricType, key); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// POSTDeleteMetrics обрабатывает POST запросы для массового удаления метрик
+// по префиксу имени и меткам. Формат запроса описан в models.DeleteRequest.
+//
+// Пример запроса:
+//
+//	curl -X POST http://localhost:8080/delete/ \
+//		-H "Content-Type: application/json" \
+//		-d '{"labels":{"host":"web-1"}}'
+//
+// Формат ответа:
+//
+//	{
+//		"deleted": 12
+//	}
+//
+// HTTP статусы:
+//   - 200: метрики удалены, в ответе количество удаленных серий
+//   - 400: неверный формат запроса, тип метрики или пустой фильтр
+//   - 405: неверный HTTP метод (ожидается POST)
+//   - 503: хранилище недоступно
+func (h *Handler) POSTDeleteMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.DeleteRequest
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	n, err := h.storageProvider.DeleteMatching(r.Context(), storage.DeleteFilter{
+		Type:   req.MType,
+		Prefix: req.Prefix,
+		Labels: req.Labels,
+	})
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(models.DeleteResponse{Deleted: n}); err != nil {
+		return
+	}
+}
+
+// POSTResetCounter обрабатывает POST запросы для сброса значения counter метрики в 0.
+//
+// Ожидаемый формат запроса:
+//
+//	{
+//		"id": "requests_total",
+//		"type": "counter",
+//		"labels": {"host": "web-1"}
+//	}
+//
+// Поле labels необязательно. Если на сервере задан ключ, запрос должен быть
+// подписан заголовком HashSHA256 (см. utils.RequestHashData).
+//
+// HTTP статусы:
+//   - 200: значение сброшено
+//   - 400: неверный формат запроса, метки или тип метрики отличен от counter
+//   - 404: метрика не найдена или отсутствуют обязательные поля
+//   - 405: неверный HTTP метод (ожидается POST)
+//   - 503: хранилище недоступно
+func (h *Handler) POSTResetCounter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req models.Metrics
+	dec := json.NewDecoder(r.Body)
+	if err := dec.Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if req.MType == "" || req.ID == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if req.MType != storage.MetricTypeCounter {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	key, ok := seriesKey(req.ID, req.Labels)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.storageProvider.ResetCounter(r.Context(), key); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
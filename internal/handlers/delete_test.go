@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDeleteRouter(ms *memstorage.MemStorage) http.Handler {
+	handler := NewHandler(ms)
+	r := chi.NewRouter()
+	r.Delete("/value/{type}/{name}", handler.DELETEMetric)
+	r.Post("/delete/", handler.POSTDeleteMetrics)
+	r.Post("/reset/", handler.POSTResetCounter)
+	return r
+}
+
+func TestDELETEMetric(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, ms.SetGauge(ctx, `Alloc{host="web-1"}`, 2))
+	require.NoError(t, ms.SetCounter(ctx, "PollCount", 3))
+	r := newDeleteRouter(ms)
+
+	testCases := []struct {
+		name         string
+		url          string
+		expectedCode int
+	}{
+		{"gauge_with_labels", "/value/gauge/Alloc?host=web-1", http.StatusOK},
+		{"counter", "/value/counter/PollCount", http.StatusOK},
+		{"already_deleted", "/value/counter/PollCount", http.StatusNotFound},
+		{"wrong_type", "/value/counter/Alloc", http.StatusNotFound},
+		{"unknown_type", "/value/unknown/Alloc", http.StatusBadRequest},
+		{"invalid_label", "/value/gauge/Alloc?1host=web-1", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, tc.url, nil))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	gauges, err := ms.KeysGauge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Alloc"}, gauges)
+}
+
+func TestPOSTDeleteMetrics(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetGauge(ctx, `disk_free{host="web-1"}`, 1))
+	require.NoError(t, ms.SetGauge(ctx, `disk_free{host="web-2"}`, 2))
+	require.NoError(t, ms.SetGauge(ctx, `cpu{host="web-1"}`, 3))
+	require.NoError(t, ms.SetCounter(ctx, `requests{host="web-1"}`, 4))
+	r := newDeleteRouter(ms)
+
+	testCases := []struct {
+		name            string
+		body            string
+		expectedCode    int
+		expectedDeleted int
+	}{
+		{"invalid_json", `{`, http.StatusBadRequest, 0},
+		{"empty_filter", `{}`, http.StatusBadRequest, 0},
+		{"unknown_type", `{"type":"unknown","prefix":"disk"}`, http.StatusBadRequest, 0},
+		{"prefix_and_labels", `{"prefix":"disk_","labels":{"host":"web-1"}}`, http.StatusOK, 1},
+		{"gauges_by_labels", `{"type":"gauge","labels":{"host":"web-1"}}`, http.StatusOK, 1},
+		{"all_by_labels", `{"labels":{"host":"web-1"}}`, http.StatusOK, 1},
+		{"nothing_matches", `{"prefix":"mem"}`, http.StatusOK, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/delete/", bytes.NewBufferString(tc.body)))
+			require.Equal(t, tc.expectedCode, rec.Code)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var resp models.DeleteResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tc.expectedDeleted, resp.Deleted)
+		})
+	}
+
+	gauges, err := ms.KeysGauge(ctx)
+	require.NoError(t, err)
+	counters, err := ms.KeysCounter(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{`disk_free{host="web-2"}`}, gauges)
+	assert.Empty(t, counters)
+}
+
+func TestPOSTResetCounter(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetCounter(ctx, `requests{host="web-1"}`, 5))
+	require.NoError(t, ms.SetGauge(ctx, "Alloc", 1))
+	r := newDeleteRouter(ms)
+
+	testCases := []struct {
+		name         string
+		body         string
+		expectedCode int
+	}{
+		{"invalid_json", `{`, http.StatusBadRequest},
+		{"missing_id", `{"type":"counter"}`, http.StatusNotFound},
+		{"gauge", `{"id":"Alloc","type":"gauge"}`, http.StatusBadRequest},
+		{"not_found", `{"id":"requests","type":"counter"}`, http.StatusNotFound},
+		{"reset", `{"id":"requests","type":"counter","labels":{"host":"web-1"}}`, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reset/", bytes.NewBufferString(tc.body)))
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+
+	v, err := ms.GetCounter(ctx, `requests{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(0), v)
+}
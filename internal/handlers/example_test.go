@@ -184,10 +184,10 @@ func ExampleHandler_POSTUpdatesMetrics() {
 	fmt.Printf("Batch update status: %d\n", resp.StatusCode)
 
 	// Проверяем, что метрики сохранились
-	if cpu, ok := s.GetGauge(context.Background(), "cpu_usage"); ok {
+	if cpu, err := s.GetGauge(context.Background(), "cpu_usage"); err == nil {
 		fmt.Printf("CPU Usage: %.1f\n", float64(cpu))
 	}
-	if requests, ok := s.GetCounter(context.Background(), "requests_total"); ok {
+	if requests, err := s.GetCounter(context.Background(), "requests_total"); err == nil {
 		fmt.Printf("Requests Total: %d\n", int64(requests))
 	}
 
@@ -1,17 +1,24 @@
 // Package handlers предоставляет HTTP обработчики для API сервиса метрик.
 // Пакет содержит обработчики для операций получения, обновления и просмотра метрик
-// через REST API эндпоинты. Поддерживает работу с метриками типов gauge и counter.
+// через REST API эндпоинты. Поддерживает работу с метриками типов gauge, counter
+// и histogram.
 package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html"
 	"io"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/am0xff/metrics/internal/influx"
 	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/otlp"
 	"github.com/am0xff/metrics/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
@@ -26,6 +33,20 @@ import (
 //	http.HandleFunc("/metrics", handler.GetMetrics)
 type Handler struct {
 	storageProvider storage.StorageProvider
+	serverGauges    []serverGauge
+	ttl             storage.TTLPolicy
+	buckets         storage.BucketPolicy
+	influx          influx.Mapping
+	promCounters    bool
+	otlp            otlp.Mapping
+	otlpDeltas      *otlp.Deltas
+	cumulative      *cumulativeCounters
+}
+
+// serverGauge - метрика самого сервера, вычисляемая при выгрузке.
+type serverGauge struct {
+	name  string
+	value func() float64
 }
 
 // NewHandler создает новый экземпляр Handler с указанным провайдером хранилища.
@@ -40,7 +61,27 @@ type Handler struct {
 //	mux := http.NewServeMux()
 //	mux.HandleFunc("/metrics", handler.GetMetrics)
 func NewHandler(sp storage.StorageProvider) *Handler {
-	return &Handler{storageProvider: sp}
+	return &Handler{
+		storageProvider: sp,
+		otlpDeltas:      otlp.NewDeltas(time.Now()),
+		cumulative:      newCumulativeCounters(),
+	}
+}
+
+// AddServerGauge добавляет в выгрузку GET /metrics gauge метрику самого
+// сервера name, значение которой вычисляет value при каждом запросе.
+// Метрики сервера не хранятся в хранилище и выводятся перед метриками агентов;
+// серии агентов с тем же именем не выводятся. Вызывается до начала
+// обработки запросов.
+func (h *Handler) AddServerGauge(name string, value func() float64) {
+	h.serverGauges = append(h.serverGauges, serverGauge{name: name, value: value})
+}
+
+// SetTTLPolicy задает политику, по которой GetMetrics определяет и скрывает
+// устаревшие серии. Без вызова серии не устаревают. Вызывается до начала
+// обработки запросов.
+func (h *Handler) SetTTLPolicy(p storage.TTLPolicy) {
+	h.ttl = p
 }
 
 // POSTGetMetric обрабатывает POST запросы для получения значения метрики в формате JSON.
@@ -50,15 +91,23 @@ func NewHandler(sp storage.StorageProvider) *Handler {
 //
 //	{
 //		"id": "metric_name",
-//		"type": "gauge" | "counter"
+//		"type": "gauge" | "counter" | "histogram",
+//		"labels": {"host": "web-1"}
 //	}
 //
+// Поле labels необязательно; метрика ищется по имени и точному набору меток,
+// метки возвращаются в ответе вместе со временем последнего обновления updated.
+// Для histogram в запросе можно задать нужные квантили в поле
+// "histogram": {"quantiles": [{"q": 0.95}]}, по умолчанию возвращаются
+// оценки квантилей 0.5, 0.9 и 0.99.
+//
 // Формат ответа для gauge:
 //
 //	{
 //		"id": "metric_name",
 //		"type": "gauge",
-//		"value": 123.45
+//		"value": 123.45,
+//		"updated": "2024-01-01T10:00:00Z"
 //	}
 //
 // Формат ответа для counter:
@@ -66,14 +115,30 @@ func NewHandler(sp storage.StorageProvider) *Handler {
 //	{
 //		"id": "metric_name",
 //		"type": "counter",
-//		"delta": 100
+//		"delta": 100,
+//		"updated": "2024-01-01T10:00:00Z"
+//	}
+//
+// Формат ответа для histogram (корзины накопленные, как в Prometheus):
+//
+//	{
+//		"id": "http_latency",
+//		"type": "histogram",
+//		"histogram": {
+//			"buckets": [{"le": 0.1, "count": 3}, {"le": 0.5, "count": 7}],
+//			"count": 8,
+//			"sum": 1.9,
+//			"quantiles": [{"q": 0.5, "value": 0.2}, {"q": 0.9, "value": 0.5}, {"q": 0.99, "value": 0.5}]
+//		},
+//		"updated": "2024-01-01T10:00:00Z"
 //	}
 //
 // HTTP статусы:
 //   - 200: метрика найдена и возвращена
-//   - 400: неверный формат запроса или тип метрики
+//   - 400: неверный формат запроса, тип метрики, метки или квантиль
 //   - 404: метрика не найдена или отсутствуют обязательные поля
 //   - 405: неверный HTTP метод (ожидается POST)
+//   - 503: хранилище недоступно
 func (h *Handler) POSTGetMetric(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -92,40 +157,77 @@ func (h *Handler) POSTGetMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	key, ok := seriesKey(req.ID, req.Labels)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	var resp models.Metrics
 
 	switch req.MType {
 	case storage.MetricTypeGauge:
-		v, ok := h.storageProvider.GetGauge(r.Context(), req.ID)
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+		v, err := h.storageProvider.GetGauge(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
 			return
 		}
 
 		value := float64(v)
 		resp = models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
-			Value: &value,
+			ID:     req.ID,
+			MType:  req.MType,
+			Value:  &value,
+			Labels: req.Labels,
 		}
 	case storage.MetricTypeCounter:
-		v, ok := h.storageProvider.GetCounter(r.Context(), req.ID)
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+		v, err := h.storageProvider.GetCounter(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
 			return
 		}
 
 		value := int64(v)
 		resp = models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
-			Delta: &value,
+			ID:     req.ID,
+			MType:  req.MType,
+			Delta:  &value,
+			Labels: req.Labels,
+		}
+	case storage.MetricTypeHistogram:
+		v, err := h.storageProvider.GetHistogram(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		var qs []models.Quantile
+		if req.Histogram != nil {
+			qs = req.Histogram.Quantiles
+		}
+		hist, err := histogramResponse(v, qs)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp = models.Metrics{
+			ID:        req.ID,
+			MType:     req.MType,
+			Histogram: hist,
+			Labels:    req.Labels,
 		}
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	updated, err := h.lastUpdated(r, req.MType, key)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	resp.Updated = updated
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 
@@ -154,13 +256,29 @@ func (h *Handler) POSTGetMetric(w http.ResponseWriter, r *http.Request) {
 //		"delta": 10
 //	}
 //
+// Для histogram передаются отдельные наблюдения и (или) предварительно
+// агрегированные накопленные корзины с общими count и sum:
+//
+//	{
+//		"id": "http_latency",
+//		"type": "histogram",
+//		"histogram": {"observations": [0.12, 0.3]}
+//	}
+//
+// Границы корзин настраиваются для метрики (SetBucketPolicy); переданные
+// корзины должны иметь те же границы.
+//
+// Необязательное поле labels задает метки метрики: метрики с одинаковым именем
+// и разными метками хранятся как отдельные серии.
+//
 // Возвращает обновленную метрику в том же формате.
 //
 // HTTP статусы:
 //   - 200: метрика успешно обновлена
-//   - 400: неверный формат запроса, тип метрики или отсутствует значение
+//   - 400: неверный формат запроса, тип метрики, метки или отсутствует значение
 //   - 404: отсутствуют обязательные поля (id или type)
 //   - 405: неверный HTTP метод (ожидается POST)
+//   - 503: хранилище недоступно, метрика не сохранена
 func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -179,6 +297,12 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	key, ok := seriesKey(req.ID, req.Labels)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	var resp models.Metrics
 
 	switch req.MType {
@@ -189,12 +313,16 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 		}
 
 		newValue := storage.Gauge(*req.Value)
-		h.storageProvider.SetGauge(r.Context(), req.ID, newValue)
+		if err := h.storageProvider.SetGauge(r.Context(), key, newValue); err != nil {
+			writeStorageError(w, err)
+			return
+		}
 
 		resp = models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
-			Value: req.Value,
+			ID:     req.ID,
+			MType:  req.MType,
+			Value:  req.Value,
+			Labels: req.Labels,
 		}
 	case storage.MetricTypeCounter:
 		if req.Delta == nil {
@@ -203,13 +331,30 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 		}
 
 		newValue := storage.Counter(*req.Delta)
-		h.storageProvider.SetCounter(r.Context(), req.ID, newValue)
+		if err := h.storageProvider.SetCounter(r.Context(), key, newValue); err != nil {
+			writeStorageError(w, err)
+			return
+		}
 
 		resp = models.Metrics{
-			ID:    req.ID,
-			MType: req.MType,
-			Delta: req.Delta,
+			ID:     req.ID,
+			MType:  req.MType,
+			Delta:  req.Delta,
+			Labels: req.Labels,
+		}
+	case storage.MetricTypeHistogram:
+		m, err := h.histogramUpdate(req, key)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
+
+		if err := h.storageProvider.UpdateBatch(r.Context(), []models.Metrics{m}); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+
+		resp = m
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -225,7 +370,8 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 }
 
 // POSTUpdatesMetrics обрабатывает POST запросы для массового обновления метрик в формате JSON.
-// Принимает массив метрик и выполняет пакетное обновление.
+// Принимает массив метрик и выполняет пакетное обновление: пакет применяется
+// целиком, при любой ошибке не сохраняется ни одна метрика.
 //
 // Ожидаемый формат запроса:
 //
@@ -239,6 +385,11 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 //			"id": "requests_total",
 //			"type": "counter",
 //			"delta": 100
+//		},
+//		{
+//			"id": "http_latency",
+//			"type": "histogram",
+//			"histogram": {"observations": [0.12, 0.3]}
 //		}
 //	]
 //
@@ -247,6 +398,7 @@ func (h *Handler) POSTUpdateMetric(w http.ResponseWriter, r *http.Request) {
 //   - 400: неверный формат запроса, пустой массив или неверные данные метрики
 //   - 404: отсутствуют обязательные поля в одной из метрик
 //   - 405: неверный HTTP метод (ожидается POST)
+//   - 503: хранилище недоступно, пакет не сохранен
 func (h *Handler) POSTUpdatesMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -265,35 +417,49 @@ func (h *Handler) POSTUpdatesMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	for _, req := range reqs {
+	// Пакет проверяется целиком до записи, чтобы вернуть те же статусы,
+	// что и при обновлении одной метрики
+	for i, req := range reqs {
 		if req.MType == "" || req.ID == "" {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
+		key, ok := seriesKey(req.ID, req.Labels)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
 		switch req.MType {
 		case storage.MetricTypeGauge:
 			if req.Value == nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-
-			newValue := storage.Gauge(*req.Value)
-			h.storageProvider.SetGauge(r.Context(), req.ID, newValue)
 		case storage.MetricTypeCounter:
 			if req.Delta == nil {
 				w.WriteHeader(http.StatusBadRequest)
 				return
 			}
-
-			newValue := storage.Counter(*req.Delta)
-			h.storageProvider.SetCounter(r.Context(), req.ID, newValue)
+		case storage.MetricTypeHistogram:
+			m, err := h.histogramUpdate(req, key)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			reqs[i] = m
 		default:
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 	}
 
+	if err := h.storageProvider.UpdateBatch(r.Context(), reqs); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -302,42 +468,82 @@ func (h *Handler) POSTUpdatesMetrics(w http.ResponseWriter, r *http.Request) {
 //
 // URL формат: /value/{type}/{name}
 // где:
-//   - type: "gauge" или "counter"
+//   - type: "gauge", "counter" или "histogram"
 //   - name: имя метрики
 //
+// Метки серии передаются в параметрах запроса. Время последнего обновления
+// метрики возвращается в заголовке Last-Modified. Для histogram обязателен
+// параметр quantile (от 0 до 1), в ответе возвращается оценка квантиля.
+//
 // Примеры URL:
 //   - /value/gauge/cpu_usage
 //   - /value/counter/requests_total
+//   - /value/gauge/Alloc?host=web-1
+//   - /value/histogram/http_latency?quantile=0.99
 //
 // HTTP статусы:
 //   - 200: метрика найдена, значение возвращено в теле ответа
-//   - 400: неверный тип метрики
+//   - 400: неверный тип метрики, метки или квантиль
 //   - 404: метрика не найдена
+//   - 503: хранилище недоступно
 func (h *Handler) GETGetMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "type")
 	name := chi.URLParam(r, "name")
 
+	var reserved []string
+	if storage.MetricType(metricType) == storage.MetricTypeHistogram {
+		reserved = append(reserved, "quantile")
+	}
+	key, ok := seriesKey(name, queryLabels(r, reserved...))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var body string
 	switch storage.MetricType(metricType) {
 	case storage.MetricTypeGauge:
-		v, ok := h.storageProvider.GetGauge(r.Context(), name)
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+		v, err := h.storageProvider.GetGauge(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
 			return
 		}
-		_, _ = io.WriteString(w, strconv.FormatFloat(float64(v), 'f', -1, 64))
+		body = strconv.FormatFloat(float64(v), 'f', -1, 64)
 	case storage.MetricTypeCounter:
-		v, ok := h.storageProvider.GetCounter(r.Context(), name)
-		if !ok {
-			w.WriteHeader(http.StatusNotFound)
+		v, err := h.storageProvider.GetCounter(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
 			return
 		}
-		_, _ = io.WriteString(w, strconv.FormatInt(int64(v), 10))
+		body = strconv.FormatInt(int64(v), 10)
+	case storage.MetricTypeHistogram:
+		q, err := strconv.ParseFloat(r.URL.Query().Get("quantile"), 64)
+		if err != nil || q < 0 || q > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		v, err := h.storageProvider.GetHistogram(r.Context(), key)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		body = strconv.FormatFloat(v.Quantile(q), 'f', -1, 64)
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	updated, err := h.lastUpdated(r, storage.MetricType(metricType), key)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if updated != nil {
+		w.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+
 	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, body)
 }
 
 // GETUpdateMetric обрабатывает POST запросы для обновления метрики через URL параметры.
@@ -345,18 +551,23 @@ func (h *Handler) GETGetMetric(w http.ResponseWriter, r *http.Request) {
 //
 // URL формат: /update/{type}/{name}/{value}
 // где:
-//   - type: "gauge" или "counter"
+//   - type: "gauge", "counter" или "histogram"
 //   - name: имя метрики
-//   - value: новое значение метрики
+//   - value: новое значение метрики, для histogram - одно наблюдение
+//
+// Метки серии передаются в параметрах запроса.
 //
 // Примеры URL:
 //   - /update/gauge/cpu_usage/85.5
 //   - /update/counter/requests_total/1000
+//   - /update/gauge/Alloc/1024?host=web-1
+//   - /update/histogram/http_latency/0.25
 //
 // HTTP статусы:
 //   - 200: метрика успешно обновлена
-//   - 400: неверный тип метрики или формат значения
+//   - 400: неверный тип метрики, формат значения или метки
 //   - 404: не указано имя метрики
+//   - 503: хранилище недоступно, метрика не сохранена
 func (h *Handler) GETUpdateMetric(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "type")
 	name := chi.URLParam(r, "name")
@@ -367,6 +578,12 @@ func (h *Handler) GETUpdateMetric(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	key, ok := seriesKey(name, queryLabels(r))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	switch storage.MetricType(metricType) {
 	case storage.MetricTypeGauge:
 		value, err := strconv.ParseFloat(valueStr, 64)
@@ -374,14 +591,40 @@ func (h *Handler) GETUpdateMetric(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		h.storageProvider.SetGauge(r.Context(), name, storage.Gauge(value))
+		if err := h.storageProvider.SetGauge(r.Context(), key, storage.Gauge(value)); err != nil {
+			writeStorageError(w, err)
+			return
+		}
 	case storage.MetricTypeCounter:
 		value, err := strconv.ParseInt(valueStr, 10, 64)
 		if err != nil {
 			http.Error(w, "Invalid counter value", http.StatusBadRequest)
 			return
 		}
-		h.storageProvider.SetCounter(r.Context(), name, storage.Counter(value))
+		if err := h.storageProvider.SetCounter(r.Context(), key, storage.Counter(value)); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	case storage.MetricTypeHistogram:
+		value, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m, err := h.histogramUpdate(models.Metrics{
+			ID:        name,
+			MType:     storage.MetricTypeHistogram,
+			Histogram: &models.Histogram{Observations: []float64{value}},
+		}, key)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		m.Labels = queryLabels(r)
+		if err := h.storageProvider.UpdateBatch(r.Context(), []models.Metrics{m}); err != nil {
+			writeStorageError(w, err)
+			return
+		}
 	default:
 		w.WriteHeader(http.StatusBadRequest)
 		return
@@ -391,41 +634,140 @@ func (h *Handler) GETUpdateMetric(w http.ResponseWriter, r *http.Request) {
 }
 
 // GetMetrics обрабатывает GET запросы для получения списка всех метрик в HTML формате.
-// Возвращает HTML страницу со списком всех gauge и counter метрик с их значениями.
+// Возвращает HTML страницу со списком всех метрик с их значениями; для histogram
+// выводятся количество и сумма наблюдений.
 //
 // URL: /
 //
 // Формат ответа: HTML страница с неупорядоченным списком метрик.
-// Каждая метрика отображается в формате "имя: значение".
+// Каждая метрика отображается в формате "имя{метки}: значение" вместе
+// со временем последнего обновления.
+//
+// Параметры запроса задают фильтр по меткам: /?host=web-1 выводит только
+// серии с меткой host="web-1". Устаревшие по политике SetTTLPolicy серии
+// не выводятся; с параметром stale=true они выводятся с пометкой "stale".
 //
 // HTTP статусы:
 //   - 200: страница с метриками успешно возвращена
 //   - 405: неверный HTTP метод (ожидается GET)
 //   - 500: ошибка при формировании ответа
+//   - 503: хранилище недоступно
 func (h *Handler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	var html strings.Builder
+	showStale, _ := strconv.ParseBool(r.URL.Query().Get("stale"))
+	matchers := queryLabels(r, "stale")
+	now := time.Now()
+
+	var page strings.Builder
+
+	gaugeKeys, err := h.storageProvider.KeysGauge(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	counterKeys, err := h.storageProvider.KeysCounter(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	gaugesUpdated, err := h.storageProvider.LastUpdated(r.Context(), storage.MetricTypeGauge)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	countersUpdated, err := h.storageProvider.LastUpdated(r.Context(), storage.MetricTypeCounter)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	histogramKeys, err := h.storageProvider.KeysHistogram(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	histogramsUpdated, err := h.storageProvider.LastUpdated(r.Context(), storage.MetricTypeHistogram)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	// item возвращает строку списка для серии k или false, если серия скрыта
+	item := func(k string, v any, updated map[string]time.Time) (string, bool) {
+		ts, ok := updated[k]
+		if !ok {
+			return fmt.Sprintf("<li>%s: %v</li>", html.EscapeString(k), v), true
+		}
+		note := "updated " + ts.UTC().Format(time.RFC3339)
+		if h.ttl.Stale(k, ts, now) {
+			if !showStale {
+				return "", false
+			}
+			note = "stale, " + note
+		}
+		return fmt.Sprintf("<li>%s: %v <small>(%s)</small></li>", html.EscapeString(k), v, note), true
+	}
 
-	html.WriteString("<html><head><title>Metrics</title></head><body>")
-	html.WriteString("<ul>")
-	for _, k := range h.storageProvider.KeysGauge(r.Context()) {
-		v, _ := h.storageProvider.GetGauge(r.Context(), k)
-		html.WriteString(fmt.Sprintf("<li>%s: %v</li>", k, v))
+	page.WriteString("<html><head><title>Metrics</title></head><body>")
+	page.WriteString("<ul>")
+	for _, k := range gaugeKeys {
+		if !storage.MatchSeriesKey(k, matchers) {
+			continue
+		}
+		v, err := h.storageProvider.GetGauge(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if li, ok := item(k, v, gaugesUpdated); ok {
+			page.WriteString(li)
+		}
 	}
-	for _, k := range h.storageProvider.KeysCounter(r.Context()) {
-		v, _ := h.storageProvider.GetCounter(r.Context(), k)
-		html.WriteString(fmt.Sprintf("<li>%s: %v</li>", k, v))
+	for _, k := range counterKeys {
+		if !storage.MatchSeriesKey(k, matchers) {
+			continue
+		}
+		v, err := h.storageProvider.GetCounter(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		if li, ok := item(k, v, countersUpdated); ok {
+			page.WriteString(li)
+		}
+	}
+	for _, k := range histogramKeys {
+		if !storage.MatchSeriesKey(k, matchers) {
+			continue
+		}
+		v, err := h.storageProvider.GetHistogram(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		summary := fmt.Sprintf("count=%d sum=%v", v.Count, v.Sum)
+		if li, ok := item(k, summary, histogramsUpdated); ok {
+			page.WriteString(li)
+		}
 	}
-	html.WriteString("</ul>")
+	page.WriteString("</ul>")
 
 	w.Header().Set("Content-Type", "text/html")
 	w.WriteHeader(http.StatusOK)
 
-	if _, err := io.WriteString(w, html.String()); err != nil {
+	if _, err := io.WriteString(w, page.String()); err != nil {
 		http.Error(w, "failed to write response", http.StatusInternalServerError)
 		return
 	}
@@ -438,12 +780,77 @@ func (h *Handler) GetMetrics(w http.ResponseWriter, r *http.Request) {
 //
 // HTTP статусы:
 //   - 200: хранилище доступно
-//   - 500: хранилище недоступно или ошибка соединения
+//   - 500: ошибка проверки соединения
+//   - 503: хранилище недоступно
 func (h *Handler) Ping(w http.ResponseWriter, r *http.Request) {
 	if err := h.storageProvider.Ping(r.Context()); err != nil {
-		http.Error(w, "database ping failed", http.StatusInternalServerError)
+		log.Printf("ping storage: %v", err)
+		status := http.StatusInternalServerError
+		if errors.Is(err, storage.ErrUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, "database ping failed", status)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// writeStorageError отвечает статусом, соответствующим ошибке хранилища:
+// ErrNotFound - 404, ErrInvalid - 400, ErrUnavailable - 503, прочие ошибки - 500.
+func writeStorageError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, storage.ErrInvalid):
+		w.WriteHeader(http.StatusBadRequest)
+	case errors.Is(err, storage.ErrUnavailable):
+		log.Printf("storage unavailable: %v", err)
+		http.Error(w, "storage unavailable", http.StatusServiceUnavailable)
+	default:
+		log.Printf("storage error: %v", err)
+		http.Error(w, "storage error", http.StatusInternalServerError)
+	}
+}
+
+// lastUpdated возвращает время последнего обновления серии key типа mtype
+// или nil, если оно неизвестно.
+func (h *Handler) lastUpdated(r *http.Request, mtype storage.MetricType, key string) (*time.Time, error) {
+	updated, err := h.storageProvider.LastUpdated(r.Context(), mtype, key)
+	if err != nil {
+		return nil, err
+	}
+	ts, ok := updated[key]
+	if !ok {
+		return nil, nil
+	}
+	return &ts, nil
+}
+
+// seriesKey проверяет имя и метки метрики и возвращает ключ ее серии в хранилище.
+// Возвращает false, если имя метрики или имена меток недопустимы.
+func seriesKey(name string, labels map[string]string) (string, bool) {
+	if err := storage.ValidateSeries(name, labels); err != nil {
+		return "", false
+	}
+	return storage.SeriesKey(name, labels), true
+}
+
+// queryLabels возвращает метки, переданные в параметрах запроса (?host=web-1),
+// за исключением параметров из списка reserved. Для повторяющихся параметров
+// используется первое значение.
+func queryLabels(r *http.Request, reserved ...string) map[string]string {
+	q := r.URL.Query()
+	for _, k := range reserved {
+		q.Del(k)
+	}
+	if len(q) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(q))
+	for k, v := range q {
+		labels[k] = v[0]
+	}
+	return labels
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
@@ -523,6 +526,263 @@ func TestGetMetrics(t *testing.T) {
 	}
 }
 
+// Тест для метрик с метками
+func TestLabeledMetrics(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	r := chi.NewRouter()
+	r.Post("/update/", handler.POSTUpdateMetric)
+	r.Post("/value/", handler.POSTGetMetric)
+	r.Get("/value/{type}/{name}", handler.GETGetMetric)
+	r.Post("/update/{type}/{name}/{value}", handler.GETUpdateMetric)
+	r.Get("/", handler.GetMetrics)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	post := func(url, body string) (int, string) {
+		resp, err := http.Post(srv.URL+url, "application/json", bytes.NewBufferString(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(resp.Body)
+		return resp.StatusCode, buf.String()
+	}
+	get := func(url string) (int, string) {
+		resp, err := http.Get(srv.URL + url)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		buf := new(bytes.Buffer)
+		buf.ReadFrom(resp.Body)
+		return resp.StatusCode, buf.String()
+	}
+
+	code, _ := post("/update/", `{"id":"Alloc","type":"gauge","value":1,"labels":{"host":"web-1"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = post("/update/gauge/Alloc/2?host=web-2", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = post("/update/", `{"id":"Alloc","type":"gauge","value":3}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body := post("/value/", `{"id":"Alloc","type":"gauge","labels":{"host":"web-1"}}`)
+	assert.Equal(t, http.StatusOK, code)
+	var got models.Metrics
+	require.NoError(t, json.Unmarshal([]byte(body), &got))
+	require.NotNil(t, got.Updated)
+	got.Updated = nil
+	value := 1.0
+	assert.Equal(t, models.Metrics{ID: "Alloc", MType: "gauge", Value: &value, Labels: map[string]string{"host": "web-1"}}, got)
+
+	code, body = get("/value/gauge/Alloc?host=web-2")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "2", body)
+
+	code, body = get("/value/gauge/Alloc")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "3", body)
+
+	code, _ = get("/value/gauge/Alloc?host=web-3")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = post("/update/", `{"id":"Alloc","type":"gauge","value":1,"labels":{"host-name":"web-1"}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, body = get("/?host=web-1")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "web-1")
+	assert.NotContains(t, body, "web-2")
+}
+
+// slowStorage - хранилище, чтение counter в котором задерживается, чтобы
+// конкурентные запросы чередовались между чтением и записью.
+type slowStorage struct {
+	*memstorage.MemStorage
+}
+
+func (s slowStorage) GetCounter(ctx context.Context, key string) (storage.Counter, error) {
+	v, err := s.MemStorage.GetCounter(ctx, key)
+	time.Sleep(time.Millisecond)
+	return v, err
+}
+
+// failingStorage - хранилище, все операции которого завершаются ошибкой err.
+type failingStorage struct {
+	*memstorage.MemStorage
+	err error
+}
+
+func (f failingStorage) GetGauge(context.Context, string) (storage.Gauge, error) {
+	return 0, f.err
+}
+
+func (f failingStorage) GetCounter(context.Context, string) (storage.Counter, error) {
+	return 0, f.err
+}
+
+func (f failingStorage) SetGauge(context.Context, string, storage.Gauge) error {
+	return f.err
+}
+
+func (f failingStorage) SetCounter(context.Context, string, storage.Counter) error {
+	return f.err
+}
+
+func (f failingStorage) KeysGauge(context.Context) ([]string, error) {
+	return nil, f.err
+}
+
+func (f failingStorage) UpdateBatch(context.Context, []models.Metrics) error {
+	return f.err
+}
+
+func (f failingStorage) Delete(context.Context, storage.MetricType, string) error {
+	return f.err
+}
+
+func (f failingStorage) DeleteMatching(context.Context, storage.DeleteFilter) (int, error) {
+	return 0, f.err
+}
+
+func (f failingStorage) ResetCounter(context.Context, string) error {
+	return f.err
+}
+
+func (f failingStorage) Ping(context.Context) error {
+	return f.err
+}
+
+func TestGetMetrics_StaleSeries(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetGauge(ctx, "fresh", 1))
+	require.NoError(t, ms.SetGauge(ctx, "old", 2))
+	require.NoError(t, ms.SetCounter(ctx, "build_count", 3))
+
+	// серии old и build_count не обновлялись час
+	hourAgo := time.Now().Add(-time.Hour)
+	ms.GaugesUpdated.Set("old", hourAgo)
+	ms.CountersUpdated.Set("build_count", hourAgo)
+
+	handler := NewHandler(ms)
+	handler.SetTTLPolicy(storage.TTLPolicy{
+		Default:   time.Minute,
+		Overrides: map[string]time.Duration{"build_": 0},
+	})
+	r := chi.NewRouter()
+	r.Get("/", handler.GetMetrics)
+	r.Get("/value/{type}/{name}", handler.GETGetMetric)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "fresh: 1")
+	assert.NotContains(t, rec.Body.String(), "old: 2")
+	assert.Contains(t, rec.Body.String(), "build_count: 3")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?stale=true", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "old: 2 <small>(stale, updated ")
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/gauge/old", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, hourAgo.UTC().Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+}
+
+func TestStorageErrors(t *testing.T) {
+	testCases := []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{"not_found", storage.ErrNotFound, http.StatusNotFound},
+		{"invalid", fmt.Errorf("%w: bad key", storage.ErrInvalid), http.StatusBadRequest},
+		{"unavailable", fmt.Errorf("%w: connection refused", storage.ErrUnavailable), http.StatusServiceUnavailable},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := NewHandler(failingStorage{MemStorage: memstorage.NewStorage(), err: tc.err})
+			r := chi.NewRouter()
+			r.Post("/value/", handler.POSTGetMetric)
+			r.Post("/update/", handler.POSTUpdateMetric)
+			r.Post("/updates/", handler.POSTUpdatesMetrics)
+			r.Get("/value/{type}/{name}", handler.GETGetMetric)
+			r.Post("/update/{type}/{name}/{value}", handler.GETUpdateMetric)
+			r.Get("/", handler.GetMetrics)
+			r.Get("/metrics", handler.GetPrometheusMetrics)
+			r.Delete("/value/{type}/{name}", handler.DELETEMetric)
+			r.Post("/delete/", handler.POSTDeleteMetrics)
+			r.Post("/reset/", handler.POSTResetCounter)
+
+			requests := []struct {
+				method, url, body string
+			}{
+				{http.MethodPost, "/value/", `{"id":"Alloc","type":"gauge"}`},
+				{http.MethodPost, "/value/", `{"id":"PollCount","type":"counter"}`},
+				{http.MethodPost, "/update/", `{"id":"Alloc","type":"gauge","value":1}`},
+				{http.MethodPost, "/update/", `{"id":"PollCount","type":"counter","delta":1}`},
+				{http.MethodPost, "/updates/", `[{"id":"Alloc","type":"gauge","value":1}]`},
+				{http.MethodGet, "/value/gauge/Alloc", ""},
+				{http.MethodPost, "/update/counter/PollCount/1", ""},
+				{http.MethodGet, "/", ""},
+				{http.MethodGet, "/metrics", ""},
+				{http.MethodDelete, "/value/gauge/Alloc", ""},
+				{http.MethodPost, "/delete/", `{"prefix":"Alloc"}`},
+				{http.MethodPost, "/reset/", `{"id":"PollCount","type":"counter"}`},
+			}
+			for _, req := range requests {
+				rec := httptest.NewRecorder()
+				r.ServeHTTP(rec, httptest.NewRequest(req.method, req.url, bytes.NewBufferString(req.body)))
+				assert.Equal(t, tc.expectedCode, rec.Code, "%s %s", req.method, req.url)
+			}
+		})
+	}
+}
+
+func TestPOSTUpdatesMetrics_AllOrNothing(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	body := `[{"id":"Alloc","type":"gauge","value":1},{"id":"Bad","type":"gauge"},{"id":"PollCount","type":"counter","delta":1}]`
+	rec := httptest.NewRecorder()
+	handler.POSTUpdatesMetrics(rec, httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(body)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	gauges, err := ms.KeysGauge(context.Background())
+	require.NoError(t, err)
+	counters, err := ms.KeysCounter(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, gauges)
+	assert.Empty(t, counters)
+}
+
+func TestGetPrometheusMetrics_ServerGauges(t *testing.T) {
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetGauge(context.Background(), "Alloc", 1))
+	handler := NewHandler(ms)
+	handler.AddServerGauge("pg_flush_lag_seconds", func() float64 { return 1.5 })
+
+	rec := httptest.NewRecorder()
+	handler.GetPrometheusMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "# TYPE pg_flush_lag_seconds gauge\npg_flush_lag_seconds 1.5\n")
+	assert.Contains(t, rec.Body.String(), "Alloc 1\n")
+}
+
+func TestPing_Unavailable(t *testing.T) {
+	handler := NewHandler(failingStorage{MemStorage: memstorage.NewStorage(), err: storage.ErrUnavailable})
+
+	rec := httptest.NewRecorder()
+	handler.Ping(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
 // Тест для Ping
 func TestPing(t *testing.T) {
 	ms := memstorage.NewStorage()
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// defaultQuantiles - квантили, оценки которых возвращаются для histogram
+// метрики, если запрос не задает свои.
+var defaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// SetBucketPolicy задает границы корзин, по которым раскладываются отдельные
+// наблюдения histogram метрик. Без вызова используются storage.DefaultBuckets.
+// Вызывается до начала обработки запросов.
+func (h *Handler) SetBucketPolicy(p storage.BucketPolicy) {
+	h.buckets = p
+}
+
+// histogramUpdate подготавливает значение histogram метрики m с ключом key
+// к записи в хранилище: если корзины не переданы, подставляет границы
+// корзин, настроенные для метрики, иначе проверяет, что переданные
+// границы с ними совпадают.
+func (h *Handler) histogramUpdate(m models.Metrics, key string) (models.Metrics, error) {
+	if m.Histogram == nil {
+		return m, errors.New("missing histogram")
+	}
+
+	bounds := h.buckets.Buckets(key)
+	hist := *m.Histogram
+	if len(hist.Buckets) == 0 {
+		hist.Buckets = make([]models.Bucket, len(bounds))
+		for i, b := range bounds {
+			hist.Buckets[i] = models.Bucket{Le: b}
+		}
+	} else {
+		given := make([]float64, len(hist.Buckets))
+		for i, b := range hist.Buckets {
+			given[i] = b.Le
+		}
+		if !slices.Equal(given, bounds) {
+			return m, fmt.Errorf("bucket bounds of %s do not match configured %v", key, bounds)
+		}
+	}
+	hist.Quantiles = nil
+
+	m.Histogram = &hist
+	return m, nil
+}
+
+// histogramResponse возвращает значение histogram метрики с оценками
+// квантилей qs (по умолчанию defaultQuantiles). Для пустой histogram
+// оценки не возвращаются.
+func histogramResponse(hist storage.Histogram, qs []models.Quantile) (*models.Histogram, error) {
+	resp := hist.Model()
+	if len(qs) == 0 {
+		for _, q := range defaultQuantiles {
+			qs = append(qs, models.Quantile{Q: q})
+		}
+	}
+	for _, q := range qs {
+		if q.Q < 0 || q.Q > 1 || math.IsNaN(q.Q) {
+			return nil, fmt.Errorf("quantile %v is out of range [0, 1]", q.Q)
+		}
+		if hist.Count > 0 {
+			resp.Quantiles = append(resp.Quantiles, models.Quantile{Q: q.Q, Value: hist.Quantile(q.Q)})
+		}
+	}
+	return resp, nil
+}
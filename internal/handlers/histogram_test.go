@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newHistogramRouter(ms *memstorage.MemStorage) chi.Router {
+	handler := NewHandler(ms)
+	handler.SetBucketPolicy(storage.BucketPolicy{
+		Default:   []float64{0.1, 0.5, 1},
+		Overrides: map[string][]float64{"db_": {1, 10}},
+	})
+
+	r := chi.NewRouter()
+	r.Post("/update/", handler.POSTUpdateMetric)
+	r.Post("/updates/", handler.POSTUpdatesMetrics)
+	r.Post("/value/", handler.POSTGetMetric)
+	r.Get("/value/{type}/{name}", handler.GETGetMetric)
+	r.Post("/update/{type}/{name}/{value}", handler.GETUpdateMetric)
+	r.Get("/metrics", handler.GetPrometheusMetrics)
+	return r
+}
+
+func serveJSON(t *testing.T, r http.Handler, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data)))
+	return rec
+}
+
+func TestHistogramUpdate(t *testing.T) {
+	ms := memstorage.NewStorage()
+	r := newHistogramRouter(ms)
+
+	// Наблюдения раскладываются по корзинам, настроенным для метрики
+	rec := serveJSON(t, r, "/update/", models.Metrics{
+		ID:        "http_latency",
+		MType:     storage.MetricTypeHistogram,
+		Histogram: &models.Histogram{Observations: []float64{0.05, 0.3}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp models.Metrics
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.NotNil(t, resp.Histogram)
+	assert.Equal(t, []models.Bucket{{Le: 0.1}, {Le: 0.5}, {Le: 1}}, resp.Histogram.Buckets)
+
+	// Предварительно агрегированные корзины и одно наблюдение через URL
+	rec = serveJSON(t, r, "/updates/", []models.Metrics{{
+		ID:    "http_latency",
+		MType: storage.MetricTypeHistogram,
+		Histogram: &models.Histogram{
+			Buckets: []models.Bucket{{Le: 0.1, Count: 1}, {Le: 0.5, Count: 1}, {Le: 1, Count: 2}},
+			Count:   3,
+			Sum:     3.5,
+		},
+	}})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/update/histogram/http_latency/0.7", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	h, err := ms.GetHistogram(context.Background(), "http_latency")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 1, 2, 1}, h.Counts)
+	assert.Equal(t, uint64(6), h.Count)
+	assert.InDelta(t, 4.55, h.Sum, 1e-9)
+
+	invalid := []any{
+		// нет значения
+		models.Metrics{ID: "http_latency", MType: storage.MetricTypeHistogram},
+		// границы не совпадают с настроенными для метрики
+		models.Metrics{ID: "db_query", MType: storage.MetricTypeHistogram, Histogram: &models.Histogram{
+			Buckets: []models.Bucket{{Le: 0.1, Count: 1}, {Le: 0.5, Count: 1}, {Le: 1, Count: 1}},
+			Count:   1,
+		}},
+		// накопленные количества убывают
+		models.Metrics{ID: "db_query", MType: storage.MetricTypeHistogram, Histogram: &models.Histogram{
+			Buckets: []models.Bucket{{Le: 1, Count: 2}, {Le: 10, Count: 1}},
+			Count:   2,
+		}},
+	}
+	for _, m := range invalid {
+		rec := serveJSON(t, r, "/update/", m)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, "%+v", m)
+	}
+	_, err = ms.GetHistogram(context.Background(), "db_query")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestHistogramValue(t *testing.T) {
+	ms := memstorage.NewStorage()
+	r := newHistogramRouter(ms)
+
+	rec := serveJSON(t, r, "/update/", models.Metrics{
+		ID:        "db_query",
+		MType:     storage.MetricTypeHistogram,
+		Labels:    map[string]string{"table": "users"},
+		Histogram: &models.Histogram{Observations: []float64{0.5, 0.5, 5, 5}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/histogram/db_query?table=users&quantile=0.75", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "5.5", rec.Body.String())
+	assert.NotEmpty(t, rec.Header().Get("Last-Modified"))
+
+	for _, q := range []string{"", "x", "1.5"} {
+		rec = httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/value/histogram/db_query?table=users&quantile="+q, nil))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, q)
+	}
+
+	rec = serveJSON(t, r, "/value/", models.Metrics{
+		ID:        "db_query",
+		MType:     storage.MetricTypeHistogram,
+		Labels:    map[string]string{"table": "users"},
+		Histogram: &models.Histogram{Quantiles: []models.Quantile{{Q: 0.5}}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp models.Metrics
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	require.NotNil(t, resp.Histogram)
+	assert.Equal(t, []models.Bucket{{Le: 1, Count: 2}, {Le: 10, Count: 4}}, resp.Histogram.Buckets)
+	assert.Equal(t, uint64(4), resp.Histogram.Count)
+	assert.Equal(t, []models.Quantile{{Q: 0.5, Value: 1}}, resp.Histogram.Quantiles)
+	assert.NotNil(t, resp.Updated)
+
+	rec = serveJSON(t, r, "/value/", models.Metrics{ID: "db_query", MType: storage.MetricTypeHistogram})
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetPrometheusMetrics_Histogram(t *testing.T) {
+	ms := memstorage.NewStorage()
+	r := newHistogramRouter(ms)
+
+	rec := serveJSON(t, r, "/update/", models.Metrics{
+		ID:        "http_latency",
+		MType:     storage.MetricTypeHistogram,
+		Labels:    map[string]string{"host": "web-1"},
+		Histogram: &models.Histogram{Observations: []float64{0.05, 0.3, 2}},
+	})
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	expected := "# TYPE http_latency histogram\n" +
+		`http_latency_bucket{host="web-1",le="0.1"} 1` + "\n" +
+		`http_latency_bucket{host="web-1",le="0.5"} 2` + "\n" +
+		`http_latency_bucket{host="web-1",le="1"} 2` + "\n" +
+		`http_latency_bucket{host="web-1",le="+Inf"} 3` + "\n" +
+		`http_latency_sum{host="web-1"} 2.35` + "\n" +
+		`http_latency_count{host="web-1"} 3` + "\n"
+	assert.Equal(t, expected, rec.Body.String())
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/am0xff/metrics/internal/influx"
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// maxInfluxLineSize - максимальная длина строки line protocol.
+const maxInfluxLineSize = 1 << 20
+
+// SetInfluxMapping задает сопоставление полей line protocol с типами
+// метрик. Без вызова используется отображение по умолчанию (см. influx.Mapping).
+// Вызывается до начала обработки запросов.
+func (h *Handler) SetInfluxMapping(m influx.Mapping) {
+	h.influx = m
+}
+
+// influxSample - значение поля точки, которое записывается в метрику.
+type influxSample struct {
+	name   string
+	labels map[string]string
+	key    string
+	mtype  storage.MetricType
+	field  influx.Field
+	ts     time.Time
+}
+
+// POSTInfluxWrite обрабатывает POST запросы для записи метрик в формате
+// InfluxDB line protocol (см. пакет influx).
+//
+// URL: /api/v1/write?precision={precision}
+// где:
+//   - precision: единица времени точек ns (по умолчанию), us, ms, s, m или h
+//
+// Каждое числовое или логическое поле точки записывается в метрику
+// <measurement>_<поле> с метками, равными тегам точки. Целые поля считаются
+// накопленными значениями и записываются в counter (прирост вычисляется
+// от предыдущего значения серии, при сбросе счетчика в источнике - равен
+// новому значению), остальные - в gauge; сопоставление задается
+// SetInfluxMapping. Строковые поля пропускаются. Точки применяются
+// в порядке времени, точки без времени - как полученные в момент запроса;
+// в хранилище сохраняется время получения.
+//
+// Пустые строки и строки, начинающиеся с #, пропускаются. Строки с ошибками
+// не записываются, остальные записываются одним пакетом.
+//
+// Пример запроса:
+//
+//	curl -X POST 'http://localhost:8080/api/v1/write?precision=s' \
+//	  --data-binary 'cpu,host=web-1 usage_idle=98.5,procs=120i 1700000000'
+//
+// Формат ответа при ошибках в строках описан в models.WriteResult.
+//
+// HTTP статусы:
+//   - 204: все строки записаны
+//   - 400: неверная точность, ошибки в строках (остальные строки записаны)
+//     или метрики отклонены хранилищем
+//   - 503: хранилище недоступно
+//   - 500: прочие ошибки хранилища
+func (h *Handler) POSTInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	precision, err := influx.ParsePrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		result  models.WriteResult
+		samples []influxSample
+		now     = time.Now()
+	)
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxInfluxLineSize)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		lineSamples, err := h.influxSamples(text, precision, now)
+		if err != nil {
+			result.Errors = append(result.Errors, models.LineError{Line: line, Error: err.Error()})
+			continue
+		}
+		samples = append(samples, lineSamples...)
+		result.Written++
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("read body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].ts.Before(samples[j].ts)
+	})
+
+	var counters []string
+	for _, s := range samples {
+		if s.mtype == storage.MetricTypeCounter {
+			counters = append(counters, s.key)
+		}
+	}
+	tx := h.cumulative.begin(r.Context(), h.storageProvider, counters)
+	defer tx.Rollback()
+
+	metrics, err := influxMetrics(tx, samples)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(metrics) > 0 {
+		if err := h.storageProvider.UpdateBatch(r.Context(), metrics); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	tx.Commit()
+
+	if len(result.Errors) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(result); err != nil {
+		return
+	}
+}
+
+// influxSamples разбирает строку line protocol и возвращает значения ее
+// полей. Точке без времени присваивается время now.
+func (h *Handler) influxSamples(line string, precision time.Duration, now time.Time) ([]influxSample, error) {
+	p, err := influx.ParseLine(line, precision)
+	if err != nil {
+		return nil, err
+	}
+	if p.Time.IsZero() {
+		p.Time = now
+	}
+
+	samples := make([]influxSample, 0, len(p.Fields))
+	for _, f := range p.Fields {
+		name := influx.MetricName(p.Measurement, f.Key)
+		mtype, ok := h.influx.Type(name, f)
+		if !ok {
+			continue
+		}
+		if err := storage.ValidateSeries(name, p.Tags); err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Key, err)
+		}
+		if mtype == storage.MetricTypeCounter && f.Type == influx.FieldFloat && math.Abs(f.Value) >= math.MaxInt64 {
+			return nil, fmt.Errorf("field %s: value %v is out of counter range", f.Key, f.Value)
+		}
+		samples = append(samples, influxSample{
+			name:   name,
+			labels: p.Tags,
+			key:    storage.SeriesKey(name, p.Tags),
+			mtype:  mtype,
+			field:  f,
+			ts:     p.Time,
+		})
+	}
+	return samples, nil
+}
+
+// influxMetrics преобразует значения полей в пакет метрик. Для counter
+// записывается прирост от предыдущего значения серии до значения поля
+// (см. cumulativeCounters), поэтому несколько значений одной серии в запросе
+// дают последнее из них.
+func influxMetrics(tx *cumulativeTx, samples []influxSample) ([]models.Metrics, error) {
+	metrics := make([]models.Metrics, 0, len(samples))
+	for _, s := range samples {
+		m := models.Metrics{ID: s.name, MType: s.mtype, Labels: s.labels}
+		switch s.mtype {
+		case storage.MetricTypeGauge:
+			value := s.field.Value
+			m.Value = &value
+		case storage.MetricTypeCounter:
+			value := s.field.Int
+			if s.field.Type == influx.FieldFloat {
+				value = int64(math.Round(s.field.Value))
+			}
+			delta, err := tx.delta(s.key, value)
+			if err != nil {
+				return nil, err
+			}
+			m.Delta = &delta
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, nil
+}
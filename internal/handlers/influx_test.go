@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/influx"
+	"github.com/am0xff/metrics/internal/middleware"
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPOSTInfluxWrite(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.POSTInfluxWrite))
+	defer srv.Close()
+
+	post := func(query, body string) *http.Response {
+		resp, err := http.Post(srv.URL+query, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := post("?precision=s", strings.Join([]string{
+		"# комментарий",
+		"cpu,host=web-1 usage_idle=98.5,procs=120i,version=\"1.0\" 1700000000",
+		"",
+		"net,host=web-1 bytes_recv=1000i,up=true",
+	}, "\n"))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	ctx := context.Background()
+	gauge, err := ms.GetGauge(ctx, `cpu_usage_idle{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(98.5), gauge)
+
+	up, err := ms.GetGauge(ctx, `net_up{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1), up)
+
+	procs, err := ms.GetCounter(ctx, `cpu_procs{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(120), procs)
+
+	_, err = ms.GetGauge(ctx, `cpu_version{host="web-1"}`)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Целые поля - накопленные значения: counter принимает последнее
+	// по времени значение, а не сумму
+	resp = post("?precision=s", strings.Join([]string{
+		"net,host=web-1 bytes_recv=1500i 1700000020",
+		"net,host=web-1 bytes_recv=1200i 1700000010",
+	}, "\n"))
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	recv, err := ms.GetCounter(ctx, `net_bytes_recv{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(1500), recv)
+}
+
+func TestPOSTInfluxWrite_CounterReset(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	ctx := context.Background()
+
+	write := func(value string) storage.Counter {
+		w := httptest.NewRecorder()
+		handler.POSTInfluxWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write",
+			strings.NewReader("net,host=web-1 bytes_recv="+value)))
+		require.Equal(t, http.StatusNoContent, w.Code)
+		c, err := ms.GetCounter(ctx, `net_bytes_recv{host="web-1"}`)
+		require.NoError(t, err)
+		return c
+	}
+
+	assert.Equal(t, storage.Counter(100), write("100i"))
+	assert.Equal(t, storage.Counter(150), write("150i"))
+	// Сброс счетчика в источнике: учитывается новое значение
+	assert.Equal(t, storage.Counter(170), write("20i"))
+	assert.Equal(t, storage.Counter(180), write("30i"))
+}
+
+func TestPOSTInfluxWrite_ConcurrentCounters(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(slowStorage{ms})
+
+	// Одно и то же накопленное значение из разных запросов учитывается один раз
+	const requests = 50
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.POSTInfluxWrite(w, httptest.NewRequest(http.MethodPost, "/api/v1/write",
+				strings.NewReader("net,host=web-1 bytes_recv=1000i")))
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		}()
+	}
+	wg.Wait()
+
+	c, err := ms.GetCounter(context.Background(), `net_bytes_recv{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(1000), c)
+}
+
+func TestPOSTInfluxWrite_LineErrors(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.POSTInfluxWrite))
+	defer srv.Close()
+
+	body := strings.Join([]string{
+		"cpu usage_idle=98.5",
+		"cpu usage_idle",
+		"mem used=x",
+		"mem,1host=a used=1",
+		"disk free=10",
+	}, "\n")
+	resp, err := http.Post(srv.URL, "text/plain", strings.NewReader(body))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	var result models.WriteResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 2, result.Written)
+	require.Len(t, result.Errors, 3)
+	assert.Equal(t, []int{2, 3, 4}, []int{result.Errors[0].Line, result.Errors[1].Line, result.Errors[2].Line})
+	for _, e := range result.Errors {
+		assert.NotEmpty(t, e.Error)
+	}
+
+	// Корректные строки записаны
+	ctx := context.Background()
+	_, err = ms.GetGauge(ctx, "cpu_usage_idle")
+	assert.NoError(t, err)
+	_, err = ms.GetGauge(ctx, "disk_free")
+	assert.NoError(t, err)
+
+	resp, err = http.Post(srv.URL+"?precision=d", "text/plain", strings.NewReader(body))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestPOSTInfluxWrite_Mapping(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	mapping, err := influx.ParseMapping("mem_=gauge,net_=counter")
+	require.NoError(t, err)
+	handler.SetInfluxMapping(mapping)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write",
+		strings.NewReader("mem used=1024i\nnet bytes_sent=10.4"))
+	w := httptest.NewRecorder()
+	handler.POSTInfluxWrite(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	ctx := context.Background()
+	used, err := ms.GetGauge(ctx, "mem_used")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1024), used)
+
+	sent, err := ms.GetCounter(ctx, "net_bytes_sent")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(10), sent)
+}
+
+func TestPOSTInfluxWrite_Gzip(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte("cpu,host=web-1 usage_idle=98.5\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/write", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	middleware.GzipMiddleware(http.HandlerFunc(handler.POSTInfluxWrite), "").ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	gauge, err := ms.GetGauge(context.Background(), `cpu_usage_idle{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(98.5), gauge)
+}
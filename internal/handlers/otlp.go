@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/otlp"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+const (
+	// maxOTLPBodySize - максимальный размер тела запроса OTLP/HTTP.
+	maxOTLPBodySize = 8 << 20
+	// maxOTLPErrors - максимальное число ошибок в сообщении частичного успеха.
+	maxOTLPErrors = 10
+
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+
+	// otlpInvalidArgument - код google.rpc.Code INVALID_ARGUMENT.
+	otlpInvalidArgument = 3
+)
+
+// SetOTLPMapping задает, какие атрибуты ресурса и области инструментирования
+// становятся префиксами имен метрик OTLP. Без вызова все атрибуты
+// становятся метками (см. otlp.Mapping). Вызывается до начала обработки
+// запросов.
+func (h *Handler) SetOTLPMapping(m otlp.Mapping) {
+	h.otlp = m
+}
+
+// otlpPoint - точка метрики OTLP с именем и метками серии.
+type otlpPoint struct {
+	name   string
+	labels map[string]string
+	metric *otlp.Metric
+	number *otlp.NumberPoint
+	hist   *otlp.HistogramPoint
+	time   uint64
+}
+
+// otlpResult - точки, отклоненные при обработке запроса OTLP.
+type otlpResult struct {
+	rejected int64
+	errs     []string
+}
+
+func (res *otlpResult) reject(points int, format string, args ...any) {
+	res.rejected += int64(points)
+	res.errs = append(res.errs, fmt.Sprintf(format, args...))
+}
+
+// message возвращает сообщение о первых maxOTLPErrors ошибках.
+func (res *otlpResult) message() string {
+	if len(res.errs) <= maxOTLPErrors {
+		return strings.Join(res.errs, "; ")
+	}
+	return fmt.Sprintf("%s; and %d more errors",
+		strings.Join(res.errs[:maxOTLPErrors], "; "), len(res.errs)-maxOTLPErrors)
+}
+
+// POSTOTLPMetrics обрабатывает запросы экспорта метрик OpenTelemetry
+// по протоколу OTLP/HTTP (см. пакет otlp).
+//
+// URL: /v1/metrics
+//
+// Тело запроса - сообщение ExportMetricsServiceRequest в формате protobuf
+// (Content-Type: application/x-protobuf) или JSON (Content-Type:
+// application/json). Имя и метки серии определяются по имени метрики
+// и атрибутам ресурса, области инструментирования и точки
+// (см. SetOTLPMapping). Точки применяются в порядке времени; в хранилище
+// сохраняется время получения.
+//
+// Метрики записываются так:
+//   - Gauge и немонотонная накопленная Sum - в gauge;
+//   - монотонная Sum - в counter: приросты (delta) записываются как есть,
+//     накопленные значения (cumulative) преобразуются в приросты
+//     относительно предыдущей точки серии (см. otlp.Deltas);
+//   - Histogram - в histogram с границами корзин из точки; накопленные
+//     значения преобразуются в приросты так же, как для Sum.
+//
+// Точки ExponentialHistogram, Summary, немонотонной Sum с приростами,
+// точки с неконечными значениями или неверными метками, а также отклоненные
+// хранилищем не записываются, остальные точки записываются. Число
+// отклоненных точек и причины возвращаются в поле partial_success ответа.
+// Точки с флагом отсутствия значения пропускаются.
+//
+// Пример конфигурации OpenTelemetry Collector:
+//
+//	exporters:
+//	  otlphttp:
+//	    metrics_endpoint: http://localhost:8080/v1/metrics
+//
+// Ответ - сообщение ExportMetricsServiceResponse в кодировке запроса,
+// при ошибке разбора - сообщение google.rpc.Status.
+//
+// HTTP статусы:
+//   - 200: точки записаны, в том числе частично
+//   - 400: тело запроса не удалось разобрать
+//   - 413: тело запроса слишком большое
+//   - 415: неподдерживаемый Content-Type
+//   - 503: хранилище недоступно
+//   - 500: прочие ошибки хранилища
+func (h *Handler) POSTOTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeProtobuf && contentType != contentTypeJSON {
+		http.Error(w, fmt.Sprintf("unsupported content type %q", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxOTLPBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("read body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var req otlp.Request
+	if contentType == contentTypeProtobuf {
+		req, err = otlp.DecodeProto(body)
+	} else {
+		req, err = otlp.DecodeJSON(body)
+	}
+	if err != nil {
+		writeOTLP(w, contentType, http.StatusBadRequest,
+			otlp.EncodeProtoStatus(otlpInvalidArgument, err.Error()),
+			otlp.EncodeJSONStatus(otlpInvalidArgument, err.Error()))
+		return
+	}
+
+	var res otlpResult
+	points := h.otlpPoints(req, &res)
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].time < points[j].time
+	})
+
+	// Блокируются только накопленные серии запроса
+	var cumulative []string
+	for _, p := range points {
+		if p.metric.Temporality == otlp.TemporalityCumulative {
+			cumulative = append(cumulative, storage.SeriesKey(p.name, p.labels))
+		}
+	}
+	tx := h.otlpDeltas.Begin(cumulative...)
+	defer tx.Rollback()
+
+	metrics := make([]models.Metrics, 0, len(points))
+	for _, p := range points {
+		m, ok, err := otlpMetric(tx, p)
+		if err != nil {
+			res.reject(1, "%s: %v", storage.SeriesKey(p.name, p.labels), err)
+			continue
+		}
+		if ok {
+			metrics = append(metrics, m)
+		}
+	}
+
+	if err := h.otlpWrite(r, tx, metrics, &res); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	tx.Commit()
+
+	msg := res.message()
+	writeOTLP(w, contentType, http.StatusOK,
+		otlp.EncodeProtoResponse(res.rejected, msg),
+		otlp.EncodeJSONResponse(res.rejected, msg))
+}
+
+// otlpPoints возвращает точки поддерживаемых метрик запроса. Точки
+// неподдерживаемых метрик учитываются в res как отклоненные.
+func (h *Handler) otlpPoints(req otlp.Request, res *otlpResult) []otlpPoint {
+	var points []otlpPoint
+	for _, rm := range req.ResourceMetrics {
+		for _, sm := range rm.ScopeMetrics {
+			for i := range sm.Metrics {
+				m := &sm.Metrics[i]
+				switch {
+				case m.Kind == otlp.KindExponentialHistogram:
+					res.reject(m.DataPoints(), "%s: exponential histogram is not supported", m.Name)
+					continue
+				case m.Kind == otlp.KindSummary:
+					res.reject(m.DataPoints(), "%s: summary is not supported", m.Name)
+					continue
+				case m.Kind == otlp.KindSum && !m.Monotonic && m.Temporality == otlp.TemporalityDelta:
+					res.reject(m.DataPoints(), "%s: non-monotonic delta sum is not supported", m.Name)
+					continue
+				case (m.Kind == otlp.KindSum || m.Kind == otlp.KindHistogram) &&
+					m.Temporality != otlp.TemporalityDelta && m.Temporality != otlp.TemporalityCumulative:
+					res.reject(m.DataPoints(), "%s: unspecified aggregation temporality", m.Name)
+					continue
+				}
+
+				for j := range m.Points {
+					p := &m.Points[j]
+					name, labels := h.otlp.Series(rm.Resource, sm.Scope, m.Name, p.Attributes)
+					points = append(points, otlpPoint{name: name, labels: labels, metric: m, number: p, time: p.Time})
+				}
+				for j := range m.HistogramPoints {
+					p := &m.HistogramPoints[j]
+					name, labels := h.otlp.Series(rm.Resource, sm.Scope, m.Name, p.Attributes)
+					points = append(points, otlpPoint{name: name, labels: labels, metric: m, hist: p, time: p.Time})
+				}
+			}
+		}
+	}
+	return points
+}
+
+// otlpMetric преобразует точку OTLP в метрику. Возвращает false, если точка
+// не записывается: у нее нет значения или она задает начальное значение
+// накопленной серии.
+func otlpMetric(tx *otlp.DeltaTx, p otlpPoint) (models.Metrics, bool, error) {
+	if err := storage.ValidateSeries(p.name, p.labels); err != nil {
+		return models.Metrics{}, false, err
+	}
+	m := models.Metrics{ID: p.name, Labels: p.labels}
+	key := storage.SeriesKey(p.name, p.labels)
+	cumulative := p.metric.Temporality == otlp.TemporalityCumulative
+
+	if p.hist != nil {
+		hp := *p.hist
+		if hp.Flags&otlp.FlagNoRecordedValue != 0 {
+			return m, false, nil
+		}
+		if len(hp.BucketCounts) != len(hp.Bounds)+1 {
+			return m, false, fmt.Errorf("%d bucket counts do not match %d bounds", len(hp.BucketCounts), len(hp.Bounds))
+		}
+		if math.IsNaN(hp.Sum) || math.IsInf(hp.Sum, 0) {
+			return m, false, fmt.Errorf("sum %v is not finite", hp.Sum)
+		}
+		if cumulative {
+			var ok bool
+			if hp, ok = tx.Histogram(key, hp); !ok {
+				return m, false, nil
+			}
+		}
+		hist := &models.Histogram{Buckets: make([]models.Bucket, len(hp.Bounds)), Count: hp.Count, Sum: hp.Sum}
+		var count uint64
+		for i, le := range hp.Bounds {
+			count += hp.BucketCounts[i]
+			hist.Buckets[i] = models.Bucket{Le: le, Count: count}
+		}
+		m.MType = storage.MetricTypeHistogram
+		m.Histogram = hist
+		return m, true, nil
+	}
+
+	np := *p.number
+	if np.Flags&otlp.FlagNoRecordedValue != 0 {
+		return m, false, nil
+	}
+	if math.IsNaN(np.Value) || math.IsInf(np.Value, 0) {
+		return m, false, fmt.Errorf("value %v is not finite", np.Value)
+	}
+	if p.metric.Kind == otlp.KindGauge || !p.metric.Monotonic {
+		m.MType = storage.MetricTypeGauge
+		m.Value = &np.Value
+		return m, true, nil
+	}
+
+	if math.Abs(np.Value) >= math.MaxInt64 {
+		return m, false, fmt.Errorf("value %v is out of counter range", np.Value)
+	}
+	delta := int64(math.Round(np.Value))
+	if np.IsInt {
+		delta = np.Int
+	}
+	if cumulative {
+		var ok bool
+		if delta, ok = tx.Counter(key, np.StartTime, np.Value); !ok {
+			return m, false, nil
+		}
+	}
+	m.MType = storage.MetricTypeCounter
+	m.Delta = &delta
+	return m, true, nil
+}
+
+// otlpWrite записывает метрики одним пакетом. Если пакет отклонен
+// хранилищем как некорректный, метрики записываются по одной: отклоненные
+// учитываются в res, а их изменения в tx отменяются. Возвращает ошибку
+// хранилища, не связанную с данными.
+func (h *Handler) otlpWrite(r *http.Request, tx *otlp.DeltaTx, metrics []models.Metrics, res *otlpResult) error {
+	var storageErr error
+	err := storage.UpdateBatchOrEach(r.Context(), h.storageProvider, metrics, func(m models.Metrics, err error) {
+		if !errors.Is(err, storage.ErrInvalid) {
+			if storageErr == nil {
+				storageErr = err
+			}
+			return
+		}
+		key := storage.SeriesKey(m.ID, m.Labels)
+		tx.Discard(key)
+		res.reject(1, "%s: %v", key, err)
+	})
+	if err != nil {
+		return err
+	}
+	return storageErr
+}
+
+// writeOTLP записывает ответ в кодировке запроса contentType.
+func writeOTLP(w http.ResponseWriter, contentType string, status int, protoBody, jsonBody []byte) {
+	body := jsonBody
+	if contentType == contentTypeProtobuf {
+		body = protoBody
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
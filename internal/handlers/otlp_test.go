@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/otlp"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func otlpRequest(contentType string, body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/metrics", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	return req
+}
+
+func otlpMetrics(metrics ...otlp.Metric) otlp.Request {
+	return otlp.Request{ResourceMetrics: []otlp.ResourceMetrics{{
+		Resource: []otlp.Attribute{{Key: "service.name", Value: "checkout"}},
+		ScopeMetrics: []otlp.ScopeMetrics{{
+			Scope:   otlp.Scope{Name: "otelhttp"},
+			Metrics: metrics,
+		}},
+	}}}
+}
+
+func TestPOSTOTLPMetrics_Protobuf(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	handler.SetOTLPMapping(otlp.Mapping{PrefixAttributes: []string{"service.name"}})
+	start := uint64(time.Now().Add(time.Second).UnixNano())
+
+	req := otlpMetrics(
+		otlp.Metric{Name: "memory.usage", Kind: otlp.KindGauge, Points: []otlp.NumberPoint{
+			{Attributes: []otlp.Attribute{{Key: "host.name", Value: "web-1"}}, Time: 2, Value: 7.5},
+			{Attributes: []otlp.Attribute{{Key: "host.name", Value: "web-1"}}, Time: 1, Value: 3},
+		}},
+		otlp.Metric{Name: "queue.size", Kind: otlp.KindSum, Temporality: otlp.TemporalityCumulative, Points: []otlp.NumberPoint{
+			{Value: 12},
+		}},
+		otlp.Metric{Name: "http.requests", Kind: otlp.KindSum, Temporality: otlp.TemporalityDelta, Monotonic: true, Points: []otlp.NumberPoint{
+			{StartTime: start, Value: 3, IsInt: true, Int: 3},
+			{StartTime: start, Value: 4, IsInt: true, Int: 4},
+		}},
+		otlp.Metric{Name: "http.duration", Kind: otlp.KindHistogram, Temporality: otlp.TemporalityDelta, HistogramPoints: []otlp.HistogramPoint{
+			{Count: 5, Sum: 1.5, BucketCounts: []uint64{1, 3, 1}, Bounds: []float64{0.1, 0.5}},
+		}},
+	)
+	w := httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/x-protobuf", otlp.EncodeProto(req)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-protobuf", w.Header().Get("Content-Type"))
+	assert.Empty(t, w.Body.Bytes())
+
+	ctx := context.Background()
+	// Точки применяются в порядке времени
+	g, err := ms.GetGauge(ctx, `checkout.memory.usage{host_name="web-1",otel_scope_name="otelhttp"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(7.5), g)
+
+	g, err = ms.GetGauge(ctx, `checkout.queue.size{otel_scope_name="otelhttp"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(12), g)
+
+	c, err := ms.GetCounter(ctx, `checkout.http.requests{otel_scope_name="otelhttp"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(7), c)
+
+	h, err := ms.GetHistogram(ctx, `checkout.http.duration{otel_scope_name="otelhttp"}`)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.5}, h.Bounds)
+	assert.Equal(t, []uint64{1, 3, 1}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 1.5, h.Sum)
+}
+
+func TestPOSTOTLPMetrics_Cumulative(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	start := uint64(time.Now().Add(time.Second).UnixNano())
+
+	write := func(value int64, counts ...uint64) {
+		var count uint64
+		for _, c := range counts {
+			count += c
+		}
+		req := otlpMetrics(
+			otlp.Metric{Name: "http.requests", Kind: otlp.KindSum, Temporality: otlp.TemporalityCumulative, Monotonic: true, Points: []otlp.NumberPoint{
+				{StartTime: start, Value: float64(value), IsInt: true, Int: value},
+			}},
+			otlp.Metric{Name: "http.duration", Kind: otlp.KindHistogram, Temporality: otlp.TemporalityCumulative, HistogramPoints: []otlp.HistogramPoint{
+				{StartTime: start, Count: count, Sum: float64(count), BucketCounts: counts, Bounds: []float64{1}},
+			}},
+			// Серия начата до запуска сервера: первая точка не записывается
+			otlp.Metric{Name: "old.requests", Kind: otlp.KindSum, Temporality: otlp.TemporalityCumulative, Monotonic: true, Points: []otlp.NumberPoint{
+				{StartTime: 1, Value: float64(value)},
+			}},
+		)
+		w := httptest.NewRecorder()
+		handler.POSTOTLPMetrics(w, otlpRequest("application/x-protobuf", otlp.EncodeProto(req)))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	ctx := context.Background()
+	write(40, 1, 2)
+	write(42, 3, 2)
+
+	c, err := ms.GetCounter(ctx, `http.requests{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(42), c)
+
+	c, err = ms.GetCounter(ctx, `old.requests{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(2), c)
+
+	h, err := ms.GetHistogram(ctx, `http.duration{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{3, 2}, h.Counts)
+	assert.Equal(t, uint64(5), h.Count)
+	assert.Equal(t, 5.0, h.Sum)
+
+	// Сброс счетчика в источнике: учитывается новое значение
+	write(5, 3, 2)
+	c, err = ms.GetCounter(ctx, `http.requests{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(47), c)
+}
+
+func TestPOSTOTLPMetrics_JSONPartialSuccess(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	body := `{"resourceMetrics": [{
+		"resource": {"attributes": [{"key": "service.name", "value": {"stringValue": "checkout"}}]},
+		"scopeMetrics": [{"metrics": [
+			{"name": "cpu.usage", "gauge": {"dataPoints": [
+				{"asDouble": 0.5},
+				{"asDouble": "NaN", "attributes": [{"key": "cpu", "value": {"intValue": "1"}}]},
+				{"asDouble": 0.7, "attributes": [{"key": "cpu", "value": {"intValue": "2"}}], "flags": 1}
+			]}},
+			{"name": "rpc.duration", "summary": {"dataPoints": [{}, {}]}},
+			{"name": "conns", "sum": {"aggregationTemporality": 1, "dataPoints": [{"asInt": "3"}]}}
+		]}]
+	}]}`
+	w := httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/json; charset=utf-8", []byte(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"partialSuccess": {
+		"rejectedDataPoints": "4",
+		"errorMessage": "rpc.duration: summary is not supported; conns: non-monotonic delta sum is not supported; cpu.usage{cpu=\"1\",service_name=\"checkout\"}: value NaN is not finite"
+	}}`, w.Body.String())
+
+	ctx := context.Background()
+	g, err := ms.GetGauge(ctx, `cpu.usage{service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(0.5), g)
+
+	_, err = ms.GetGauge(ctx, `cpu.usage{cpu="2",service_name="checkout"}`)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPOSTOTLPMetrics_RejectedByStorage(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	req := otlpMetrics(
+		otlp.Metric{Name: "up", Kind: otlp.KindGauge, Points: []otlp.NumberPoint{{Value: 1}}},
+		otlp.Metric{Name: "latency", Kind: otlp.KindHistogram, Temporality: otlp.TemporalityDelta, HistogramPoints: []otlp.HistogramPoint{
+			{Count: 1, BucketCounts: []uint64{1, 0}, Bounds: []float64{2}},
+			{Count: 1, BucketCounts: []uint64{1}},
+		}},
+	)
+	w := httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/x-protobuf", otlp.EncodeProto(req)))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Body.Bytes())
+
+	ctx := context.Background()
+	g, err := ms.GetGauge(ctx, `up{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1), g)
+
+	h, err := ms.GetHistogram(ctx, `latency{otel_scope_name="otelhttp",service_name="checkout"}`)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), h.Count)
+}
+
+func TestPOSTOTLPMetrics_Errors(t *testing.T) {
+	handler := NewHandler(memstorage.NewStorage())
+
+	w := httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("text/plain", []byte("up 1")))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/json", []byte("{")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `"code":3`)
+
+	w = httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/x-protobuf", []byte{0x0a}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	handler.POSTOTLPMetrics(w, otlpRequest("application/x-protobuf", make([]byte, maxOTLPBodySize+1)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
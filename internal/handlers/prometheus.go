@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// PrometheusContentType - Content-Type текстового формата экспозиции Prometheus 0.0.4.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// GetPrometheusMetrics обрабатывает GET запросы для выгрузки всех метрик
+// в текстовом формате экспозиции Prometheus 0.0.4.
+//
+// URL: /metrics
+//
+// Имена метрик приводятся к виду [a-zA-Z_:][a-zA-Z0-9_:]*, недопустимые символы
+// заменяются на "_". Метрики выводятся в отсортированном по имени порядке,
+// перед сериями каждой метрики выводится строка "# TYPE". Метки серий
+// выводятся в фигурных скобках после имени. Histogram метрика выводится,
+// как в Prometheus, сериями name_bucket с накопленными количествами
+// наблюдений в корзинах (метка le), name_sum и name_count.
+//
+// Параметры запроса задают фильтр по меткам: /metrics?host=web-1 выводит
+// только серии с меткой host="web-1".
+//
+// Пример ответа:
+//
+//	# TYPE Alloc gauge
+//	Alloc{host="web-1"} 1048576
+//	Alloc{host="web-2"} 2097152
+//	# TYPE PollCount counter
+//	PollCount 42
+//	# TYPE http_latency histogram
+//	http_latency_bucket{le="0.1"} 3
+//	http_latency_bucket{le="0.5"} 7
+//	http_latency_bucket{le="+Inf"} 8
+//	http_latency_sum 1.9
+//	http_latency_count 8
+//
+// Если после нормализации имена нескольких серий совпадают, выводится только первая
+// из них (gauge имеют приоритет над counter, counter - над histogram), так как Prometheus не допускает
+// повторяющихся серий и разных типов у одной метрики.
+//
+// HTTP статусы:
+//   - 200: метрики успешно выгружены
+//   - 405: неверный HTTP метод (ожидается GET)
+//   - 503: хранилище недоступно
+func (h *Handler) GetPrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	matchers := queryLabels(r)
+	families := make(map[string]*promFamily)
+
+	for _, g := range h.serverGauges {
+		addPromSeries(families, storage.MetricTypeGauge, g.name, formatPromFloat(g.value()), matchers)
+	}
+
+	gaugeKeys, err := h.storageProvider.KeysGauge(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	sort.Strings(gaugeKeys)
+	for _, k := range gaugeKeys {
+		v, err := h.storageProvider.GetGauge(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		addPromSeries(families, storage.MetricTypeGauge, k, formatPromFloat(float64(v)), matchers)
+	}
+
+	counterKeys, err := h.storageProvider.KeysCounter(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	sort.Strings(counterKeys)
+	for _, k := range counterKeys {
+		v, err := h.storageProvider.GetCounter(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		addPromSeries(families, storage.MetricTypeCounter, k, strconv.FormatInt(int64(v), 10), matchers)
+	}
+
+	histogramKeys, err := h.storageProvider.KeysHistogram(r.Context())
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	sort.Strings(histogramKeys)
+	for _, k := range histogramKeys {
+		v, err := h.storageProvider.GetHistogram(r.Context(), k)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		addPromFamily(families, storage.MetricTypeHistogram, k, matchers, histogramSamples(v))
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		f := families[name]
+		b.WriteString("# TYPE ")
+		b.WriteString(name)
+		b.WriteByte(' ')
+		b.WriteString(string(f.mtype))
+		b.WriteByte('\n')
+
+		sort.Slice(f.series, func(i, j int) bool { return f.series[i].id < f.series[j].id })
+		for _, s := range f.series {
+			for _, smp := range s.samples {
+				b.WriteString(smp.id)
+				b.WriteByte(' ')
+				b.WriteString(smp.value)
+				b.WriteByte('\n')
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", PrometheusContentType)
+	w.WriteHeader(http.StatusOK)
+
+	_, _ = io.WriteString(w, b.String())
+}
+
+// promFamily - серии одной метрики в выгрузке Prometheus.
+type promFamily struct {
+	mtype  storage.MetricType
+	series []promSeries
+	seen   map[string]bool
+}
+
+// promSeries - серия выгрузки: идентификатор серии с метками и ее строки.
+// У gauge и counter одна строка, у histogram - строки корзин, суммы
+// и количества в порядке вывода.
+type promSeries struct {
+	id      string
+	samples []promSample
+}
+
+// promSample - строка выгрузки: идентификатор с метками и значение.
+type promSample struct {
+	id    string
+	value string
+}
+
+// addPromSeries добавляет серию с ключом хранилища key и значением value
+// в соответствующее семейство, как addPromFamily.
+func addPromSeries(families map[string]*promFamily, mtype storage.MetricType, key, value string, matchers map[string]string) {
+	addPromFamily(families, mtype, key, matchers, func(name string, labels map[string]string) []promSample {
+		return []promSample{{id: storage.SeriesKey(name, labels), value: value}}
+	})
+}
+
+// histogramSamples возвращает функцию, формирующую строки выгрузки
+// histogram метрики hist.
+func histogramSamples(hist storage.Histogram) func(string, map[string]string) []promSample {
+	return func(name string, labels map[string]string) []promSample {
+		cumulative := hist.Cumulative()
+		samples := make([]promSample, 0, len(cumulative)+3)
+
+		bucketLabels := make(map[string]string, len(labels)+1)
+		for k, v := range labels {
+			bucketLabels[k] = v
+		}
+		for i, b := range hist.Bounds {
+			bucketLabels["le"] = formatPromFloat(b)
+			samples = append(samples, promSample{
+				id:    storage.SeriesKey(name+"_bucket", bucketLabels),
+				value: strconv.FormatUint(cumulative[i], 10),
+			})
+		}
+		bucketLabels["le"] = "+Inf"
+		samples = append(samples,
+			promSample{id: storage.SeriesKey(name+"_bucket", bucketLabels), value: strconv.FormatUint(hist.Count, 10)},
+			promSample{id: storage.SeriesKey(name+"_sum", labels), value: formatPromFloat(hist.Sum)},
+			promSample{id: storage.SeriesKey(name+"_count", labels), value: strconv.FormatUint(hist.Count, 10)},
+		)
+		return samples
+	}
+}
+
+// addPromFamily добавляет серию с ключом хранилища key в соответствующее семейство,
+// если ее метки удовлетворяют matchers и она не конфликтует с уже добавленными сериями.
+// Строки серии формирует samples по нормализованному имени и меткам.
+func addPromFamily(families map[string]*promFamily, mtype storage.MetricType, key string, matchers map[string]string, samples func(name string, labels map[string]string) []promSample) {
+	name, labels, err := storage.ParseSeriesKey(key)
+	if err != nil {
+		name, labels = key, nil
+	}
+	if !storage.MatchLabels(labels, matchers) {
+		return
+	}
+
+	name = sanitizeMetricName(name)
+	f, ok := families[name]
+	if !ok {
+		f = &promFamily{mtype: mtype, seen: make(map[string]bool)}
+		families[name] = f
+	}
+	if f.mtype != mtype {
+		return
+	}
+
+	id := storage.SeriesKey(name, labels)
+	if f.seen[id] {
+		return
+	}
+	f.seen[id] = true
+	f.series = append(f.series, promSeries{id: id, samples: samples(name, labels)})
+}
+
+// sanitizeMetricName приводит имя метрики к допустимому в Prometheus виду
+// [a-zA-Z_:][a-zA-Z0-9_:]*. Недопустимые символы заменяются на "_",
+// если имя начинается с цифры, к нему добавляется префикс "_".
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	b.Grow(len(name) + 1)
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_', c == ':':
+			b.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(c)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// formatPromFloat форматирует число с плавающей точкой по правилам Prometheus:
+// бесконечности выводятся как +Inf/-Inf, нечисловое значение - как NaN.
+func formatPromFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPrometheusMetrics(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	ms.SetGauge(ctx, "HeapAlloc", storage.Gauge(1048576))
+	ms.SetGauge(ctx, "cpu.usage-1", storage.Gauge(85.5))
+	ms.SetGauge(ctx, "1stValue", storage.Gauge(-2))
+	ms.SetCounter(ctx, "PollCount", storage.Counter(42))
+
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.GetPrometheusMetrics))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, PrometheusContentType, resp.Header.Get("Content-Type"))
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	expected := "# TYPE HeapAlloc gauge\n" +
+		"HeapAlloc 1048576\n" +
+		"# TYPE PollCount counter\n" +
+		"PollCount 42\n" +
+		"# TYPE _1stValue gauge\n" +
+		"_1stValue -2\n" +
+		"# TYPE cpu_usage_1 gauge\n" +
+		"cpu_usage_1 85.5\n"
+	assert.Equal(t, expected, string(body))
+}
+
+func TestGetPrometheusMetrics_DuplicateNames(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	ms.SetGauge(ctx, "a.b", storage.Gauge(1))
+	ms.SetGauge(ctx, "a-b", storage.Gauge(2))
+	ms.SetCounter(ctx, "a_b", storage.Counter(3))
+
+	handler := NewHandler(ms)
+	w := httptest.NewRecorder()
+	handler.GetPrometheusMetrics(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "# TYPE a_b gauge\na_b 2\n", w.Body.String())
+}
+
+func TestGetPrometheusMetrics_MethodNotAllowed(t *testing.T) {
+	handler := NewHandler(memstorage.NewStorage())
+	w := httptest.NewRecorder()
+	handler.GetPrometheusMetrics(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"HeapAlloc", "HeapAlloc"},
+		{"http_requests:total", "http_requests:total"},
+		{"cpu.usage", "cpu_usage"},
+		{"9lives", "_9lives"},
+		{"метрика", "_______"},
+		{"", "_"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.in, func(t *testing.T) {
+			assert.Equal(t, tc.expected, sanitizeMetricName(tc.in))
+		})
+	}
+}
+
+func TestFormatPromFloat(t *testing.T) {
+	assert.Equal(t, "+Inf", formatPromFloat(math.Inf(1)))
+	assert.Equal(t, "-Inf", formatPromFloat(math.Inf(-1)))
+	assert.Equal(t, "NaN", formatPromFloat(math.NaN()))
+	assert.Equal(t, "0.25", formatPromFloat(0.25))
+}
+
+func TestGetPrometheusMetrics_Labels(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	ms.SetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"host": "web-1", "dc": "eu"}), storage.Gauge(1))
+	ms.SetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"host": "web-2", "dc": "us"}), storage.Gauge(2))
+	ms.SetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"path": `C:\tmp "x"`}), storage.Gauge(3))
+
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.GetPrometheusMetrics))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	expected := "# TYPE Alloc gauge\n" +
+		"Alloc{dc=\"eu\",host=\"web-1\"} 1\n" +
+		"Alloc{dc=\"us\",host=\"web-2\"} 2\n" +
+		"Alloc{path=\"C:\\\\tmp \\\"x\\\"\"} 3\n"
+	assert.Equal(t, expected, string(body))
+
+	resp, err = http.Get(srv.URL + "?dc=eu")
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+
+	assert.Equal(t, "# TYPE Alloc gauge\nAlloc{dc=\"eu\",host=\"web-1\"} 1\n", string(body))
+}
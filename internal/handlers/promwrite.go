@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/promwrite"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// maxPromWriteBodySize - максимальный размер сжатого тела запроса remote_write.
+const maxPromWriteBodySize = 8 << 20
+
+// SetPromWriteCounters задает, записываются ли серии remote_write с именем,
+// оканчивающимся на _total, в counter метрики. Без вызова все серии
+// записываются в gauge. Вызывается до начала обработки запросов.
+func (h *Handler) SetPromWriteCounters(enabled bool) {
+	h.promCounters = enabled
+}
+
+// POSTPromWrite обрабатывает запросы Prometheus remote_write 1.0
+// (см. пакет promwrite).
+//
+// URL: /api/v1/prom/write
+//
+// Тело запроса - сообщение WriteRequest в формате protobuf, сжатое snappy
+// (Content-Encoding: snappy). Имя метрики берется из метки __name__,
+// остальные метки становятся метками серии. Из значений серии записывается
+// значение с наибольшим временем; значения NaN (в том числе метки
+// устаревания Prometheus) и бесконечности пропускаются. В хранилище
+// сохраняется время получения.
+//
+// Серии записываются в gauge. Если включено SetPromWriteCounters, серии
+// с именем на _total записываются в counter: значение считается накопленным,
+// прирост вычисляется от предыдущего значения серии, а при сбросе счетчика
+// в Prometheus равен новому значению.
+//
+// Пример конфигурации Prometheus:
+//
+//	remote_write:
+//	  - url: http://localhost:8080/api/v1/prom/write
+//
+// HTTP статусы:
+//   - 204: все серии записаны
+//   - 400: тело не удалось распаковать или разобрать, серии без имени
+//     или с неверными метками (остальные серии записаны), метрики отклонены
+//     хранилищем
+//   - 413: тело запроса слишком большое
+//   - 415: неподдерживаемый Content-Encoding
+//   - 503: хранилище недоступно
+//   - 500: прочие ошибки хранилища
+func (h *Handler) POSTPromWrite(w http.ResponseWriter, r *http.Request) {
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && enc != "snappy" {
+		http.Error(w, fmt.Sprintf("unsupported content encoding %q", enc), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPromWriteBodySize))
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, fmt.Sprintf("read body: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	req, err := promwrite.Decode(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		metrics  = make([]models.Metrics, 0, len(req.Timeseries))
+		counters []string
+		invalid  []string
+	)
+	for _, ts := range req.Timeseries {
+		m, ok, err := h.promWriteMetric(ts)
+		if err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if m.MType == storage.MetricTypeCounter {
+			counters = append(counters, storage.SeriesKey(m.ID, m.Labels))
+		}
+		metrics = append(metrics, m)
+	}
+
+	tx := h.cumulative.begin(r.Context(), h.storageProvider, counters)
+	defer tx.Rollback()
+
+	for i, m := range metrics {
+		if m.MType != storage.MetricTypeCounter {
+			continue
+		}
+		delta, err := tx.delta(storage.SeriesKey(m.ID, m.Labels), int64(math.Round(*m.Value)))
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		metrics[i].Value, metrics[i].Delta = nil, &delta
+	}
+
+	if len(metrics) > 0 {
+		if err := h.storageProvider.UpdateBatch(r.Context(), metrics); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+	tx.Commit()
+
+	if len(invalid) > 0 {
+		http.Error(w, fmt.Sprintf("%d invalid series: %s", len(invalid), strings.Join(invalid, "; ")), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// promWriteMetric преобразует серию remote_write в метрику. Для counter
+// метрики Value содержит накопленное значение, которое POSTPromWrite
+// заменяет приростом. Возвращает false, если у серии нет конечных значений,
+// и ErrInvalid для недопустимой серии.
+func (h *Handler) promWriteMetric(ts promwrite.TimeSeries) (models.Metrics, bool, error) {
+	name := ts.Name()
+	var labels map[string]string
+	for _, l := range ts.Labels {
+		if l.Name == promwrite.NameLabel {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string, len(ts.Labels)-1)
+		}
+		labels[l.Name] = l.Value
+	}
+	if err := storage.ValidateSeries(name, labels); err != nil {
+		return models.Metrics{}, false, fmt.Errorf("%w: %w", storage.ErrInvalid, err)
+	}
+
+	var (
+		latest promwrite.Sample
+		found  bool
+	)
+	for _, s := range ts.Samples {
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			continue
+		}
+		if !found || s.Timestamp >= latest.Timestamp {
+			latest, found = s, true
+		}
+	}
+	if !found {
+		return models.Metrics{}, false, nil
+	}
+
+	m := models.Metrics{ID: name, MType: storage.MetricTypeGauge, Labels: labels, Value: &latest.Value}
+	if !h.promCounters || !strings.HasSuffix(name, "_total") {
+		return m, true, nil
+	}
+
+	if math.Abs(latest.Value) >= math.MaxInt64 {
+		return models.Metrics{}, false, fmt.Errorf("%w: %s: value %v is out of counter range",
+			storage.ErrInvalid, storage.SeriesKey(name, labels), latest.Value)
+	}
+	m.MType = storage.MetricTypeCounter
+	return m, true, nil
+}
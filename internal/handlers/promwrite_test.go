@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/promwrite"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func promWriteRequest(body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/prom/write", bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return req
+}
+
+func series(name string, samples ...promwrite.Sample) promwrite.TimeSeries {
+	return promwrite.TimeSeries{
+		Labels:  []promwrite.Label{{Name: promwrite.NameLabel, Value: name}, {Name: "instance", Value: "web-1"}},
+		Samples: samples,
+	}
+}
+
+func TestPOSTPromWrite(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	body := promwrite.Encode(promwrite.WriteRequest{Timeseries: []promwrite.TimeSeries{
+		series("node_load1", promwrite.Sample{Value: 0.7, Timestamp: 2000}, promwrite.Sample{Value: 0.5, Timestamp: 1000}),
+		series("http_requests_total", promwrite.Sample{Value: 42, Timestamp: 1000}),
+		series("stale", promwrite.Sample{Value: math.NaN(), Timestamp: 1000}),
+	}})
+	w := httptest.NewRecorder()
+	handler.POSTPromWrite(w, promWriteRequest(body))
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	ctx := context.Background()
+	g, err := ms.GetGauge(ctx, `node_load1{instance="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(0.7), g)
+
+	// Без SetPromWriteCounters серии _total записываются в gauge
+	g, err = ms.GetGauge(ctx, `http_requests_total{instance="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(42), g)
+
+	_, err = ms.GetGauge(ctx, `stale{instance="web-1"}`)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestPOSTPromWrite_Counters(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+	handler.SetPromWriteCounters(true)
+
+	write := func(value float64) {
+		body := promwrite.Encode(promwrite.WriteRequest{Timeseries: []promwrite.TimeSeries{
+			series("http_requests_total", promwrite.Sample{Value: value, Timestamp: 1000}),
+		}})
+		w := httptest.NewRecorder()
+		handler.POSTPromWrite(w, promWriteRequest(body))
+		require.Equal(t, http.StatusNoContent, w.Code)
+	}
+
+	// Значение counter накопленное: повторная запись не удваивает его,
+	// а после сброса счетчика в Prometheus учитывается новое значение
+	key := `http_requests_total{instance="web-1"}`
+	for _, tc := range []struct{ value, want float64 }{{40, 40}, {42, 42}, {42, 42}, {3, 45}, {5, 47}} {
+		write(tc.value)
+		c, err := ms.GetCounter(context.Background(), key)
+		require.NoError(t, err)
+		assert.Equal(t, storage.Counter(tc.want), c)
+	}
+}
+
+func TestPOSTPromWrite_ConcurrentCounters(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(slowStorage{ms})
+	handler.SetPromWriteCounters(true)
+
+	body := promwrite.Encode(promwrite.WriteRequest{Timeseries: []promwrite.TimeSeries{
+		series("http_requests_total", promwrite.Sample{Value: 1000, Timestamp: 1000}),
+	}})
+
+	// Одно и то же накопленное значение из разных запросов учитывается один раз
+	const requests = 50
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.POSTPromWrite(w, promWriteRequest(body))
+			assert.Equal(t, http.StatusNoContent, w.Code)
+		}()
+	}
+	wg.Wait()
+
+	c, err := ms.GetCounter(context.Background(), `http_requests_total{instance="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(1000), c)
+}
+
+func TestPOSTPromWrite_Invalid(t *testing.T) {
+	ms := memstorage.NewStorage()
+	handler := NewHandler(ms)
+
+	// Серия без имени отклоняется, остальные записываются
+	body := promwrite.Encode(promwrite.WriteRequest{Timeseries: []promwrite.TimeSeries{
+		{Labels: []promwrite.Label{{Name: "job", Value: "node"}}, Samples: []promwrite.Sample{{Value: 1}}},
+		series("up", promwrite.Sample{Value: 1}),
+	}})
+	w := httptest.NewRecorder()
+	handler.POSTPromWrite(w, promWriteRequest(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "1 invalid series")
+
+	_, err := ms.GetGauge(context.Background(), `up{instance="web-1"}`)
+	assert.NoError(t, err)
+
+	// Тело не в формате snappy
+	w = httptest.NewRecorder()
+	handler.POSTPromWrite(w, promWriteRequest([]byte("up 1")))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Неподдерживаемое сжатие
+	req := promWriteRequest(body)
+	req.Header.Set("Content-Encoding", "zstd")
+	w = httptest.NewRecorder()
+	handler.POSTPromWrite(w, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+
+	// Слишком большое тело
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/prom/write",
+		io.LimitReader(strings.NewReader(strings.Repeat("x", maxPromWriteBodySize+1)), maxPromWriteBodySize+1))
+	w = httptest.NewRecorder()
+	handler.POSTPromWrite(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/query"
+)
+
+// GETQuery обрабатывает GET запросы для вычисления выражения языка запросов
+// (см. пакет query) по истории значений метрик.
+//
+// URL: /api/v1/query?query={expr}&time={time}
+// или: /api/v1/query?query={expr}&from={from}&to={to}&step={step}
+// где:
+//   - query: выражение, например sum by (host) (rate(PollCount[5m])) (обязательный)
+//   - time: момент вычисления в формате RFC3339 или Unix-время в секундах (по умолчанию текущее время)
+//   - from, to, step: интервал и шаг сетки; если step указан, выражение вычисляется
+//     в каждой точке сетки от from (по умолчанию to - 1h) до to (по умолчанию текущее время)
+//
+// Пример запроса:
+//
+//	curl 'http://localhost:8080/api/v1/query?query=rate(PollCount[5m])&step=1m'
+//
+// Формат ответа описан в models.QueryResult.
+//
+// HTTP статусы:
+//   - 200: выражение успешно вычислено
+//   - 400: не указано или неверно выражение, неверный формат времени или шага
+//   - 503: хранилище недоступно
+//   - 500: прочие ошибки чтения истории из хранилища
+func (h *Handler) GETQuery(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	if q.Get("query") == "" {
+		http.Error(w, "missing query", http.StatusBadRequest)
+		return
+	}
+	expr, err := query.Parse(q.Get("query"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	step, err := parseStep(q.Get("step"))
+	if err != nil {
+		http.Error(w, "invalid step", http.StatusBadRequest)
+		return
+	}
+
+	evaluator := query.NewEvaluator(h.storageProvider)
+	var series []query.Series
+	if step == 0 {
+		ts, err := parseTime(q.Get("time"), time.Now())
+		if err != nil {
+			http.Error(w, "invalid time", http.StatusBadRequest)
+			return
+		}
+		series, err = evaluator.Eval(r.Context(), expr, ts)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	} else {
+		to, err := parseTime(q.Get("to"), time.Now())
+		if err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+		from, err := parseTime(q.Get("from"), to.Add(-defaultQueryRange))
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		if from.After(to) {
+			http.Error(w, "from must not be after to", http.StatusBadRequest)
+			return
+		}
+		if to.Sub(from)/step > maxQueryPoints {
+			http.Error(w, "too many points, increase step", http.StatusBadRequest)
+			return
+		}
+		series, err = evaluator.EvalRange(r.Context(), expr, from, to, step)
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+
+	resp := models.QueryResult{
+		Query:  expr.String(),
+		Series: make([]models.QuerySeries, 0, len(series)),
+	}
+	for _, s := range series {
+		qs := models.QuerySeries{
+			ID:      s.Name,
+			Labels:  s.Labels,
+			Samples: make([]models.Sample, 0, len(s.Samples)),
+		}
+		for _, smp := range s.Samples {
+			qs.Samples = append(qs.Samples, models.Sample{Timestamp: smp.Timestamp, Value: smp.Value})
+		}
+		resp.Series = append(resp.Series, qs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		return
+	}
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+const (
+	// defaultQueryRange - интервал запроса истории, если параметр from не указан.
+	defaultQueryRange = time.Hour
+
+	// maxQueryPoints - максимальное количество точек в ответе при запросе с шагом.
+	maxQueryPoints = 11000
+)
+
+// GETQueryRange обрабатывает GET запросы для получения истории значений метрики за интервал времени.
+//
+// URL: /api/v1/query_range?id={name}&type={type}&from={from}&to={to}&step={step}
+// где:
+//   - id: имя метрики (обязательный)
+//   - type: "gauge" или "counter" (обязательный)
+//   - from: начало интервала в формате RFC3339 или Unix-время в секундах (по умолчанию to - 1h)
+//   - to: конец интервала в формате RFC3339 или Unix-время в секундах (по умолчанию текущее время)
+//   - step: шаг сетки, например "30s" или число секунд; если не указан, возвращаются все значения
+//
+// Остальные параметры запроса задают метки серии, например &host=web-1.
+//
+// При указании step для каждой точки сетки возвращается последнее значение,
+// полученное в пределах одного шага до нее.
+//
+// Пример запроса:
+//
+//	curl 'http://localhost:8080/api/v1/query_range?id=HeapAlloc&type=gauge&step=1m'
+//
+// Формат ответа описан в models.Series.
+//
+// HTTP статусы:
+//   - 200: история успешно возвращена
+//   - 400: неверный тип метрики, метки, формат времени или шага
+//   - 404: не указано имя метрики
+//   - 503: хранилище недоступно
+//   - 500: прочие ошибки чтения истории из хранилища
+func (h *Handler) GETQueryRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	id := q.Get("id")
+	if id == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	mtype := storage.MetricType(q.Get("type"))
+	if mtype != storage.MetricTypeGauge && mtype != storage.MetricTypeCounter {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	labels := queryLabels(r, "id", "type", "from", "to", "step")
+	key, ok := seriesKey(id, labels)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	to, err := parseTime(q.Get("to"), time.Now())
+	if err != nil {
+		http.Error(w, "invalid to", http.StatusBadRequest)
+		return
+	}
+	from, err := parseTime(q.Get("from"), to.Add(-defaultQueryRange))
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	if from.After(to) {
+		http.Error(w, "from must not be after to", http.StatusBadRequest)
+		return
+	}
+
+	step, err := parseStep(q.Get("step"))
+	if err != nil {
+		http.Error(w, "invalid step", http.StatusBadRequest)
+		return
+	}
+	if step > 0 && to.Sub(from)/step > maxQueryPoints {
+		http.Error(w, "too many points, increase step", http.StatusBadRequest)
+		return
+	}
+
+	samples, err := h.storageProvider.QueryRange(r.Context(), mtype, key, from, to)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	samples = storage.Downsample(samples, from, to, step)
+
+	resp := models.Series{
+		ID:      id,
+		MType:   mtype,
+		Labels:  labels,
+		Samples: make([]models.Sample, 0, len(samples)),
+	}
+	for _, s := range samples {
+		resp.Samples = append(resp.Samples, models.Sample{Timestamp: s.Timestamp, Value: s.Value})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(resp); err != nil {
+		return
+	}
+}
+
+// parseTime разбирает время в формате RFC3339 или Unix-время в секундах
+// (допускается дробная часть). Для пустой строки возвращает def.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return time.Time{}, errors.New("invalid unix time")
+	}
+	sec, frac := math.Modf(f)
+	return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+}
+
+// parseStep разбирает шаг в формате time.Duration ("30s", "1m") или число секунд.
+// Для пустой строки возвращает 0.
+func parseStep(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil {
+			return 0, err
+		}
+		d = time.Duration(f * float64(time.Second))
+	}
+	if d < 0 {
+		return 0, errors.New("negative step")
+	}
+	return d, nil
+}
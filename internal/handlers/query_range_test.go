@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGETQueryRange(t *testing.T) {
+	ms := memstorage.NewStorage()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	ms.GaugesHistory.Append("cpu", base.Add(10*time.Second), 1)
+	ms.GaugesHistory.Append("cpu", base.Add(50*time.Second), 2)
+	ms.GaugesHistory.Append("cpu", base.Add(90*time.Second), 3)
+	ms.SetCounter(context.Background(), "requests", storage.Counter(5))
+
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.GETQueryRange))
+	defer srv.Close()
+
+	from := base.Format(time.RFC3339)
+	to := strconv.FormatInt(base.Add(2*time.Minute).Unix(), 10)
+
+	testCases := []struct {
+		name            string
+		query           string
+		expectedCode    int
+		expectedSamples []models.Sample
+	}{
+		{
+			name:         "raw_samples",
+			query:        "?id=cpu&type=gauge&from=" + from + "&to=" + to,
+			expectedCode: http.StatusOK,
+			expectedSamples: []models.Sample{
+				{Timestamp: base.Add(10 * time.Second), Value: 1},
+				{Timestamp: base.Add(50 * time.Second), Value: 2},
+				{Timestamp: base.Add(90 * time.Second), Value: 3},
+			},
+		},
+		{
+			name:         "with_step",
+			query:        "?id=cpu&type=gauge&from=" + from + "&to=" + to + "&step=1m",
+			expectedCode: http.StatusOK,
+			expectedSamples: []models.Sample{
+				{Timestamp: base.Add(time.Minute), Value: 2},
+				{Timestamp: base.Add(2 * time.Minute), Value: 3},
+			},
+		},
+		{
+			name:            "unknown_metric",
+			query:           "?id=unknown&type=gauge&from=" + from + "&to=" + to,
+			expectedCode:    http.StatusOK,
+			expectedSamples: []models.Sample{},
+		},
+		{
+			name:         "missing_id",
+			query:        "?type=gauge",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "invalid_type",
+			query:        "?id=cpu&type=unknown",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid_from",
+			query:        "?id=cpu&type=gauge&from=yesterday",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "from_after_to",
+			query:        "?id=cpu&type=gauge&from=" + to + "&to=" + from,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "invalid_step",
+			query:        "?id=cpu&type=gauge&step=fast",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "too_many_points",
+			query:        "?id=cpu&type=gauge&from=0&to=" + to + "&step=1s",
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := http.Get(srv.URL + tc.query)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, tc.expectedCode, resp.StatusCode)
+			if tc.expectedCode != http.StatusOK {
+				return
+			}
+
+			var series models.Series
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&series))
+			assert.Equal(t, storage.MetricTypeGauge, series.MType)
+			require.Len(t, series.Samples, len(tc.expectedSamples))
+			for i, s := range tc.expectedSamples {
+				assert.True(t, s.Timestamp.Equal(series.Samples[i].Timestamp))
+				assert.Equal(t, s.Value, series.Samples[i].Value)
+			}
+		})
+	}
+}
+
+func TestGETQueryRange_Counter(t *testing.T) {
+	ms := memstorage.NewStorage()
+	ms.SetCounter(context.Background(), "requests", storage.Counter(5))
+	ms.SetCounter(context.Background(), "requests", storage.Counter(7))
+
+	handler := NewHandler(ms)
+	w := httptest.NewRecorder()
+	handler.GETQueryRange(w, httptest.NewRequest(http.MethodGet, "/api/v1/query_range?id=requests&type=counter", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var series models.Series
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &series))
+	require.Len(t, series.Samples, 2)
+	assert.Equal(t, 5.0, series.Samples[0].Value)
+	assert.Equal(t, 12.0, series.Samples[1].Value)
+}
+
+func TestParseTime(t *testing.T) {
+	def := time.Unix(100, 0)
+
+	tm, err := parseTime("", def)
+	require.NoError(t, err)
+	assert.Equal(t, def, tm)
+
+	tm, err = parseTime("1700000000.5", def)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1700000000, 500000000), tm)
+
+	tm, err = parseTime("2024-01-01T00:00:00Z", def)
+	require.NoError(t, err)
+	assert.True(t, tm.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err = parseTime("NaN", def)
+	assert.Error(t, err)
+}
+
+func TestParseStep(t *testing.T) {
+	d, err := parseStep("")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d)
+
+	d, err = parseStep("30s")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, d)
+
+	d, err = parseStep("15")
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, d)
+
+	_, err = parseStep("-1m")
+	assert.Error(t, err)
+}
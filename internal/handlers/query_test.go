@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGETQuery(t *testing.T) {
+	ms := memstorage.NewStorage()
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	for host, values := range map[string][]float64{"web-1": {0, 30, 60}, "web-2": {10, 20, 30}} {
+		key := `PollCount{host="` + host + `"}`
+		ms.Counters.Set(key, 0)
+		for i, v := range values {
+			ms.CountersHistory.Append(key, base.Add(time.Duration(i)*30*time.Second), v)
+		}
+	}
+
+	handler := NewHandler(ms)
+	srv := httptest.NewServer(http.HandlerFunc(handler.GETQuery))
+	defer srv.Close()
+
+	get := func(params url.Values) (*http.Response, models.QueryResult) {
+		resp, err := http.Get(srv.URL + "?" + params.Encode())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var result models.QueryResult
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+		}
+		return resp, result
+	}
+
+	end := base.Add(time.Minute)
+
+	// Мгновенный запрос
+	resp, result := get(url.Values{
+		"query": {"sum(rate(PollCount[5m]))"},
+		"time":  {end.Format(time.RFC3339)},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, models.QueryResult{
+		Query:  "sum(rate(PollCount[5m0s]))",
+		Series: []models.QuerySeries{{Samples: []models.Sample{{Timestamp: end, Value: 1 + 20.0/60}}}},
+	}, result)
+
+	// Запрос с шагом
+	resp, result = get(url.Values{
+		"query": {`PollCount{host="web-2"}`},
+		"from":  {base.Format(time.RFC3339)},
+		"to":    {end.Format(time.RFC3339)},
+		"step":  {"30s"},
+	})
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Len(t, result.Series, 1)
+	assert.Equal(t, "PollCount", result.Series[0].ID)
+	assert.Equal(t, map[string]string{"host": "web-2"}, result.Series[0].Labels)
+	assert.Len(t, result.Series[0].Samples, 3)
+
+	invalid := []url.Values{
+		{},
+		{"query": {"rate(PollCount)"}},
+		{"query": {"PollCount"}, "time": {"yesterday"}},
+		{"query": {"PollCount"}, "step": {"-1s"}},
+		{"query": {"PollCount"}, "step": {"1ms"}},
+		{"query": {"PollCount"}, "step": {"1m"}, "from": {end.Format(time.RFC3339)}, "to": {base.Format(time.RFC3339)}},
+	}
+	for _, params := range invalid {
+		resp, _ := get(params)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, params.Encode())
+	}
+}
@@ -0,0 +1,74 @@
+package influx
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Mapping определяет тип метрики, в которую записывается поле точки.
+// По умолчанию целые поля записываются в counter, остальные числовые
+// и логические - в gauge. Overrides задает тип метрик, имя которых
+// начинается с префикса; при нескольких подходящих префиксах выбирается
+// самый длинный.
+//
+// Пример использования:
+//
+//	m := influx.Mapping{Overrides: map[string]storage.MetricType{"mem_": storage.MetricTypeGauge}}
+//	m.Type("mem_used", influx.Field{Type: influx.FieldInteger}) // gauge
+type Mapping struct {
+	Overrides map[string]storage.MetricType
+}
+
+// MetricName возвращает имя метрики для поля field точки с измерением
+// measurement: cpu и usage_idle дают cpu_usage_idle.
+func MetricName(measurement, field string) string {
+	return measurement + "_" + field
+}
+
+// Type возвращает тип метрики name для поля f. Для строковых полей
+// возвращает false: они не записываются.
+func (m Mapping) Type(name string, f Field) (storage.MetricType, bool) {
+	if f.Type == FieldString {
+		return "", false
+	}
+
+	mtype, matched := storage.MetricTypeGauge, -1
+	if f.Type == FieldInteger || f.Type == FieldUnsigned {
+		mtype = storage.MetricTypeCounter
+	}
+	for prefix, t := range m.Overrides {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			mtype, matched = t, len(prefix)
+		}
+	}
+	return mtype, true
+}
+
+// ParseMapping разбирает переопределения типов в формате
+// "префикс=тип,префикс=тип", например "cpu_=gauge,net_bytes_=counter".
+// Пустая строка означает отображение по умолчанию.
+func ParseMapping(s string) (Mapping, error) {
+	var m Mapping
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		prefix, typ, ok := strings.Cut(rule, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || prefix == "" {
+			return Mapping{}, fmt.Errorf("invalid influx type mapping %q", rule)
+		}
+		mtype := storage.MetricType(strings.TrimSpace(typ))
+		if mtype != storage.MetricTypeGauge && mtype != storage.MetricTypeCounter {
+			return Mapping{}, fmt.Errorf("invalid metric type %q in influx type mapping %q", mtype, rule)
+		}
+		if m.Overrides == nil {
+			m.Overrides = make(map[string]storage.MetricType)
+		}
+		m.Overrides[prefix] = mtype
+	}
+	return m, nil
+}
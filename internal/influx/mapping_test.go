@@ -0,0 +1,50 @@
+package influx
+
+import (
+	"testing"
+
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMapping_Type(t *testing.T) {
+	m, err := ParseMapping("net_=gauge, net_bytes_=counter")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name     string
+		metric   string
+		field    Field
+		expected storage.MetricType
+	}{
+		{"integer_default", "procs_total", Field{Type: FieldInteger}, storage.MetricTypeCounter},
+		{"unsigned_default", "disk_free", Field{Type: FieldUnsigned}, storage.MetricTypeCounter},
+		{"float_default", "cpu_idle", Field{Type: FieldFloat}, storage.MetricTypeGauge},
+		{"boolean_default", "app_up", Field{Type: FieldBoolean}, storage.MetricTypeGauge},
+		{"override", "net_drops", Field{Type: FieldInteger}, storage.MetricTypeGauge},
+		{"longest_prefix", "net_bytes_recv", Field{Type: FieldFloat}, storage.MetricTypeCounter},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mtype, ok := m.Type(tc.metric, tc.field)
+			require.True(t, ok)
+			assert.Equal(t, tc.expected, mtype)
+		})
+	}
+
+	_, ok := m.Type("app_version", Field{Type: FieldString})
+	assert.False(t, ok)
+}
+
+func TestParseMapping_Invalid(t *testing.T) {
+	for _, s := range []string{"cpu_", "=gauge", "cpu_=histogram", "cpu_=summary"} {
+		_, err := ParseMapping(s)
+		assert.Error(t, err, s)
+	}
+
+	m, err := ParseMapping("")
+	require.NoError(t, err)
+	assert.Empty(t, m.Overrides)
+}
@@ -0,0 +1,226 @@
+// Package influx реализует разбор строкового протокола InfluxDB (line protocol)
+// и сопоставление полей точек с типами метрик сервиса.
+//
+// Формат строки:
+//
+//	<measurement>[,<тег>=<значение>...] <поле>=<значение>[,<поле>=<значение>...] [<время>]
+//
+// Пример:
+//
+//	cpu,host=web-1,cpu=cpu0 usage_idle=98.5,usage_user=1.2 1700000000000000000
+//	net,host=web-1 bytes_recv=1048576i
+//
+// Значения полей: число с плавающей точкой (1.5), целое (10i), беззнаковое
+// целое (10u), логическое (t, true, f, false в любом регистре) и строка в
+// двойных кавычках. Запятые, пробелы и знаки равенства в именах экранируются
+// обратной косой чертой.
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrParse возвращается для строки, не соответствующей протоколу.
+var ErrParse = errors.New("line protocol parse error")
+
+// FieldType - тип значения поля.
+type FieldType int
+
+const (
+	FieldFloat FieldType = iota
+	FieldInteger
+	FieldUnsigned
+	FieldBoolean
+	FieldString
+)
+
+// Field - поле точки. Числовое значение (для логических полей 1 или 0)
+// хранится в Value, значение целых полей без потери точности - в Int,
+// значение строковых полей - в Str.
+type Field struct {
+	Key   string
+	Type  FieldType
+	Value float64
+	Int   int64
+	Str   string
+}
+
+// Point - точка, разобранная из одной строки.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+
+	// Time - время точки; нулевое, если оно не указано в строке.
+	Time time.Time
+}
+
+// ParsePrecision возвращает единицу времени точек по названию точности:
+// ns (по умолчанию), u или us, ms, s, m, h.
+func ParsePrecision(s string) (time.Duration, error) {
+	switch s {
+	case "", "ns", "n":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("unknown precision %q", s)
+	}
+}
+
+// ParseLine разбирает строку протокола. Время точки задано в единицах precision.
+func ParseLine(line string, precision time.Duration) (Point, error) {
+	sections := split(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return Point{}, fmt.Errorf("%w: expected measurement, fields and optional timestamp", ErrParse)
+	}
+
+	var p Point
+	series := split(sections[0], ',', false)
+	p.Measurement = unescape(series[0])
+	if p.Measurement == "" {
+		return Point{}, fmt.Errorf("%w: missing measurement", ErrParse)
+	}
+	for _, tag := range series[1:] {
+		k, v, ok := cutUnescaped(tag, '=')
+		if !ok || k == "" || v == "" {
+			return Point{}, fmt.Errorf("%w: invalid tag %q", ErrParse, tag)
+		}
+		if p.Tags == nil {
+			p.Tags = make(map[string]string)
+		}
+		p.Tags[unescape(k)] = unescape(v)
+	}
+
+	for _, field := range split(sections[1], ',', true) {
+		k, v, ok := cutUnescaped(field, '=')
+		if !ok || k == "" || v == "" {
+			return Point{}, fmt.Errorf("%w: invalid field %q", ErrParse, field)
+		}
+		f, err := parseFieldValue(v)
+		if err != nil {
+			return Point{}, fmt.Errorf("%w: field %s: %w", ErrParse, unescape(k), err)
+		}
+		f.Key = unescape(k)
+		p.Fields = append(p.Fields, f)
+	}
+
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return Point{}, fmt.Errorf("%w: invalid timestamp %q", ErrParse, sections[2])
+		}
+		if limit := int64(math.MaxInt64 / precision); ts > limit || ts < -limit {
+			return Point{}, fmt.Errorf("%w: timestamp %d is out of range", ErrParse, ts)
+		}
+		p.Time = time.Unix(0, ts*int64(precision)).UTC()
+	}
+	return p, nil
+}
+
+// parseFieldValue разбирает значение поля.
+func parseFieldValue(v string) (Field, error) {
+	switch {
+	case v[0] == '"':
+		if len(v) < 2 || v[len(v)-1] != '"' {
+			return Field{}, errors.New("unterminated string")
+		}
+		s := strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(v[1 : len(v)-1])
+		return Field{Type: FieldString, Str: s}, nil
+	case strings.HasSuffix(v, "i"):
+		n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+		if err != nil {
+			return Field{}, fmt.Errorf("invalid integer %q", v)
+		}
+		return Field{Type: FieldInteger, Value: float64(n), Int: n}, nil
+	case strings.HasSuffix(v, "u"):
+		n, err := strconv.ParseUint(v[:len(v)-1], 10, 64)
+		if err != nil || n > math.MaxInt64 {
+			return Field{}, fmt.Errorf("invalid unsigned integer %q", v)
+		}
+		return Field{Type: FieldUnsigned, Value: float64(n), Int: int64(n)}, nil
+	}
+
+	switch v {
+	case "t", "T", "true", "True", "TRUE":
+		return Field{Type: FieldBoolean, Value: 1, Int: 1}, nil
+	case "f", "F", "false", "False", "FALSE":
+		return Field{Type: FieldBoolean}, nil
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return Field{}, fmt.Errorf("invalid float %q", v)
+	}
+	return Field{Type: FieldFloat, Value: f}, nil
+}
+
+// split разбивает s по неэкранированному разделителю sep. Если quotes
+// равно true, разделители внутри строк в двойных кавычках не учитываются.
+// Разделитель sep, равный пробелу, может повторяться.
+func split(s string, sep byte, quotes bool) []string {
+	var (
+		parts    []string
+		start    int
+		inQuotes bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quotes:
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			if sep != ' ' || i > start {
+				parts = append(parts, s[start:i])
+			}
+			start = i + 1
+		}
+	}
+	if sep != ' ' || start < len(s) {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// cutUnescaped делит s по первому неэкранированному символу sep.
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescape убирает экранирование запятых, пробелов, знаков равенства,
+// кавычек и обратной косой черты.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`,= "\`, s[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package influx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		name      string
+		input     string
+		precision time.Duration
+		expected  Point
+	}{
+		{
+			name:      "float_without_time",
+			input:     "cpu usage_idle=98.5",
+			precision: time.Nanosecond,
+			expected: Point{
+				Measurement: "cpu",
+				Fields:      []Field{{Key: "usage_idle", Type: FieldFloat, Value: 98.5}},
+			},
+		},
+		{
+			name:      "tags_and_time",
+			input:     "cpu,host=web-1,cpu=cpu0 usage_idle=98.5,procs=120i 1700000000",
+			precision: time.Second,
+			expected: Point{
+				Measurement: "cpu",
+				Tags:        map[string]string{"host": "web-1", "cpu": "cpu0"},
+				Fields: []Field{
+					{Key: "usage_idle", Type: FieldFloat, Value: 98.5},
+					{Key: "procs", Type: FieldInteger, Value: 120, Int: 120},
+				},
+				Time: time.Unix(1700000000, 0).UTC(),
+			},
+		},
+		{
+			name:      "unsigned_and_boolean",
+			input:     "disk free=10u,ok=t,failed=FALSE",
+			precision: time.Nanosecond,
+			expected: Point{
+				Measurement: "disk",
+				Fields: []Field{
+					{Key: "free", Type: FieldUnsigned, Value: 10, Int: 10},
+					{Key: "ok", Type: FieldBoolean, Value: 1, Int: 1},
+					{Key: "failed", Type: FieldBoolean},
+				},
+			},
+		},
+		{
+			name:      "string_with_separators",
+			input:     `app version="1.0, \"beta\"",up=1i`,
+			precision: time.Nanosecond,
+			expected: Point{
+				Measurement: "app",
+				Fields: []Field{
+					{Key: "version", Type: FieldString, Str: `1.0, "beta"`},
+					{Key: "up", Type: FieldInteger, Value: 1, Int: 1},
+				},
+			},
+		},
+		{
+			name:      "escaped_names",
+			input:     `my\ cpu,host\,name=web\=1 idle\ time=1 1000`,
+			precision: time.Millisecond,
+			expected: Point{
+				Measurement: "my cpu",
+				Tags:        map[string]string{"host,name": "web=1"},
+				Fields:      []Field{{Key: "idle time", Type: FieldFloat, Value: 1}},
+				Time:        time.Unix(1, 0).UTC(),
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := ParseLine(tc.input, tc.precision)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, p)
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	invalid := []string{
+		"cpu",
+		",host=a idle=1",
+		"cpu,host idle=1",
+		"cpu,host= idle=1",
+		"cpu idle",
+		"cpu idle=",
+		"cpu idle=x",
+		"cpu idle=1x",
+		"cpu idle=NaN",
+		"cpu idle=-1u",
+		`cpu version="1.0`,
+		"cpu idle=1 abc",
+		"cpu idle=1 1 2",
+	}
+
+	for _, s := range invalid {
+		_, err := ParseLine(s, time.Nanosecond)
+		assert.ErrorIs(t, err, ErrParse, s)
+	}
+
+	_, err := ParseLine("cpu idle=1 9223372036854775807", time.Second)
+	assert.ErrorIs(t, err, ErrParse)
+}
+
+func TestParsePrecision(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"":   time.Nanosecond,
+		"ns": time.Nanosecond,
+		"us": time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"h":  time.Hour,
+	} {
+		p, err := ParsePrecision(s)
+		require.NoError(t, err)
+		assert.Equal(t, expected, p, s)
+	}
+
+	_, err := ParsePrecision("d")
+	assert.Error(t, err)
+}
@@ -0,0 +1,139 @@
+// Package janitor реализует фоновую очистку устаревших серий метрик.
+// Серия устаревает, если не обновлялась дольше времени жизни, заданного
+// политикой storage.TTLPolicy. Устаревшие серии либо удаляются из хранилища,
+// либо только учитываются (помечаются) и скрываются при выводе.
+package janitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Action определяет, что происходит с устаревшими сериями.
+type Action string
+
+const (
+	// ActionHide - устаревшие серии остаются в хранилище и скрываются при выводе.
+	ActionHide Action = "hide"
+	// ActionEvict - устаревшие серии удаляются из хранилища вместе с историей.
+	ActionEvict Action = "evict"
+)
+
+// ParseAction проверяет название действия. Пустая строка означает ActionHide.
+func ParseAction(s string) (Action, error) {
+	switch a := Action(s); a {
+	case "":
+		return ActionHide, nil
+	case ActionHide, ActionEvict:
+		return a, nil
+	default:
+		return "", fmt.Errorf("unknown stale action %q", s)
+	}
+}
+
+// Result - итог одного прохода очистки.
+type Result struct {
+	Stale   int // устаревших серий найдено
+	Evicted int // из них удалено
+}
+
+// Janitor периодически находит устаревшие серии и, в режиме ActionEvict,
+// удаляет их.
+//
+// Пример использования:
+//
+//	j := janitor.New(storage, storage.TTLPolicy{Default: 10 * time.Minute}, janitor.ActionEvict, time.Minute)
+//	go j.Run(ctx)
+type Janitor struct {
+	sp       storage.StorageProvider
+	policy   storage.TTLPolicy
+	action   Action
+	interval time.Duration
+
+	stale atomic.Int64 // устаревших серий в хранилище после последнего прохода
+}
+
+// New создает Janitor с политикой policy, действием action и периодом interval.
+func New(sp storage.StorageProvider, policy storage.TTLPolicy, action Action, interval time.Duration) *Janitor {
+	return &Janitor{
+		sp:       sp,
+		policy:   policy,
+		action:   action,
+		interval: interval,
+	}
+}
+
+// Run выполняет очистку с периодом, заданным при создании, до отмены контекста.
+// Если по политике серии не устаревают, сразу возвращает управление.
+func (j *Janitor) Run(ctx context.Context) {
+	if !j.policy.Enabled() || j.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			res, err := j.Sweep(ctx, now)
+			if err != nil {
+				log.Printf("janitor: sweep: %v", err)
+				continue
+			}
+			if res.Evicted > 0 {
+				log.Printf("janitor: evicted %d stale series", res.Evicted)
+			}
+		}
+	}
+}
+
+// Sweep однократно находит серии, устаревшие к моменту now, и в режиме
+// ActionEvict удаляет их. Серии с разным временем жизни удаляются
+// отдельными вызовами DeleteStale, поэтому серия, обновленная во время
+// прохода, не удаляется.
+func (j *Janitor) Sweep(ctx context.Context, now time.Time) (Result, error) {
+	var res Result
+	for _, mtype := range storage.MetricTypes() {
+		updated, err := j.sp.LastUpdated(ctx, mtype)
+		if err != nil {
+			return res, err
+		}
+
+		byTTL := make(map[time.Duration][]string)
+		for k, ts := range updated {
+			if j.policy.Stale(k, ts, now) {
+				ttl := j.policy.TTL(k)
+				byTTL[ttl] = append(byTTL[ttl], k)
+				res.Stale++
+			}
+		}
+
+		if j.action != ActionEvict {
+			continue
+		}
+		for ttl, keys := range byTTL {
+			n, err := j.sp.DeleteStale(ctx, mtype, keys, now.Add(-ttl))
+			if err != nil {
+				return res, err
+			}
+			res.Evicted += n
+		}
+	}
+
+	j.stale.Store(int64(res.Stale - res.Evicted))
+	return res, nil
+}
+
+// Stale возвращает количество устаревших серий, оставшихся в хранилище
+// после последнего прохода.
+func (j *Janitor) Stale() int {
+	return int(j.stale.Load())
+}
@@ -0,0 +1,89 @@
+package janitor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAction(t *testing.T) {
+	for in, want := range map[string]Action{"": ActionHide, "hide": ActionHide, "evict": ActionEvict} {
+		got, err := ParseAction(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseAction("drop")
+	assert.Error(t, err)
+}
+
+// newStorage возвращает хранилище с сериями, последний раз обновленными
+// в указанные моменты.
+func newStorage(t *testing.T, gauges, counters map[string]time.Time) *memstorage.MemStorage {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	for k, ts := range gauges {
+		require.NoError(t, ms.SetGauge(ctx, k, 1))
+		ms.GaugesUpdated.Set(k, ts)
+	}
+	for k, ts := range counters {
+		require.NoError(t, ms.SetCounter(ctx, k, 1))
+		ms.CountersUpdated.Set(k, ts)
+	}
+	return ms
+}
+
+func TestJanitor_Evict(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	ms := newStorage(t,
+		map[string]time.Time{
+			`Alloc{host="web-1"}`: now.Add(-time.Hour),
+			`Alloc{host="web-2"}`: now.Add(-time.Minute),
+			"disk_free":           now.Add(-time.Hour),
+			"build_info":          now.Add(-24 * time.Hour),
+		},
+		map[string]time.Time{
+			"PollCount": now.Add(-20 * time.Minute),
+		})
+
+	policy := storage.TTLPolicy{
+		Default:   10 * time.Minute,
+		Overrides: map[string]time.Duration{"disk_": 2 * time.Hour, "build_": 0},
+	}
+	j := New(ms, policy, ActionEvict, time.Minute)
+
+	res, err := j.Sweep(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Stale: 2, Evicted: 2}, res)
+	assert.Zero(t, j.Stale())
+
+	gauges, err := ms.KeysGauge(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{`Alloc{host="web-2"}`, "disk_free", "build_info"}, gauges)
+	counters, err := ms.KeysCounter(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, counters)
+}
+
+func TestJanitor_Hide(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	ms := newStorage(t, map[string]time.Time{"Alloc": now.Add(-time.Hour)}, nil)
+	j := New(ms, storage.TTLPolicy{Default: 10 * time.Minute}, ActionHide, time.Minute)
+
+	res, err := j.Sweep(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Stale: 1}, res)
+	assert.Equal(t, 1, j.Stale())
+
+	_, err = ms.GetGauge(ctx, "Alloc")
+	assert.NoError(t, err)
+}
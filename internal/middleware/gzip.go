@@ -10,11 +10,15 @@ import (
 )
 
 // compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
-// сжимать передаваемые данные и выставлять правильные HTTP-заголовки
+// сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
+// Тело сжимается, только если выставлен заголовок Content-Encoding: gzip,
+// остальные ответы передаются как есть
 type compressWriter struct {
-	w   http.ResponseWriter
-	zw  *gzip.Writer
-	key string
+	w           http.ResponseWriter
+	zw          *gzip.Writer
+	key         string
+	wroteHeader bool
+	compress    bool
 }
 
 func newCompressWriter(w http.ResponseWriter, key string) *compressWriter {
@@ -34,22 +38,34 @@ func (c *compressWriter) Write(p []byte) (int, error) {
 		h := utils.CreateHash(p, c.key)
 		c.w.Header().Set("HashSHA256", h)
 	}
+	if !c.wroteHeader {
+		c.WriteHeader(http.StatusOK)
+	}
 
+	if !c.compress {
+		return c.w.Write(p)
+	}
 	return c.zw.Write(p)
 }
 
 func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+
 	contentType := c.w.Header().Get("Content-Type")
 	isJSONOrHTML := strings.Contains(contentType, "application/json") || strings.Contains(contentType, "text/html")
 	if statusCode < 300 && isJSONOrHTML {
 		c.w.Header().Set("Content-Encoding", "gzip")
+		c.compress = true
 	}
 	c.w.WriteHeader(statusCode)
 }
 
-// Close закрывает gzip.Writer
+// Close закрывает gzip.Writer, если ответ сжимался
 func (c *compressWriter) Close() error {
-	if c.zw == nil {
+	if c.zw == nil || !c.compress {
 		return nil
 	}
 	return c.zw.Close()
@@ -131,6 +131,24 @@ func TestCompressWriter_WriteHeader_ErrorStatus(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
 
+func TestGzipMiddleware_ErrorStatusNotCompressed(t *testing.T) {
+	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "bad request"}`))
+	})
+
+	req := httptest.NewRequest("POST", "/test", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	GzipMiddleware(testHandler, "").ServeHTTP(w, req)
+
+	// Тело без заголовка Content-Encoding передается без сжатия
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, `{"error": "bad request"}`, w.Body.String())
+}
+
 func TestCompressWriter_Close(t *testing.T) {
 	w := httptest.NewRecorder()
 	cw := newCompressWriter(w, "")
@@ -4,17 +4,37 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"slices"
 
 	"github.com/am0xff/metrics/internal/utils"
 )
 
+// signedPaths - маршруты записи метрик, подпись которых проверяется,
+// если она передана.
+var signedPaths = []string{"/update/", "/api/v1/prom/write"}
+
+// HashMiddleware проверяет подпись HMAC-SHA256 тела запроса из заголовка
+// HashSHA256, если задан ключ key. Для обновления метрики (POST /update/)
+// и приема Prometheus remote_write (POST /api/v1/prom/write, подписывается
+// сжатое тело) подпись проверяется, только если заголовок передан. Для удаляющих
+// и сбрасывающих метрики запросов (POST /delete/, POST /reset/ и запросов
+// с методом DELETE) подпись обязательна и вычисляется по методу, пути
+// с параметрами и телу запроса (см. utils.RequestHashData). При отсутствии
+// или несовпадении подписи возвращается статус 400.
 func HashMiddleware(next http.Handler, key string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if key != "" &&
-			r.Method == http.MethodPost &&
-			r.URL.Path == "/update/" {
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
 
+		required := isDestructive(r)
+		if required || (r.Method == http.MethodPost && slices.Contains(signedPaths, r.URL.Path)) {
 			sig := r.Header.Get("HashSHA256")
+			if sig == "" && required {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 			if sig != "" {
 				raw, err := io.ReadAll(r.Body)
 				if err != nil {
@@ -25,7 +45,11 @@ func HashMiddleware(next http.Handler, key string) http.Handler {
 
 				r.Body = io.NopCloser(bytes.NewReader(raw))
 
-				if err := utils.ValidateHash(raw, key, sig); err != nil {
+				data := raw
+				if required {
+					data = utils.RequestHashData(r.Method, r.URL.RequestURI(), raw)
+				}
+				if err := utils.ValidateHash(data, key, sig); err != nil {
 					w.WriteHeader(http.StatusBadRequest)
 					return
 				}
@@ -35,3 +59,11 @@ func HashMiddleware(next http.Handler, key string) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// isDestructive сообщает, удаляет или сбрасывает ли запрос метрики.
+func isDestructive(r *http.Request) bool {
+	if r.Method == http.MethodDelete {
+		return true
+	}
+	return r.Method == http.MethodPost && (r.URL.Path == "/delete/" || r.URL.Path == "/reset/")
+}
@@ -105,6 +105,103 @@ func TestHashMiddleware_InvalidHash(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+func TestHashMiddleware_Destructive(t *testing.T) {
+	key := "secret-key"
+	body := `{"id":"requests","type":"counter"}`
+	sign := func(method, uri, body string) string {
+		return utils.CreateHash(utils.RequestHashData(method, uri, []byte(body)), key)
+	}
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		body         string
+		hash         string
+		expectedCode int
+	}{
+		{"reset_signed", http.MethodPost, "/reset/", body, sign(http.MethodPost, "/reset/", body), http.StatusOK},
+		{"reset_body_only", http.MethodPost, "/reset/", body, utils.CreateHash([]byte(body), key), http.StatusBadRequest},
+		{"reset_unsigned", http.MethodPost, "/reset/", body, "", http.StatusBadRequest},
+		{"reset_invalid", http.MethodPost, "/reset/", body, "invalid-hash", http.StatusBadRequest},
+		{"delete_signed", http.MethodPost, "/delete/", body, sign(http.MethodPost, "/delete/", body), http.StatusOK},
+		{"delete_unsigned", http.MethodPost, "/delete/", body, "", http.StatusBadRequest},
+		{"delete_value_signed", http.MethodDelete, "/value/gauge/cpu", "", sign(http.MethodDelete, "/value/gauge/cpu", ""), http.StatusOK},
+		{"delete_value_empty_body", http.MethodDelete, "/value/gauge/cpu", "", utils.CreateHash(nil, key), http.StatusBadRequest},
+		{"delete_value_other_metric", http.MethodDelete, "/value/gauge/b", "", sign(http.MethodDelete, "/value/gauge/a", ""), http.StatusBadRequest},
+		{"delete_value_other_labels", http.MethodDelete, "/value/gauge/cpu?host=web-2", "", sign(http.MethodDelete, "/value/gauge/cpu?host=web-1", ""), http.StatusBadRequest},
+		{"delete_value_labels_signed", http.MethodDelete, "/value/gauge/cpu?host=web-1", "", sign(http.MethodDelete, "/value/gauge/cpu?host=web-1", ""), http.StatusOK},
+		{"delete_value_unsigned", http.MethodDelete, "/value/gauge/cpu", "", "", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handlerCalled := false
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handlerCalled = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			if tc.hash != "" {
+				req.Header.Set("HashSHA256", tc.hash)
+			}
+			w := httptest.NewRecorder()
+
+			HashMiddleware(testHandler, key).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			assert.Equal(t, tc.expectedCode == http.StatusOK, handlerCalled)
+		})
+	}
+
+	// Without key - no signature required
+	req := httptest.NewRequest(http.MethodPost, "/reset/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	HashMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "").ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHashMiddleware_PromWrite(t *testing.T) {
+	key := "secret-key"
+	body := "\x10\x00snappy"
+
+	testCases := []struct {
+		name         string
+		hash         string
+		expectedCode int
+	}{
+		{"signed", utils.CreateHash([]byte(body), key), http.StatusOK},
+		{"unsigned", "", http.StatusOK},
+		{"invalid", "invalid-hash", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received string
+			testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				raw, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				received = string(raw)
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/prom/write", strings.NewReader(body))
+			if tc.hash != "" {
+				req.Header.Set("HashSHA256", tc.hash)
+			}
+			w := httptest.NewRecorder()
+
+			HashMiddleware(testHandler, key).ServeHTTP(w, req)
+
+			assert.Equal(t, tc.expectedCode, w.Code)
+			if tc.expectedCode == http.StatusOK {
+				assert.Equal(t, body, received)
+			}
+		})
+	}
+}
+
 func TestHashMiddleware_ReadError(t *testing.T) {
 	handlerCalled := false
 	testHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -5,10 +5,20 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/am0xff/metrics/internal/utils"
 )
 
+// EncryptionVersionHeader - заголовок, в котором агент указывает версию
+// формата шифрования тела запроса (см. utils.EncryptEnvelope). Старые агенты
+// заголовок не передают и шифруют тело напрямую RSA-OAEP; такие запросы
+// по-прежнему принимаются. Если версия не поддерживается, сервер отвечает
+// 415 и возвращает в этом же заголовке поддерживаемую версию.
+const EncryptionVersionHeader = "X-Encryption-Version"
+
+// RSAMiddleware расшифровывает тело запросов на обновление метрик
+// приватным ключом из файла cryptoKeyPath.
 func RSAMiddleware(next http.Handler, cryptoKeyPath string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Если путь к ключу не указан, пропускаем без изменений
@@ -32,6 +42,17 @@ func RSAMiddleware(next http.Handler, cryptoKeyPath string) http.Handler {
 			return
 		}
 
+		version := 0
+		if v := r.Header.Get(EncryptionVersionHeader); v != "" {
+			var err error
+			version, err = strconv.Atoi(v)
+			if err != nil || version != utils.EnvelopeVersion1 {
+				w.Header().Set(EncryptionVersionHeader, strconv.Itoa(utils.EnvelopeVersion))
+				w.WriteHeader(http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
 		// Читаем тело запроса
 		encryptedData, err := io.ReadAll(r.Body)
 		if err != nil {
@@ -49,8 +70,14 @@ func RSAMiddleware(next http.Handler, cryptoKeyPath string) http.Handler {
 			return
 		}
 
-		// Расшифровываем данные
-		decryptedData, err := utils.DecryptRSA(encryptedData, privateKey)
+		// Расшифровываем данные: конверт указанной версии или, если версия
+		// не указана, конверт либо данные в старом формате RSA-OAEP
+		var decryptedData []byte
+		if version != 0 {
+			decryptedData, err = utils.DecryptEnvelope(encryptedData, privateKey)
+		} else {
+			decryptedData, err = utils.DecryptPayload(encryptedData, privateKey)
+		}
 		if err != nil {
 			log.Printf("RSA middleware: failed to decrypt data: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
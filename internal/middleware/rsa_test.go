@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/am0xff/metrics/internal/utils"
+)
+
+// writePrivateKey создает RSA ключ и сохраняет приватную часть в PEM файл.
+func writePrivateKey(t *testing.T) (*rsa.PrivateKey, string) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "private.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return privateKey, path
+}
+
+func TestRSAMiddleware(t *testing.T) {
+	privateKey, keyPath := writePrivateKey(t)
+	payload := bytes.Repeat([]byte(`{"id":"Alloc","type":"gauge","value":1}`), 100)
+
+	var received []byte
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		received, err = io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := RSAMiddleware(next, keyPath)
+
+	envelope, err := utils.EncryptEnvelope(payload, &privateKey.PublicKey)
+	require.NoError(t, err)
+	legacy, err := utils.EncryptRSA([]byte("small"), &privateKey.PublicKey)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name         string
+		body         []byte
+		version      string
+		expectedCode int
+		expectedBody []byte
+	}{
+		{"envelope_v1", envelope, "1", http.StatusOK, payload},
+		{"envelope_without_header", envelope, "", http.StatusOK, payload},
+		{"legacy_agent", legacy, "", http.StatusOK, []byte("small")},
+		{"legacy_with_v1_header", legacy, "1", http.StatusBadRequest, nil},
+		{"unsupported_version", envelope, "2", http.StatusUnsupportedMediaType, nil},
+		{"garbage", []byte("garbage"), "", http.StatusBadRequest, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tc.body))
+			if tc.version != "" {
+				req.Header.Set(EncryptionVersionHeader, tc.version)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+			assert.Equal(t, tc.expectedBody, received)
+			if tc.expectedCode == http.StatusUnsupportedMediaType {
+				assert.Equal(t, "1", rec.Header().Get(EncryptionVersionHeader))
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// writePaths - префиксы маршрутов, изменяющих метрики.
+var writePaths = []string{"/update/", "/updates/", "/delete/", "/reset/", "/api/v1/write", "/api/v1/prom/write", "/v1/metrics"}
+
+// TrustedSubnetMiddleware отклоняет запросы к маршрутам изменения метрик
+// (/update/, /updates/, /update/{type}/{name}/{value}, /delete/, /reset/,
+// /api/v1/write, /api/v1/prom/write, /v1/metrics и запросы с методом DELETE)
+// со статусом 403, если IP-адрес из заголовка X-Real-IP не входит в подсеть
+// subnet или заголовок отсутствует. Если subnet равен nil, запросы пропускаются без проверки.
+func TrustedSubnetMiddleware(next http.Handler, subnet *net.IPNet) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subnet == nil || (r.Method != http.MethodDelete && !isWritePath(r.URL.Path)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP")))
+		if ip == nil || !subnet.Contains(ip) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isWritePath(path string) bool {
+	for _, p := range writePaths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := TrustedSubnetMiddleware(next, subnet)
+
+	testCases := []struct {
+		name         string
+		method       string
+		path         string
+		realIP       string
+		expectedCode int
+	}{
+		{"update_trusted", http.MethodPost, "/update/", "192.168.1.10", http.StatusOK},
+		{"updates_trusted", http.MethodPost, "/updates/", "192.168.1.10", http.StatusOK},
+		{"update_url_trusted", http.MethodPost, "/update/gauge/cpu/1", "192.168.1.10", http.StatusOK},
+		{"update_untrusted", http.MethodPost, "/update/", "10.0.0.1", http.StatusForbidden},
+		{"updates_untrusted", http.MethodPost, "/updates/", "10.0.0.1", http.StatusForbidden},
+		{"update_url_untrusted", http.MethodPost, "/update/gauge/cpu/1", "10.0.0.1", http.StatusForbidden},
+		{"update_no_header", http.MethodPost, "/update/", "", http.StatusForbidden},
+		{"update_invalid_header", http.MethodPost, "/update/", "not-an-ip", http.StatusForbidden},
+		{"delete_trusted", http.MethodPost, "/delete/", "192.168.1.10", http.StatusOK},
+		{"delete_untrusted", http.MethodPost, "/delete/", "10.0.0.1", http.StatusForbidden},
+		{"reset_untrusted", http.MethodPost, "/reset/", "10.0.0.1", http.StatusForbidden},
+		{"influx_write_untrusted", http.MethodPost, "/api/v1/write", "10.0.0.1", http.StatusForbidden},
+		{"prom_write_trusted", http.MethodPost, "/api/v1/prom/write", "192.168.1.10", http.StatusOK},
+		{"prom_write_untrusted", http.MethodPost, "/api/v1/prom/write", "10.0.0.1", http.StatusForbidden},
+		{"otlp_untrusted", http.MethodPost, "/v1/metrics", "10.0.0.1", http.StatusForbidden},
+		{"delete_value_trusted", http.MethodDelete, "/value/gauge/cpu", "192.168.1.10", http.StatusOK},
+		{"delete_value_untrusted", http.MethodDelete, "/value/gauge/cpu", "10.0.0.1", http.StatusForbidden},
+		{"read_untrusted", http.MethodGet, "/value/gauge/cpu", "10.0.0.1", http.StatusOK},
+		{"value_untrusted", http.MethodPost, "/value/", "10.0.0.1", http.StatusOK},
+		{"ping_no_header", http.MethodGet, "/ping", "", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+			if tc.realIP != "" {
+				req.Header.Set("X-Real-IP", tc.realIP)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.expectedCode, rec.Code)
+		})
+	}
+}
+
+func TestTrustedSubnetMiddleware_Disabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := TrustedSubnetMiddleware(next, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -5,34 +5,74 @@ package models
 
 import (
 	"strconv"
+	"time"
+)
+
+// MetricType определяет тип метрики. В пакете storage доступен
+// под тем же именем (storage.MetricType).
+type MetricType string
+
+const (
+	// MetricTypeGauge представляет тип метрики gauge.
+	// Используется для измеряемых показателей, которые могут увеличиваться и уменьшаться.
+	MetricTypeGauge MetricType = "gauge"
+
+	// MetricTypeCounter представляет тип метрики counter.
+	// Используется для счетчиков, которые только увеличиваются.
+	MetricTypeCounter MetricType = "counter"
 
-	"github.com/am0xff/metrics/internal/storage"
+	// MetricTypeHistogram представляет тип метрики histogram.
+	// Используется для распределений значений, например времени ответа.
+	MetricTypeHistogram MetricType = "histogram"
 )
 
 // Metrics представляет структуру данных для метрики.
-// Структура поддерживает два типа метрик: gauge и counter.
-// Для gauge используется поле Value, для counter - поле Delta.
+// Структура поддерживает три типа метрик: gauge, counter и histogram.
+// Для gauge используется поле Value, для counter - поле Delta,
+// для histogram - поле Histogram.
+// Необязательные метки Labels вместе с ID определяют серию метрики:
+// метрики с одинаковым именем и разными метками хранятся независимо.
 //
 // Пример использования:
 //
 //	// Создание gauge метрики
 //	gaugeMetric := Metrics{
 //		ID:    "cpu_usage",
-//		MType: storage.MetricTypeGauge,
+//		MType: MetricTypeGauge,
 //		Value: &[]float64{85.5}[0],
 //	}
 //
 //	// Создание counter метрики
 //	counterMetric := Metrics{
 //		ID:    "requests_total",
-//		MType: storage.MetricTypeCounter,
+//		MType: MetricTypeCounter,
 //		Delta: &[]int64{1}[0],
 //	}
+//
+//	// Создание gauge метрики с метками
+//	hostMetric := Metrics{
+//		ID:     "Alloc",
+//		MType:  MetricTypeGauge,
+//		Value:  &[]float64{1024}[0],
+//		Labels: map[string]string{"host": "web-1"},
+//	}
+//
+//	// Создание histogram метрики из отдельных наблюдений
+//	latencyMetric := Metrics{
+//		ID:        "http_latency",
+//		MType:     MetricTypeHistogram,
+//		Histogram: &Histogram{Observations: []float64{0.12, 0.3}},
+//	}
 type Metrics struct {
-	ID    string             `json:"id"`              // имя метрики
-	MType storage.MetricType `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64             `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64           `json:"value,omitempty"` // значение метрики в случае передачи gauge
+	ID     string            `json:"id"`               // имя метрики
+	MType  MetricType        `json:"type"`             // параметр, принимающий значение gauge, counter или histogram
+	Delta  *int64            `json:"delta,omitempty"`  // значение метрики в случае передачи counter
+	Value  *float64          `json:"value,omitempty"`  // значение метрики в случае передачи gauge
+	Labels map[string]string `json:"labels,omitempty"` // метки метрики
+
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+
+	Updated *time.Time `json:"updated,omitempty"` // время последнего обновления (только в ответах сервера)
 }
 
 // String возвращает строковое представление значения метрики.
@@ -44,20 +84,154 @@ type Metrics struct {
 //
 //	metric := Metrics{
 //		ID:    "temperature",
-//		MType: storage.MetricTypeGauge,
+//		MType: MetricTypeGauge,
 //		Value: &[]float64{23.5}[0],
 //	}
 //	fmt.Println(metric.String()) // Выведет: "23.5"
 func (m Metrics) String() string {
 	switch m.MType {
-	case storage.MetricTypeGauge:
+	case MetricTypeGauge:
 		if m.Value != nil {
 			return strconv.FormatFloat(*m.Value, 'f', -1, 64)
 		}
-	case storage.MetricTypeCounter:
+	case MetricTypeCounter:
 		if m.Delta != nil {
 			return strconv.FormatInt(*m.Delta, 10)
 		}
 	}
 	return ""
 }
+
+// Histogram представляет значение histogram метрики: число наблюдений
+// в корзинах с заданными верхними границами, их общее число и сумму.
+//
+// В запросе обновления наблюдения передаются либо по отдельности
+// в Observations (сервер раскладывает их по корзинам, настроенным для
+// метрики), либо предварительно агрегированными в Buckets, Count и Sum.
+// В ответе Buckets, Count и Sum содержат накопленное значение метрики,
+// а Quantiles - оценки квантилей.
+//
+// Пример предварительно агрегированного значения:
+//
+//	{
+//		"buckets": [{"le": 0.1, "count": 3}, {"le": 0.5, "count": 7}],
+//		"count": 8,
+//		"sum": 1.9
+//	}
+//
+// Наблюдения больше последней границы учитываются только в count.
+type Histogram struct {
+	Observations []float64  `json:"observations,omitempty"` // отдельные наблюдения (только в запросах)
+	Buckets      []Bucket   `json:"buckets,omitempty"`      // корзины по возрастанию границ
+	Count        uint64     `json:"count"`                  // общее число наблюдений
+	Sum          float64    `json:"sum"`                    // сумма наблюдений
+	Quantiles    []Quantile `json:"quantiles,omitempty"`    // оценки квантилей (в запросе - нужные квантили q)
+}
+
+// Bucket - корзина histogram метрики: число наблюдений, не превышающих Le.
+// Как и в Prometheus, число накопленное: включает наблюдения всех
+// предыдущих корзин.
+type Bucket struct {
+	Le    float64 `json:"le"`    // верхняя граница корзины
+	Count uint64  `json:"count"` // число наблюдений не больше Le
+}
+
+// Quantile - оценка квантиля Q распределения histogram метрики.
+type Quantile struct {
+	Q     float64 `json:"q"`     // квантиль от 0 до 1
+	Value float64 `json:"value"` // оценка значения (только в ответах сервера)
+}
+
+// Sample представляет значение метрики в определенный момент времени.
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"` // время получения значения
+	Value     float64   `json:"value"`     // значение метрики
+}
+
+// Series представляет историю значений метрики, возвращаемую запросом за интервал времени.
+//
+// Пример ответа:
+//
+//	{
+//		"id": "HeapAlloc",
+//		"type": "gauge",
+//		"samples": [
+//			{"timestamp": "2024-01-01T10:00:00Z", "value": 1048576},
+//			{"timestamp": "2024-01-01T10:00:10Z", "value": 2097152}
+//		]
+//	}
+type Series struct {
+	ID      string            `json:"id"`               // имя метрики
+	MType   MetricType        `json:"type"`             // тип метрики
+	Labels  map[string]string `json:"labels,omitempty"` // метки метрики
+	Samples []Sample          `json:"samples"`          // значения, упорядоченные по времени
+}
+
+// QueryResult представляет результат вычисления выражения языка запросов.
+//
+// Пример ответа:
+//
+//	{
+//		"query": "sum by (host) (rate(PollCount[5m0s]))",
+//		"series": [
+//			{
+//				"labels": {"host": "web-1"},
+//				"samples": [{"timestamp": "2024-01-01T10:00:00Z", "value": 0.5}]
+//			}
+//		]
+//	}
+type QueryResult struct {
+	Query  string        `json:"query"`  // выражение в каноническом виде
+	Series []QuerySeries `json:"series"` // серии результата, упорядоченные по имени и меткам
+}
+
+// QuerySeries представляет одну серию результата запроса.
+type QuerySeries struct {
+	ID      string            `json:"id,omitempty"`     // имя метрики, пусто для результатов функций и агрегаций
+	Labels  map[string]string `json:"labels,omitempty"` // метки серии
+	Samples []Sample          `json:"samples"`          // значения, упорядоченные по времени
+}
+
+// WriteResult представляет результат записи метрик в текстовом формате,
+// разбираемом построчно (например, InfluxDB line protocol). Строки с ошибками
+// пропускаются, остальные записываются.
+//
+// Пример ответа:
+//
+//	{
+//		"written": 2,
+//		"errors": [{"line": 3, "error": "line protocol parse error: invalid field \"x\""}]
+//	}
+type WriteResult struct {
+	Written int         `json:"written"`          // количество записанных строк
+	Errors  []LineError `json:"errors,omitempty"` // ошибки в порядке строк
+}
+
+// LineError описывает ошибку в одной строке запроса.
+type LineError struct {
+	Line  int    `json:"line"`  // номер строки, начиная с 1
+	Error string `json:"error"` // описание ошибки
+}
+
+// DeleteRequest описывает запрос массового удаления метрик.
+// Удаляются серии, имя которых начинается с Prefix и которые содержат
+// все метки Labels. Пустой Type означает метрики всех типов.
+// Хотя бы одно из полей prefix и labels обязательно.
+//
+// Пример запроса:
+//
+//	{
+//		"type": "gauge",
+//		"prefix": "disk_",
+//		"labels": {"host": "web-1"}
+//	}
+type DeleteRequest struct {
+	MType  MetricType        `json:"type,omitempty"`   // тип метрик
+	Prefix string            `json:"prefix,omitempty"` // префикс имени метрики
+	Labels map[string]string `json:"labels,omitempty"` // метки, которые должна содержать серия
+}
+
+// DeleteResponse - результат массового удаления метрик.
+type DeleteResponse struct {
+	Deleted int `json:"deleted"` // количество удаленных серий
+}
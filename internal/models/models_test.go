@@ -3,7 +3,6 @@ package models
 import (
 	"testing"
 
-	"github.com/am0xff/metrics/internal/storage"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -54,7 +53,7 @@ func TestMetrics_String_Gauge(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			metric := Metrics{
 				ID:    "test_gauge",
-				MType: storage.MetricTypeGauge,
+				MType: MetricTypeGauge,
 				Value: tt.value,
 			}
 			result := metric.String()
@@ -105,7 +104,7 @@ func TestMetrics_String_Counter(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			metric := Metrics{
 				ID:    "test_counter",
-				MType: storage.MetricTypeCounter,
+				MType: MetricTypeCounter,
 				Delta: tt.delta,
 			}
 			result := metric.String()
@@ -129,12 +128,12 @@ func TestMetrics_Creation_Gauge(t *testing.T) {
 	value := 85.5
 	metric := Metrics{
 		ID:    "cpu_usage",
-		MType: storage.MetricTypeGauge,
+		MType: MetricTypeGauge,
 		Value: &value,
 	}
 
 	assert.Equal(t, "cpu_usage", metric.ID)
-	assert.Equal(t, storage.MetricTypeGauge, metric.MType)
+	assert.Equal(t, MetricTypeGauge, metric.MType)
 	assert.NotNil(t, metric.Value)
 	assert.Equal(t, 85.5, *metric.Value)
 	assert.Nil(t, metric.Delta)
@@ -144,12 +143,12 @@ func TestMetrics_Creation_Counter(t *testing.T) {
 	delta := int64(1)
 	metric := Metrics{
 		ID:    "requests_total",
-		MType: storage.MetricTypeCounter,
+		MType: MetricTypeCounter,
 		Delta: &delta,
 	}
 
 	assert.Equal(t, "requests_total", metric.ID)
-	assert.Equal(t, storage.MetricTypeCounter, metric.MType)
+	assert.Equal(t, MetricTypeCounter, metric.MType)
 	assert.NotNil(t, metric.Delta)
 	assert.Equal(t, int64(1), *metric.Delta)
 	assert.Nil(t, metric.Value)
@@ -164,7 +163,7 @@ func TestMetrics_EmptyValues(t *testing.T) {
 			name: "gauge with nil value",
 			metric: Metrics{
 				ID:    "empty_gauge",
-				MType: storage.MetricTypeGauge,
+				MType: MetricTypeGauge,
 				Value: nil,
 			},
 		},
@@ -172,7 +171,7 @@ func TestMetrics_EmptyValues(t *testing.T) {
 			name: "counter with nil delta",
 			metric: Metrics{
 				ID:    "empty_counter",
-				MType: storage.MetricTypeCounter,
+				MType: MetricTypeCounter,
 				Delta: nil,
 			},
 		},
@@ -193,7 +192,7 @@ func TestMetrics_BothValuesSet(t *testing.T) {
 
 	gaugeMetric := Metrics{
 		ID:    "test_gauge",
-		MType: storage.MetricTypeGauge,
+		MType: MetricTypeGauge,
 		Value: &value,
 		Delta: &delta, // This should be ignored for gauge
 	}
@@ -201,7 +200,7 @@ func TestMetrics_BothValuesSet(t *testing.T) {
 
 	counterMetric := Metrics{
 		ID:    "test_counter",
-		MType: storage.MetricTypeCounter,
+		MType: MetricTypeCounter,
 		Value: &value, // This should be ignored for counter
 		Delta: &delta,
 	}
@@ -215,13 +214,13 @@ func TestMetrics_JSONTags(t *testing.T) {
 	value := 42.5
 	metric := Metrics{
 		ID:    "test_metric",
-		MType: storage.MetricTypeGauge,
+		MType: MetricTypeGauge,
 		Value: &value,
 	}
 
 	// Basic verification that fields are accessible
 	assert.Equal(t, "test_metric", metric.ID)
-	assert.Equal(t, storage.MetricTypeGauge, metric.MType)
+	assert.Equal(t, MetricTypeGauge, metric.MType)
 	assert.Equal(t, 42.5, *metric.Value)
 }
 
@@ -230,7 +229,7 @@ func BenchmarkMetrics_String_Gauge(b *testing.B) {
 	value := 123.456789
 	metric := Metrics{
 		ID:    "benchmark_gauge",
-		MType: storage.MetricTypeGauge,
+		MType: MetricTypeGauge,
 		Value: &value,
 	}
 
@@ -244,7 +243,7 @@ func BenchmarkMetrics_String_Counter(b *testing.B) {
 	delta := int64(123456789)
 	metric := Metrics{
 		ID:    "benchmark_counter",
-		MType: storage.MetricTypeCounter,
+		MType: MetricTypeCounter,
 		Delta: &delta,
 	}
 
@@ -0,0 +1,141 @@
+package otlp
+
+import (
+	"math"
+	"slices"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Deltas преобразует накопленные (cumulative) значения Sum и Histogram
+// в приросты относительно предыдущей точки той же серии.
+//
+// Первая точка серии задает начальное значение и не дает прироста, если
+// серия начата (StartTime) до создания Deltas: ее значение могло быть уже
+// учтено до перезапуска сервера. Для серии, начатой позже, прирост равен
+// всему значению. Сброс серии (другое StartTime или значение меньше
+// предыдущего) также дает прирост, равный всему значению.
+//
+// Изменения вносятся в транзакции (см. Begin) на основе storage.SeriesState
+// и сохраняются только после Commit, чтобы приросты точек, которые не удалось
+// записать, не терялись.
+type Deltas struct {
+	started uint64
+	states  *storage.SeriesState[seriesState]
+}
+
+// seriesState - последняя накопленная точка серии.
+type seriesState struct {
+	counter   *counterState
+	histogram *histogramState
+}
+
+type counterState struct {
+	start uint64
+	value float64
+}
+
+type histogramState struct {
+	start  uint64
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewDeltas создает преобразователь для сервера, запущенного в момент started.
+func NewDeltas(started time.Time) *Deltas {
+	return &Deltas{
+		started: uint64(started.UnixNano()),
+		states:  storage.NewSeriesState[seriesState](),
+	}
+}
+
+// DeltaTx - транзакция преобразования. Транзакции с общими сериями
+// выполняются по очереди: Begin ждет завершения предыдущей транзакции
+// через Commit или Rollback.
+type DeltaTx struct {
+	d     *Deltas
+	state *storage.SeriesStateTx[seriesState]
+}
+
+// Begin начинает транзакцию для серий keys и блокирует их. Counter
+// и Histogram вызываются только для серий из keys.
+func (d *Deltas) Begin(keys ...string) *DeltaTx {
+	return &DeltaTx{d: d, state: d.states.Begin(keys...)}
+}
+
+// Counter возвращает прирост серии key со значением value, накопленным
+// с момента start (Unix-время в наносекундах). Возвращает false,
+// если точка только задает начальное значение.
+func (tx *DeltaTx) Counter(key string, start uint64, value float64) (int64, bool) {
+	st, _ := tx.state.Get(key)
+	prev := st.counter
+	st.counter = &counterState{start: start, value: value}
+	tx.state.Set(key, st)
+
+	switch {
+	case prev == nil && !tx.isNew(start):
+		return 0, false
+	case prev == nil, prev.start != start, value < prev.value:
+		return int64(math.Round(value)), true
+	default:
+		return int64(math.Round(value)) - int64(math.Round(prev.value)), true
+	}
+}
+
+// Histogram возвращает прирост histogram серии key по накопленной точке p.
+// Возвращает false, если точка только задает начальное значение.
+func (tx *DeltaTx) Histogram(key string, p HistogramPoint) (HistogramPoint, bool) {
+	st, _ := tx.state.Get(key)
+	prev := st.histogram
+	st.histogram = &histogramState{
+		start:  p.StartTime,
+		bounds: p.Bounds,
+		counts: p.BucketCounts,
+		count:  p.Count,
+		sum:    p.Sum,
+	}
+	tx.state.Set(key, st)
+
+	if prev == nil {
+		return p, tx.isNew(p.StartTime)
+	}
+	if prev.start != p.StartTime || p.Count < prev.count ||
+		!slices.Equal(prev.bounds, p.Bounds) || len(prev.counts) != len(p.BucketCounts) {
+		return p, true
+	}
+	delta := p
+	delta.BucketCounts = make([]uint64, len(p.BucketCounts))
+	for i, c := range p.BucketCounts {
+		if c < prev.counts[i] {
+			return p, true
+		}
+		delta.BucketCounts[i] = c - prev.counts[i]
+	}
+	delta.Count = p.Count - prev.count
+	delta.Sum = p.Sum - prev.sum
+	return delta, true
+}
+
+// Discard отменяет изменения серии key в транзакции.
+func (tx *DeltaTx) Discard(key string) {
+	tx.state.Discard(key)
+}
+
+// Commit сохраняет изменения транзакции и завершает ее.
+func (tx *DeltaTx) Commit() {
+	tx.state.Commit()
+}
+
+// Rollback отменяет изменения транзакции и завершает ее. После Commit
+// ничего не делает.
+func (tx *DeltaTx) Rollback() {
+	tx.state.Rollback()
+}
+
+// isNew сообщает, начата ли серия после создания Deltas.
+func (tx *DeltaTx) isNew(start uint64) bool {
+	return start > tx.d.started
+}
@@ -0,0 +1,101 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeltas_Counter(t *testing.T) {
+	started := time.Unix(100, 0)
+	before := uint64(time.Unix(50, 0).UnixNano())
+	after := uint64(time.Unix(150, 0).UnixNano())
+
+	d := NewDeltas(started)
+	tx := d.Begin("old", "new")
+
+	// Серия начата до запуска: первая точка задает начальное значение
+	_, ok := tx.Counter("old", before, 40)
+	assert.False(t, ok)
+	delta, ok := tx.Counter("old", before, 42)
+	assert.True(t, ok)
+	assert.Equal(t, int64(2), delta)
+
+	// Серия начата после запуска: учитывается все значение
+	delta, ok = tx.Counter("new", after, 5)
+	assert.True(t, ok)
+	assert.Equal(t, int64(5), delta)
+
+	// Сброс по уменьшению значения и по новому времени начала
+	delta, _ = tx.Counter("new", after, 3)
+	assert.Equal(t, int64(3), delta)
+	delta, _ = tx.Counter("new", after+1, 4)
+	assert.Equal(t, int64(4), delta)
+	tx.Commit()
+
+	tx = d.Begin("old")
+	delta, ok = tx.Counter("old", before, 50)
+	assert.True(t, ok)
+	assert.Equal(t, int64(8), delta)
+	tx.Rollback()
+
+	// Изменения отмененной транзакции не сохраняются
+	tx = d.Begin("old")
+	defer tx.Rollback()
+	delta, _ = tx.Counter("old", before, 50)
+	assert.Equal(t, int64(8), delta)
+}
+
+func TestDeltas_Discard(t *testing.T) {
+	start := uint64(time.Unix(150, 0).UnixNano())
+	d := NewDeltas(time.Unix(100, 0))
+
+	tx := d.Begin("a", "b")
+	tx.Counter("a", start, 10)
+	tx.Counter("b", start, 10)
+	tx.Discard("b")
+	tx.Commit()
+
+	tx = d.Begin("a", "b")
+	defer tx.Rollback()
+	delta, _ := tx.Counter("a", start, 15)
+	assert.Equal(t, int64(5), delta)
+	delta, _ = tx.Counter("b", start, 15)
+	assert.Equal(t, int64(15), delta)
+}
+
+func TestDeltas_Histogram(t *testing.T) {
+	before := uint64(time.Unix(50, 0).UnixNano())
+	d := NewDeltas(time.Unix(100, 0))
+	tx := d.Begin("h")
+	defer tx.Rollback()
+
+	point := func(start uint64, counts ...uint64) HistogramPoint {
+		p := HistogramPoint{StartTime: start, BucketCounts: counts, Bounds: []float64{1, 2}}
+		for _, c := range counts {
+			p.Count += c
+			p.Sum += float64(c)
+		}
+		return p
+	}
+
+	_, ok := tx.Histogram("h", point(before, 1, 2, 3))
+	assert.False(t, ok)
+
+	delta, ok := tx.Histogram("h", point(before, 2, 4, 3))
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{1, 2, 0}, delta.BucketCounts)
+	assert.Equal(t, uint64(3), delta.Count)
+	assert.Equal(t, 3.0, delta.Sum)
+
+	// Уменьшение числа в корзине - сброс
+	delta, _ = tx.Histogram("h", point(before, 1, 5, 4))
+	assert.Equal(t, point(before, 1, 5, 4), delta)
+
+	// Другие границы - сброс
+	p := point(before, 2, 6, 4)
+	p.Bounds = []float64{1, 3}
+	delta, _ = tx.Histogram("h", p)
+	assert.Equal(t, p, delta)
+}
@@ -0,0 +1,326 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Сообщения OTLP/JSON: имена полей в lowerCamelCase, 64-битные целые
+// передаются строками или числами, перечисления - числами или именами.
+
+type jsonRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes []jsonKeyValue `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Scope struct {
+				Name       string         `json:"name"`
+				Version    string         `json:"version"`
+				Attributes []jsonKeyValue `json:"attributes"`
+			} `json:"scope"`
+			Metrics []jsonMetric `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type jsonMetric struct {
+	Name  string `json:"name"`
+	Unit  string `json:"unit"`
+	Gauge *struct {
+		DataPoints []jsonNumberPoint `json:"dataPoints"`
+	} `json:"gauge"`
+	Sum *struct {
+		DataPoints             []jsonNumberPoint `json:"dataPoints"`
+		AggregationTemporality jsonTemporality   `json:"aggregationTemporality"`
+		IsMonotonic            bool              `json:"isMonotonic"`
+	} `json:"sum"`
+	Histogram *struct {
+		DataPoints             []jsonHistogramPoint `json:"dataPoints"`
+		AggregationTemporality jsonTemporality      `json:"aggregationTemporality"`
+	} `json:"histogram"`
+	ExponentialHistogram *jsonUnsupported `json:"exponentialHistogram"`
+	Summary              *jsonUnsupported `json:"summary"`
+}
+
+type jsonUnsupported struct {
+	DataPoints []json.RawMessage `json:"dataPoints"`
+}
+
+type jsonNumberPoint struct {
+	Attributes        []jsonKeyValue `json:"attributes"`
+	StartTimeUnixNano jsonUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64     `json:"timeUnixNano"`
+	AsDouble          *jsonFloat     `json:"asDouble"`
+	AsInt             *jsonInt64     `json:"asInt"`
+	Flags             uint32         `json:"flags"`
+}
+
+type jsonHistogramPoint struct {
+	Attributes        []jsonKeyValue `json:"attributes"`
+	StartTimeUnixNano jsonUint64     `json:"startTimeUnixNano"`
+	TimeUnixNano      jsonUint64     `json:"timeUnixNano"`
+	Count             jsonUint64     `json:"count"`
+	Sum               jsonFloat      `json:"sum"`
+	BucketCounts      []jsonUint64   `json:"bucketCounts"`
+	ExplicitBounds    []jsonFloat    `json:"explicitBounds"`
+	Flags             uint32         `json:"flags"`
+}
+
+type jsonKeyValue struct {
+	Key   string       `json:"key"`
+	Value jsonAnyValue `json:"value"`
+}
+
+type jsonAnyValue struct {
+	StringValue *string    `json:"stringValue"`
+	BoolValue   *bool      `json:"boolValue"`
+	IntValue    *jsonInt64 `json:"intValue"`
+	DoubleValue *jsonFloat `json:"doubleValue"`
+	BytesValue  *string    `json:"bytesValue"`
+	ArrayValue  *struct {
+		Values []jsonAnyValue `json:"values"`
+	} `json:"arrayValue"`
+	KvlistValue *struct {
+		Values []jsonKeyValue `json:"values"`
+	} `json:"kvlistValue"`
+}
+
+// value возвращает значение в виде, принимаемом formatValue.
+func (v jsonAnyValue) value() any {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return *v.BoolValue
+	case v.IntValue != nil:
+		return int64(*v.IntValue)
+	case v.DoubleValue != nil:
+		return float64(*v.DoubleValue)
+	case v.BytesValue != nil:
+		b, err := base64.StdEncoding.DecodeString(*v.BytesValue)
+		if err != nil {
+			return *v.BytesValue
+		}
+		return b
+	case v.ArrayValue != nil:
+		values := make([]any, 0, len(v.ArrayValue.Values))
+		for _, item := range v.ArrayValue.Values {
+			values = append(values, item.value())
+		}
+		return values
+	case v.KvlistValue != nil:
+		values := make(map[string]any, len(v.KvlistValue.Values))
+		for _, kv := range v.KvlistValue.Values {
+			values[kv.Key] = formatValue(kv.Value.value())
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// jsonUint64 - uint64, переданный строкой или числом.
+type jsonUint64 uint64
+
+func (v *jsonUint64) UnmarshalJSON(b []byte) error {
+	n, err := strconv.ParseUint(unquote(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid uint64 %s", b)
+	}
+	*v = jsonUint64(n)
+	return nil
+}
+
+// jsonInt64 - int64, переданный строкой или числом.
+type jsonInt64 int64
+
+func (v *jsonInt64) UnmarshalJSON(b []byte) error {
+	n, err := strconv.ParseInt(unquote(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid int64 %s", b)
+	}
+	*v = jsonInt64(n)
+	return nil
+}
+
+// jsonFloat - число или строки "NaN", "Infinity", "-Infinity".
+type jsonFloat float64
+
+func (v *jsonFloat) UnmarshalJSON(b []byte) error {
+	switch s := unquote(b); s {
+	case "NaN":
+		*v = jsonFloat(math.NaN())
+	case "Infinity":
+		*v = jsonFloat(math.Inf(1))
+	case "-Infinity":
+		*v = jsonFloat(math.Inf(-1))
+	default:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %s", b)
+		}
+		*v = jsonFloat(f)
+	}
+	return nil
+}
+
+// jsonTemporality - AggregationTemporality, переданная числом или именем.
+type jsonTemporality Temporality
+
+func (v *jsonTemporality) UnmarshalJSON(b []byte) error {
+	switch s := unquote(b); s {
+	case "AGGREGATION_TEMPORALITY_UNSPECIFIED":
+		*v = jsonTemporality(TemporalityUnspecified)
+	case "AGGREGATION_TEMPORALITY_DELTA":
+		*v = jsonTemporality(TemporalityDelta)
+	case "AGGREGATION_TEMPORALITY_CUMULATIVE":
+		*v = jsonTemporality(TemporalityCumulative)
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid aggregation temporality %s", b)
+		}
+		*v = jsonTemporality(n)
+	}
+	return nil
+}
+
+func unquote(b []byte) string {
+	if s, err := strconv.Unquote(string(b)); err == nil {
+		return s
+	}
+	return string(b)
+}
+
+// DecodeJSON разбирает сообщение ExportMetricsServiceRequest
+// в кодировке OTLP/JSON.
+func DecodeJSON(b []byte) (Request, error) {
+	var jr jsonRequest
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return Request{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	var req Request
+	for _, jrm := range jr.ResourceMetrics {
+		rm := ResourceMetrics{Resource: attributes(jrm.Resource.Attributes)}
+		for _, jsm := range jrm.ScopeMetrics {
+			sm := ScopeMetrics{Scope: Scope{
+				Name:       jsm.Scope.Name,
+				Version:    jsm.Scope.Version,
+				Attributes: attributes(jsm.Scope.Attributes),
+			}}
+			for _, jm := range jsm.Metrics {
+				sm.Metrics = append(sm.Metrics, jm.metric())
+			}
+			rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, rm)
+	}
+	return req, nil
+}
+
+func (jm jsonMetric) metric() Metric {
+	m := Metric{Name: jm.Name, Unit: jm.Unit}
+	switch {
+	case jm.Gauge != nil:
+		m.Kind = KindGauge
+		m.Points = numberPoints(jm.Gauge.DataPoints)
+	case jm.Sum != nil:
+		m.Kind = KindSum
+		m.Temporality = Temporality(jm.Sum.AggregationTemporality)
+		m.Monotonic = jm.Sum.IsMonotonic
+		m.Points = numberPoints(jm.Sum.DataPoints)
+	case jm.Histogram != nil:
+		m.Kind = KindHistogram
+		m.Temporality = Temporality(jm.Histogram.AggregationTemporality)
+		for _, jp := range jm.Histogram.DataPoints {
+			p := HistogramPoint{
+				Attributes: attributes(jp.Attributes),
+				StartTime:  uint64(jp.StartTimeUnixNano),
+				Time:       uint64(jp.TimeUnixNano),
+				Count:      uint64(jp.Count),
+				Sum:        float64(jp.Sum),
+				Flags:      jp.Flags,
+			}
+			for _, c := range jp.BucketCounts {
+				p.BucketCounts = append(p.BucketCounts, uint64(c))
+			}
+			for _, b := range jp.ExplicitBounds {
+				p.Bounds = append(p.Bounds, float64(b))
+			}
+			m.HistogramPoints = append(m.HistogramPoints, p)
+		}
+	case jm.ExponentialHistogram != nil:
+		m.Kind = KindExponentialHistogram
+		m.UnsupportedPoints = len(jm.ExponentialHistogram.DataPoints)
+	case jm.Summary != nil:
+		m.Kind = KindSummary
+		m.UnsupportedPoints = len(jm.Summary.DataPoints)
+	}
+	return m
+}
+
+func numberPoints(jps []jsonNumberPoint) []NumberPoint {
+	points := make([]NumberPoint, 0, len(jps))
+	for _, jp := range jps {
+		p := NumberPoint{
+			Attributes: attributes(jp.Attributes),
+			StartTime:  uint64(jp.StartTimeUnixNano),
+			Time:       uint64(jp.TimeUnixNano),
+			Flags:      jp.Flags,
+		}
+		switch {
+		case jp.AsInt != nil:
+			p.Int, p.IsInt = int64(*jp.AsInt), true
+			p.Value = float64(p.Int)
+		case jp.AsDouble != nil:
+			p.Value = float64(*jp.AsDouble)
+		}
+		points = append(points, p)
+	}
+	return points
+}
+
+func attributes(kvs []jsonKeyValue) []Attribute {
+	if len(kvs) == 0 {
+		return nil
+	}
+	attrs := make([]Attribute, 0, len(kvs))
+	for _, kv := range kvs {
+		attrs = append(attrs, Attribute{Key: kv.Key, Value: formatValue(kv.Value.value())})
+	}
+	return attrs
+}
+
+// EncodeJSONResponse сериализует ответ ExportMetricsServiceResponse
+// в кодировке OTLP/JSON.
+func EncodeJSONResponse(rejected int64, message string) []byte {
+	type partialSuccess struct {
+		RejectedDataPoints string `json:"rejectedDataPoints,omitempty"`
+		ErrorMessage       string `json:"errorMessage,omitempty"`
+	}
+	var resp struct {
+		PartialSuccess *partialSuccess `json:"partialSuccess,omitempty"`
+	}
+	if rejected != 0 || message != "" {
+		resp.PartialSuccess = &partialSuccess{ErrorMessage: message}
+		if rejected != 0 {
+			resp.PartialSuccess.RejectedDataPoints = strconv.FormatInt(rejected, 10)
+		}
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+// EncodeJSONStatus сериализует сообщение google.rpc.Status в кодировке JSON.
+func EncodeJSONStatus(code int32, message string) []byte {
+	b, _ := json.Marshal(struct {
+		Code    int32  `json:"code"`
+		Message string `json:"message"`
+	}{code, message})
+	return b
+}
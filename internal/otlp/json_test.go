@@ -0,0 +1,123 @@
+package otlp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	body := `{
+		"resourceMetrics": [{
+			"resource": {"attributes": [
+				{"key": "service.name", "value": {"stringValue": "checkout"}},
+				{"key": "pid", "value": {"intValue": "1234"}},
+				{"key": "tags", "value": {"arrayValue": {"values": [{"stringValue": "a"}, {"boolValue": true}]}}}
+			]},
+			"scopeMetrics": [{
+				"scope": {"name": "otelhttp", "version": "0.1.0"},
+				"metrics": [
+					{
+						"name": "memory.usage",
+						"unit": "By",
+						"gauge": {"dataPoints": [
+							{"timeUnixNano": "2000", "asDouble": 1.5, "attributes": [{"key": "host", "value": {"stringValue": "web-1"}}]},
+							{"timeUnixNano": 3000, "asDouble": "NaN"}
+						]}
+					},
+					{
+						"name": "http.requests",
+						"sum": {
+							"aggregationTemporality": "AGGREGATION_TEMPORALITY_CUMULATIVE",
+							"isMonotonic": true,
+							"dataPoints": [{"startTimeUnixNano": "1000", "timeUnixNano": "2000", "asInt": "42"}]
+						}
+					},
+					{
+						"name": "http.duration",
+						"histogram": {
+							"aggregationTemporality": 1,
+							"dataPoints": [{
+								"timeUnixNano": "2000",
+								"count": "5",
+								"sum": 1.25,
+								"bucketCounts": ["1", 3, "1"],
+								"explicitBounds": [0.1, 0.5],
+								"flags": 1
+							}]
+						}
+					},
+					{"name": "rpc.duration", "summary": {"dataPoints": [{}, {}]}}
+				]
+			}]
+		}]
+	}`
+
+	req, err := DecodeJSON([]byte(body))
+	require.NoError(t, err)
+	require.Len(t, req.ResourceMetrics, 1)
+	rm := req.ResourceMetrics[0]
+	assert.Equal(t, []Attribute{
+		{Key: "service.name", Value: "checkout"},
+		{Key: "pid", Value: "1234"},
+		{Key: "tags", Value: `["a",true]`},
+	}, rm.Resource)
+	require.Len(t, rm.ScopeMetrics, 1)
+	assert.Equal(t, Scope{Name: "otelhttp", Version: "0.1.0"}, rm.ScopeMetrics[0].Scope)
+
+	metrics := rm.ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 4)
+
+	gauge := metrics[0]
+	assert.Equal(t, KindGauge, gauge.Kind)
+	assert.Equal(t, "By", gauge.Unit)
+	require.Len(t, gauge.Points, 2)
+	assert.Equal(t, NumberPoint{Attributes: []Attribute{{Key: "host", Value: "web-1"}}, Time: 2000, Value: 1.5}, gauge.Points[0])
+	assert.True(t, math.IsNaN(gauge.Points[1].Value))
+
+	assert.Equal(t, Metric{
+		Name:        "http.requests",
+		Kind:        KindSum,
+		Temporality: TemporalityCumulative,
+		Monotonic:   true,
+		Points:      []NumberPoint{{StartTime: 1000, Time: 2000, Value: 42, IsInt: true, Int: 42}},
+	}, metrics[1])
+
+	assert.Equal(t, Metric{
+		Name:        "http.duration",
+		Kind:        KindHistogram,
+		Temporality: TemporalityDelta,
+		HistogramPoints: []HistogramPoint{{
+			Time:         2000,
+			Count:        5,
+			Sum:          1.25,
+			BucketCounts: []uint64{1, 3, 1},
+			Bounds:       []float64{0.1, 0.5},
+			Flags:        FlagNoRecordedValue,
+		}},
+	}, metrics[2])
+
+	assert.Equal(t, KindSummary, metrics[3].Kind)
+	assert.Equal(t, 2, metrics[3].DataPoints())
+}
+
+func TestDecodeJSON_Invalid(t *testing.T) {
+	for _, body := range []string{
+		`{`,
+		`{"resourceMetrics": {}}`,
+		`{"resourceMetrics": [{"scopeMetrics": [{"metrics": [{"gauge": {"dataPoints": [{"timeUnixNano": "soon"}]}}]}]}]}`,
+		`{"resourceMetrics": [{"scopeMetrics": [{"metrics": [{"sum": {"aggregationTemporality": "DAILY"}}]}]}]}`,
+	} {
+		_, err := DecodeJSON([]byte(body))
+		assert.ErrorIs(t, err, ErrDecode, body)
+	}
+}
+
+func TestEncodeJSONResponse(t *testing.T) {
+	assert.JSONEq(t, `{}`, string(EncodeJSONResponse(0, "")))
+	assert.JSONEq(t, `{"partialSuccess": {"rejectedDataPoints": "2", "errorMessage": "rpc: summary is not supported"}}`,
+		string(EncodeJSONResponse(2, "rpc: summary is not supported")))
+	assert.JSONEq(t, `{"code": 3, "message": "bad request"}`, string(EncodeJSONStatus(3, "bad request")))
+}
@@ -0,0 +1,215 @@
+// Package otlp реализует разбор запросов экспорта метрик OpenTelemetry
+// (OTLP/HTTP) в кодировках protobuf и JSON, формирование ответов
+// и преобразование накопленных значений в приросты.
+//
+// Из сообщения ExportMetricsServiceRequest разбираются метрики типов Gauge,
+// Sum и Histogram с атрибутами ресурса, области инструментирования (scope)
+// и точек. Для ExponentialHistogram и Summary учитывается только число точек:
+// они не поддерживаются и отклоняются.
+package otlp
+
+import (
+	"errors"
+	"strings"
+)
+
+// ErrDecode возвращается для тела запроса, которое не удалось разобрать.
+var ErrDecode = errors.New("otlp decode error")
+
+// Kind - тип метрики OTLP.
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	KindGauge
+	KindSum
+	KindHistogram
+	KindExponentialHistogram
+	KindSummary
+)
+
+// Temporality - способ накопления значений Sum и Histogram.
+type Temporality int
+
+const (
+	TemporalityUnspecified Temporality = iota
+	TemporalityDelta                   // значение за интервал от StartTime
+	TemporalityCumulative              // значение, накопленное с StartTime
+)
+
+// FlagNoRecordedValue - флаг точки без значения (аналог метки устаревания
+// Prometheus).
+const FlagNoRecordedValue = 1
+
+// Request - запрос экспорта метрик.
+type Request struct {
+	ResourceMetrics []ResourceMetrics
+}
+
+// ResourceMetrics - метрики одного ресурса (сервиса, процесса, хоста).
+type ResourceMetrics struct {
+	Resource     []Attribute
+	ScopeMetrics []ScopeMetrics
+}
+
+// ScopeMetrics - метрики одной области инструментирования.
+type ScopeMetrics struct {
+	Scope   Scope
+	Metrics []Metric
+}
+
+// Scope - область инструментирования (как правило, библиотека).
+type Scope struct {
+	Name       string
+	Version    string
+	Attributes []Attribute
+}
+
+// Attribute - атрибут со значением, приведенным к строке. Массивы
+// и списки пар записываются в формате JSON, байты - в base64.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Metric - метрика с точками. Для Gauge и Sum заполняется Points,
+// для Histogram - HistogramPoints, для неподдерживаемых типов -
+// UnsupportedPoints.
+type Metric struct {
+	Name        string
+	Unit        string
+	Kind        Kind
+	Temporality Temporality
+	Monotonic   bool
+
+	Points            []NumberPoint
+	HistogramPoints   []HistogramPoint
+	UnsupportedPoints int
+}
+
+// DataPoints возвращает число точек метрики.
+func (m Metric) DataPoints() int {
+	return len(m.Points) + len(m.HistogramPoints) + m.UnsupportedPoints
+}
+
+// NumberPoint - точка Gauge или Sum. Целое значение дополнительно
+// хранится в Int без потери точности.
+type NumberPoint struct {
+	Attributes []Attribute
+	StartTime  uint64 // Unix-время в наносекундах
+	Time       uint64 // Unix-время в наносекундах
+	Value      float64
+	IsInt      bool
+	Int        int64
+	Flags      uint32
+}
+
+// HistogramPoint - точка Histogram. BucketCounts содержит число наблюдений
+// в каждой корзине (не накопленное), на одну больше, чем границ Bounds.
+type HistogramPoint struct {
+	Attributes   []Attribute
+	StartTime    uint64
+	Time         uint64
+	Count        uint64
+	Sum          float64
+	BucketCounts []uint64
+	Bounds       []float64
+	Flags        uint32
+}
+
+// ScopeNameAttribute - ключ, под которым имя области инструментирования
+// становится меткой (otel_scope_name) или префиксом имени.
+const ScopeNameAttribute = "otel.scope.name"
+
+// Mapping определяет имена и метки метрик. Атрибуты ресурса, области
+// инструментирования и точки становятся метками (при совпадении имен
+// приоритет у атрибутов точки), имя области - меткой otel_scope_name.
+// Значения атрибутов ресурса или области из PrefixAttributes вместо
+// меток становятся префиксами имени в указанном порядке.
+//
+// Пример: с PrefixAttributes = ["service.name"] метрика http.server.duration
+// ресурса с service.name=checkout записывается как checkout.http.server.duration.
+type Mapping struct {
+	PrefixAttributes []string
+}
+
+// ParseMapping разбирает список ключей атрибутов-префиксов через запятую,
+// например "service.name,otel.scope.name".
+func ParseMapping(s string) Mapping {
+	var m Mapping
+	for _, key := range strings.Split(s, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			m.PrefixAttributes = append(m.PrefixAttributes, key)
+		}
+	}
+	return m
+}
+
+// Series возвращает имя и метки серии метрики name ресурса с атрибутами
+// resource, области scope и точки с атрибутами attrs.
+func (m Mapping) Series(resource []Attribute, scope Scope, name string, attrs []Attribute) (string, map[string]string) {
+	scopeAttrs := scope.Attributes
+	if scope.Name != "" {
+		scopeAttrs = append([]Attribute{{Key: ScopeNameAttribute, Value: scope.Name}}, scopeAttrs...)
+	}
+
+	prefixed := make(map[string]bool, len(m.PrefixAttributes))
+	parts := make([]string, 0, len(m.PrefixAttributes)+1)
+	for _, key := range m.PrefixAttributes {
+		if v, ok := lookup(resource, key); ok {
+			parts = append(parts, v)
+		} else if v, ok := lookup(scopeAttrs, key); ok {
+			parts = append(parts, v)
+		}
+		prefixed[key] = true
+	}
+	parts = append(parts, name)
+
+	labels := make(map[string]string)
+	for _, group := range [][]Attribute{resource, scopeAttrs} {
+		for _, a := range group {
+			if !prefixed[a.Key] {
+				labels[LabelName(a.Key)] = a.Value
+			}
+		}
+	}
+	for _, a := range attrs {
+		labels[LabelName(a.Key)] = a.Value
+	}
+	if len(labels) == 0 {
+		labels = nil
+	}
+	return strings.Join(parts, "."), labels
+}
+
+// LabelName приводит ключ атрибута к имени метки: недопустимые символы
+// заменяются на _, к имени, начинающемуся с цифры, добавляется _.
+// Например, service.name становится service_name.
+func LabelName(key string) string {
+	if key == "" {
+		return "_"
+	}
+	var b strings.Builder
+	for i, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+		default:
+			c = '_'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+func lookup(attrs []Attribute, key string) (string, bool) {
+	for _, a := range attrs {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,79 @@
+package otlp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMapping(t *testing.T) {
+	assert.Equal(t, Mapping{}, ParseMapping(""))
+	assert.Equal(t, Mapping{PrefixAttributes: []string{"service.name", "otel.scope.name"}},
+		ParseMapping(" service.name, ,otel.scope.name"))
+}
+
+func TestLabelName(t *testing.T) {
+	tests := map[string]string{
+		"host":         "host",
+		"service.name": "service_name",
+		"http-method":  "http_method",
+		"2xx":          "_2xx",
+		"status_2xx":   "status_2xx",
+		"имя":          "___",
+		"":             "_",
+	}
+	for key, want := range tests {
+		assert.Equal(t, want, LabelName(key), key)
+	}
+}
+
+func TestMapping_Series(t *testing.T) {
+	resource := []Attribute{{Key: "service.name", Value: "checkout"}, {Key: "host.name", Value: "web-1"}}
+	scope := Scope{Name: "otelhttp", Attributes: []Attribute{{Key: "host.name", Value: "scope"}}}
+	attrs := []Attribute{{Key: "http.method", Value: "GET"}}
+
+	tests := []struct {
+		name       string
+		mapping    Mapping
+		wantName   string
+		wantLabels map[string]string
+	}{
+		{
+			name:     "labels",
+			wantName: "http.server.duration",
+			wantLabels: map[string]string{
+				"service_name":    "checkout",
+				"host_name":       "scope",
+				"otel_scope_name": "otelhttp",
+				"http_method":     "GET",
+			},
+		},
+		{
+			name:     "prefixes",
+			mapping:  Mapping{PrefixAttributes: []string{"service.name", ScopeNameAttribute, "missing"}},
+			wantName: "checkout.otelhttp.http.server.duration",
+			wantLabels: map[string]string{
+				"host_name":   "scope",
+				"http_method": "GET",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, labels := tt.mapping.Series(resource, scope, "http.server.duration", attrs)
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantLabels, labels)
+		})
+	}
+}
+
+func TestMapping_Series_PointAttributesWin(t *testing.T) {
+	name, labels := Mapping{}.Series(
+		[]Attribute{{Key: "host", Value: "resource"}}, Scope{},
+		"up", []Attribute{{Key: "host", Value: "point"}})
+	assert.Equal(t, "up", name)
+	assert.Equal(t, map[string]string{"host": "point"}, labels)
+
+	_, labels = Mapping{}.Series(nil, Scope{}, "up", nil)
+	assert.Nil(t, labels)
+}
@@ -0,0 +1,619 @@
+package otlp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Номера полей сообщений opentelemetry.proto.metrics.v1 и common.v1.
+const (
+	fieldResourceMetrics = 1 // ExportMetricsServiceRequest
+
+	fieldResource     = 1 // ResourceMetrics
+	fieldScopeMetrics = 2
+
+	fieldResourceAttributes = 1 // Resource
+
+	fieldScope   = 1 // ScopeMetrics
+	fieldMetrics = 2
+
+	fieldScopeName       = 1 // InstrumentationScope
+	fieldScopeVersion    = 2
+	fieldScopeAttributes = 3
+
+	fieldMetricName      = 1 // Metric
+	fieldMetricUnit      = 3
+	fieldGauge           = 5
+	fieldSum             = 7
+	fieldHistogram       = 9
+	fieldExpHistogram    = 10
+	fieldSummary         = 11
+	fieldDataPoints      = 1 // Gauge, Sum, Histogram, ExponentialHistogram, Summary
+	fieldTemporality     = 2 // Sum, Histogram
+	fieldMonotonic       = 3 // Sum
+	fieldPointStartTime  = 2 // NumberDataPoint, HistogramDataPoint
+	fieldPointTime       = 3
+	fieldNumberDouble    = 4 // NumberDataPoint
+	fieldNumberInt       = 6
+	fieldNumberAttrs     = 7
+	fieldNumberFlags     = 8
+	fieldHistCount       = 4 // HistogramDataPoint
+	fieldHistSum         = 5
+	fieldHistBucketCount = 6
+	fieldHistBounds      = 7
+	fieldHistAttrs       = 9
+	fieldHistFlags       = 10
+
+	fieldKey   = 1 // KeyValue
+	fieldValue = 2
+
+	fieldStringValue = 1 // AnyValue
+	fieldBoolValue   = 2
+	fieldIntValue    = 3
+	fieldDoubleValue = 4
+	fieldArrayValue  = 5
+	fieldKvlistValue = 6
+	fieldBytesValue  = 7
+	fieldValues      = 1 // ArrayValue, KeyValueList
+)
+
+// metricKinds - типы метрик по номерам полей данных сообщения Metric.
+var metricKinds = map[protowire.Number]Kind{
+	fieldGauge:        KindGauge,
+	fieldSum:          KindSum,
+	fieldHistogram:    KindHistogram,
+	fieldExpHistogram: KindExponentialHistogram,
+	fieldSummary:      KindSummary,
+}
+
+// DecodeProto разбирает сообщение ExportMetricsServiceRequest
+// в кодировке protobuf.
+func DecodeProto(b []byte) (Request, error) {
+	var req Request
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != fieldResourceMetrics {
+			return skip(num, typ, b)
+		}
+		return consumeMessage(typ, b, func(v []byte) error {
+			rm, err := decodeResourceMetrics(v)
+			req.ResourceMetrics = append(req.ResourceMetrics, rm)
+			return err
+		})
+	})
+	if err != nil {
+		return Request{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return req, nil
+}
+
+func decodeResourceMetrics(b []byte) (ResourceMetrics, error) {
+	var rm ResourceMetrics
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldResource:
+			return consumeMessage(typ, b, func(v []byte) error {
+				return consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					if num != fieldResourceAttributes {
+						return skip(num, typ, b)
+					}
+					return consumeAttribute(typ, b, &rm.Resource)
+				})
+			})
+		case fieldScopeMetrics:
+			return consumeMessage(typ, b, func(v []byte) error {
+				sm, err := decodeScopeMetrics(v)
+				rm.ScopeMetrics = append(rm.ScopeMetrics, sm)
+				return err
+			})
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return rm, err
+}
+
+func decodeScopeMetrics(b []byte) (ScopeMetrics, error) {
+	var sm ScopeMetrics
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldScope:
+			return consumeMessage(typ, b, func(v []byte) error {
+				return consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					switch num {
+					case fieldScopeName:
+						return consumeString(typ, b, &sm.Scope.Name)
+					case fieldScopeVersion:
+						return consumeString(typ, b, &sm.Scope.Version)
+					case fieldScopeAttributes:
+						return consumeAttribute(typ, b, &sm.Scope.Attributes)
+					default:
+						return skip(num, typ, b)
+					}
+				})
+			})
+		case fieldMetrics:
+			return consumeMessage(typ, b, func(v []byte) error {
+				m, err := decodeMetric(v)
+				sm.Metrics = append(sm.Metrics, m)
+				return err
+			})
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return sm, err
+}
+
+func decodeMetric(b []byte) (Metric, error) {
+	var m Metric
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldMetricName:
+			return consumeString(typ, b, &m.Name)
+		case fieldMetricUnit:
+			return consumeString(typ, b, &m.Unit)
+		case fieldGauge, fieldSum, fieldHistogram, fieldExpHistogram, fieldSummary:
+			m.Kind = metricKinds[num]
+			return consumeMessage(typ, b, func(v []byte) error {
+				return decodeMetricData(v, &m)
+			})
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return m, err
+}
+
+// decodeMetricData разбирает сообщение Gauge, Sum, Histogram,
+// ExponentialHistogram или Summary в метрику m с заданным Kind.
+func decodeMetricData(b []byte, m *Metric) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == fieldDataPoints:
+			return consumeMessage(typ, b, func(v []byte) error {
+				switch m.Kind {
+				case KindGauge, KindSum:
+					p, err := decodeNumberPoint(v)
+					m.Points = append(m.Points, p)
+					return err
+				case KindHistogram:
+					p, err := decodeHistogramPoint(v)
+					m.HistogramPoints = append(m.HistogramPoints, p)
+					return err
+				default:
+					m.UnsupportedPoints++
+					return nil
+				}
+			})
+		case num == fieldTemporality && (m.Kind == KindSum || m.Kind == KindHistogram):
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			m.Temporality = Temporality(v)
+			return n, err
+		case num == fieldMonotonic && m.Kind == KindSum:
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			m.Monotonic = v != 0
+			return n, err
+		default:
+			return skip(num, typ, b)
+		}
+	})
+}
+
+func decodeNumberPoint(b []byte) (NumberPoint, error) {
+	var p NumberPoint
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldNumberAttrs:
+			return consumeAttribute(typ, b, &p.Attributes)
+		case fieldPointStartTime:
+			return consumeFixed64(typ, b, &p.StartTime)
+		case fieldPointTime:
+			return consumeFixed64(typ, b, &p.Time)
+		case fieldNumberDouble:
+			var v uint64
+			n, err := consumeFixed64(typ, b, &v)
+			p.Value, p.IsInt, p.Int = math.Float64frombits(v), false, 0
+			return n, err
+		case fieldNumberInt:
+			var v uint64
+			n, err := consumeFixed64(typ, b, &v)
+			p.Int, p.IsInt = int64(v), true
+			p.Value = float64(p.Int)
+			return n, err
+		case fieldNumberFlags:
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			p.Flags = uint32(v)
+			return n, err
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return p, err
+}
+
+func decodeHistogramPoint(b []byte) (HistogramPoint, error) {
+	var p HistogramPoint
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldHistAttrs:
+			return consumeAttribute(typ, b, &p.Attributes)
+		case fieldPointStartTime:
+			return consumeFixed64(typ, b, &p.StartTime)
+		case fieldPointTime:
+			return consumeFixed64(typ, b, &p.Time)
+		case fieldHistCount:
+			return consumeFixed64(typ, b, &p.Count)
+		case fieldHistSum:
+			var v uint64
+			n, err := consumeFixed64(typ, b, &v)
+			p.Sum = math.Float64frombits(v)
+			return n, err
+		case fieldHistBucketCount:
+			return consumeRepeatedFixed64(typ, b, func(v uint64) {
+				p.BucketCounts = append(p.BucketCounts, v)
+			})
+		case fieldHistBounds:
+			return consumeRepeatedFixed64(typ, b, func(v uint64) {
+				p.Bounds = append(p.Bounds, math.Float64frombits(v))
+			})
+		case fieldHistFlags:
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			p.Flags = uint32(v)
+			return n, err
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return p, err
+}
+
+// consumeAttribute разбирает KeyValue и добавляет атрибут в attrs.
+func consumeAttribute(typ protowire.Type, b []byte, attrs *[]Attribute) (int, error) {
+	return consumeMessage(typ, b, func(v []byte) error {
+		var (
+			a   Attribute
+			val any
+		)
+		err := consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			switch num {
+			case fieldKey:
+				return consumeString(typ, b, &a.Key)
+			case fieldValue:
+				return consumeMessage(typ, b, func(v []byte) (err error) {
+					val, err = decodeAnyValue(v)
+					return err
+				})
+			default:
+				return skip(num, typ, b)
+			}
+		})
+		a.Value = formatValue(val)
+		*attrs = append(*attrs, a)
+		return err
+	})
+}
+
+// decodeAnyValue разбирает AnyValue в string, bool, int64, float64,
+// []byte, []any или map[string]any.
+func decodeAnyValue(b []byte) (any, error) {
+	var val any
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch num {
+		case fieldStringValue:
+			var s string
+			n, err := consumeString(typ, b, &s)
+			val = s
+			return n, err
+		case fieldBoolValue:
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			val = v != 0
+			return n, err
+		case fieldIntValue:
+			var v uint64
+			n, err := consumeVarint(typ, b, &v)
+			val = int64(v)
+			return n, err
+		case fieldDoubleValue:
+			var v uint64
+			n, err := consumeFixed64(typ, b, &v)
+			val = math.Float64frombits(v)
+			return n, err
+		case fieldBytesValue:
+			return consumeMessage(typ, b, func(v []byte) error {
+				val = append([]byte(nil), v...)
+				return nil
+			})
+		case fieldArrayValue:
+			values := []any{}
+			val = values
+			return consumeMessage(typ, b, func(v []byte) error {
+				return consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					if num != fieldValues {
+						return skip(num, typ, b)
+					}
+					return consumeMessage(typ, b, func(v []byte) error {
+						item, err := decodeAnyValue(v)
+						values = append(values, item)
+						val = values
+						return err
+					})
+				})
+			})
+		case fieldKvlistValue:
+			values := map[string]any{}
+			val = values
+			return consumeMessage(typ, b, func(v []byte) error {
+				return consumeFields(v, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					if num != fieldValues {
+						return skip(num, typ, b)
+					}
+					var attrs []Attribute
+					n, err := consumeAttribute(typ, b, &attrs)
+					for _, a := range attrs {
+						values[a.Key] = a.Value
+					}
+					return n, err
+				})
+			})
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return val, err
+}
+
+// formatValue приводит значение атрибута к строке.
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
+
+// EncodeProto сериализует запрос в сообщение ExportMetricsServiceRequest.
+// Значения атрибутов записываются строками.
+func EncodeProto(req Request) []byte {
+	var b []byte
+	for _, rm := range req.ResourceMetrics {
+		var rb []byte
+		var res []byte
+		for _, a := range rm.Resource {
+			res = appendMessage(res, fieldResourceAttributes, encodeAttribute(a))
+		}
+		rb = appendMessage(rb, fieldResource, res)
+		for _, sm := range rm.ScopeMetrics {
+			var sb, scope []byte
+			scope = appendString(scope, fieldScopeName, sm.Scope.Name)
+			scope = appendString(scope, fieldScopeVersion, sm.Scope.Version)
+			for _, a := range sm.Scope.Attributes {
+				scope = appendMessage(scope, fieldScopeAttributes, encodeAttribute(a))
+			}
+			sb = appendMessage(sb, fieldScope, scope)
+			for _, m := range sm.Metrics {
+				sb = appendMessage(sb, fieldMetrics, encodeMetric(m))
+			}
+			rb = appendMessage(rb, fieldScopeMetrics, sb)
+		}
+		b = appendMessage(b, fieldResourceMetrics, rb)
+	}
+	return b
+}
+
+func encodeMetric(m Metric) []byte {
+	var b, data []byte
+	b = appendString(b, fieldMetricName, m.Name)
+	b = appendString(b, fieldMetricUnit, m.Unit)
+
+	for _, p := range m.Points {
+		var pb []byte
+		for _, a := range p.Attributes {
+			pb = appendMessage(pb, fieldNumberAttrs, encodeAttribute(a))
+		}
+		pb = appendFixed64(pb, fieldPointStartTime, p.StartTime)
+		pb = appendFixed64(pb, fieldPointTime, p.Time)
+		if p.IsInt {
+			pb = appendFixed64(pb, fieldNumberInt, uint64(p.Int))
+		} else {
+			pb = appendFixed64(pb, fieldNumberDouble, math.Float64bits(p.Value))
+		}
+		if p.Flags != 0 {
+			pb = protowire.AppendTag(pb, fieldNumberFlags, protowire.VarintType)
+			pb = protowire.AppendVarint(pb, uint64(p.Flags))
+		}
+		data = appendMessage(data, fieldDataPoints, pb)
+	}
+	for _, p := range m.HistogramPoints {
+		var pb, counts, bounds []byte
+		for _, a := range p.Attributes {
+			pb = appendMessage(pb, fieldHistAttrs, encodeAttribute(a))
+		}
+		pb = appendFixed64(pb, fieldPointStartTime, p.StartTime)
+		pb = appendFixed64(pb, fieldPointTime, p.Time)
+		pb = appendFixed64(pb, fieldHistCount, p.Count)
+		pb = appendFixed64(pb, fieldHistSum, math.Float64bits(p.Sum))
+		for _, c := range p.BucketCounts {
+			counts = protowire.AppendFixed64(counts, c)
+		}
+		for _, v := range p.Bounds {
+			bounds = protowire.AppendFixed64(bounds, math.Float64bits(v))
+		}
+		pb = appendMessage(pb, fieldHistBucketCount, counts)
+		pb = appendMessage(pb, fieldHistBounds, bounds)
+		data = appendMessage(data, fieldDataPoints, pb)
+	}
+	if m.Temporality != TemporalityUnspecified {
+		data = protowire.AppendTag(data, fieldTemporality, protowire.VarintType)
+		data = protowire.AppendVarint(data, uint64(m.Temporality))
+	}
+	if m.Monotonic {
+		data = protowire.AppendTag(data, fieldMonotonic, protowire.VarintType)
+		data = protowire.AppendVarint(data, 1)
+	}
+
+	for field, kind := range metricKinds {
+		if kind == m.Kind {
+			b = appendMessage(b, field, data)
+		}
+	}
+	return b
+}
+
+func encodeAttribute(a Attribute) []byte {
+	var b []byte
+	b = appendString(b, fieldKey, a.Key)
+	return appendMessage(b, fieldValue, appendString(nil, fieldStringValue, a.Value))
+}
+
+// EncodeProtoResponse сериализует ответ ExportMetricsServiceResponse.
+// Если rejected равно 0, поле partial_success не заполняется.
+func EncodeProtoResponse(rejected int64, message string) []byte {
+	if rejected == 0 && message == "" {
+		return []byte{}
+	}
+	var ps []byte
+	ps = protowire.AppendTag(ps, 1, protowire.VarintType)
+	ps = protowire.AppendVarint(ps, uint64(rejected))
+	ps = appendString(ps, 2, message)
+	return appendMessage(nil, 1, ps)
+}
+
+// EncodeProtoStatus сериализует сообщение google.rpc.Status, которым
+// отвечают на запросы со статусами 4xx и 5xx.
+func EncodeProtoStatus(code int32, message string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(code))
+	return appendString(b, 2, message)
+}
+
+func appendMessage(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendFixed64(b []byte, num protowire.Number, v uint64) []byte {
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, v)
+}
+
+// consumeFields разбирает поля сообщения b, передавая field номер, тип
+// и данные поля после тега. field возвращает длину данных поля
+// или отрицательный код ошибки protowire.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// consumeMessage разбирает вложенное сообщение или строку байт
+// и передает его содержимое в fn.
+func consumeMessage(typ protowire.Type, b []byte, fn func(v []byte) error) (int, error) {
+	if typ != protowire.BytesType {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+	return n, fn(v)
+}
+
+func consumeString(typ protowire.Type, b []byte, s *string) (int, error) {
+	return consumeMessage(typ, b, func(v []byte) error {
+		*s = string(v)
+		return nil
+	})
+}
+
+func consumeVarint(typ protowire.Type, b []byte, v *uint64) (int, error) {
+	if typ != protowire.VarintType {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	var n int
+	*v, n = protowire.ConsumeVarint(b)
+	return n, nil
+}
+
+func consumeFixed64(typ protowire.Type, b []byte, v *uint64) (int, error) {
+	if typ != protowire.Fixed64Type {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	var n int
+	*v, n = protowire.ConsumeFixed64(b)
+	return n, nil
+}
+
+// consumeRepeatedFixed64 разбирает повторяющееся поле fixed64 или double
+// в упакованной или обычной форме.
+func consumeRepeatedFixed64(typ protowire.Type, b []byte, fn func(v uint64)) (int, error) {
+	if typ == protowire.Fixed64Type {
+		var v uint64
+		n, err := consumeFixed64(typ, b, &v)
+		fn(v)
+		return n, err
+	}
+	return consumeMessage(typ, b, func(v []byte) error {
+		if len(v)%8 != 0 {
+			return fmt.Errorf("invalid packed fixed64 length %d", len(v))
+		}
+		for len(v) > 0 {
+			x, n := protowire.ConsumeFixed64(v)
+			fn(x)
+			v = v[n:]
+		}
+		return nil
+	})
+}
+
+// skip пропускает значение неизвестного поля.
+func skip(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	return protowire.ConsumeFieldValue(num, typ, b), nil
+}
@@ -0,0 +1,164 @@
+package otlp
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testRequest() Request {
+	return Request{ResourceMetrics: []ResourceMetrics{{
+		Resource: []Attribute{{Key: "service.name", Value: "checkout"}},
+		ScopeMetrics: []ScopeMetrics{{
+			Scope: Scope{Name: "otelhttp", Version: "0.1.0", Attributes: []Attribute{{Key: "team", Value: "core"}}},
+			Metrics: []Metric{
+				{
+					Name: "memory.usage",
+					Unit: "By",
+					Kind: KindGauge,
+					Points: []NumberPoint{{
+						Attributes: []Attribute{{Key: "host", Value: "web-1"}},
+						Time:       2000,
+						Value:      1.5,
+					}},
+				},
+				{
+					Name:        "http.requests",
+					Kind:        KindSum,
+					Temporality: TemporalityCumulative,
+					Monotonic:   true,
+					Points: []NumberPoint{{
+						StartTime: 1000,
+						Time:      2000,
+						Value:     42,
+						IsInt:     true,
+						Int:       42,
+						Flags:     FlagNoRecordedValue,
+					}},
+				},
+				{
+					Name:        "http.duration",
+					Kind:        KindHistogram,
+					Temporality: TemporalityDelta,
+					HistogramPoints: []HistogramPoint{{
+						StartTime:    1000,
+						Time:         2000,
+						Count:        5,
+						Sum:          1.25,
+						BucketCounts: []uint64{1, 3, 1},
+						Bounds:       []float64{0.1, 0.5},
+					}},
+				},
+			},
+		}},
+	}}}
+}
+
+func TestEncodeDecodeProto(t *testing.T) {
+	req := testRequest()
+	got, err := DecodeProto(EncodeProto(req))
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+}
+
+func TestDecodeProto_AttributeValues(t *testing.T) {
+	anyValue := func(field protowire.Number, v []byte) []byte {
+		return appendMessage(nil, field, v)
+	}
+	attr := func(key string, value []byte) []byte {
+		return appendMessage(appendString(nil, fieldKey, key), fieldValue, value)
+	}
+
+	var array []byte
+	array = appendMessage(array, fieldValues, appendString(nil, fieldStringValue, "a"))
+	array = appendMessage(array, fieldValues, protowire.AppendVarint(protowire.AppendTag(nil, fieldIntValue, protowire.VarintType), 2))
+
+	var res []byte
+	res = appendMessage(res, fieldResourceAttributes, attr("bool", protowire.AppendVarint(protowire.AppendTag(nil, fieldBoolValue, protowire.VarintType), 1)))
+	res = appendMessage(res, fieldResourceAttributes, attr("int", protowire.AppendVarint(protowire.AppendTag(nil, fieldIntValue, protowire.VarintType), uint64(math.MaxUint64))))
+	res = appendMessage(res, fieldResourceAttributes, attr("double", appendFixed64(nil, fieldDoubleValue, math.Float64bits(0.5))))
+	res = appendMessage(res, fieldResourceAttributes, attr("bytes", appendMessage(nil, fieldBytesValue, []byte{0xff})))
+	res = appendMessage(res, fieldResourceAttributes, attr("array", anyValue(fieldArrayValue, array)))
+	res = appendMessage(res, fieldResourceAttributes, attr("kvlist", anyValue(fieldKvlistValue,
+		appendMessage(nil, fieldValues, attr("k", appendString(nil, fieldStringValue, "v"))))))
+	b := appendMessage(nil, fieldResourceMetrics, appendMessage(nil, fieldResource, res))
+
+	req, err := DecodeProto(b)
+	require.NoError(t, err)
+	require.Len(t, req.ResourceMetrics, 1)
+	assert.Equal(t, []Attribute{
+		{Key: "bool", Value: "true"},
+		{Key: "int", Value: "-1"},
+		{Key: "double", Value: "0.5"},
+		{Key: "bytes", Value: "/w=="},
+		{Key: "array", Value: `["a",2]`},
+		{Key: "kvlist", Value: `{"k":"v"}`},
+	}, req.ResourceMetrics[0].Resource)
+}
+
+func TestDecodeProto_UnpackedAndUnknownFields(t *testing.T) {
+	var point []byte
+	point = appendFixed64(point, fieldHistCount, 3)
+	point = appendFixed64(point, fieldHistBucketCount, 1)
+	point = appendFixed64(point, fieldHistBucketCount, 2)
+	point = appendFixed64(point, fieldHistBounds, math.Float64bits(1))
+	point = appendString(point, 100, "unknown")
+
+	var data []byte
+	data = appendMessage(data, fieldDataPoints, point)
+	data = protowire.AppendVarint(protowire.AppendTag(data, fieldTemporality, protowire.VarintType), uint64(TemporalityCumulative))
+
+	var metric []byte
+	metric = appendString(metric, fieldMetricName, "latency")
+	metric = appendString(metric, 12, "metadata")
+	metric = appendMessage(metric, fieldHistogram, data)
+
+	summary := appendMessage(appendString(nil, fieldMetricName, "rpc"), fieldSummary,
+		appendMessage(appendMessage(nil, fieldDataPoints, nil), fieldDataPoints, nil))
+
+	var sm []byte
+	sm = appendMessage(sm, fieldMetrics, metric)
+	sm = appendMessage(sm, fieldMetrics, summary)
+	b := appendMessage(nil, fieldResourceMetrics, appendMessage(nil, fieldScopeMetrics, sm))
+
+	req, err := DecodeProto(b)
+	require.NoError(t, err)
+	metrics := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	require.Len(t, metrics, 2)
+	assert.Equal(t, Metric{
+		Name:        "latency",
+		Kind:        KindHistogram,
+		Temporality: TemporalityCumulative,
+		HistogramPoints: []HistogramPoint{{
+			Count:        3,
+			BucketCounts: []uint64{1, 2},
+			Bounds:       []float64{1},
+		}},
+	}, metrics[0])
+	assert.Equal(t, KindSummary, metrics[1].Kind)
+	assert.Equal(t, 2, metrics[1].DataPoints())
+}
+
+func TestDecodeProto_Invalid(t *testing.T) {
+	for name, b := range map[string][]byte{
+		"truncated tag":     {0x0a},
+		"truncated message": {0x0a, 0x05, 0x01},
+		"wrong wire type":   appendMessage(nil, fieldResourceMetrics, appendFixed64(nil, fieldResource, 1)),
+	} {
+		_, err := DecodeProto(b)
+		assert.ErrorIs(t, err, ErrDecode, name)
+	}
+}
+
+func TestEncodeProtoResponse(t *testing.T) {
+	assert.Empty(t, EncodeProtoResponse(0, ""))
+
+	b := EncodeProtoResponse(2, "rpc: summary is not supported")
+	var ps []byte
+	ps = protowire.AppendVarint(protowire.AppendTag(ps, 1, protowire.VarintType), 2)
+	ps = appendString(ps, 2, "rpc: summary is not supported")
+	assert.Equal(t, appendMessage(nil, 1, ps), b)
+}
@@ -0,0 +1,214 @@
+// Package promwrite реализует разбор запросов протокола Prometheus
+// remote_write 1.0: сообщение WriteRequest в формате protobuf, сжатое
+// блочным форматом snappy.
+//
+// Сообщения разбираются без сгенерированного кода по схеме:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label        { string name = 1; string value = 2; }
+//	message Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Остальные поля (метаданные, exemplars, нативные гистограммы) пропускаются.
+package promwrite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// MaxDecodedSize - максимальный размер распакованного сообщения.
+const MaxDecodedSize = 32 << 20
+
+// NameLabel - метка с именем метрики.
+const NameLabel = "__name__"
+
+// ErrDecode возвращается для тела запроса, которое не удалось распаковать
+// или разобрать.
+var ErrDecode = errors.New("remote write decode error")
+
+// WriteRequest - запрос remote_write.
+type WriteRequest struct {
+	Timeseries []TimeSeries
+}
+
+// TimeSeries - серия с метками и значениями.
+type TimeSeries struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// Label - метка серии.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample - значение серии.
+type Sample struct {
+	Value     float64
+	Timestamp int64 // Unix-время в миллисекундах
+}
+
+// Decode распаковывает и разбирает тело запроса remote_write.
+func Decode(body []byte) (WriteRequest, error) {
+	raw, err := decodeSnappy(body, MaxDecodedSize)
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+
+	var req WriteRequest
+	err = consumeFields(raw, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if num != 1 || typ != protowire.BytesType {
+			return skip(num, typ, b)
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		ts, err := decodeTimeSeries(v)
+		if err != nil {
+			return 0, err
+		}
+		req.Timeseries = append(req.Timeseries, ts)
+		return n, nil
+	})
+	if err != nil {
+		return WriteRequest{}, fmt.Errorf("%w: %w", ErrDecode, err)
+	}
+	return req, nil
+}
+
+// Encode сериализует и сжимает запрос remote_write.
+func Encode(req WriteRequest) []byte {
+	var b []byte
+	for _, ts := range req.Timeseries {
+		var sb []byte
+		for _, l := range ts.Labels {
+			var lb []byte
+			lb = protowire.AppendTag(lb, 1, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Name)
+			lb = protowire.AppendTag(lb, 2, protowire.BytesType)
+			lb = protowire.AppendString(lb, l.Value)
+			sb = protowire.AppendTag(sb, 1, protowire.BytesType)
+			sb = protowire.AppendBytes(sb, lb)
+		}
+		for _, s := range ts.Samples {
+			var smp []byte
+			smp = protowire.AppendTag(smp, 1, protowire.Fixed64Type)
+			smp = protowire.AppendFixed64(smp, math.Float64bits(s.Value))
+			smp = protowire.AppendTag(smp, 2, protowire.VarintType)
+			smp = protowire.AppendVarint(smp, uint64(s.Timestamp))
+			sb = protowire.AppendTag(sb, 2, protowire.BytesType)
+			sb = protowire.AppendBytes(sb, smp)
+		}
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+	return encodeSnappy(b)
+}
+
+// Name возвращает имя метрики серии - значение метки __name__.
+func (ts TimeSeries) Name() string {
+	for _, l := range ts.Labels {
+		if l.Name == NameLabel {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+func decodeTimeSeries(b []byte) (TimeSeries, error) {
+	var ts TimeSeries
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if (num != 1 && num != 2) || typ != protowire.BytesType {
+			return skip(num, typ, b)
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return n, nil
+		}
+		if num == 1 {
+			l, err := decodeLabel(v)
+			if err != nil {
+				return 0, err
+			}
+			ts.Labels = append(ts.Labels, l)
+		} else {
+			s, err := decodeSample(v)
+			if err != nil {
+				return 0, err
+			}
+			ts.Samples = append(ts.Samples, s)
+		}
+		return n, nil
+	})
+	return ts, err
+}
+
+func decodeLabel(b []byte) (Label, error) {
+	var l Label
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		if (num != 1 && num != 2) || typ != protowire.BytesType {
+			return skip(num, typ, b)
+		}
+		v, n := protowire.ConsumeString(b)
+		if num == 1 {
+			l.Name = v
+		} else {
+			l.Value = v
+		}
+		return n, nil
+	})
+	return l, err
+}
+
+func decodeSample(b []byte) (Sample, error) {
+	var s Sample
+	err := consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == 1 && typ == protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			s.Value = math.Float64frombits(v)
+			return n, nil
+		case num == 2 && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			s.Timestamp = int64(v)
+			return n, nil
+		default:
+			return skip(num, typ, b)
+		}
+	})
+	return s, err
+}
+
+// consumeFields разбирает поля сообщения b, передавая field номер, тип
+// и данные поля после тега. field возвращает длину данных поля
+// или отрицательный код ошибки protowire.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// skip пропускает значение неизвестного поля.
+func skip(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+	return protowire.ConsumeFieldValue(num, typ, b), nil
+}
@@ -0,0 +1,120 @@
+package promwrite
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	req := WriteRequest{Timeseries: []TimeSeries{
+		{
+			Labels: []Label{
+				{Name: NameLabel, Value: "http_requests_total"},
+				{Name: "instance", Value: "web-1:9100"},
+			},
+			Samples: []Sample{{Value: 10, Timestamp: 1700000000000}, {Value: 12.5, Timestamp: 1700000015000}},
+		},
+		{
+			Labels:  []Label{{Name: NameLabel, Value: "up"}},
+			Samples: []Sample{{Value: 1, Timestamp: -1}},
+		},
+	}}
+
+	got, err := Decode(Encode(req))
+	require.NoError(t, err)
+	assert.Equal(t, req, got)
+	assert.Equal(t, "http_requests_total", got.Timeseries[0].Name())
+}
+
+func TestDecode_SkipsUnknownFields(t *testing.T) {
+	var sample []byte
+	sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+	sample = protowire.AppendFixed64(sample, math.Float64bits(3))
+	sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+	sample = protowire.AppendVarint(sample, 1000)
+
+	var ts []byte
+	ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+	ts = protowire.AppendBytes(ts, sample)
+	// exemplar
+	ts = protowire.AppendTag(ts, 3, protowire.BytesType)
+	ts = protowire.AppendBytes(ts, []byte{0x08, 0x01})
+
+	var msg []byte
+	msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, ts)
+	// metadata
+	msg = protowire.AppendTag(msg, 3, protowire.BytesType)
+	msg = protowire.AppendBytes(msg, []byte("metadata"))
+
+	req, err := Decode(encodeSnappy(msg))
+	require.NoError(t, err)
+	assert.Equal(t, WriteRequest{Timeseries: []TimeSeries{{Samples: []Sample{{Value: 3, Timestamp: 1000}}}}}, req)
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	for name, body := range map[string][]byte{
+		"empty":             nil,
+		"not_snappy":        []byte("plain text body"),
+		"truncated_message": encodeSnappy([]byte{0x0a, 0x05, 0x01}),
+		"bad_tag":           encodeSnappy([]byte{0x00}),
+	} {
+		_, err := Decode(body)
+		assert.ErrorIs(t, err, ErrDecode, name)
+	}
+}
+
+func TestSnappy(t *testing.T) {
+	inputs := map[string][]byte{
+		"empty":    {},
+		"short":    []byte("abc"),
+		"repeated": bytes.Repeat([]byte("metric_name{host=\"web-1\"} "), 500),
+		"long_run": bytes.Repeat([]byte{'a'}, 70000),
+		"long_plain": func() []byte {
+			b := make([]byte, 70000)
+			for i := range b {
+				b[i] = byte(i * 7919 >> 3)
+			}
+			return b
+		}(),
+	}
+	for name, in := range inputs {
+		enc := encodeSnappy(in)
+		out, err := decodeSnappy(enc, MaxDecodedSize)
+		require.NoError(t, err, name)
+		assert.Equal(t, in, out, name)
+	}
+	assert.Less(t, len(encodeSnappy(inputs["repeated"])), len(inputs["repeated"])/10)
+
+	// Блок с копиями всех видов
+	block := []byte{
+		15,                         // длина 15
+		3 << 2, 'a', 'b', 'c', 'd', // литерал abcd
+		4<<2 | 1, 4, // копия 8 байт со смещением 4 (11-битное смещение)
+		1<<2 | 2, 8, 0, // копия 2 байт со смещением 8 (16-битное смещение)
+		0<<2 | 3, 2, 0, 0, 0, // копия 1 байта со смещением 2 (32-битное смещение)
+	}
+	out, err := decodeSnappy(block, MaxDecodedSize)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdabcdabcdaba", string(out))
+}
+
+func TestDecodeSnappy_Invalid(t *testing.T) {
+	for name, block := range map[string][]byte{
+		"no_length":       {},
+		"too_large":       {0xff, 0xff, 0xff, 0xff, 0x0f},
+		"short_literal":   {5, 4 << 2, 'a'},
+		"bad_offset":      {8, 0, 'a', 1<<2 | 2, 5, 0},
+		"zero_offset":     {4, 0, 'a', 1<<2 | 2, 0, 0},
+		"length_mismatch": {5, 0, 'a'},
+		"overflow":        {2, 2 << 2, 'a', 'b', 'c'},
+	} {
+		_, err := decodeSnappy(block, MaxDecodedSize)
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,141 @@
+package promwrite
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// errCorrupt возвращается для данных, не соответствующих блочному формату snappy.
+var errCorrupt = errors.New("corrupt snappy block")
+
+// decodeSnappy распаковывает блок snappy (без потокового заголовка,
+// как в remote_write). Блоки, распакованный размер которых больше maxSize,
+// отклоняются до распаковки.
+func decodeSnappy(src []byte, maxSize int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errCorrupt
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("decoded size %d exceeds %d bytes", size, maxSize)
+	}
+	src = src[n:]
+	dst := make([]byte, 0, size)
+
+	for len(src) > 0 {
+		tag := src[0]
+		var length, offset int
+		switch tag & 3 {
+		case 0: // литерал
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+			if length <= 0 || length > len(src) || len(dst)+length > int(size) {
+				return nil, errCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+		case 1: // копия с 11-битным смещением
+			if len(src) < 2 {
+				return nil, errCorrupt
+			}
+			length = 4 + int(tag>>2&7)
+			offset = int(tag&0xe0)<<3 | int(src[1])
+			src = src[2:]
+		case 2: // копия с 16-битным смещением
+			if len(src) < 3 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+		case 3: // копия с 32-битным смещением
+			if len(src) < 5 {
+				return nil, errCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errCorrupt
+		}
+		// Копия может перекрывать саму себя, поэтому копируется побайтно
+		start := len(dst) - offset
+		for i := range length {
+			dst = append(dst, dst[start+i])
+		}
+	}
+
+	if len(dst) != int(size) {
+		return nil, errCorrupt
+	}
+	return dst, nil
+}
+
+// encodeSnappy сжимает src в блок snappy, заменяя повторы длиной от 4 байт
+// в пределах 64 КБ копиями.
+func encodeSnappy(src []byte) []byte {
+	const minMatch, maxOffset = 4, 1<<16 - 1
+
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	table := make(map[uint32]int)
+	lit := 0
+	for i := 0; i+minMatch <= len(src); {
+		h := binary.LittleEndian.Uint32(src[i:])
+		j, ok := table[h]
+		table[h] = i
+		if !ok || i-j > maxOffset {
+			i++
+			continue
+		}
+
+		n := minMatch
+		for i+n < len(src) && src[j+n] == src[i+n] {
+			n++
+		}
+		dst = appendLiteral(dst, src[lit:i])
+		for rest := n; rest > 0; {
+			l := min(rest, 64)
+			dst = append(dst, byte((l-1)<<2|2), byte(i-j), byte((i-j)>>8))
+			rest -= l
+		}
+		i += n
+		lit = i
+	}
+	return appendLiteral(dst, src[lit:])
+}
+
+// appendLiteral добавляет в dst литерал snappy с байтами lit.
+func appendLiteral(dst, lit []byte) []byte {
+	if len(lit) == 0 {
+		return dst
+	}
+	switch n := len(lit) - 1; {
+	case n < 60:
+		dst = append(dst, byte(n<<2))
+	case n < 1<<8:
+		dst = append(dst, 60<<2, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, lit...)
+}
@@ -0,0 +1,95 @@
+// Package query реализует язык запросов к истории метрик: выражение
+// разбирается в синтаксическое дерево (AST) функцией Parse и вычисляется
+// по данным хранилища Evaluator.
+//
+// Поддерживаемые выражения:
+//
+//	PollCount                                   - значения серий метрики
+//	HeapAlloc{host="web-1"}                     - только серии с указанными метками
+//	rate(PollCount[5m])                         - скорость роста counter в секунду
+//	increase(PollCount[1h])                     - прирост counter за окно
+//	avg_over_time(HeapAlloc[10m])               - среднее значение за окно
+//	min_over_time, max_over_time, sum_over_time - минимум, максимум и сумма за окно
+//	count_over_time(HeapAlloc[10m])             - количество значений за окно
+//	sum by (host) (rate(PollCount[5m]))         - агрегация серий по меткам
+//	sum(rate(PollCount[5m])) by (host)          - то же, by после выражения
+//
+// Кроме sum поддерживаются агрегации avg, min, max и count.
+// Окна задаются в формате time.ParseDuration.
+package query
+
+import (
+	"strings"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Expr - узел синтаксического дерева выражения.
+type Expr interface {
+	// String возвращает выражение в каноническом текстовом виде.
+	String() string
+
+	expr()
+}
+
+// Selector выбирает серии метрики Name, содержащие все метки Labels.
+// Значение серии в момент времени - последнее значение, полученное
+// не раньше DefaultLookback до него.
+type Selector struct {
+	Name   string
+	Labels map[string]string
+}
+
+// RangeSelector выбирает значения серий Selector за окно Range до момента
+// вычисления. Используется только как аргумент функции.
+type RangeSelector struct {
+	Selector
+	Range time.Duration
+}
+
+// Call - вызов функции Func над значениями серий за окно.
+type Call struct {
+	Func string
+	Arg  *RangeSelector
+}
+
+// Aggregate объединяет серии выражения Expr в группы с одинаковыми
+// значениями меток By и вычисляет для каждой группы агрегацию Op.
+// Без меток By все серии объединяются в одну группу.
+type Aggregate struct {
+	Op   string
+	By   []string
+	Expr Expr
+}
+
+func (*Selector) expr()      {}
+func (*RangeSelector) expr() {}
+func (*Call) expr()          {}
+func (*Aggregate) expr()     {}
+
+func (s *Selector) String() string {
+	return storage.SeriesKey(s.Name, s.Labels)
+}
+
+func (s *RangeSelector) String() string {
+	return s.Selector.String() + "[" + s.Range.String() + "]"
+}
+
+func (c *Call) String() string {
+	return c.Func + "(" + c.Arg.String() + ")"
+}
+
+func (a *Aggregate) String() string {
+	var b strings.Builder
+	b.WriteString(a.Op)
+	if len(a.By) > 0 {
+		b.WriteString(" by (")
+		b.WriteString(strings.Join(a.By, ", "))
+		b.WriteString(") ")
+	}
+	b.WriteByte('(')
+	b.WriteString(a.Expr.String())
+	b.WriteByte(')')
+	return b.String()
+}
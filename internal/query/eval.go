@@ -0,0 +1,350 @@
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// DefaultLookback - насколько давним может быть последнее значение серии,
+// чтобы селектор без окна вернул его в момент вычисления.
+const DefaultLookback = 5 * time.Minute
+
+// Series - результат вычисления выражения для одной серии.
+// Name пусто для результатов функций и агрегаций.
+type Series struct {
+	Name    string
+	Labels  map[string]string
+	Samples []storage.Sample
+}
+
+// Evaluator вычисляет выражения по истории значений gauge и counter
+// метрик хранилища.
+//
+// Пример использования:
+//
+//	expr, _ := query.Parse("rate(PollCount[5m])")
+//	series, err := query.NewEvaluator(storage).Eval(ctx, expr, time.Now())
+type Evaluator struct {
+	sp storage.StorageProvider
+}
+
+// NewEvaluator создает Evaluator для хранилища sp.
+func NewEvaluator(sp storage.StorageProvider) *Evaluator {
+	return &Evaluator{sp: sp}
+}
+
+// Eval вычисляет выражение в момент ts. Каждая серия результата
+// содержит не больше одного значения.
+func (e *Evaluator) Eval(ctx context.Context, expr Expr, ts time.Time) ([]Series, error) {
+	return e.eval(ctx, expr, []time.Time{ts})
+}
+
+// EvalRange вычисляет выражение в моменты from, from+step, ..., не позже to.
+// Серии, не имеющие значения ни в один из моментов, не возвращаются.
+func (e *Evaluator) EvalRange(ctx context.Context, expr Expr, from, to time.Time, step time.Duration) ([]Series, error) {
+	if step <= 0 {
+		return nil, fmt.Errorf("%w: step must be positive", storage.ErrInvalid)
+	}
+	var steps []time.Time
+	for ts := from; !ts.After(to); ts = ts.Add(step) {
+		steps = append(steps, ts)
+	}
+	return e.eval(ctx, expr, steps)
+}
+
+// point - значение серии в один из моментов вычисления.
+type point struct {
+	value float64
+	ok    bool
+}
+
+// vector - серия, вычисленная во все моменты: points[i] соответствует steps[i].
+type vector struct {
+	name   string
+	labels map[string]string
+	points []point
+}
+
+func (e *Evaluator) eval(ctx context.Context, expr Expr, steps []time.Time) ([]Series, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	vectors, err := e.evalExpr(ctx, expr, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Series, 0, len(vectors))
+	for _, v := range vectors {
+		s := Series{Name: v.name, Labels: v.labels}
+		for i, p := range v.points {
+			if p.ok {
+				s.Samples = append(s.Samples, storage.Sample{Timestamp: steps[i], Value: p.value})
+			}
+		}
+		if len(s.Samples) > 0 {
+			result = append(result, s)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return storage.SeriesKey(result[i].Name, result[i].Labels) < storage.SeriesKey(result[j].Name, result[j].Labels)
+	})
+	return result, nil
+}
+
+func (e *Evaluator) evalExpr(ctx context.Context, expr Expr, steps []time.Time) ([]vector, error) {
+	switch n := expr.(type) {
+	case *Selector:
+		return e.evalWindow(ctx, n, DefaultLookback, steps, func(samples []storage.Sample) (float64, bool) {
+			if len(samples) == 0 {
+				return 0, false
+			}
+			return samples[len(samples)-1].Value, true
+		}, true)
+	case *Call:
+		fn, ok := windowFunctions[n.Func]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown function %s", storage.ErrInvalid, n.Func)
+		}
+		return e.evalWindow(ctx, &n.Arg.Selector, n.Arg.Range, steps, fn, false)
+	case *Aggregate:
+		inner, err := e.evalExpr(ctx, n.Expr, steps)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(n, inner, len(steps))
+	default:
+		return nil, fmt.Errorf("%w: cannot evaluate %s", storage.ErrInvalid, expr)
+	}
+}
+
+// evalWindow вычисляет fn над значениями каждой серии sel за окно window
+// до каждого из моментов steps. keepName сохраняет имя метрики в результате.
+func (e *Evaluator) evalWindow(ctx context.Context, sel *Selector, window time.Duration, steps []time.Time,
+	fn func([]storage.Sample) (float64, bool), keepName bool) ([]vector, error) {
+	from, to := steps[0].Add(-window), steps[len(steps)-1]
+
+	keys, err := e.match(ctx, sel)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([]vector, 0, len(keys))
+	for _, k := range keys {
+		samples, err := e.sp.QueryRange(ctx, k.mtype, k.key, from, to)
+		if err != nil {
+			return nil, err
+		}
+
+		v := vector{labels: k.labels, points: make([]point, len(steps))}
+		if keepName {
+			v.name = sel.Name
+		}
+		for i, ts := range steps {
+			// значения в окне (ts - window, ts]
+			lo := sort.Search(len(samples), func(j int) bool { return samples[j].Timestamp.After(ts.Add(-window)) })
+			hi := sort.Search(len(samples), func(j int) bool { return samples[j].Timestamp.After(ts) })
+			if lo >= hi {
+				continue
+			}
+			value, ok := fn(samples[lo:hi])
+			v.points[i] = point{value: value, ok: ok}
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+// seriesRef - серия хранилища, выбранная селектором.
+type seriesRef struct {
+	mtype  storage.MetricType
+	key    string
+	labels map[string]string
+}
+
+// match возвращает серии gauge и counter метрик, выбранные селектором sel.
+// Если gauge и counter серии имеют одинаковый ключ, выбирается gauge.
+func (e *Evaluator) match(ctx context.Context, sel *Selector) ([]seriesRef, error) {
+	gaugeKeys, err := e.sp.KeysGauge(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counterKeys, err := e.sp.KeysCounter(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []seriesRef
+	seen := make(map[string]bool)
+	add := func(mtype storage.MetricType, keys []string) {
+		sort.Strings(keys)
+		for _, k := range keys {
+			name, labels, err := storage.ParseSeriesKey(k)
+			if err != nil || name != sel.Name || seen[k] || !storage.MatchLabels(labels, sel.Labels) {
+				continue
+			}
+			seen[k] = true
+			refs = append(refs, seriesRef{mtype: mtype, key: k, labels: labels})
+		}
+	}
+	add(storage.MetricTypeGauge, gaugeKeys)
+	add(storage.MetricTypeCounter, counterKeys)
+	return refs, nil
+}
+
+// windowFunctions - функции над значениями серии за окно. Второе
+// возвращаемое значение равно false, если значений недостаточно.
+var windowFunctions = map[string]func([]storage.Sample) (float64, bool){
+	"rate": func(samples []storage.Sample) (float64, bool) {
+		if len(samples) < 2 {
+			return 0, false
+		}
+		elapsed := samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp).Seconds()
+		if elapsed <= 0 {
+			return 0, false
+		}
+		return increase(samples) / elapsed, true
+	},
+	"increase": func(samples []storage.Sample) (float64, bool) {
+		if len(samples) < 2 {
+			return 0, false
+		}
+		return increase(samples), true
+	},
+	"avg_over_time": func(samples []storage.Sample) (float64, bool) {
+		var sum float64
+		for _, s := range samples {
+			sum += s.Value
+		}
+		return sum / float64(len(samples)), true
+	},
+	"min_over_time": func(samples []storage.Sample) (float64, bool) {
+		v := math.Inf(1)
+		for _, s := range samples {
+			v = math.Min(v, s.Value)
+		}
+		return v, true
+	},
+	"max_over_time": func(samples []storage.Sample) (float64, bool) {
+		v := math.Inf(-1)
+		for _, s := range samples {
+			v = math.Max(v, s.Value)
+		}
+		return v, true
+	},
+	"sum_over_time": func(samples []storage.Sample) (float64, bool) {
+		var sum float64
+		for _, s := range samples {
+			sum += s.Value
+		}
+		return sum, true
+	},
+	"count_over_time": func(samples []storage.Sample) (float64, bool) {
+		return float64(len(samples)), true
+	},
+}
+
+// increase вычисляет прирост значений counter. Уменьшение значения
+// считается сбросом счетчика: прирост после сброса равен новому значению.
+func increase(samples []storage.Sample) float64 {
+	var total float64
+	for i := 1; i < len(samples); i++ {
+		delta := samples[i].Value - samples[i-1].Value
+		if delta < 0 {
+			delta = samples[i].Value
+		}
+		total += delta
+	}
+	return total
+}
+
+// aggregate объединяет серии inner в группы по меткам agg.By и вычисляет
+// агрегацию в каждый из n моментов по сериям, имеющим в этот момент значение.
+func aggregate(agg *Aggregate, inner []vector, n int) ([]vector, error) {
+	type group struct {
+		labels map[string]string
+		values [][]float64
+	}
+
+	groups := make(map[string]*group)
+	var order []string
+	for _, v := range inner {
+		labels := make(map[string]string, len(agg.By))
+		for _, l := range agg.By {
+			if lv, ok := v.labels[l]; ok {
+				labels[l] = lv
+			}
+		}
+		if len(labels) == 0 {
+			labels = nil
+		}
+
+		id := storage.SeriesKey("", labels)
+		g, ok := groups[id]
+		if !ok {
+			g = &group{labels: labels, values: make([][]float64, n)}
+			groups[id] = g
+			order = append(order, id)
+		}
+		for i, p := range v.points {
+			if p.ok {
+				g.values[i] = append(g.values[i], p.value)
+			}
+		}
+	}
+
+	result := make([]vector, 0, len(groups))
+	for _, id := range order {
+		g := groups[id]
+		v := vector{labels: g.labels, points: make([]point, n)}
+		for i, values := range g.values {
+			if len(values) == 0 {
+				continue
+			}
+			value, err := aggregateValues(agg.Op, values)
+			if err != nil {
+				return nil, err
+			}
+			v.points[i] = point{value: value, ok: true}
+		}
+		result = append(result, v)
+	}
+	return result, nil
+}
+
+// aggregateValues вычисляет агрегацию op непустого набора значений.
+func aggregateValues(op string, values []float64) (float64, error) {
+	switch op {
+	case "sum", "avg":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		if op == "avg" {
+			return sum / float64(len(values)), nil
+		}
+		return sum, nil
+	case "min":
+		v := math.Inf(1)
+		for _, x := range values {
+			v = math.Min(v, x)
+		}
+		return v, nil
+	case "max":
+		v := math.Inf(-1)
+		for _, x := range values {
+			v = math.Max(v, x)
+		}
+		return v, nil
+	case "count":
+		return float64(len(values)), nil
+	default:
+		return 0, fmt.Errorf("%w: unknown aggregation %s", storage.ErrInvalid, op)
+	}
+}
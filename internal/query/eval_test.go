@@ -0,0 +1,163 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var base = time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+// newTestStorage создает хранилище с историей counter PollCount двух хостов
+// и gauge HeapAlloc за первые 2 минуты после base.
+func newTestStorage(t *testing.T) *memstorage.MemStorage {
+	t.Helper()
+	ms := memstorage.NewStorage()
+
+	history := map[string][]float64{
+		`PollCount{host="a"}`: {0, 10, 20, 5, 15},
+		`PollCount{host="b"}`: {100, 130},
+	}
+	for key, values := range history {
+		ms.Counters.Set(key, storage.Counter(values[len(values)-1]))
+		for i, v := range values {
+			ms.CountersHistory.Append(key, base.Add(time.Duration(i)*30*time.Second), v)
+		}
+	}
+
+	ms.Gauges.Set(`HeapAlloc{host="a"}`, 4)
+	for i, v := range []float64{1, 3, 2, 4} {
+		ms.GaugesHistory.Append(`HeapAlloc{host="a"}`, base.Add(time.Duration(i)*40*time.Second), v)
+	}
+	return ms
+}
+
+func eval(t *testing.T, sp storage.StorageProvider, s string, ts time.Time) []Series {
+	t.Helper()
+	expr, err := Parse(s)
+	require.NoError(t, err)
+	series, err := NewEvaluator(sp).Eval(context.Background(), expr, ts)
+	require.NoError(t, err)
+	return series
+}
+
+func sample(ts time.Time, v float64) []storage.Sample {
+	return []storage.Sample{{Timestamp: ts, Value: v}}
+}
+
+func TestEvaluator_Eval(t *testing.T) {
+	ms := newTestStorage(t)
+	now := base.Add(2 * time.Minute)
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []Series
+	}{
+		{
+			name:  "selector",
+			query: `PollCount{host="a"}`,
+			expected: []Series{
+				{Name: "PollCount", Labels: map[string]string{"host": "a"}, Samples: sample(now, 15)},
+			},
+		},
+		{
+			// a: 10 + 10 + 5 (сброс) + 10 за 120s, b: 30 за 30s
+			name:  "rate",
+			query: "rate(PollCount[5m])",
+			expected: []Series{
+				{Labels: map[string]string{"host": "a"}, Samples: sample(now, 35.0/120)},
+				{Labels: map[string]string{"host": "b"}, Samples: sample(now, 1)},
+			},
+		},
+		{
+			name:  "increase_window",
+			query: `increase(PollCount{host="a"}[1m])`,
+			expected: []Series{
+				{Labels: map[string]string{"host": "a"}, Samples: sample(now, 10)},
+			},
+		},
+		{
+			name:  "over_time",
+			query: "avg_over_time(HeapAlloc[5m])",
+			expected: []Series{
+				{Labels: map[string]string{"host": "a"}, Samples: sample(now, 2.5)},
+			},
+		},
+		{
+			name:  "max_over_time",
+			query: "max_over_time(HeapAlloc[1m])",
+			expected: []Series{
+				{Labels: map[string]string{"host": "a"}, Samples: sample(now, 4)},
+			},
+		},
+		{
+			name:  "sum_by",
+			query: "sum by (host) (increase(PollCount[5m]))",
+			expected: []Series{
+				{Labels: map[string]string{"host": "a"}, Samples: sample(now, 35)},
+				{Labels: map[string]string{"host": "b"}, Samples: sample(now, 30)},
+			},
+		},
+		{
+			name:  "sum",
+			query: "sum(increase(PollCount[5m]))",
+			expected: []Series{
+				{Samples: sample(now, 65)},
+			},
+		},
+		{
+			name:  "count",
+			query: "count(PollCount)",
+			expected: []Series{
+				{Samples: sample(now, 2)},
+			},
+		},
+		{
+			name:     "unknown_metric",
+			query:    "rate(Missing[5m])",
+			expected: []Series{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, eval(t, ms, tc.query, now))
+		})
+	}
+}
+
+func TestEvaluator_Lookback(t *testing.T) {
+	ms := newTestStorage(t)
+
+	// Последнее значение HeapAlloc получено в base+2m
+	assert.Len(t, eval(t, ms, "HeapAlloc", base.Add(2*time.Minute+DefaultLookback-time.Second)), 1)
+	assert.Empty(t, eval(t, ms, "HeapAlloc", base.Add(2*time.Minute+DefaultLookback)))
+
+	// Для rate нужно хотя бы два значения в окне
+	assert.Empty(t, eval(t, ms, "rate(PollCount[20s])", base.Add(2*time.Minute)))
+}
+
+func TestEvaluator_EvalRange(t *testing.T) {
+	ms := newTestStorage(t)
+
+	expr, err := Parse(`increase(PollCount{host="a"}[1m])`)
+	require.NoError(t, err)
+	series, err := NewEvaluator(ms).EvalRange(context.Background(), expr, base, base.Add(2*time.Minute), time.Minute)
+	require.NoError(t, err)
+
+	// В момент base в окне одно значение, прироста нет
+	require.Len(t, series, 1)
+	assert.Equal(t, []storage.Sample{
+		{Timestamp: base.Add(time.Minute), Value: 10},
+		{Timestamp: base.Add(2 * time.Minute), Value: 10},
+	}, series[0].Samples)
+
+	_, err = NewEvaluator(ms).EvalRange(context.Background(), expr, base, base, 0)
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+}
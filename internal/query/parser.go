@@ -0,0 +1,305 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// ErrSyntax возвращается для выражения, которое не удается разобрать.
+var ErrSyntax = errors.New("syntax error")
+
+// functions - функции над значениями серий за окно.
+var functions = []string{
+	"rate",
+	"increase",
+	"avg_over_time",
+	"min_over_time",
+	"max_over_time",
+	"sum_over_time",
+	"count_over_time",
+}
+
+// aggregations - агрегации серий.
+var aggregations = []string{"sum", "avg", "min", "max", "count"}
+
+// Parse разбирает выражение s в синтаксическое дерево.
+//
+// Пример использования:
+//
+//	expr, err := query.Parse(`sum by (host) (rate(PollCount[5m]))`)
+func Parse(s string) (Expr, error) {
+	p := &parser{s: s}
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos:])
+	}
+	if _, ok := expr.(*RangeSelector); ok {
+		return nil, p.errorf("range selector %s must be an argument of a function", expr)
+	}
+	return expr, nil
+}
+
+// parser - разбор выражения рекурсивным спуском.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	p.skipSpace()
+	name := p.name()
+	if name == "" {
+		return nil, p.errorf("expected metric, function or aggregation")
+	}
+
+	p.skipSpace()
+	switch {
+	case slices.Contains(aggregations, name) && (p.peek() == '(' || p.keyword("by")):
+		return p.parseAggregate(name)
+	case slices.Contains(functions, name) && p.peek() == '(':
+		return p.parseCall(name)
+	default:
+		return p.parseSelector(name)
+	}
+}
+
+// parseAggregate разбирает агрегацию op после ее имени: метки by
+// допускаются как перед выражением, так и после него.
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	agg := &Aggregate{Op: op}
+
+	if p.consumeKeyword("by") {
+		by, err := p.parseLabelList()
+		if err != nil {
+			return nil, err
+		}
+		agg.By = by
+	}
+
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := inner.(*RangeSelector); ok {
+		return nil, p.errorf("%s expects an instant expression, got range selector %s", op, inner)
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	agg.Expr = inner
+
+	if agg.By == nil && p.consumeKeyword("by") {
+		by, err := p.parseLabelList()
+		if err != nil {
+			return nil, err
+		}
+		agg.By = by
+	}
+	return agg, nil
+}
+
+// parseCall разбирает вызов функции fn после ее имени.
+func (p *parser) parseCall(fn string) (Expr, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+	arg, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	rs, ok := arg.(*RangeSelector)
+	if !ok {
+		return nil, p.errorf("%s expects a range selector, got %s", fn, arg)
+	}
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return &Call{Func: fn, Arg: rs}, nil
+}
+
+// parseSelector разбирает метки и окно селектора метрики name.
+func (p *parser) parseSelector(name string) (Expr, error) {
+	sel := Selector{Name: name}
+
+	if p.peek() == '{' {
+		p.pos++
+		sel.Labels = make(map[string]string)
+		for {
+			p.skipSpace()
+			if p.peek() == '}' {
+				p.pos++
+				break
+			}
+
+			label := p.labelName()
+			if label == "" {
+				return nil, p.errorf("expected label name")
+			}
+			if err := p.expect('='); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			quoted, err := strconv.QuotedPrefix(p.s[p.pos:])
+			if err != nil || quoted[0] != '"' {
+				return nil, p.errorf("expected quoted value of label %s", label)
+			}
+			value, err := strconv.Unquote(quoted)
+			if err != nil {
+				return nil, p.errorf("invalid value of label %s", label)
+			}
+			p.pos += len(quoted)
+			sel.Labels[label] = value
+
+			p.skipSpace()
+			if p.peek() == ',' {
+				p.pos++
+				continue
+			}
+			if err := p.expect('}'); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if len(sel.Labels) == 0 {
+			sel.Labels = nil
+		}
+	}
+	if err := storage.ValidateSeries(sel.Name, sel.Labels); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSyntax, err)
+	}
+
+	p.skipSpace()
+	if p.peek() != '[' {
+		return &sel, nil
+	}
+	end := strings.IndexByte(p.s[p.pos:], ']')
+	if end < 0 {
+		return nil, p.errorf("unclosed range of %s", sel.String())
+	}
+	window, err := time.ParseDuration(strings.TrimSpace(p.s[p.pos+1 : p.pos+end]))
+	if err != nil || window <= 0 {
+		return nil, p.errorf("invalid range of %s", sel.String())
+	}
+	p.pos += end + 1
+	return &RangeSelector{Selector: sel, Range: window}, nil
+}
+
+// parseLabelList разбирает список имен меток в скобках: (host, dc).
+func (p *parser) parseLabelList() ([]string, error) {
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, 0)
+	for {
+		p.skipSpace()
+		if p.peek() == ')' && len(labels) == 0 {
+			p.pos++
+			return labels, nil
+		}
+		label := p.labelName()
+		if label == "" {
+			return nil, p.errorf("expected label name")
+		}
+		labels = append(labels, label)
+
+		p.skipSpace()
+		if p.peek() == ',' {
+			p.pos++
+			continue
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return labels, nil
+	}
+}
+
+// name считывает имя метрики, функции или агрегации: любые символы, кроме
+// пробельных и используемых в синтаксисе выражений.
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.ContainsRune(" \t\r\n(){}[],=\"", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// labelName считывает имя метки вида [a-zA-Z_][a-zA-Z0-9_]*.
+func (p *parser) labelName() string {
+	start := p.pos
+	for p.pos < len(p.s) {
+		c := p.s[p.pos]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && p.pos > start {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.s[start:p.pos]
+}
+
+// keyword сообщает, начинается ли оставшаяся часть выражения
+// с отдельного слова kw.
+func (p *parser) keyword(kw string) bool {
+	rest := p.s[p.pos:]
+	if !strings.HasPrefix(rest, kw) {
+		return false
+	}
+	if len(rest) == len(kw) {
+		return true
+	}
+	c := rest[len(kw)]
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '('
+}
+
+// consumeKeyword пропускает слово kw, если выражение продолжается им.
+func (p *parser) consumeKeyword(kw string) bool {
+	p.skipSpace()
+	if !p.keyword(kw) {
+		return false
+	}
+	p.pos += len(kw)
+	p.skipSpace()
+	return true
+}
+
+func (p *parser) expect(c byte) error {
+	p.skipSpace()
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && strings.ContainsRune(" \t\r\n", rune(p.s[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("%w at position %d: %s", ErrSyntax, p.pos, fmt.Sprintf(format, args...))
+}
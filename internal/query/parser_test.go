@@ -0,0 +1,113 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Expr
+		str      string
+	}{
+		{
+			name:     "selector",
+			input:    "PollCount",
+			expected: &Selector{Name: "PollCount"},
+			str:      "PollCount",
+		},
+		{
+			name:     "selector_with_labels",
+			input:    `HeapAlloc{ host = "web-1", dc="eu" }`,
+			expected: &Selector{Name: "HeapAlloc", Labels: map[string]string{"host": "web-1", "dc": "eu"}},
+			str:      `HeapAlloc{dc="eu",host="web-1"}`,
+		},
+		{
+			name:  "rate",
+			input: "rate(PollCount[5m])",
+			expected: &Call{Func: "rate", Arg: &RangeSelector{
+				Selector: Selector{Name: "PollCount"}, Range: 5 * time.Minute,
+			}},
+			str: "rate(PollCount[5m0s])",
+		},
+		{
+			name:  "over_time_with_labels",
+			input: `max_over_time(cpu.usage-1{host="a\"b"}[30s])`,
+			expected: &Call{Func: "max_over_time", Arg: &RangeSelector{
+				Selector: Selector{Name: "cpu.usage-1", Labels: map[string]string{"host": `a"b`}}, Range: 30 * time.Second,
+			}},
+			str: `max_over_time(cpu.usage-1{host="a\"b"}[30s])`,
+		},
+		{
+			name:  "sum_by_before",
+			input: "sum by (host, dc) (rate(PollCount[1m]))",
+			expected: &Aggregate{Op: "sum", By: []string{"host", "dc"}, Expr: &Call{Func: "rate", Arg: &RangeSelector{
+				Selector: Selector{Name: "PollCount"}, Range: time.Minute,
+			}}},
+			str: "sum by (host, dc) (rate(PollCount[1m0s]))",
+		},
+		{
+			name:  "sum_by_after",
+			input: "sum(increase(PollCount[1h])) by(host)",
+			expected: &Aggregate{Op: "sum", By: []string{"host"}, Expr: &Call{Func: "increase", Arg: &RangeSelector{
+				Selector: Selector{Name: "PollCount"}, Range: time.Hour,
+			}}},
+			str: "sum by (host) (increase(PollCount[1h0m0s]))",
+		},
+		{
+			name:     "nested_aggregation",
+			input:    "max(avg by (host) (HeapAlloc))",
+			expected: &Aggregate{Op: "max", Expr: &Aggregate{Op: "avg", By: []string{"host"}, Expr: &Selector{Name: "HeapAlloc"}}},
+			str:      "max(avg by (host) (HeapAlloc))",
+		},
+		{
+			name:     "function_name_as_metric",
+			input:    "rate",
+			expected: &Selector{Name: "rate"},
+			str:      "rate",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			expr, err := Parse(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, expr)
+			assert.Equal(t, tc.str, expr.String())
+
+			// Канонический вид разбирается в то же дерево
+			again, err := Parse(expr.String())
+			require.NoError(t, err)
+			assert.Equal(t, expr, again)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"PollCount[5m]",
+		"rate(PollCount)",
+		"rate(PollCount[5x])",
+		"rate(PollCount[-1m])",
+		"rate(PollCount[5m]",
+		"sum(PollCount[5m])",
+		"sum by host (PollCount)",
+		"sum by (1host) (PollCount)",
+		`HeapAlloc{host=web}`,
+		`HeapAlloc{host="web"`,
+		`HeapAlloc{"host"="web"}`,
+		"HeapAlloc extra",
+		"rate(sum(PollCount))",
+	}
+
+	for _, s := range invalid {
+		_, err := Parse(s)
+		assert.ErrorIs(t, err, ErrSyntax, s)
+	}
+}
@@ -6,27 +6,101 @@ package router
 import (
 	"net/http"
 
+	"github.com/am0xff/metrics/internal/alerts"
 	"github.com/am0xff/metrics/internal/handlers"
+	"github.com/am0xff/metrics/internal/influx"
+	"github.com/am0xff/metrics/internal/otlp"
 	"github.com/am0xff/metrics/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
 
+// Option добавляет в маршрутизатор необязательные маршруты
+// или настраивает обработчики h.
+type Option func(r chi.Router, h *handlers.Handler)
+
+// WithAlerts добавляет маршрут GET /api/v1/alerts, возвращающий
+// текущее состояние оповещений движка e.
+func WithAlerts(e *alerts.Engine) Option {
+	return func(r chi.Router, _ *handlers.Handler) {
+		r.Method(http.MethodGet, "/api/v1/alerts", e)
+	}
+}
+
+// WithServerGauge добавляет в выгрузку GET /metrics gauge метрику
+// самого сервера name со значением value (см. Handler.AddServerGauge).
+func WithServerGauge(name string, value func() float64) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.AddServerGauge(name, value)
+	}
+}
+
+// WithTTLPolicy задает политику устаревания серий, по которой GET /
+// скрывает устаревшие серии (см. Handler.SetTTLPolicy).
+func WithTTLPolicy(p storage.TTLPolicy) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.SetTTLPolicy(p)
+	}
+}
+
+// WithBucketPolicy задает границы корзин histogram метрик
+// (см. Handler.SetBucketPolicy).
+func WithBucketPolicy(p storage.BucketPolicy) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.SetBucketPolicy(p)
+	}
+}
+
+// WithInfluxMapping задает сопоставление полей InfluxDB line protocol
+// с типами метрик (см. Handler.SetInfluxMapping).
+func WithInfluxMapping(m influx.Mapping) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.SetInfluxMapping(m)
+	}
+}
+
+// WithPromWriteCounters включает запись серий Prometheus remote_write
+// с именем на _total в counter метрики (см. Handler.SetPromWriteCounters).
+func WithPromWriteCounters(enabled bool) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.SetPromWriteCounters(enabled)
+	}
+}
+
+// WithOTLPMapping задает атрибуты, которые становятся префиксами имен
+// метрик OTLP (см. Handler.SetOTLPMapping).
+func WithOTLPMapping(m otlp.Mapping) Option {
+	return func(_ chi.Router, h *handlers.Handler) {
+		h.SetOTLPMapping(m)
+	}
+}
+
 // SetupRoutes создает и настраивает HTTP маршрутизатор для API метрик.
 // Принимает провайдер хранилища и возвращает настроенный HTTP обработчик
-// со всеми необходимыми маршрутами.
+// со всеми необходимыми маршрутами. Необязательные маршруты подключаются
+// через opts.
 //
 // Настроенные маршруты:
 //
-//	GET  /                              - HTML страница со всеми метриками
+//	GET  /                              - HTML страница со всеми метриками (?stale=true - с устаревшими)
 //	GET  /ping                          - проверка доступности хранилища
+//	GET  /metrics                       - все метрики в формате Prometheus
 //	POST /value/                        - получение метрики (JSON)
 //	POST /update/                       - обновление метрики (JSON)
 //	POST /updates/                      - массовое обновление метрик (JSON)
 //	GET  /value/{type}/{name}           - получение метрики (URL параметры)
 //	POST /update/{type}/{name}/{value}  - обновление метрики (URL параметры)
+//	DELETE /value/{type}/{name}         - удаление метрики
+//	POST /delete/                       - массовое удаление метрик (JSON)
+//	POST /reset/                        - сброс counter метрики (JSON)
+//	GET  /api/v1/query_range            - история значений метрики за интервал
+//	GET  /api/v1/query                  - вычисление выражения языка запросов
+//	POST /api/v1/write                  - запись метрик в формате InfluxDB line protocol
+//	POST /api/v1/prom/write             - прием метрик Prometheus remote_write
+//	POST /v1/metrics                    - прием метрик OpenTelemetry (OTLP/HTTP)
+//	GET  /api/v1/alerts                 - состояние оповещений (WithAlerts)
 //
 // Параметры маршрутов:
-//   - {type}: тип метрики ("gauge" или "counter")
+//   - {type}: тип метрики ("gauge", "counter" или "histogram")
 //   - {name}: имя метрики
 //   - {value}: значение метрики
 //
@@ -49,32 +123,67 @@ import (
 //	# Проверка доступности
 //	curl http://localhost:8080/ping
 //
+//	# Выгрузка метрик в формате Prometheus
+//	curl http://localhost:8080/metrics
+//
 //	# Обновление gauge метрики через URL
 //	curl -X POST http://localhost:8080/update/gauge/cpu_usage/85.5
 //
 //	# Получение counter метрики через URL
 //	curl http://localhost:8080/value/counter/requests_total
 //
+//	# Наблюдение histogram метрики и оценка ее 99-го перцентиля
+//	curl -X POST http://localhost:8080/update/histogram/http_latency/0.25
+//	curl 'http://localhost:8080/value/histogram/http_latency?quantile=0.99'
+//
 //	# Обновление метрики через JSON
 //	curl -X POST http://localhost:8080/update/ \
 //		-H "Content-Type: application/json" \
 //		-d '{"id":"memory_usage","type":"gauge","value":67.2}'
 //
+//	# История gauge метрики за последний час с шагом в минуту
+//	curl 'http://localhost:8080/api/v1/query_range?id=cpu_usage&type=gauge&step=1m'
+//
+//	# Скорость роста PollCount по хостам
+//	curl 'http://localhost:8080/api/v1/query?query=sum+by+(host)+(rate(PollCount[5m]))'
+//
+//	# Запись метрик в формате InfluxDB line protocol
+//	curl -X POST 'http://localhost:8080/api/v1/write?precision=s' \
+//		--data-binary 'cpu,host=web-1 usage_idle=98.5,procs=120i 1700000000'
+//
+//	# Удаление всех метрик хоста web-1
+//	curl -X POST http://localhost:8080/delete/ \
+//		-H "Content-Type: application/json" \
+//		-d '{"labels":{"host":"web-1"}}'
+//
 //	# Массовое обновление через JSON
 //	curl -X POST http://localhost:8080/updates/ \
 //		-H "Content-Type: application/json" \
 //		-d '[{"id":"cpu","type":"gauge","value":85.5},{"id":"requests","type":"counter","delta":100}]'
-func SetupRoutes(sp storage.StorageProvider) http.Handler {
+func SetupRoutes(sp storage.StorageProvider, opts ...Option) http.Handler {
 	r := chi.NewRouter()
 
 	handler := handlers.NewHandler(sp)
 
 	r.Get("/", handler.GetMetrics)
 	r.Get("/ping", handler.Ping)
+	r.Get("/metrics", handler.GetPrometheusMetrics)
 	r.Post("/value/", handler.POSTGetMetric)
 	r.Post("/update/", handler.POSTUpdateMetric)
 	r.Post("/updates/", handler.POSTUpdatesMetrics)
 	r.Get("/value/{type}/{name}", handler.GETGetMetric)
 	r.Post("/update/{type}/{name}/{value}", handler.GETUpdateMetric)
+	r.Delete("/value/{type}/{name}", handler.DELETEMetric)
+	r.Post("/delete/", handler.POSTDeleteMetrics)
+	r.Post("/reset/", handler.POSTResetCounter)
+	r.Get("/api/v1/query_range", handler.GETQueryRange)
+	r.Get("/api/v1/query", handler.GETQuery)
+	r.Post("/api/v1/write", handler.POSTInfluxWrite)
+	r.Post("/api/v1/prom/write", handler.POSTPromWrite)
+	r.Post("/v1/metrics", handler.POSTOTLPMetrics)
+
+	for _, opt := range opts {
+		opt(r, handler)
+	}
 	return r
 }
@@ -1,12 +1,21 @@
 package router
 
 import (
+	"bytes"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/am0xff/metrics/internal/alerts"
+	"github.com/am0xff/metrics/internal/middleware"
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/otlp"
 	memstorage "github.com/am0xff/metrics/internal/storage/memory"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSetupRoutes(t *testing.T) {
@@ -26,6 +35,7 @@ func TestSetupRoutes(t *testing.T) {
 	}{
 		{"GET", "/", 200},
 		{"GET", "/ping", 200},
+		{"GET", "/metrics", 200},
 		{"GET", "/value/gauge/test", 404}, // метрика не существует
 	}
 
@@ -36,3 +46,81 @@ func TestSetupRoutes(t *testing.T) {
 		resp.Body.Close()
 	}
 }
+
+func TestSetupRoutes_WithAlerts(t *testing.T) {
+	storage := memstorage.NewStorage()
+	engine := alerts.NewEngine(storage, nil, nil, 0)
+	handler := SetupRoutes(storage, WithAlerts(engine))
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/alerts")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestSetupRoutes_GzipInfluxLineErrors(t *testing.T) {
+	storage := memstorage.NewStorage()
+	server := httptest.NewServer(middleware.GzipMiddleware(SetupRoutes(storage), ""))
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/write", strings.NewReader("cpu usage_idle=1\ncpu usage_idle"))
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Ответ с ошибками строк не сжимается, раз заголовок не выставлен
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+
+	var result models.WriteResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 1, result.Written)
+	require.Len(t, result.Errors, 1)
+	assert.Equal(t, 2, result.Errors[0].Line)
+}
+
+func TestSetupRoutes_GzipOTLP(t *testing.T) {
+	storage := memstorage.NewStorage()
+	server := httptest.NewServer(middleware.GzipMiddleware(SetupRoutes(storage), ""))
+	defer server.Close()
+
+	post := func(contentType string, body []byte) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/metrics", bytes.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Accept-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, b
+	}
+
+	// Частичный успех в protobuf передается без сжатия
+	req := otlp.Request{ResourceMetrics: []otlp.ResourceMetrics{{
+		ScopeMetrics: []otlp.ScopeMetrics{{Metrics: []otlp.Metric{
+			{Name: "latency", Kind: otlp.KindHistogram, Temporality: otlp.TemporalityDelta, HistogramPoints: []otlp.HistogramPoint{
+				{Count: 1, BucketCounts: []uint64{1, 0}, Bounds: []float64{2}},
+				{Count: 1, BucketCounts: []uint64{1}},
+			}},
+		}}},
+	}}}
+	resp, body := post("application/x-protobuf", otlp.EncodeProto(req))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-protobuf", resp.Header.Get("Content-Type"))
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	require.NotEmpty(t, body)
+	assert.NotEqual(t, byte(0x1f), body[0])
+
+	// Тело ошибки тоже
+	resp, body = post("application/json", []byte("{"))
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Contains(t, string(body), `"code":3`)
+}
@@ -0,0 +1,82 @@
+package rpc
+
+import (
+	"fmt"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/pb"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// MetricTypeToProto преобразует тип метрики хранилища в тип protobuf.
+func MetricTypeToProto(mtype storage.MetricType) pb.MetricType {
+	switch mtype {
+	case storage.MetricTypeGauge:
+		return pb.MetricType_GAUGE
+	case storage.MetricTypeCounter:
+		return pb.MetricType_COUNTER
+	}
+	return pb.MetricType_METRIC_TYPE_UNSPECIFIED
+}
+
+// MetricTypeFromProto преобразует тип protobuf в тип метрики хранилища.
+func MetricTypeFromProto(t pb.MetricType) (storage.MetricType, error) {
+	switch t {
+	case pb.MetricType_GAUGE:
+		return storage.MetricTypeGauge, nil
+	case pb.MetricType_COUNTER:
+		return storage.MetricTypeCounter, nil
+	}
+	return "", fmt.Errorf("unknown metric type %v", t)
+}
+
+// MetricToProto преобразует метрику в сообщение protobuf.
+func MetricToProto(m models.Metrics) *pb.Metric {
+	p := &pb.Metric{
+		Id:     m.ID,
+		Type:   MetricTypeToProto(m.MType),
+		Labels: m.Labels,
+	}
+	if m.Value != nil {
+		p.Value = *m.Value
+	}
+	if m.Delta != nil {
+		p.Delta = *m.Delta
+	}
+	return p
+}
+
+// MetricsToProto преобразует список метрик в сообщения protobuf.
+func MetricsToProto(metrics []models.Metrics) []*pb.Metric {
+	out := make([]*pb.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		out = append(out, MetricToProto(m))
+	}
+	return out
+}
+
+// MetricFromProto преобразует сообщение protobuf в метрику.
+func MetricFromProto(p *pb.Metric) (models.Metrics, error) {
+	mtype, err := MetricTypeFromProto(p.GetType())
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	m := models.Metrics{
+		ID:    p.GetId(),
+		MType: mtype,
+	}
+	if len(p.GetLabels()) > 0 {
+		m.Labels = p.GetLabels()
+	}
+
+	switch mtype {
+	case storage.MetricTypeGauge:
+		v := p.GetValue()
+		m.Value = &v
+	case storage.MetricTypeCounter:
+		d := p.GetDelta()
+		m.Delta = &d
+	}
+	return m, nil
+}
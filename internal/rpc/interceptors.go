@@ -0,0 +1,282 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rsa"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/am0xff/metrics/internal/pb"
+	"github.com/am0xff/metrics/internal/utils"
+)
+
+// Подпись и шифрование применяются только к сообщениям UpdateMetricsRequest,
+// так же как HTTP middleware применяются только к запросам на обновление метрик.
+// Агент сначала шифрует метрики, затем подписывает сообщение; сервер сначала
+// проверяет подпись, затем расшифровывает метрики.
+
+// RealIPKey - ключ метаданных с IP-адресом агента, аналог HTTP заголовка X-Real-IP.
+const RealIPKey = "x-real-ip"
+
+// writeMethods - методы, изменяющие метрики.
+var writeMethods = map[string]bool{
+	pb.Metrics_UpdateMetrics_FullMethodName: true,
+	pb.Metrics_StreamMetrics_FullMethodName: true,
+}
+
+// SignRequest вычисляет HMAC-SHA256 сообщения с пустым полем hash
+// и записывает его в поле hash.
+func SignRequest(req *pb.UpdateMetricsRequest, key string) error {
+	data, err := hashPayload(req)
+	if err != nil {
+		return err
+	}
+	req.Hash = utils.CreateHash(data, key)
+	return nil
+}
+
+// VerifyRequest проверяет подпись сообщения. Сообщения без подписи
+// пропускаются, как и HTTP запросы без заголовка HashSHA256.
+func VerifyRequest(req *pb.UpdateMetricsRequest, key string) error {
+	if req.GetHash() == "" {
+		return nil
+	}
+	data, err := hashPayload(req)
+	if err != nil {
+		return err
+	}
+	return utils.ValidateHash(data, key, req.GetHash())
+}
+
+// EncryptRequest шифрует метрики сообщения в конверт RSA-OAEP + AES-GCM
+// (см. utils.EncryptEnvelope) и переносит их в поле encrypted.
+func EncryptRequest(req *pb.UpdateMetricsRequest, publicKey *rsa.PublicKey) error {
+	data, err := proto.Marshal(&pb.MetricList{Metrics: req.GetMetrics()})
+	if err != nil {
+		return err
+	}
+	encrypted, err := utils.EncryptEnvelope(data, publicKey)
+	if err != nil {
+		return err
+	}
+	req.Metrics = nil
+	req.Encrypted = encrypted
+	return nil
+}
+
+// DecryptRequest расшифровывает поле encrypted приватным ключом и переносит
+// метрики в поле metrics. Принимаются как конверты, так и данные,
+// зашифрованные напрямую RSA-OAEP. Сообщения без поля encrypted не изменяются.
+func DecryptRequest(req *pb.UpdateMetricsRequest, privateKey *rsa.PrivateKey) error {
+	if len(req.GetEncrypted()) == 0 {
+		return nil
+	}
+	data, err := utils.DecryptPayload(req.GetEncrypted(), privateKey)
+	if err != nil {
+		return err
+	}
+	var list pb.MetricList
+	if err := proto.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	req.Metrics = list.GetMetrics()
+	req.Encrypted = nil
+	return nil
+}
+
+// TrustedSubnetUnaryInterceptor отклоняет вызовы UpdateMetrics с кодом
+// PermissionDenied, если IP-адрес из метаданных x-real-ip не входит в подсеть
+// subnet или метаданные отсутствуют. Если subnet равен nil, вызовы
+// пропускаются без проверки.
+func TrustedSubnetUnaryInterceptor(subnet *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, info.FullMethod, subnet); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetStreamInterceptor отклоняет потоки StreamMetrics так же,
+// как TrustedSubnetUnaryInterceptor.
+func TrustedSubnetStreamInterceptor(subnet *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), info.FullMethod, subnet); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+// HashUnaryInterceptor проверяет подпись сообщений UpdateMetricsRequest.
+// Если key пуст, подпись не проверяется.
+func HashUnaryInterceptor(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := verify(req, key); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// HashStreamInterceptor проверяет подпись каждого сообщения UpdateMetricsRequest в потоке.
+func HashStreamInterceptor(key string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &recvStream{ServerStream: ss, recv: func(m any) error {
+			return verify(m, key)
+		}})
+	}
+}
+
+// DecryptUnaryInterceptor расшифровывает сообщения UpdateMetricsRequest.
+func DecryptUnaryInterceptor(privateKey *rsa.PrivateKey) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := decrypt(req, privateKey); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// DecryptStreamInterceptor расшифровывает каждое сообщение UpdateMetricsRequest в потоке.
+func DecryptStreamInterceptor(privateKey *rsa.PrivateKey) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &recvStream{ServerStream: ss, recv: func(m any) error {
+			return decrypt(m, privateKey)
+		}})
+	}
+}
+
+// SignUnaryClientInterceptor подписывает исходящие сообщения UpdateMetricsRequest.
+func SignUnaryClientInterceptor(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if r, ok := req.(*pb.UpdateMetricsRequest); ok {
+			if err := SignRequest(r, key); err != nil {
+				return err
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// SignStreamClientInterceptor подписывает каждое исходящее сообщение UpdateMetricsRequest в потоке.
+func SignStreamClientInterceptor(key string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &sendStream{ClientStream: cs, send: func(m any) error {
+			if r, ok := m.(*pb.UpdateMetricsRequest); ok {
+				return SignRequest(r, key)
+			}
+			return nil
+		}}, nil
+	}
+}
+
+// EncryptUnaryClientInterceptor шифрует метрики исходящих сообщений UpdateMetricsRequest.
+func EncryptUnaryClientInterceptor(publicKey *rsa.PublicKey) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if r, ok := req.(*pb.UpdateMetricsRequest); ok {
+			if err := EncryptRequest(r, publicKey); err != nil {
+				return err
+			}
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// EncryptStreamClientInterceptor шифрует метрики каждого исходящего сообщения UpdateMetricsRequest в потоке.
+func EncryptStreamClientInterceptor(publicKey *rsa.PublicKey) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &sendStream{ClientStream: cs, send: func(m any) error {
+			if r, ok := m.(*pb.UpdateMetricsRequest); ok {
+				return EncryptRequest(r, publicKey)
+			}
+			return nil
+		}}, nil
+	}
+}
+
+func checkSubnet(ctx context.Context, method string, subnet *net.IPNet) error {
+	if subnet == nil || !writeMethods[method] {
+		return nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	var ip net.IP
+	if v := md.Get(RealIPKey); len(v) > 0 {
+		ip = net.ParseIP(strings.TrimSpace(v[0]))
+	}
+	if ip == nil || !subnet.Contains(ip) {
+		return status.Error(codes.PermissionDenied, "ip is not in trusted subnet")
+	}
+	return nil
+}
+
+func verify(m any, key string) error {
+	r, ok := m.(*pb.UpdateMetricsRequest)
+	if !ok || key == "" {
+		return nil
+	}
+	if err := VerifyRequest(r, key); err != nil {
+		return status.Error(codes.InvalidArgument, "hash mismatch")
+	}
+	return nil
+}
+
+func decrypt(m any, privateKey *rsa.PrivateKey) error {
+	r, ok := m.(*pb.UpdateMetricsRequest)
+	if !ok {
+		return nil
+	}
+	if err := DecryptRequest(r, privateKey); err != nil {
+		return status.Error(codes.InvalidArgument, "failed to decrypt metrics")
+	}
+	return nil
+}
+
+// hashPayload возвращает детерминированную сериализацию сообщения с пустым полем hash.
+func hashPayload(req *pb.UpdateMetricsRequest) ([]byte, error) {
+	unsigned := &pb.UpdateMetricsRequest{
+		Metrics:   req.GetMetrics(),
+		Encrypted: req.GetEncrypted(),
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(unsigned)
+}
+
+// recvStream вызывает recv для каждого принятого сообщения.
+type recvStream struct {
+	grpc.ServerStream
+	recv func(m any) error
+}
+
+func (s *recvStream) RecvMsg(m any) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return s.recv(m)
+}
+
+// sendStream вызывает send для каждого сообщения перед отправкой.
+type sendStream struct {
+	grpc.ClientStream
+	send func(m any) error
+}
+
+func (s *sendStream) SendMsg(m any) error {
+	if err := s.send(m); err != nil {
+		return err
+	}
+	return s.ClientStream.SendMsg(m)
+}
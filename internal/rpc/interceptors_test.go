@@ -0,0 +1,150 @@
+package rpc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/am0xff/metrics/internal/pb"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+)
+
+func TestSignVerifyRequest(t *testing.T) {
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1, Labels: map[string]string{"b": "2", "a": "1"}},
+	}}
+
+	require.NoError(t, SignRequest(req, "secret"))
+	assert.NotEmpty(t, req.GetHash())
+	assert.NoError(t, VerifyRequest(req, "secret"))
+	assert.Error(t, VerifyRequest(req, "other"))
+
+	req.Metrics[0].Value = 2
+	assert.Error(t, VerifyRequest(req, "secret"))
+
+	// Сообщения без подписи не проверяются
+	assert.NoError(t, VerifyRequest(&pb.UpdateMetricsRequest{}, "secret"))
+}
+
+func TestEncryptDecryptRequest(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1}}}
+	require.NoError(t, EncryptRequest(req, &privateKey.PublicKey))
+	assert.Empty(t, req.GetMetrics())
+	assert.NotEmpty(t, req.GetEncrypted())
+
+	require.NoError(t, DecryptRequest(req, privateKey))
+	assert.Empty(t, req.GetEncrypted())
+	require.Len(t, req.GetMetrics(), 1)
+	assert.Equal(t, "Alloc", req.GetMetrics()[0].GetId())
+}
+
+func TestInterceptors(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ms := memstorage.NewStorage()
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(HashUnaryInterceptor("secret"), DecryptUnaryInterceptor(privateKey)),
+		grpc.ChainStreamInterceptor(HashStreamInterceptor("secret"), DecryptStreamInterceptor(privateKey)),
+	)
+	pb.RegisterMetricsServer(srv, NewServer(ms))
+
+	client := startServer(t, srv,
+		grpc.WithChainUnaryInterceptor(EncryptUnaryClientInterceptor(&privateKey.PublicKey), SignUnaryClientInterceptor("secret")),
+		grpc.WithChainStreamInterceptor(EncryptStreamClientInterceptor(&privateKey.PublicKey), SignStreamClientInterceptor("secret")),
+	)
+	ctx := context.Background()
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1},
+	}})
+	require.NoError(t, err)
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: 2},
+	}}))
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resp.GetReceived())
+
+	v, err := ms.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, float64(v))
+	c, err := ms.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), int64(c))
+}
+
+func TestHashUnaryInterceptor_Mismatch(t *testing.T) {
+	srv := grpc.NewServer(grpc.UnaryInterceptor(HashUnaryInterceptor("secret")))
+	pb.RegisterMetricsServer(srv, NewServer(memstorage.NewStorage()))
+
+	client := startServer(t, srv, grpc.WithUnaryInterceptor(SignUnaryClientInterceptor("wrong")))
+
+	_, err := client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestTrustedSubnetInterceptors(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+
+	ms := memstorage.NewStorage()
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(TrustedSubnetUnaryInterceptor(subnet)),
+		grpc.StreamInterceptor(TrustedSubnetStreamInterceptor(subnet)),
+	)
+	pb.RegisterMetricsServer(srv, NewServer(ms))
+	client := startServer(t, srv)
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1}}}
+	update := func(ctx context.Context) error {
+		_, err := client.UpdateMetrics(ctx, req)
+		return err
+	}
+	streamUpdate := func(ctx context.Context) error {
+		stream, err := client.StreamMetrics(ctx)
+		if err != nil {
+			return err
+		}
+		// Ошибку отклоненного потока возвращает CloseAndRecv
+		if err := stream.Send(req); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	// Без адреса и с адресом вне подсети изменения отклоняются
+	outside := metadata.AppendToOutgoingContext(context.Background(), RealIPKey, "192.168.1.1")
+	for _, ctx := range []context.Context{context.Background(), outside} {
+		assert.Equal(t, codes.PermissionDenied, status.Code(update(ctx)))
+		assert.Equal(t, codes.PermissionDenied, status.Code(streamUpdate(ctx)))
+	}
+
+	inside := metadata.AppendToOutgoingContext(context.Background(), RealIPKey, "10.1.2.3")
+	require.NoError(t, update(inside))
+	require.NoError(t, streamUpdate(inside))
+
+	// Чтение метрик не ограничивается
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "Alloc", Type: pb.MetricType_GAUGE})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,237 @@
+// Package rpc реализует gRPC сервис метрик, описанный в pb.MetricsServer.
+// Сервис работает с тем же storage.StorageProvider, что и HTTP обработчики,
+// а проверка доверенной подсети, подписи и расшифровка сообщений выполняются
+// перехватчиками, аналогичными HTTP middleware.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sort"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/pb"
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/am0xff/metrics/internal/utils"
+)
+
+// Server реализует gRPC сервис метрик поверх провайдера хранилища.
+type Server struct {
+	pb.UnimplementedMetricsServer
+	sp storage.StorageProvider
+}
+
+// NewServer создает сервис метрик для указанного провайдера хранилища.
+func NewServer(sp storage.StorageProvider) *Server {
+	return &Server{sp: sp}
+}
+
+// NewGRPCServer создает gRPC сервер с зарегистрированным сервисом метрик.
+// Если trustedSubnet не nil, вызовы UpdateMetrics и StreamMetrics принимаются
+// только от агентов из этой подсети (метаданные x-real-ip). Если key не пуст,
+// подписанные сообщения UpdateMetricsRequest проверяются по HMAC-SHA256.
+// Если указан cryptoKeyPath, зашифрованные сообщения расшифровываются
+// приватным ключом из этого файла.
+//
+// Пример использования:
+//
+//	srv, _ := rpc.NewGRPCServer(storage, cfg.Key, cfg.CryptoKey, trustedSubnet)
+//	lis, _ := net.Listen("tcp", ":3200")
+//	go srv.Serve(lis)
+func NewGRPCServer(sp storage.StorageProvider, key, cryptoKeyPath string, trustedSubnet *net.IPNet) (*grpc.Server, error) {
+	unary := []grpc.UnaryServerInterceptor{TrustedSubnetUnaryInterceptor(trustedSubnet), HashUnaryInterceptor(key)}
+	stream := []grpc.StreamServerInterceptor{TrustedSubnetStreamInterceptor(trustedSubnet), HashStreamInterceptor(key)}
+
+	if cryptoKeyPath != "" {
+		privateKey, err := utils.LoadPrivateKey(cryptoKeyPath)
+		if err != nil {
+			return nil, err
+		}
+		unary = append(unary, DecryptUnaryInterceptor(privateKey))
+		stream = append(stream, DecryptStreamInterceptor(privateKey))
+	}
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	pb.RegisterMetricsServer(srv, NewServer(sp))
+	return srv, nil
+}
+
+// UpdateMetrics обновляет пакет метрик. Пакет проверяется целиком
+// до записи в хранилище: при ошибке в одной из метрик ни одна не записывается.
+//
+// Коды ошибок:
+//   - InvalidArgument: пустой пакет, неизвестный тип, недопустимое имя или метки
+//   - Unavailable: хранилище недоступно, пакет не сохранен
+func (s *Server) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if len(req.GetMetrics()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty batch")
+	}
+	if err := s.update(ctx, req.GetMetrics()); err != nil {
+		return nil, err
+	}
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+// StreamMetrics принимает поток пакетов метрик. Каждый пакет записывается
+// в хранилище по мере получения. После завершения потока клиентом
+// возвращается количество принятых метрик.
+func (s *Server) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	var received int64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.StreamMetricsResponse{Received: received})
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.update(stream.Context(), req.GetMetrics()); err != nil {
+			return err
+		}
+		received += int64(len(req.GetMetrics()))
+	}
+}
+
+// GetMetric возвращает значение метрики.
+//
+// Коды ошибок:
+//   - InvalidArgument: неизвестный тип, недопустимое имя или метки
+//   - NotFound: метрика не найдена
+//   - Unavailable: хранилище недоступно
+func (s *Server) GetMetric(ctx context.Context, req *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	mtype, err := MetricTypeFromProto(req.GetType())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.ValidateSeries(req.GetId(), req.GetLabels()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	key := storage.SeriesKey(req.GetId(), req.GetLabels())
+
+	metric := &pb.Metric{
+		Id:     req.GetId(),
+		Type:   req.GetType(),
+		Labels: req.GetLabels(),
+	}
+	switch mtype {
+	case storage.MetricTypeGauge:
+		v, err := s.sp.GetGauge(ctx, key)
+		if err != nil {
+			return nil, storageError(err)
+		}
+		metric.Value = float64(v)
+	case storage.MetricTypeCounter:
+		v, err := s.sp.GetCounter(ctx, key)
+		if err != nil {
+			return nil, storageError(err)
+		}
+		metric.Delta = int64(v)
+	}
+
+	return &pb.GetMetricResponse{Metric: metric}, nil
+}
+
+// ListMetrics возвращает значения всех метрик, отсортированные по типу
+// и ключу серии. Если в запросе указаны метки, возвращаются только серии,
+// содержащие все эти метки.
+func (s *Server) ListMetrics(ctx context.Context, req *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	resp := &pb.ListMetricsResponse{}
+
+	gauges, err := s.sp.KeysGauge(ctx)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	sort.Strings(gauges)
+	for _, key := range gauges {
+		v, err := s.sp.GetGauge(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+		if m := seriesMetric(key, pb.MetricType_GAUGE, req.GetLabels()); m != nil {
+			m.Value = float64(v)
+			resp.Metrics = append(resp.Metrics, m)
+		}
+	}
+
+	counters, err := s.sp.KeysCounter(ctx)
+	if err != nil {
+		return nil, storageError(err)
+	}
+	sort.Strings(counters)
+	for _, key := range counters {
+		v, err := s.sp.GetCounter(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, storageError(err)
+		}
+		if m := seriesMetric(key, pb.MetricType_COUNTER, req.GetLabels()); m != nil {
+			m.Delta = int64(v)
+			resp.Metrics = append(resp.Metrics, m)
+		}
+	}
+
+	return resp, nil
+}
+
+// update проверяет все метрики пакета и записывает их в хранилище
+// одной операцией UpdateBatch.
+func (s *Server) update(ctx context.Context, metrics []*pb.Metric) error {
+	batch := make([]models.Metrics, len(metrics))
+	for i, m := range metrics {
+		metric, err := MetricFromProto(m)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := storage.ValidateSeries(m.GetId(), m.GetLabels()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		batch[i] = metric
+	}
+
+	if err := s.sp.UpdateBatch(ctx, batch); err != nil {
+		return storageError(err)
+	}
+	return nil
+}
+
+// storageError преобразует ошибку хранилища в статус gRPC.
+func storageError(err error) error {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return status.Error(codes.NotFound, "metric not found")
+	case errors.Is(err, storage.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, storage.ErrUnavailable):
+		return status.Error(codes.Unavailable, "storage unavailable")
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// seriesMetric разбирает ключ серии и возвращает метрику без значения
+// или nil, если серия не соответствует matchers.
+func seriesMetric(key string, mtype pb.MetricType, matchers map[string]string) *pb.Metric {
+	name, labels, err := storage.ParseSeriesKey(key)
+	if err != nil {
+		name, labels = key, nil
+	}
+	if !storage.MatchLabels(labels, matchers) {
+		return nil
+	}
+	return &pb.Metric{Id: name, Type: mtype, Labels: labels}
+}
@@ -0,0 +1,147 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/am0xff/metrics/internal/pb"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+)
+
+// startServer запускает gRPC сервер в памяти и возвращает клиента к нему.
+func startServer(t *testing.T, srv *grpc.Server, opts ...grpc.DialOption) pb.MetricsClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn)
+}
+
+func TestServer_UpdateAndGet(t *testing.T) {
+	ms := memstorage.NewStorage()
+	srv, err := NewGRPCServer(ms, "", "", nil)
+	require.NoError(t, err)
+	client := startServer(t, srv)
+	ctx := context.Background()
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1.5},
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 2.5, Labels: map[string]string{"host": "web-1"}},
+		{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: 3},
+		{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: 4},
+	}})
+	require.NoError(t, err)
+
+	v, err := ms.GetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"host": "web-1"}))
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(2.5), v)
+
+	resp, err := client.GetMetric(ctx, &pb.GetMetricRequest{Id: "PollCount", Type: pb.MetricType_COUNTER})
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), resp.GetMetric().GetDelta())
+
+	resp, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc", Type: pb.MetricType_GAUGE, Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2.5, resp.GetMetric().GetValue())
+	assert.Equal(t, map[string]string{"host": "web-1"}, resp.GetMetric().GetLabels())
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "unknown", Type: pb.MetricType_GAUGE})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetMetric(ctx, &pb.GetMetricRequest{Id: "Alloc"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_UpdateMetricsInvalid(t *testing.T) {
+	ms := memstorage.NewStorage()
+	client := startServer(t, mustServer(t, ms))
+	ctx := context.Background()
+
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Пакет с недопустимой метрикой не записывается целиком
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1},
+		{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: 1, Labels: map[string]string{"host-name": "web"}},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, ms.Gauges.Keys())
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{{Id: "Alloc"}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_StreamMetrics(t *testing.T) {
+	ms := memstorage.NewStorage()
+	client := startServer(t, mustServer(t, ms))
+	ctx := context.Background()
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			{Id: "PollCount", Type: pb.MetricType_COUNTER, Delta: 1},
+			{Id: "Alloc", Type: pb.MetricType_GAUGE, Value: float64(i)},
+		}}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), resp.GetReceived())
+
+	v, err := ms.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(3), v)
+}
+
+func TestServer_ListMetrics(t *testing.T) {
+	ms := memstorage.NewStorage()
+	ctx := context.Background()
+	ms.SetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"host": "web-2"}), 2)
+	ms.SetGauge(ctx, storage.SeriesKey("Alloc", map[string]string{"host": "web-1"}), 1)
+	ms.SetCounter(ctx, "PollCount", 5)
+
+	client := startServer(t, mustServer(t, ms))
+
+	resp, err := client.ListMetrics(ctx, &pb.ListMetricsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetMetrics(), 3)
+	assert.Equal(t, "web-1", resp.GetMetrics()[0].GetLabels()["host"])
+	assert.Equal(t, "web-2", resp.GetMetrics()[1].GetLabels()["host"])
+	assert.Equal(t, pb.MetricType_COUNTER, resp.GetMetrics()[2].GetType())
+	assert.Equal(t, int64(5), resp.GetMetrics()[2].GetDelta())
+
+	resp, err = client.ListMetrics(ctx, &pb.ListMetricsRequest{Labels: map[string]string{"host": "web-2"}})
+	require.NoError(t, err)
+	require.Len(t, resp.GetMetrics(), 1)
+	assert.Equal(t, 2.0, resp.GetMetrics()[0].GetValue())
+}
+
+func mustServer(t *testing.T, sp storage.StorageProvider) *grpc.Server {
+	t.Helper()
+	srv, err := NewGRPCServer(sp, "", "", nil)
+	require.NoError(t, err)
+	return srv
+}
@@ -3,25 +3,70 @@ package server
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
+	"net"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/am0xff/metrics/internal/graphite"
+	"github.com/am0xff/metrics/internal/influx"
+	"github.com/am0xff/metrics/internal/janitor"
+	"github.com/am0xff/metrics/internal/storage"
+	fstorage "github.com/am0xff/metrics/internal/storage/file"
 	"github.com/caarlos0/env/v6"
 )
 
 type Config struct {
-	ServerAddr      string `env:"ADDRESS" envDefault:":8080"`
-	StoreInterval   int    `env:"STORE_INTERVAL" envDefault:"300"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" envDefault:"storage_file"`
-	Restore         bool   `env:"RESTORE" envDefault:"false"`
-	DatabaseDSN     string `env:"DATABASE_DSN"`
-	Key             string `env:"KEY" envDefault:""`
-	PprofEnabled    bool   `env:"PPROF_ENABLED" envDefault:"true"`
-	PprofAddr       string `env:"PPROF_PORT" envDefault:":6060"`
-	CryptoKey       string `env:"CRYPTO_KEY" envDefault:""`
-	ConfigFile      string `env:"CONFIG" envDefault:""`
+	ServerAddr           string `env:"ADDRESS" envDefault:":8080"`
+	StoreInterval        int    `env:"STORE_INTERVAL" envDefault:"300"`
+	FileStoragePath      string `env:"FILE_STORAGE_PATH" envDefault:"storage_file"`
+	Restore              bool   `env:"RESTORE" envDefault:"false"`
+	DatabaseDSN          string `env:"DATABASE_DSN"`
+	Key                  string `env:"KEY" envDefault:""`
+	PprofEnabled         bool   `env:"PPROF_ENABLED" envDefault:"true"`
+	PprofAddr            string `env:"PPROF_PORT" envDefault:":6060"`
+	CryptoKey            string `env:"CRYPTO_KEY" envDefault:""`
+	ConfigFile           string `env:"CONFIG" envDefault:""`
+	AlertRulesFile       string `env:"ALERT_RULES" envDefault:""`
+	AlertWebhooks        string `env:"ALERT_WEBHOOKS" envDefault:""`
+	AlertInterval        int    `env:"ALERT_INTERVAL" envDefault:"15"`
+	GRPCAddr             string `env:"GRPC_ADDRESS" envDefault:""`
+	TrustedSubnet        string `env:"TRUSTED_SUBNET" envDefault:""`
+	WALSync              string `env:"WAL_SYNC" envDefault:"interval"`
+	WALSyncInterval      int    `env:"WAL_SYNC_INTERVAL" envDefault:"1"`
+	Migrate              string `env:"MIGRATE" envDefault:""`
+	DBFlushInterval      int    `env:"DB_FLUSH_INTERVAL" envDefault:"0"`
+	DBFlushSize          int    `env:"DB_FLUSH_SIZE" envDefault:"1000"`
+	SeriesTTL            int    `env:"SERIES_TTL" envDefault:"0"`
+	SeriesTTLRules       string `env:"SERIES_TTL_OVERRIDES" envDefault:""`
+	StaleAction          string `env:"STALE_ACTION" envDefault:"hide"`
+	JanitorInterval      int    `env:"JANITOR_INTERVAL" envDefault:"60"`
+	HistogramBuckets     string `env:"HISTOGRAM_BUCKETS" envDefault:""`
+	HistogramBucketRules string `env:"HISTOGRAM_BUCKET_OVERRIDES" envDefault:""`
+	StatsDAddr           string `env:"STATSD_ADDRESS" envDefault:""`
+	StatsDFlushInterval  int    `env:"STATSD_FLUSH_INTERVAL" envDefault:"10"`
+	InfluxTypeMapping    string `env:"INFLUX_TYPE_MAPPING" envDefault:""`
+	GraphiteAddr         string `env:"GRAPHITE_ADDRESS" envDefault:""`
+	GraphiteTemplates    string `env:"GRAPHITE_TEMPLATES" envDefault:""`
+	GraphiteIdleTimeout  int    `env:"GRAPHITE_IDLE_TIMEOUT" envDefault:"60"`
+	PromWriteCounters    bool   `env:"PROM_WRITE_COUNTERS" envDefault:"false"`
+	OTLPPrefixAttributes string `env:"OTLP_PREFIX_ATTRIBUTES" envDefault:""`
 }
 
+// LoadConfig загружает конфигурацию сервера. Источники в порядке убывания
+// приоритета:
+//
+//  1. флаги командной строки;
+//  2. переменные окружения;
+//  3. файл конфигурации в формате JSON (-c или CONFIG);
+//  4. значения по умолчанию.
+//
+// Значение из файла конфигурации применяется к параметру, только если
+// параметр не задан ни флагом, ни переменной окружения (даже пустой),
+// поэтому значение по умолчанию не перекрывает значение из файла.
 func LoadConfig() (Config, error) {
 	var cfg Config
 
@@ -40,6 +85,30 @@ func LoadConfig() (Config, error) {
 	pprofAddr := flag.String("pp", cfg.PprofAddr, "pprof address")
 	fCryptoKey := flag.String("crypto-key", cfg.CryptoKey, "Путь к файлу с приватным ключом для расшифровки")
 	fConfigFile := flag.String("c", cfg.ConfigFile, "Путь к файлу конфигурации")
+	fAlertRules := flag.String("alert-rules", cfg.AlertRulesFile, "Путь к файлу с правилами оповещений")
+	fAlertWebhooks := flag.String("alert-webhooks", cfg.AlertWebhooks, "URL вебхуков для оповещений через запятую")
+	fAlertInterval := flag.Int("alert-interval", cfg.AlertInterval, "Интервал вычисления правил оповещений (сек)")
+	fGRPCAddr := flag.String("grpc-address", cfg.GRPCAddr, "Адрес gRPC сервера (пусто - gRPC сервер не запускается)")
+	fTrustedSubnet := flag.String("t", cfg.TrustedSubnet, "Доверенная подсеть агентов в формате CIDR (пусто - без ограничений)")
+	fWALSync := flag.String("wal-sync", cfg.WALSync, "Режим fsync журнала файлового хранилища: always, interval или never")
+	fWALSyncInterval := flag.Int("wal-sync-interval", cfg.WALSyncInterval, "Интервал fsync журнала в режиме interval (сек)")
+	fMigrate := flag.String("migrate", cfg.Migrate, "Только применить (up) или откатить на одну версию (down) миграции базы данных и завершить работу")
+	fDBFlushInterval := flag.Int("db-flush-interval", cfg.DBFlushInterval, "Интервал отложенной записи в базу данных (сек, 0 - запись сразу)")
+	fDBFlushSize := flag.Int("db-flush-size", cfg.DBFlushSize, "Число серий в буфере отложенной записи, при котором он записывается досрочно")
+	fSeriesTTL := flag.Int("series-ttl", cfg.SeriesTTL, "Время без обновлений, после которого серия устаревает (сек, 0 - не устаревает)")
+	fSeriesTTLRules := flag.String("series-ttl-overrides", cfg.SeriesTTLRules, "Время жизни серий по префиксу имени в формате префикс=длительность через запятую")
+	fStaleAction := flag.String("stale-action", cfg.StaleAction, "Действие с устаревшими сериями: hide или evict")
+	fJanitorInterval := flag.Int("janitor-interval", cfg.JanitorInterval, "Интервал поиска устаревших серий (сек)")
+	fHistogramBuckets := flag.String("histogram-buckets", cfg.HistogramBuckets, "Границы корзин histogram метрик через запятую (по умолчанию - как в Prometheus)")
+	fHistogramBucketRules := flag.String("histogram-bucket-overrides", cfg.HistogramBucketRules, "Границы корзин по префиксу имени в формате префикс=b1,b2 через точку с запятой")
+	fStatsDAddr := flag.String("statsd-address", cfg.StatsDAddr, "Адрес приема метрик StatsD по UDP и TCP (пусто - не принимать)")
+	fStatsDFlushInterval := flag.Int("statsd-flush-interval", cfg.StatsDFlushInterval, "Интервал агрегации метрик StatsD перед записью в хранилище (сек)")
+	fInfluxTypeMapping := flag.String("influx-type-mapping", cfg.InfluxTypeMapping, "Типы метрик для полей InfluxDB line protocol по префиксу имени в формате префикс=тип через запятую")
+	fGraphiteAddr := flag.String("graphite-address", cfg.GraphiteAddr, "Адрес приема метрик Graphite по TCP, например :2003 (пусто - не принимать)")
+	fGraphiteTemplates := flag.String("graphite-templates", cfg.GraphiteTemplates, "Правила разбора путей Graphite в формате \"фильтр шаблон [тип]\" через точку с запятой")
+	fGraphiteIdleTimeout := flag.Int("graphite-idle-timeout", cfg.GraphiteIdleTimeout, "Время ожидания данных в соединении Graphite до его закрытия (сек)")
+	fPromWriteCounters := flag.Bool("prom-write-counters", cfg.PromWriteCounters, "Записывать серии Prometheus remote_write с именем на _total в counter метрики")
+	fOTLPPrefixAttributes := flag.String("otlp-prefix-attributes", cfg.OTLPPrefixAttributes, "Атрибуты ресурса OTLP через запятую, значения которых становятся префиксами имен метрик (например, service.name)")
 	flag.Parse()
 
 	cfg.ServerAddr = *serverAddr
@@ -52,27 +121,180 @@ func LoadConfig() (Config, error) {
 	cfg.PprofAddr = *pprofAddr
 	cfg.CryptoKey = *fCryptoKey
 	cfg.ConfigFile = *fConfigFile
+	cfg.AlertRulesFile = *fAlertRules
+	cfg.AlertWebhooks = *fAlertWebhooks
+	cfg.AlertInterval = *fAlertInterval
+	cfg.GRPCAddr = *fGRPCAddr
+	cfg.TrustedSubnet = *fTrustedSubnet
+	cfg.WALSync = *fWALSync
+	cfg.WALSyncInterval = *fWALSyncInterval
+	cfg.Migrate = *fMigrate
+	cfg.DBFlushInterval = *fDBFlushInterval
+	cfg.DBFlushSize = *fDBFlushSize
+	cfg.SeriesTTL = *fSeriesTTL
+	cfg.SeriesTTLRules = *fSeriesTTLRules
+	cfg.StaleAction = *fStaleAction
+	cfg.JanitorInterval = *fJanitorInterval
+	cfg.HistogramBuckets = *fHistogramBuckets
+	cfg.HistogramBucketRules = *fHistogramBucketRules
+	cfg.StatsDAddr = *fStatsDAddr
+	cfg.StatsDFlushInterval = *fStatsDFlushInterval
+	cfg.InfluxTypeMapping = *fInfluxTypeMapping
+	cfg.GraphiteAddr = *fGraphiteAddr
+	cfg.GraphiteTemplates = *fGraphiteTemplates
+	cfg.GraphiteIdleTimeout = *fGraphiteIdleTimeout
+	cfg.PromWriteCounters = *fPromWriteCounters
+	cfg.OTLPPrefixAttributes = *fOTLPPrefixAttributes
 
-	if *fConfigFile != "" && *fConfigFile != cfg.ConfigFile {
+	// Значения из файла конфигурации применяются только к параметрам,
+	// которые не заданы переменными окружения или флагами.
+	if cfg.ConfigFile != "" {
 		tempCfg := cfg
-		if err := loadFromJSON(*fConfigFile, &tempCfg); err != nil {
+		if err := loadFromJSON(cfg.ConfigFile, &tempCfg); err != nil {
 			return cfg, err
 		}
 
-		tempCfg.ServerAddr = *serverAddr
-		tempCfg.StoreInterval = *storeInterval
-		tempCfg.FileStoragePath = *fileStoragePath
-		tempCfg.Restore = *restore
-		tempCfg.DatabaseDSN = *databaseDSN
-		tempCfg.Key = *fKey
-		tempCfg.PprofEnabled = *pprofEnabled
-		tempCfg.PprofAddr = *pprofAddr
-		tempCfg.CryptoKey = *fCryptoKey
-		tempCfg.ConfigFile = *fConfigFile
+		isSet := explicitlySet()
+		if isSet("a", "ADDRESS") {
+			tempCfg.ServerAddr = cfg.ServerAddr
+		}
+		if isSet("i", "STORE_INTERVAL") {
+			tempCfg.StoreInterval = cfg.StoreInterval
+		}
+		if isSet("f", "FILE_STORAGE_PATH") {
+			tempCfg.FileStoragePath = cfg.FileStoragePath
+		}
+		if isSet("r", "RESTORE") {
+			tempCfg.Restore = cfg.Restore
+		}
+		if isSet("d", "DATABASE_DSN") {
+			tempCfg.DatabaseDSN = cfg.DatabaseDSN
+		}
+		if isSet("k", "KEY") {
+			tempCfg.Key = cfg.Key
+		}
+		if isSet("pe", "PPROF_ENABLED") {
+			tempCfg.PprofEnabled = cfg.PprofEnabled
+		}
+		if isSet("pp", "PPROF_PORT") {
+			tempCfg.PprofAddr = cfg.PprofAddr
+		}
+		if isSet("crypto-key", "CRYPTO_KEY") {
+			tempCfg.CryptoKey = cfg.CryptoKey
+		}
+		if isSet("alert-rules", "ALERT_RULES") {
+			tempCfg.AlertRulesFile = cfg.AlertRulesFile
+		}
+		if isSet("alert-webhooks", "ALERT_WEBHOOKS") {
+			tempCfg.AlertWebhooks = cfg.AlertWebhooks
+		}
+		if isSet("alert-interval", "ALERT_INTERVAL") {
+			tempCfg.AlertInterval = cfg.AlertInterval
+		}
+		if isSet("grpc-address", "GRPC_ADDRESS") {
+			tempCfg.GRPCAddr = cfg.GRPCAddr
+		}
+		if isSet("t", "TRUSTED_SUBNET") {
+			tempCfg.TrustedSubnet = cfg.TrustedSubnet
+		}
+		if isSet("wal-sync", "WAL_SYNC") {
+			tempCfg.WALSync = cfg.WALSync
+		}
+		if isSet("wal-sync-interval", "WAL_SYNC_INTERVAL") {
+			tempCfg.WALSyncInterval = cfg.WALSyncInterval
+		}
+		if isSet("db-flush-interval", "DB_FLUSH_INTERVAL") {
+			tempCfg.DBFlushInterval = cfg.DBFlushInterval
+		}
+		if isSet("db-flush-size", "DB_FLUSH_SIZE") {
+			tempCfg.DBFlushSize = cfg.DBFlushSize
+		}
+		if isSet("series-ttl", "SERIES_TTL") {
+			tempCfg.SeriesTTL = cfg.SeriesTTL
+		}
+		if isSet("series-ttl-overrides", "SERIES_TTL_OVERRIDES") {
+			tempCfg.SeriesTTLRules = cfg.SeriesTTLRules
+		}
+		if isSet("stale-action", "STALE_ACTION") {
+			tempCfg.StaleAction = cfg.StaleAction
+		}
+		if isSet("janitor-interval", "JANITOR_INTERVAL") {
+			tempCfg.JanitorInterval = cfg.JanitorInterval
+		}
+		if isSet("histogram-buckets", "HISTOGRAM_BUCKETS") {
+			tempCfg.HistogramBuckets = cfg.HistogramBuckets
+		}
+		if isSet("histogram-bucket-overrides", "HISTOGRAM_BUCKET_OVERRIDES") {
+			tempCfg.HistogramBucketRules = cfg.HistogramBucketRules
+		}
+		if isSet("statsd-address", "STATSD_ADDRESS") {
+			tempCfg.StatsDAddr = cfg.StatsDAddr
+		}
+		if isSet("statsd-flush-interval", "STATSD_FLUSH_INTERVAL") {
+			tempCfg.StatsDFlushInterval = cfg.StatsDFlushInterval
+		}
+		if isSet("influx-type-mapping", "INFLUX_TYPE_MAPPING") {
+			tempCfg.InfluxTypeMapping = cfg.InfluxTypeMapping
+		}
+		if isSet("graphite-address", "GRAPHITE_ADDRESS") {
+			tempCfg.GraphiteAddr = cfg.GraphiteAddr
+		}
+		if isSet("graphite-templates", "GRAPHITE_TEMPLATES") {
+			tempCfg.GraphiteTemplates = cfg.GraphiteTemplates
+		}
+		if isSet("graphite-idle-timeout", "GRAPHITE_IDLE_TIMEOUT") {
+			tempCfg.GraphiteIdleTimeout = cfg.GraphiteIdleTimeout
+		}
+		if isSet("prom-write-counters", "PROM_WRITE_COUNTERS") {
+			tempCfg.PromWriteCounters = cfg.PromWriteCounters
+		}
+		if isSet("otlp-prefix-attributes", "OTLP_PREFIX_ATTRIBUTES") {
+			tempCfg.OTLPPrefixAttributes = cfg.OTLPPrefixAttributes
+		}
 
 		cfg = tempCfg
 	}
 
+	if cfg.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(cfg.TrustedSubnet); err != nil {
+			return cfg, fmt.Errorf("invalid trusted subnet: %w", err)
+		}
+	}
+
+	if _, err := fstorage.ParseSyncMode(cfg.WALSync); err != nil {
+		return cfg, err
+	}
+
+	if _, err := storage.ParseTTLOverrides(cfg.SeriesTTLRules); err != nil {
+		return cfg, err
+	}
+
+	if _, err := janitor.ParseAction(cfg.StaleAction); err != nil {
+		return cfg, err
+	}
+
+	if _, err := storage.ParseBuckets(cfg.HistogramBuckets); err != nil {
+		return cfg, err
+	}
+
+	if _, err := storage.ParseBucketOverrides(cfg.HistogramBucketRules); err != nil {
+		return cfg, err
+	}
+
+	if _, err := influx.ParseMapping(cfg.InfluxTypeMapping); err != nil {
+		return cfg, err
+	}
+
+	if _, err := graphite.ParseTemplates(cfg.GraphiteTemplates); err != nil {
+		return cfg, err
+	}
+
+	switch cfg.Migrate {
+	case "", "up", "down":
+	default:
+		return cfg, fmt.Errorf("invalid migrate mode %q: expected up or down", cfg.Migrate)
+	}
+
 	return cfg, nil
 }
 
@@ -87,12 +309,35 @@ func loadFromJSON(configPath string, cfg *Config) error {
 	}
 
 	var jsonConfig struct {
-		Address       string `json:"address"`
-		Restore       *bool  `json:"restore"`
-		StoreInterval string `json:"store_interval"`
-		StoreFile     string `json:"store_file"`
-		DatabaseDSN   string `json:"database_dsn"`
-		CryptoKey     string `json:"crypto_key"`
+		Address        string               `json:"address"`
+		Restore        *bool                `json:"restore"`
+		StoreInterval  string               `json:"store_interval"`
+		StoreFile      string               `json:"store_file"`
+		DatabaseDSN    string               `json:"database_dsn"`
+		CryptoKey      string               `json:"crypto_key"`
+		AlertRules     string               `json:"alert_rules"`
+		AlertWebhooks  []string             `json:"alert_webhooks"`
+		AlertInterval  string               `json:"alert_interval"`
+		GRPCAddress    string               `json:"grpc_address"`
+		TrustedSubnet  string               `json:"trusted_subnet"`
+		WALSync        string               `json:"wal_sync"`
+		WALSyncPeriod  string               `json:"wal_sync_interval"`
+		DBFlushPeriod  string               `json:"db_flush_interval"`
+		DBFlushSize    int                  `json:"db_flush_size"`
+		SeriesTTL      string               `json:"series_ttl"`
+		SeriesTTLRules map[string]string    `json:"series_ttl_overrides"`
+		StaleAction    string               `json:"stale_action"`
+		JanitorPeriod  string               `json:"janitor_interval"`
+		Buckets        []float64            `json:"histogram_buckets"`
+		BucketRules    map[string][]float64 `json:"histogram_bucket_overrides"`
+		StatsDAddress  string               `json:"statsd_address"`
+		StatsDPeriod   string               `json:"statsd_flush_interval"`
+		InfluxTypes    map[string]string    `json:"influx_type_mapping"`
+		GraphiteAddr   string               `json:"graphite_address"`
+		GraphiteRules  []string             `json:"graphite_templates"`
+		GraphiteIdle   string               `json:"graphite_idle_timeout"`
+		PromCounters   *bool                `json:"prom_write_counters"`
+		OTLPPrefixes   []string             `json:"otlp_prefix_attributes"`
 	}
 
 	if err := json.Unmarshal(data, &jsonConfig); err != nil {
@@ -114,6 +359,104 @@ func loadFromJSON(configPath string, cfg *Config) error {
 	if jsonConfig.CryptoKey != "" {
 		cfg.CryptoKey = jsonConfig.CryptoKey
 	}
+	if jsonConfig.TrustedSubnet != "" {
+		cfg.TrustedSubnet = jsonConfig.TrustedSubnet
+	}
+	if jsonConfig.WALSync != "" {
+		cfg.WALSync = jsonConfig.WALSync
+	}
+	if jsonConfig.WALSyncPeriod != "" {
+		if duration, err := time.ParseDuration(jsonConfig.WALSyncPeriod); err == nil {
+			cfg.WALSyncInterval = int(duration.Seconds())
+		}
+	}
+	if jsonConfig.DBFlushPeriod != "" {
+		if duration, err := time.ParseDuration(jsonConfig.DBFlushPeriod); err == nil {
+			cfg.DBFlushInterval = int(duration.Seconds())
+		}
+	}
+	if jsonConfig.DBFlushSize != 0 {
+		cfg.DBFlushSize = jsonConfig.DBFlushSize
+	}
+	if jsonConfig.SeriesTTL != "" {
+		if duration, err := time.ParseDuration(jsonConfig.SeriesTTL); err == nil {
+			cfg.SeriesTTL = int(duration.Seconds())
+		}
+	}
+	if len(jsonConfig.SeriesTTLRules) > 0 {
+		rules := make([]string, 0, len(jsonConfig.SeriesTTLRules))
+		for prefix, ttl := range jsonConfig.SeriesTTLRules {
+			rules = append(rules, prefix+"="+ttl)
+		}
+		sort.Strings(rules)
+		cfg.SeriesTTLRules = strings.Join(rules, ",")
+	}
+	if jsonConfig.StaleAction != "" {
+		cfg.StaleAction = jsonConfig.StaleAction
+	}
+	if jsonConfig.JanitorPeriod != "" {
+		if duration, err := time.ParseDuration(jsonConfig.JanitorPeriod); err == nil {
+			cfg.JanitorInterval = int(duration.Seconds())
+		}
+	}
+	if len(jsonConfig.Buckets) > 0 {
+		cfg.HistogramBuckets = formatBuckets(jsonConfig.Buckets)
+	}
+	if len(jsonConfig.BucketRules) > 0 {
+		rules := make([]string, 0, len(jsonConfig.BucketRules))
+		for prefix, bounds := range jsonConfig.BucketRules {
+			rules = append(rules, prefix+"="+formatBuckets(bounds))
+		}
+		sort.Strings(rules)
+		cfg.HistogramBucketRules = strings.Join(rules, ";")
+	}
+	if jsonConfig.StatsDAddress != "" {
+		cfg.StatsDAddr = jsonConfig.StatsDAddress
+	}
+	if jsonConfig.StatsDPeriod != "" {
+		if duration, err := time.ParseDuration(jsonConfig.StatsDPeriod); err == nil {
+			cfg.StatsDFlushInterval = int(duration.Seconds())
+		}
+	}
+	if len(jsonConfig.InfluxTypes) > 0 {
+		rules := make([]string, 0, len(jsonConfig.InfluxTypes))
+		for prefix, mtype := range jsonConfig.InfluxTypes {
+			rules = append(rules, prefix+"="+mtype)
+		}
+		sort.Strings(rules)
+		cfg.InfluxTypeMapping = strings.Join(rules, ",")
+	}
+	if jsonConfig.GraphiteAddr != "" {
+		cfg.GraphiteAddr = jsonConfig.GraphiteAddr
+	}
+	if len(jsonConfig.GraphiteRules) > 0 {
+		cfg.GraphiteTemplates = strings.Join(jsonConfig.GraphiteRules, ";")
+	}
+	if jsonConfig.GraphiteIdle != "" {
+		if duration, err := time.ParseDuration(jsonConfig.GraphiteIdle); err == nil {
+			cfg.GraphiteIdleTimeout = int(duration.Seconds())
+		}
+	}
+	if jsonConfig.PromCounters != nil {
+		cfg.PromWriteCounters = *jsonConfig.PromCounters
+	}
+	if len(jsonConfig.OTLPPrefixes) > 0 {
+		cfg.OTLPPrefixAttributes = strings.Join(jsonConfig.OTLPPrefixes, ",")
+	}
+	if jsonConfig.GRPCAddress != "" {
+		cfg.GRPCAddr = jsonConfig.GRPCAddress
+	}
+	if jsonConfig.AlertRules != "" {
+		cfg.AlertRulesFile = jsonConfig.AlertRules
+	}
+	if len(jsonConfig.AlertWebhooks) > 0 {
+		cfg.AlertWebhooks = strings.Join(jsonConfig.AlertWebhooks, ",")
+	}
+	if jsonConfig.AlertInterval != "" {
+		if duration, err := time.ParseDuration(jsonConfig.AlertInterval); err == nil {
+			cfg.AlertInterval = int(duration.Seconds())
+		}
+	}
 	if jsonConfig.StoreInterval != "" {
 		if duration, err := time.ParseDuration(jsonConfig.StoreInterval); err == nil {
 			cfg.StoreInterval = int(duration.Seconds())
@@ -122,3 +465,29 @@ func loadFromJSON(configPath string, cfg *Config) error {
 
 	return nil
 }
+
+// explicitlySet возвращает функцию, которая сообщает, задан ли параметр
+// флагом flagName или переменной окружения envName.
+func explicitlySet() func(flagName, envName string) bool {
+	flags := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		flags[f.Name] = true
+	})
+
+	return func(flagName, envName string) bool {
+		if flags[flagName] {
+			return true
+		}
+		_, ok := os.LookupEnv(envName)
+		return ok
+	}
+}
+
+// formatBuckets записывает границы корзин в формате storage.ParseBuckets.
+func formatBuckets(bounds []float64) string {
+	parts := make([]string, len(bounds))
+	for i, b := range bounds {
+		parts[i] = strconv.FormatFloat(b, 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
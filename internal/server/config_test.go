@@ -0,0 +1,94 @@
+package server
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadConfig вызывает LoadConfig с аргументами командной строки args
+// и отдельным набором флагов.
+func loadConfig(t *testing.T, args ...string) (Config, error) {
+	t.Helper()
+	oldArgs, oldFlags := os.Args, flag.CommandLine
+	t.Cleanup(func() {
+		os.Args, flag.CommandLine = oldArgs, oldFlags
+	})
+	os.Args = append([]string{"server"}, args...)
+	flag.CommandLine = flag.NewFlagSet("server", flag.ContinueOnError)
+	return LoadConfig()
+}
+
+// unsetenv удаляет переменную окружения на время теста.
+func unsetenv(t *testing.T, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		t.Setenv(key, "")
+		require.NoError(t, os.Unsetenv(key))
+	}
+}
+
+func writeConfigFile(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	unsetenv(t, "ADDRESS", "CONFIG", "WAL_SYNC", "FILE_STORAGE_PATH")
+
+	cfg, err := loadConfig(t)
+	require.NoError(t, err)
+	assert.Equal(t, ":8080", cfg.ServerAddr)
+	assert.Equal(t, "interval", cfg.WALSync)
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	unsetenv(t, "ADDRESS", "CONFIG", "WAL_SYNC", "FILE_STORAGE_PATH", "OTLP_PREFIX_ATTRIBUTES", "STORE_INTERVAL")
+	path := writeConfigFile(t, `{
+		"address": "json:1",
+		"store_file": "/tmp/json.db",
+		"store_interval": "5m",
+		"wal_sync": "never",
+		"otlp_prefix_attributes": ["service.name", "otel.scope.name"]
+	}`)
+
+	// Файл конфигурации перекрывает значения по умолчанию
+	cfg, err := loadConfig(t, "-c", path)
+	require.NoError(t, err)
+	assert.Equal(t, "json:1", cfg.ServerAddr)
+	assert.Equal(t, "/tmp/json.db", cfg.FileStoragePath)
+	assert.Equal(t, 300, cfg.StoreInterval)
+	assert.Equal(t, "never", cfg.WALSync)
+	assert.Equal(t, "service.name,otel.scope.name", cfg.OTLPPrefixAttributes)
+
+	// Переменные окружения перекрывают файл, в том числе пустые
+	t.Setenv("ADDRESS", "env:2")
+	t.Setenv("WAL_SYNC", "")
+	cfg, err = loadConfig(t, "-c", path)
+	require.NoError(t, err)
+	assert.Equal(t, "env:2", cfg.ServerAddr)
+	assert.Equal(t, "", cfg.WALSync)
+	assert.Equal(t, "/tmp/json.db", cfg.FileStoragePath)
+
+	// Флаги перекрывают переменные окружения и файл
+	cfg, err = loadConfig(t, "-c", path, "-a", "flag:3", "-f", "/tmp/flag.db")
+	require.NoError(t, err)
+	assert.Equal(t, "flag:3", cfg.ServerAddr)
+	assert.Equal(t, "/tmp/flag.db", cfg.FileStoragePath)
+}
+
+func TestLoadConfig_InvalidFile(t *testing.T) {
+	unsetenv(t, "CONFIG")
+
+	_, err := loadConfig(t, "-c", filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	_, err = loadConfig(t, "-c", writeConfigFile(t, `{"trusted_subnet": "10.0.0.0/33"}`))
+	assert.Error(t, err)
+}
@@ -3,19 +3,29 @@ package server
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/am0xff/metrics/internal/alerts"
+	"github.com/am0xff/metrics/internal/graphite"
+	"github.com/am0xff/metrics/internal/influx"
+	"github.com/am0xff/metrics/internal/janitor"
 	"github.com/am0xff/metrics/internal/logger"
 	"github.com/am0xff/metrics/internal/middleware"
+	"github.com/am0xff/metrics/internal/otlp"
 	"github.com/am0xff/metrics/internal/router"
+	"github.com/am0xff/metrics/internal/rpc"
+	"github.com/am0xff/metrics/internal/statsd"
 	"github.com/am0xff/metrics/internal/storage"
 	fstorage "github.com/am0xff/metrics/internal/storage/file"
 	memstorage "github.com/am0xff/metrics/internal/storage/memory"
@@ -32,6 +42,10 @@ func Run() error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
+	if cfg.Migrate != "" {
+		return migrate(ctx, cfg)
+	}
+
 	if cfg.PprofEnabled {
 		go func() {
 			if err := http.ListenAndServe(cfg.PprofAddr, nil); err != nil {
@@ -51,17 +65,19 @@ func Run() error {
 
 	var s storage.StorageProvider
 
-	ms := memstorage.NewStorage()
-	fs, _ := fstorage.NewStorage(ctx, fstorage.Config{
-		FileStoragePath: cfg.FileStoragePath,
-		Restore:         cfg.Restore,
-		StoreInterval:   cfg.StoreInterval,
-	})
+	var fs *fstorage.FileStorage
+	var ds *pgstorage.PGStorage
+	routerOpts := make([]router.Option, 0, 2)
 
 	if cfg.DatabaseDSN != "" {
-		var ds *pgstorage.PGStorage
 		if cfg.DatabaseDSN != "" {
-			ds = pgstorage.NewStorage(db)
+			ds = pgstorage.NewStorage(db, pgstorage.WithWriteBehind(
+				time.Duration(cfg.DBFlushInterval)*time.Second, cfg.DBFlushSize))
+			if cfg.DBFlushInterval > 0 {
+				routerOpts = append(routerOpts, router.WithServerGauge("pg_flush_lag_seconds", func() float64 {
+					return ds.FlushLag().Seconds()
+				}))
+			}
 			// Точка входа для создания таблиц
 			if err := ds.Bootstrap(context.Background()); err != nil {
 				return fmt.Errorf("bootstrap db storage: %w", err)
@@ -69,16 +85,105 @@ func Run() error {
 			s = ds
 		}
 	} else if cfg.FileStoragePath != "" {
+		walSync, err := fstorage.ParseSyncMode(cfg.WALSync)
+		if err != nil {
+			return err
+		}
+		fs, err = fstorage.NewStorage(ctx, fstorage.Config{
+			FileStoragePath: cfg.FileStoragePath,
+			Restore:         cfg.Restore,
+			StoreInterval:   cfg.StoreInterval,
+			WALSync:         walSync,
+			WALSyncInterval: time.Duration(cfg.WALSyncInterval) * time.Second,
+		})
+		if err != nil {
+			return fmt.Errorf("init file storage: %w", err)
+		}
 		s = fs
 	} else {
-		s = ms
+		s = memstorage.NewStorage()
+	}
+
+	alertEngine, err := newAlertEngine(cfg, s)
+	if err != nil {
+		return fmt.Errorf("init alerts: %w", err)
+	}
+
+	policy, err := newTTLPolicy(cfg)
+	if err != nil {
+		return fmt.Errorf("init series ttl: %w", err)
+	}
+	staleAction, err := janitor.ParseAction(cfg.StaleAction)
+	if err != nil {
+		return fmt.Errorf("init series ttl: %w", err)
+	}
+	seriesJanitor := janitor.New(s, policy, staleAction, time.Duration(cfg.JanitorInterval)*time.Second)
+	if policy.Enabled() {
+		routerOpts = append(routerOpts,
+			router.WithTTLPolicy(policy),
+			router.WithServerGauge("stale_series", func() float64 {
+				return float64(seriesJanitor.Stale())
+			}))
+	}
+
+	buckets, err := newBucketPolicy(cfg)
+	if err != nil {
+		return fmt.Errorf("init histogram buckets: %w", err)
+	}
+	routerOpts = append(routerOpts, router.WithBucketPolicy(buckets))
+
+	influxMapping, err := influx.ParseMapping(cfg.InfluxTypeMapping)
+	if err != nil {
+		return fmt.Errorf("init influx type mapping: %w", err)
+	}
+	routerOpts = append(routerOpts,
+		router.WithInfluxMapping(influxMapping),
+		router.WithPromWriteCounters(cfg.PromWriteCounters),
+		router.WithOTLPMapping(otlp.ParseMapping(cfg.OTLPPrefixAttributes)))
+
+	var statsdListener *statsd.Listener
+	if cfg.StatsDAddr != "" {
+		statsdListener = statsd.NewListener(s, cfg.StatsDAddr,
+			time.Duration(cfg.StatsDFlushInterval)*time.Second, buckets)
+		if err := statsdListener.Listen(); err != nil {
+			return fmt.Errorf("listen statsd: %w", err)
+		}
+		routerOpts = append(routerOpts, router.WithServerGauge("statsd_parse_errors", func() float64 {
+			return float64(statsdListener.ParseErrors())
+		}))
 	}
 
-	r := router.SetupRoutes(s)
+	var graphiteListener *graphite.Listener
+	if cfg.GraphiteAddr != "" {
+		templates, err := graphite.ParseTemplates(cfg.GraphiteTemplates)
+		if err != nil {
+			return fmt.Errorf("init graphite templates: %w", err)
+		}
+		graphiteListener = graphite.NewListener(s, cfg.GraphiteAddr, templates,
+			time.Duration(cfg.GraphiteIdleTimeout)*time.Second)
+		if err := graphiteListener.Listen(); err != nil {
+			return fmt.Errorf("listen graphite: %w", err)
+		}
+		routerOpts = append(routerOpts, router.WithServerGauge("graphite_parse_errors", func() float64 {
+			return float64(graphiteListener.ParseErrors())
+		}))
+	}
+
+	var trustedSubnet *net.IPNet
+	if cfg.TrustedSubnet != "" {
+		_, trustedSubnet, err = net.ParseCIDR(cfg.TrustedSubnet)
+		if err != nil {
+			return fmt.Errorf("parse trusted subnet: %w", err)
+		}
+	}
+
+	routerOpts = append(routerOpts, router.WithAlerts(alertEngine))
+	r := router.SetupRoutes(s, routerOpts...)
 
 	handler := middleware.HashMiddleware(r, cfg.Key)
 	handler = middleware.GzipMiddleware(handler, cfg.Key)
 	handler = middleware.RSAMiddleware(handler, cfg.CryptoKey)
+	handler = middleware.TrustedSubnetMiddleware(handler, trustedSubnet)
 	handler = middleware.LoggerMiddleware(handler)
 
 	server := &http.Server{
@@ -86,6 +191,19 @@ func Run() error {
 		Handler: handler,
 	}
 
+	grpcServer, err := rpc.NewGRPCServer(s, cfg.Key, cfg.CryptoKey, trustedSubnet)
+	if err != nil {
+		return fmt.Errorf("init grpc server: %w", err)
+	}
+
+	var grpcListener net.Listener
+	if cfg.GRPCAddr != "" {
+		grpcListener, err = net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			return fmt.Errorf("listen grpc: %w", err)
+		}
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
@@ -104,7 +222,7 @@ func Run() error {
 				case <-saveCtx.Done():
 					return
 				case <-ticker.C:
-					if cfg.DatabaseDSN == "" && cfg.FileStoragePath != "" {
+					if fs != nil {
 						if err := fs.Save(); err != nil {
 							log.Printf("Save storage to the file: %v", err)
 						}
@@ -114,6 +232,14 @@ func Run() error {
 		}()
 	}
 
+	alertCtx, alertCancel := context.WithCancel(ctx)
+	defer alertCancel()
+	go alertEngine.Run(alertCtx)
+
+	janitorCtx, janitorCancel := context.WithCancel(ctx)
+	defer janitorCancel()
+	go seriesJanitor.Run(janitorCtx)
+
 	go func() {
 		fmt.Println("Running server on", cfg.ServerAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -121,25 +247,154 @@ func Run() error {
 		}
 	}()
 
+	var ingestWg sync.WaitGroup
+	ingestCtx, ingestCancel := context.WithCancel(ctx)
+	defer ingestCancel()
+	if statsdListener != nil {
+		fmt.Println("Running StatsD listener on", cfg.StatsDAddr)
+		ingestWg.Add(1)
+		go func() {
+			defer ingestWg.Done()
+			statsdListener.Serve(ingestCtx)
+		}()
+	}
+	if graphiteListener != nil {
+		fmt.Println("Running Graphite listener on", cfg.GraphiteAddr)
+		ingestWg.Add(1)
+		go func() {
+			defer ingestWg.Done()
+			graphiteListener.Serve(ingestCtx)
+		}()
+	}
+
+	if grpcListener != nil {
+		go func() {
+			fmt.Println("Running gRPC server on", cfg.GRPCAddr)
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Fatalf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+
 	sig := <-sigChan
 	fmt.Printf("\nReceived signal: %v. Shutting down gracefully...\n", sig)
 
+	grpcServer.GracefulStop()
+	alertCancel()
+	janitorCancel()
+	// Накопленные метрики StatsD и Graphite записываются до сохранения хранилища
+	ingestCancel()
+	ingestWg.Wait()
 	saveCancel()
 	saveWg.Wait()
 
-	if cfg.DatabaseDSN == "" && cfg.FileStoragePath != "" {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		log.Printf("Server shutdown failed: %v", shutdownErr)
+	}
+
+	// Запросы обработаны: снимок сохраняется с их изменениями, оставшиеся
+	// в буфере изменения записываются в базу
+	if fs != nil {
 		if err := fs.Save(); err != nil {
 			log.Printf("Save storage to the file: %v", err)
 		}
+		if err := fs.Close(); err != nil {
+			log.Printf("Close file storage: %v", err)
+		}
+	}
+	if ds != nil {
+		if err := ds.Close(shutdownCtx); err != nil {
+			log.Printf("Close db storage: %v", err)
+			return err
+		}
 	}
 
-	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	return shutdownErr
+}
 
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("Server shutdown failed: %v", err)
-		return err
+// migrate применяет (up) или откатывает на одну версию (down) миграции схемы
+// базы данных cfg.DatabaseDSN. Используется в режиме -migrate, в котором
+// сервер не запускается.
+func migrate(ctx context.Context, cfg Config) error {
+	if cfg.DatabaseDSN == "" {
+		return errors.New("migrate: database DSN is not set")
 	}
 
+	db, err := sql.Open("pgx", cfg.DatabaseDSN)
+	if err != nil {
+		return fmt.Errorf("open db: %w", err)
+	}
+	defer db.Close()
+
+	m, err := pgstorage.NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("load migrations: %w", err)
+	}
+
+	if cfg.Migrate == "down" {
+		err = m.Down(ctx, 1)
+	} else {
+		err = m.Up(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("migrate %s: %w", cfg.Migrate, err)
+	}
 	return nil
 }
+
+// newTTLPolicy собирает политику устаревания серий из cfg.SeriesTTL
+// и переопределений cfg.SeriesTTLRules.
+func newTTLPolicy(cfg Config) (storage.TTLPolicy, error) {
+	overrides, err := storage.ParseTTLOverrides(cfg.SeriesTTLRules)
+	if err != nil {
+		return storage.TTLPolicy{}, err
+	}
+	return storage.TTLPolicy{
+		Default:   time.Duration(cfg.SeriesTTL) * time.Second,
+		Overrides: overrides,
+	}, nil
+}
+
+// newBucketPolicy собирает границы корзин histogram метрик из
+// cfg.HistogramBuckets и переопределений cfg.HistogramBucketRules.
+func newBucketPolicy(cfg Config) (storage.BucketPolicy, error) {
+	bounds, err := storage.ParseBuckets(cfg.HistogramBuckets)
+	if err != nil {
+		return storage.BucketPolicy{}, err
+	}
+	overrides, err := storage.ParseBucketOverrides(cfg.HistogramBucketRules)
+	if err != nil {
+		return storage.BucketPolicy{}, err
+	}
+	return storage.BucketPolicy{Default: bounds, Overrides: overrides}, nil
+}
+
+// newAlertEngine создает движок оповещений по правилам из cfg.AlertRulesFile.
+// Если файл правил не указан, движок создается без правил.
+func newAlertEngine(cfg Config, s storage.StorageProvider) (*alerts.Engine, error) {
+	var rules []alerts.Rule
+	if cfg.AlertRulesFile != "" {
+		var err error
+		rules, err = alerts.LoadRules(cfg.AlertRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("load alert rules: %w", err)
+		}
+	}
+
+	var notifier alerts.Notifier
+	var urls []string
+	for _, u := range strings.Split(cfg.AlertWebhooks, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) > 0 {
+		notifier = alerts.NewWebhookNotifier(urls)
+	}
+
+	return alerts.NewEngine(s, rules, notifier, time.Duration(cfg.AlertInterval)*time.Second), nil
+}
@@ -0,0 +1,447 @@
+// Package statsd реализует прием метрик в формате StatsD по UDP и TCP.
+// Значения агрегируются в окне и записываются в хранилище одним пакетом
+// при каждом сбросе окна: counter - суммой приростов с учетом доли
+// отправленных значений, gauge - последним значением, таймеры - наблюдениями
+// histogram метрики.
+package statsd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// DefaultFlushInterval - период сброса окна агрегации по умолчанию.
+const DefaultFlushInterval = 10 * time.Second
+
+// maxPacketSize - максимальный размер UDP пакета.
+const maxPacketSize = 65535
+
+// Listener принимает метрики StatsD и записывает их в хранилище.
+//
+// Пример использования:
+//
+//	l := statsd.NewListener(storage, ":8125", 10*time.Second, storage.BucketPolicy{})
+//	if err := l.Listen(); err != nil {
+//		return err
+//	}
+//	go l.Serve(ctx)
+type Listener struct {
+	sp       storage.StorageProvider
+	addr     string
+	interval time.Duration
+	buckets  storage.BucketPolicy
+
+	udp     net.PacketConn
+	tcp     net.Listener
+	conns   sync.WaitGroup
+	connsMu sync.Mutex
+	active  map[net.Conn]struct{}
+
+	mu     sync.Mutex
+	window *window
+
+	parseErrors atomic.Int64
+}
+
+// NewListener создает Listener, принимающий метрики на адресе addr
+// и сбрасывающий окно агрегации с периодом interval (по умолчанию
+// DefaultFlushInterval). Наблюдения таймеров раскладываются по корзинам
+// политики buckets; так как они записываются в миллисекундах, для таймеров
+// обычно задаются отдельные границы корзин.
+func NewListener(sp storage.StorageProvider, addr string, interval time.Duration, buckets storage.BucketPolicy) *Listener {
+	if interval <= 0 {
+		interval = DefaultFlushInterval
+	}
+	return &Listener{
+		sp:       sp,
+		addr:     addr,
+		interval: interval,
+		buckets:  buckets,
+		active:   make(map[net.Conn]struct{}),
+		window:   newWindow(),
+	}
+}
+
+// Listen открывает UDP и TCP сокеты на адресе, заданном при создании.
+// TCP сокет открывается на том же порту, что и UDP.
+func (l *Listener) Listen() error {
+	udp, err := net.ListenPacket("udp", l.addr)
+	if err != nil {
+		return fmt.Errorf("listen udp: %w", err)
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return fmt.Errorf("listen tcp: %w", err)
+	}
+	l.udp, l.tcp = udp, tcp
+	return nil
+}
+
+// Addr возвращает адрес открытых сокетов.
+func (l *Listener) Addr() net.Addr {
+	return l.udp.LocalAddr()
+}
+
+// Serve принимает метрики до отмены контекста, после чего закрывает сокеты
+// и записывает в хранилище накопленные значения. Listen должен быть вызван
+// заранее.
+func (l *Listener) Serve(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		l.serveUDP()
+	}()
+	go func() {
+		defer wg.Done()
+		l.serveTCP()
+	}()
+
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			l.udp.Close()
+			l.tcp.Close()
+			wg.Wait()
+
+			// Новые соединения больше не принимаются, открытые закрываются,
+			// чтобы не ждать отключения клиентов
+			l.connsMu.Lock()
+			for conn := range l.active {
+				conn.Close()
+			}
+			l.connsMu.Unlock()
+			l.conns.Wait()
+
+			if err := l.Flush(context.Background()); err != nil {
+				log.Printf("statsd: flush: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := l.Flush(ctx); err != nil {
+				log.Printf("statsd: flush: %v", err)
+			}
+		}
+	}
+}
+
+// ParseErrors возвращает количество строк, которые не удалось разобрать
+// или учесть в окне агрегации.
+func (l *Listener) ParseErrors() int64 {
+	return l.parseErrors.Load()
+}
+
+func (l *Listener) serveUDP() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("statsd: read udp: %v", err)
+			}
+			return
+		}
+		l.HandlePacket(buf[:n])
+	}
+}
+
+func (l *Listener) serveTCP() {
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("statsd: accept tcp: %v", err)
+			}
+			return
+		}
+
+		l.connsMu.Lock()
+		l.active[conn] = struct{}{}
+		l.connsMu.Unlock()
+
+		l.conns.Add(1)
+		go func() {
+			defer l.conns.Done()
+			defer func() {
+				l.connsMu.Lock()
+				delete(l.active, conn)
+				l.connsMu.Unlock()
+				conn.Close()
+			}()
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				l.HandleLine(scanner.Text())
+			}
+		}()
+	}
+}
+
+// HandlePacket разбирает пакет со строками StatsD, разделенными переводом
+// строки, и добавляет значения в окно агрегации.
+func (l *Listener) HandlePacket(data []byte) {
+	for _, line := range strings.Split(string(data), "\n") {
+		l.HandleLine(line)
+	}
+}
+
+// HandleLine разбирает строку StatsD и добавляет значение в окно агрегации.
+// Пустые строки пропускаются, ошибки разбора и значения, которые нельзя
+// учесть (сумма counter вне диапазона int64), учитываются в ParseErrors.
+func (l *Listener) HandleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+	m, err := ParseLine(line)
+	if err != nil {
+		l.parseErrors.Add(1)
+		return
+	}
+
+	l.mu.Lock()
+	err = l.window.add(m)
+	l.mu.Unlock()
+	if err != nil {
+		l.parseErrors.Add(1)
+	}
+}
+
+// Flush записывает накопленные в окне значения в хранилище одним пакетом
+// и начинает новое окно. Если пакет отклонен хранилищем как некорректный
+// (например, из-за изменившихся границ корзин), метрики записываются
+// по одной, чтобы ошибка одной серии не отбросила остальные. При других
+// ошибках (например, недоступности хранилища) значения возвращаются
+// в окно и записываются при следующем сбросе.
+func (l *Listener) Flush(ctx context.Context) error {
+	l.mu.Lock()
+	w := l.window
+	l.window = newWindow()
+	l.mu.Unlock()
+
+	metrics, err := w.metrics(ctx, l.sp, l.buckets)
+	if err != nil {
+		l.restore(w)
+		return err
+	}
+
+	var errs []error
+	err = storage.UpdateBatchOrEach(ctx, l.sp, metrics, func(m models.Metrics, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", storage.SeriesKey(m.ID, m.Labels), err))
+	})
+	if err != nil {
+		l.restore(w)
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// restore возвращает незаписанное окно w, объединяя его со значениями,
+// накопленными после начала сброса.
+func (l *Listener) restore(w *window) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w.merge(l.window)
+	l.window = w
+}
+
+// series - серия в окне агрегации.
+type series struct {
+	name   string
+	labels map[string]string
+}
+
+type gaugeValue struct {
+	series
+	value float64 // последнее абсолютное значение
+	set   bool    // было ли в окне абсолютное значение
+	delta float64 // изменение после последнего абсолютного значения
+}
+
+// maxCounterSum - наибольшая по модулю сумма counter в окне: наибольшее
+// значение float64, которое помещается в int64.
+const maxCounterSum = 1<<63 - 1024
+
+type counterValue struct {
+	series
+	sum float64
+}
+
+type timerValue struct {
+	series
+	observations []timerObservation
+	sum          float64
+}
+
+// timerObservation - значение таймера, учитываемое weight раз.
+type timerObservation struct {
+	value  float64
+	weight uint64
+}
+
+// window - значения, накопленные между сбросами.
+type window struct {
+	counters map[string]*counterValue
+	gauges   map[string]*gaugeValue
+	timers   map[string]*timerValue
+}
+
+func newWindow() *window {
+	return &window{
+		counters: make(map[string]*counterValue),
+		gauges:   make(map[string]*gaugeValue),
+		timers:   make(map[string]*timerValue),
+	}
+}
+
+// add добавляет значение в окно. Возвращает ошибку, если сумма counter
+// или таймера с учетом доли отправленных значений выходит за допустимый
+// диапазон; окно при этом не изменяется.
+func (w *window) add(m Metric) error {
+	key := storage.SeriesKey(m.Name, m.Labels)
+	s := series{name: m.Name, labels: m.Labels}
+
+	switch m.Type {
+	case TypeCounter:
+		c, ok := w.counters[key]
+		sum := m.Value / m.SampleRate
+		if ok {
+			sum += c.sum
+		}
+		if math.Abs(sum) > maxCounterSum {
+			return fmt.Errorf("counter %s: sum %v is out of range", key, sum)
+		}
+		if !ok {
+			c = &counterValue{series: s}
+			w.counters[key] = c
+		}
+		c.sum = sum
+	case TypeGauge:
+		g, ok := w.gauges[key]
+		if !ok {
+			g = &gaugeValue{series: s}
+			w.gauges[key] = g
+		}
+		if m.Relative {
+			g.delta += m.Value
+		} else {
+			g.value, g.set, g.delta = m.Value, true, 0
+		}
+	case TypeTimer:
+		// Значение, отправленное с долей 0.1, учитывается 10 раз
+		n := max(1, uint64(math.Round(1/m.SampleRate)))
+		t, ok := w.timers[key]
+		sum := m.Value * float64(n)
+		if ok {
+			sum += t.sum
+		}
+		if math.IsInf(sum, 0) {
+			return fmt.Errorf("timer %s: sum is out of range", key)
+		}
+		if !ok {
+			t = &timerValue{series: s}
+			w.timers[key] = t
+		}
+		t.observations = append(t.observations, timerObservation{value: m.Value, weight: n})
+		t.sum = sum
+	}
+	return nil
+}
+
+// merge добавляет к окну значения более нового окна newer. Используется,
+// чтобы вернуть в работу окно, которое не удалось записать в хранилище.
+func (w *window) merge(newer *window) {
+	for key, n := range newer.counters {
+		c, ok := w.counters[key]
+		if !ok {
+			w.counters[key] = n
+			continue
+		}
+		c.sum = max(-maxCounterSum, min(maxCounterSum, c.sum+n.sum))
+	}
+
+	for key, n := range newer.gauges {
+		g, ok := w.gauges[key]
+		if !ok || n.set {
+			w.gauges[key] = n
+			continue
+		}
+		g.delta += n.delta
+	}
+
+	for key, n := range newer.timers {
+		t, ok := w.timers[key]
+		if !ok {
+			w.timers[key] = n
+			continue
+		}
+		t.observations = append(t.observations, n.observations...)
+		t.sum += n.sum
+	}
+}
+
+// metrics преобразует окно в пакет метрик. Gauge, измененные в окне только
+// относительно, вычисляются от текущего значения в хранилище sp.
+func (w *window) metrics(ctx context.Context, sp storage.StorageProvider, buckets storage.BucketPolicy) ([]models.Metrics, error) {
+	metrics := make([]models.Metrics, 0, len(w.counters)+len(w.gauges)+len(w.timers))
+
+	for _, c := range w.counters {
+		delta := int64(math.Round(c.sum))
+		metrics = append(metrics, models.Metrics{
+			ID:     c.name,
+			MType:  storage.MetricTypeCounter,
+			Delta:  &delta,
+			Labels: c.labels,
+		})
+	}
+
+	for key, g := range w.gauges {
+		value := g.value + g.delta
+		if !g.set {
+			current, err := sp.GetGauge(ctx, key)
+			if err != nil && !errors.Is(err, storage.ErrNotFound) {
+				return nil, err
+			}
+			value = float64(current) + g.delta
+		}
+		metrics = append(metrics, models.Metrics{
+			ID:     g.name,
+			MType:  storage.MetricTypeGauge,
+			Value:  &value,
+			Labels: g.labels,
+		})
+	}
+
+	// Наблюдения таймеров раскладываются по корзинам здесь, а не в хранилище:
+	// значение с долей отправки учитывается числом, а не копиями
+	for key, t := range w.timers {
+		h := storage.NewHistogram(buckets.Buckets(key))
+		for _, o := range t.observations {
+			h.ObserveN(o.value, o.weight)
+		}
+		metrics = append(metrics, models.Metrics{
+			ID:        t.name,
+			MType:     storage.MetricTypeHistogram,
+			Histogram: h.Model(),
+			Labels:    t.labels,
+		})
+	}
+
+	return metrics, nil
+}
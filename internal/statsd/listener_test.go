@@ -0,0 +1,199 @@
+package statsd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	memstorage "github.com/am0xff/metrics/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListener_Flush(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	require.NoError(t, ms.SetGauge(ctx, "queue", 10))
+	require.NoError(t, ms.SetCounter(ctx, "requests", 5))
+
+	l := NewListener(ms, "", time.Minute, storage.BucketPolicy{Default: []float64{10, 100}})
+	l.HandlePacket([]byte("requests:1|c|@0.5\nrequests:1|c\n\nqueue:+3|g\nqueue:-1|g"))
+	l.HandleLine("temperature:20|g")
+	l.HandleLine("temperature:+1.5|g")
+	l.HandleLine(`latency:50|ms|#host:web-1`)
+	l.HandleLine(`latency:5|ms|@0.5|#host:web-1`)
+	l.HandleLine("broken")
+	l.HandleLine("requests:1|x")
+
+	require.NoError(t, l.Flush(ctx))
+	assert.Equal(t, int64(2), l.ParseErrors())
+
+	// 1/0.5 + 1
+	c, err := ms.GetCounter(ctx, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(8), c)
+
+	// Относительное изменение считается от значения в хранилище
+	g, err := ms.GetGauge(ctx, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(12), g)
+
+	g, err = ms.GetGauge(ctx, "temperature")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(21.5), g)
+
+	h, err := ms.GetHistogram(ctx, `latency{host="web-1"}`)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{10, 100}, h.Bounds)
+	assert.Equal(t, []uint64{2, 1, 0}, h.Counts)
+
+	// Окно после сброса пустое
+	require.NoError(t, l.Flush(ctx))
+	c, _ = ms.GetCounter(ctx, "requests")
+	assert.Equal(t, storage.Counter(8), c)
+}
+
+func TestListener_InvalidSeriesDoesNotDropBatch(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+
+	// Серия сохранена с другими границами корзин
+	l := NewListener(ms, "", time.Minute, storage.BucketPolicy{Default: []float64{1}})
+	l.HandleLine("latency:5|ms")
+	require.NoError(t, l.Flush(ctx))
+
+	l = NewListener(ms, "", time.Minute, storage.BucketPolicy{Default: []float64{10, 100}})
+	l.HandleLine("latency:5|ms")
+	l.HandleLine("requests:1|c")
+	assert.ErrorIs(t, l.Flush(ctx), storage.ErrInvalid)
+
+	c, err := ms.GetCounter(ctx, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(1), c)
+}
+
+func TestListener_SampleRate(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	l := NewListener(ms, "", time.Minute, storage.BucketPolicy{Default: []float64{10, 100}})
+
+	// Значение с наименьшей долей учитывается весом, а не копиями
+	l.HandleLine("latency:5|ms|@0.001")
+	l.mu.Lock()
+	assert.Len(t, l.window.timers["latency"].observations, 1)
+	l.mu.Unlock()
+
+	// Суммы counter, не помещающиеся в int64, отклоняются
+	l.HandleLine("requests:9e18|c")
+	l.HandleLine("requests:9e18|c")
+	l.HandleLine("other:1e16|c|@0.001")
+	l.HandleLine("requests:1|c|@1e-300")
+	l.HandleLine("latency:1|ms|@1e-9")
+	assert.Equal(t, int64(4), l.ParseErrors())
+
+	require.NoError(t, l.Flush(ctx))
+
+	c, err := ms.GetCounter(ctx, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(9e18), c)
+	_, err = ms.GetCounter(ctx, "other")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	h, err := ms.GetHistogram(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1000, 0, 0}, h.Counts)
+	assert.Equal(t, uint64(1000), h.Count)
+	assert.InDelta(t, 5000, h.Sum, 1e-9)
+}
+
+// unavailableStorage отклоняет первые fails пакетов как недоступное хранилище.
+type unavailableStorage struct {
+	storage.StorageProvider
+	fails int
+}
+
+func (s *unavailableStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	if s.fails > 0 {
+		s.fails--
+		return storage.ErrUnavailable
+	}
+	return s.StorageProvider.UpdateBatch(ctx, metrics)
+}
+
+func TestListener_FlushRestoresWindow(t *testing.T) {
+	ctx := context.Background()
+	ms := memstorage.NewStorage()
+	sp := &unavailableStorage{StorageProvider: ms, fails: 1}
+	l := NewListener(sp, "", time.Minute, storage.BucketPolicy{Default: []float64{10}})
+
+	l.HandleLine("requests:2|c")
+	l.HandleLine("queue:5|g")
+	l.HandleLine("latency:5|ms")
+	assert.ErrorIs(t, l.Flush(ctx), storage.ErrUnavailable)
+
+	// Значения, полученные после неудачного сброса, объединяются с окном
+	l.HandleLine("requests:3|c")
+	l.HandleLine("queue:+1|g")
+	l.HandleLine("latency:50|ms")
+	require.NoError(t, l.Flush(ctx))
+
+	c, err := ms.GetCounter(ctx, "requests")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(5), c)
+	g, err := ms.GetGauge(ctx, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(6), g)
+	h, err := ms.GetHistogram(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1}, h.Counts)
+}
+
+func TestListener_Serve(t *testing.T) {
+	ms := memstorage.NewStorage()
+	l := NewListener(ms, "127.0.0.1:0", time.Hour, storage.BucketPolicy{})
+	require.NoError(t, l.Listen())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l.Serve(ctx)
+	}()
+
+	udp, err := net.Dial("udp", l.Addr().String())
+	require.NoError(t, err)
+	defer udp.Close()
+	_, err = udp.Write([]byte("udp_requests:2|c\nbad line"))
+	require.NoError(t, err)
+
+	tcp, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer tcp.Close()
+	_, err = tcp.Write([]byte("tcp_gauge:7|g\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return len(l.window.counters) == 1 && len(l.window.gauges) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// При остановке накопленные значения записываются в хранилище
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not stop")
+	}
+
+	c, err := ms.GetCounter(context.Background(), "udp_requests")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(2), c)
+	g, err := ms.GetGauge(context.Background(), "tcp_gauge")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(7), g)
+	assert.Equal(t, int64(1), l.ParseErrors())
+}
@@ -0,0 +1,121 @@
+package statsd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// ErrParse возвращается для строки, не соответствующей формату StatsD.
+var ErrParse = errors.New("statsd parse error")
+
+// MinSampleRate - наименьшая допустимая доля отправленных значений.
+// Значение с долей 0.001 учитывается 1000 раз; меньшие доли отклоняются,
+// чтобы одна строка не давала огромный прирост или число наблюдений.
+const MinSampleRate = 0.001
+
+// Type - тип значения StatsD.
+type Type string
+
+const (
+	TypeCounter Type = "c"  // прирост counter
+	TypeGauge   Type = "g"  // значение gauge или, со знаком, его изменение
+	TypeTimer   Type = "ms" // длительность в миллисекундах, наблюдение histogram
+)
+
+// Metric - значение из одной строки StatsD.
+type Metric struct {
+	Name       string
+	Labels     map[string]string
+	Type       Type
+	Value      float64
+	Relative   bool    // значение gauge задано со знаком + или - и изменяет текущее
+	SampleRate float64 // доля отправленных значений, от MinSampleRate до 1
+}
+
+// ParseLine разбирает строку формата StatsD:
+//
+//	<имя>:<значение>|<тип>[|@<доля>][|#<метка>:<значение>,...]
+//
+// Тип - c, g или ms (h - синоним ms). Значение gauge со знаком + или -
+// изменяет текущее значение на указанную величину. Доля отправленных
+// значений (от MinSampleRate до 1) учитывается для c и ms. Метки в формате DogStatsD становятся
+// метками серии.
+//
+// Примеры:
+//
+//	requests:1|c|@0.1
+//	temperature:-2|g
+//	db.query:12.5|ms|#table:users
+func ParseLine(line string) (Metric, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 2 {
+		return Metric{}, fmt.Errorf("%w: missing type in %q", ErrParse, line)
+	}
+
+	sep := strings.LastIndexByte(fields[0], ':')
+	if sep <= 0 {
+		return Metric{}, fmt.Errorf("%w: missing value in %q", ErrParse, line)
+	}
+	m := Metric{Name: fields[0][:sep], SampleRate: 1}
+	raw := fields[0][sep+1:]
+
+	switch t := Type(fields[1]); t {
+	case TypeCounter, TypeGauge, TypeTimer:
+		m.Type = t
+	case "h":
+		m.Type = TypeTimer
+	default:
+		return Metric{}, fmt.Errorf("%w: unknown type %q in %q", ErrParse, fields[1], line)
+	}
+
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return Metric{}, fmt.Errorf("%w: invalid value %q in %q", ErrParse, raw, line)
+	}
+	m.Value = value
+	m.Relative = m.Type == TypeGauge && (raw[0] == '+' || raw[0] == '-')
+
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || !(rate >= MinSampleRate && rate <= 1) {
+				return Metric{}, fmt.Errorf("%w: invalid sample rate %q in %q", ErrParse, f, line)
+			}
+			if m.Type != TypeGauge {
+				m.SampleRate = rate
+			}
+		case strings.HasPrefix(f, "#"):
+			labels, err := parseTags(f[1:])
+			if err != nil {
+				return Metric{}, fmt.Errorf("%w: %w in %q", ErrParse, err, line)
+			}
+			m.Labels = labels
+		default:
+			return Metric{}, fmt.Errorf("%w: unknown field %q in %q", ErrParse, f, line)
+		}
+	}
+
+	if err := storage.ValidateSeries(m.Name, m.Labels); err != nil {
+		return Metric{}, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+	return m, nil
+}
+
+// parseTags разбирает метки DogStatsD: host:web-1,dc:eu.
+func parseTags(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(tag, ":")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		labels[k] = v
+	}
+	return labels, nil
+}
@@ -0,0 +1,92 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected Metric
+	}{
+		{
+			name:     "counter",
+			input:    "requests:3|c",
+			expected: Metric{Name: "requests", Type: TypeCounter, Value: 3, SampleRate: 1},
+		},
+		{
+			name:     "counter_sample_rate",
+			input:    "requests:1|c|@0.1",
+			expected: Metric{Name: "requests", Type: TypeCounter, Value: 1, SampleRate: 0.1},
+		},
+		{
+			name:     "gauge",
+			input:    "temperature:21.5|g",
+			expected: Metric{Name: "temperature", Type: TypeGauge, Value: 21.5, SampleRate: 1},
+		},
+		{
+			name:     "relative_gauge",
+			input:    "temperature:-2|g",
+			expected: Metric{Name: "temperature", Type: TypeGauge, Value: -2, Relative: true, SampleRate: 1},
+		},
+		{
+			name:     "gauge_ignores_sample_rate",
+			input:    "queue:+5|g|@0.5",
+			expected: Metric{Name: "queue", Type: TypeGauge, Value: 5, Relative: true, SampleRate: 1},
+		},
+		{
+			name:  "timer_with_tags",
+			input: "db.query:12.5|ms|@0.5|#table:users,dc:eu",
+			expected: Metric{
+				Name: "db.query", Labels: map[string]string{"table": "users", "dc": "eu"},
+				Type: TypeTimer, Value: 12.5, SampleRate: 0.5,
+			},
+		},
+		{
+			name:     "histogram_alias",
+			input:    "latency:7|h",
+			expected: Metric{Name: "latency", Type: TypeTimer, Value: 7, SampleRate: 1},
+		},
+		{
+			name:     "name_with_colon",
+			input:    "app:requests:1|c",
+			expected: Metric{Name: "app:requests", Type: TypeCounter, Value: 1, SampleRate: 1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := ParseLine(tc.input)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, m)
+		})
+	}
+}
+
+func TestParseLine_Invalid(t *testing.T) {
+	invalid := []string{
+		"requests",
+		"requests:1",
+		":1|c",
+		"requests:x|c",
+		"requests:NaN|g",
+		"requests:1|s",
+		"requests:1|c|@0",
+		"requests:1|c|@2",
+		"requests:1|c|@1e-300",
+		"latency:1|ms|@1e-9",
+		"requests:1|c|extra",
+		"requests:1|c|#host",
+		"requests:1|c|#1host:a",
+		"req{uests:1|c",
+	}
+
+	for _, s := range invalid {
+		_, err := ParseLine(s)
+		assert.ErrorIs(t, err, ErrParse, s)
+	}
+}
@@ -0,0 +1,210 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/am0xff/metrics/internal/models"
+)
+
+// UpdateOp - вид изменения серии.
+type UpdateOp uint8
+
+const (
+	// OpSet устанавливает значение gauge или увеличивает counter на Delta.
+	OpSet UpdateOp = iota
+	// OpDelete удаляет серию.
+	OpDelete
+	// OpReset сбрасывает значение counter в 0.
+	OpReset
+)
+
+// Update представляет изменение одной серии в пакете обновлений.
+type Update struct {
+	Type  MetricType
+	Key   string    // ключ серии, построенный SeriesKey
+	Value Gauge     // новое значение для gauge
+	Delta Counter   // приращение для counter
+	Hist  Histogram // наблюдения, прибавляемые к histogram
+	Op    UpdateOp  // вид изменения, по умолчанию OpSet
+}
+
+// NewUpdates проверяет все метрики пакета и преобразует их в изменения серий.
+// Если хотя бы одна метрика недопустима (пустое имя, неизвестный тип,
+// недопустимые метки, отсутствует или недопустимо значение), возвращает
+// ошибку ErrInvalid с номером метрики в пакете. Значение histogram
+// преобразуется функцией NewHistogramDelta.
+//
+// Пример использования:
+//
+//	updates, err := storage.NewUpdates(metrics)
+//	if err != nil {
+//		return err // пакет не применяется целиком
+//	}
+func NewUpdates(metrics []models.Metrics) ([]Update, error) {
+	updates := make([]Update, 0, len(metrics))
+	for i, m := range metrics {
+		if err := ValidateSeries(m.ID, m.Labels); err != nil {
+			return nil, fmt.Errorf("%w: metric %d: %w", ErrInvalid, i, err)
+		}
+
+		u := Update{Type: m.MType, Key: SeriesKey(m.ID, m.Labels)}
+		switch m.MType {
+		case MetricTypeGauge:
+			if m.Value == nil {
+				return nil, fmt.Errorf("%w: metric %d: missing value", ErrInvalid, i)
+			}
+			u.Value = Gauge(*m.Value)
+		case MetricTypeCounter:
+			if m.Delta == nil {
+				return nil, fmt.Errorf("%w: metric %d: missing delta", ErrInvalid, i)
+			}
+			u.Delta = Counter(*m.Delta)
+		case MetricTypeHistogram:
+			h, err := NewHistogramDelta(m.Histogram)
+			if err != nil {
+				return nil, fmt.Errorf("%w: metric %d: %w", ErrInvalid, i, err)
+			}
+			u.Hist = h
+		default:
+			return nil, fmt.Errorf("%w: metric %d: unknown type %q", ErrInvalid, i, m.MType)
+		}
+		updates = append(updates, u)
+	}
+	return updates, nil
+}
+
+// UpdateBatchOrEach записывает метрики в sp одним пакетом. Если пакет отклонен
+// как некорректный (ErrInvalid), метрики записываются по одной, чтобы ошибка
+// одной серии не отбросила остальные: для каждой не записанной метрики
+// вызывается failed с ошибкой хранилища.
+//
+// Возвращает ошибку записи пакета, если она не ErrInvalid; в этом случае
+// ни одна метрика не записана.
+//
+// Пример использования:
+//
+//	var errs []error
+//	err := storage.UpdateBatchOrEach(ctx, sp, metrics, func(m models.Metrics, err error) {
+//		errs = append(errs, fmt.Errorf("%s: %w", storage.SeriesKey(m.ID, m.Labels), err))
+//	})
+//	if err != nil {
+//		return err // хранилище недоступно
+//	}
+//	return errors.Join(errs...)
+func UpdateBatchOrEach(ctx context.Context, sp StorageProvider, metrics []models.Metrics, failed func(m models.Metrics, err error)) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	err := sp.UpdateBatch(ctx, metrics)
+	if !errors.Is(err, ErrInvalid) {
+		return err
+	}
+	for _, m := range metrics {
+		if err := sp.UpdateBatch(ctx, []models.Metrics{m}); err != nil {
+			failed(m, err)
+		}
+	}
+	return nil
+}
+
+// ApplyBatch применяет пакет изменений к хранилищам gauge и counter метрик
+// как одну операцию: на время применения блокируются все затронутые сегменты,
+// поэтому ни чтение, ни снимок не видят пакет частично.
+//
+// Возвращает значения серий после каждого изменения: для gauge - установленное
+// значение, для counter - накопленное значение счетчика, для удаленной серии - 0.
+// Изменения histogram пропускаются (см. Histograms.Apply), их значение - 0.
+func ApplyBatch(gauges *Storage[Gauge], counters *Storage[Counter], updates []Update) []float64 {
+	var gaugeShards, counterShards []int
+	for _, u := range updates {
+		switch u.Type {
+		case MetricTypeGauge:
+			gaugeShards = append(gaugeShards, shardIndex(u.Key))
+		case MetricTypeCounter:
+			counterShards = append(counterShards, shardIndex(u.Key))
+		}
+	}
+	// Сегменты блокируются в порядке возрастания номеров, сначала gauge,
+	// затем counter - в том же порядке, что и в TakeSnapshot.
+	gaugeShards = uniqueSorted(gaugeShards)
+	counterShards = uniqueSorted(counterShards)
+	for _, i := range gaugeShards {
+		gauges.shards[i].mu.Lock()
+	}
+	for _, i := range counterShards {
+		counters.shards[i].mu.Lock()
+	}
+	defer func() {
+		for _, i := range gaugeShards {
+			gauges.shards[i].mu.Unlock()
+		}
+		for _, i := range counterShards {
+			counters.shards[i].mu.Unlock()
+		}
+	}()
+
+	values := make([]float64, len(updates))
+	for i, u := range updates {
+		switch {
+		case u.Type == MetricTypeHistogram:
+		case u.Op == OpDelete && u.Type == MetricTypeGauge:
+			delete(gauges.shard(u.Key).data, u.Key)
+		case u.Op == OpDelete:
+			delete(counters.shard(u.Key).data, u.Key)
+		case u.Op == OpReset:
+			counters.cellLocked(u.Key).Store(encode(Counter(0)))
+		case u.Type == MetricTypeGauge:
+			gauges.cellLocked(u.Key).Store(encode(u.Value))
+			values[i] = float64(u.Value)
+		default:
+			values[i] = float64(decode[Counter](counters.cellLocked(u.Key).Add(uint64(u.Delta))))
+		}
+	}
+	return values
+}
+
+// Apply применяет изменения gauge и counter к снимку.
+func (s Snapshot) Apply(updates []Update) {
+	for _, u := range updates {
+		switch {
+		case u.Type == MetricTypeHistogram:
+		case u.Op == OpDelete && u.Type == MetricTypeGauge:
+			delete(s.Gauges, u.Key)
+		case u.Op == OpDelete:
+			delete(s.Counters, u.Key)
+		case u.Op == OpReset:
+			s.Counters[u.Key] = 0
+		case u.Type == MetricTypeGauge:
+			s.Gauges[u.Key] = u.Value
+		default:
+			s.Counters[u.Key] += u.Delta
+		}
+	}
+}
+
+// cellLocked возвращает ячейку ключа, создавая ее при необходимости.
+// Сегмент ключа должен быть заблокирован на запись.
+func (s *Storage[T]) cellLocked(key string) *atomic.Uint64 {
+	sh := s.shard(key)
+	cell, ok := sh.data[key]
+	if !ok {
+		cell = new(atomic.Uint64)
+		sh.data[key] = cell
+	}
+	return cell
+}
+
+func uniqueSorted(a []int) []int {
+	sort.Ints(a)
+	out := a[:0]
+	for _, v := range a {
+		if len(out) == 0 || out[len(out)-1] != v {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeMetric(id string, v float64) models.Metrics {
+	return models.Metrics{ID: id, MType: MetricTypeGauge, Value: &v}
+}
+
+func counterMetric(id string, d int64) models.Metrics {
+	return models.Metrics{ID: id, MType: MetricTypeCounter, Delta: &d}
+}
+
+func TestNewUpdates(t *testing.T) {
+	updates, err := NewUpdates([]models.Metrics{
+		gaugeMetric("Alloc", 1.5),
+		{ID: "Alloc", MType: MetricTypeGauge, Labels: map[string]string{"host": "web-1"}, Value: func() *float64 { v := 2.5; return &v }()},
+		counterMetric("PollCount", 3),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []Update{
+		{Type: MetricTypeGauge, Key: "Alloc", Value: 1.5},
+		{Type: MetricTypeGauge, Key: `Alloc{host="web-1"}`, Value: 2.5},
+		{Type: MetricTypeCounter, Key: "PollCount", Delta: 3},
+	}, updates)
+
+	invalid := []models.Metrics{
+		{ID: "", MType: MetricTypeGauge},
+		{ID: "Alloc", MType: MetricTypeGauge},
+		{ID: "PollCount", MType: MetricTypeCounter},
+		{ID: "x", MType: "unknown"},
+		{ID: "x", MType: MetricTypeGauge, Labels: map[string]string{"bad key": "v"}, Value: func() *float64 { v := 1.0; return &v }()},
+	}
+	for _, m := range invalid {
+		_, err := NewUpdates([]models.Metrics{gaugeMetric("ok", 1), m})
+		assert.ErrorIs(t, err, ErrInvalid, "%+v", m)
+		assert.ErrorContains(t, err, "metric 1")
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	gauges := NewStorage[Gauge]()
+	counters := NewStorage[Counter]()
+	counters.Set("PollCount", 10)
+
+	values := ApplyBatch(gauges, counters, []Update{
+		{Type: MetricTypeGauge, Key: "Alloc", Value: 1},
+		{Type: MetricTypeCounter, Key: "PollCount", Delta: 2},
+		{Type: MetricTypeGauge, Key: "Alloc", Value: 3},
+		{Type: MetricTypeCounter, Key: "PollCount", Delta: 5},
+	})
+	assert.Equal(t, []float64{1, 12, 3, 17}, values)
+
+	snap := TakeSnapshot(gauges, counters)
+	assert.Equal(t, map[string]Gauge{"Alloc": 3}, snap.Gauges)
+	assert.Equal(t, map[string]Counter{"PollCount": 17}, snap.Counters)
+}
+
+func TestSnapshot_Apply(t *testing.T) {
+	snap := Snapshot{
+		Gauges:   map[string]Gauge{"Alloc": 1},
+		Counters: map[string]Counter{"PollCount": 1},
+	}
+	snap.Apply([]Update{
+		{Type: MetricTypeGauge, Key: "Alloc", Value: 2},
+		{Type: MetricTypeCounter, Key: "PollCount", Delta: 4},
+		{Type: MetricTypeCounter, Key: "Errors", Delta: 1},
+	})
+	assert.Equal(t, map[string]Gauge{"Alloc": 2}, snap.Gauges)
+	assert.Equal(t, map[string]Counter{"PollCount": 5, "Errors": 1}, snap.Counters)
+}
+
+func TestApplyBatch_DeleteAndReset(t *testing.T) {
+	gauges := NewStorage[Gauge]()
+	counters := NewStorage[Counter]()
+	gauges.Set("Alloc", 1)
+	counters.Set("PollCount", 10)
+	counters.Set("Errors", 3)
+
+	ApplyBatch(gauges, counters, []Update{
+		{Type: MetricTypeGauge, Key: "Alloc", Op: OpDelete},
+		{Type: MetricTypeCounter, Key: "Errors", Op: OpDelete},
+		{Type: MetricTypeCounter, Key: "PollCount", Op: OpReset},
+		{Type: MetricTypeCounter, Key: "PollCount", Delta: 2},
+	})
+
+	snap := TakeSnapshot(gauges, counters)
+	assert.Empty(t, snap.Gauges)
+	assert.Equal(t, map[string]Counter{"PollCount": 2}, snap.Counters)
+
+	saved := Snapshot{
+		Gauges:   map[string]Gauge{"Alloc": 1},
+		Counters: map[string]Counter{"PollCount": 10, "Errors": 3},
+	}
+	saved.Apply([]Update{
+		{Type: MetricTypeGauge, Key: "Alloc", Op: OpDelete},
+		{Type: MetricTypeCounter, Key: "Errors", Op: OpDelete},
+		{Type: MetricTypeCounter, Key: "PollCount", Op: OpReset},
+	})
+	assert.Empty(t, saved.Gauges)
+	assert.Equal(t, map[string]Counter{"PollCount": 0}, saved.Counters)
+}
+
+// batchProvider отклоняет пакеты с метрикой invalid как некорректные.
+type batchProvider struct {
+	StorageProvider
+	err     error
+	written []string
+}
+
+func (p *batchProvider) UpdateBatch(_ context.Context, metrics []models.Metrics) error {
+	if p.err != nil {
+		return p.err
+	}
+	for _, m := range metrics {
+		if m.ID == "invalid" {
+			return fmt.Errorf("%w: metric %s", ErrInvalid, m.ID)
+		}
+	}
+	for _, m := range metrics {
+		p.written = append(p.written, m.ID)
+	}
+	return nil
+}
+
+func TestUpdateBatchOrEach(t *testing.T) {
+	ctx := context.Background()
+	var failed []string
+	onFailed := func(m models.Metrics, err error) {
+		assert.ErrorIs(t, err, ErrInvalid)
+		failed = append(failed, m.ID)
+	}
+
+	// Пакет записывается целиком
+	p := &batchProvider{}
+	require.NoError(t, UpdateBatchOrEach(ctx, p, []models.Metrics{gaugeMetric("a", 1), counterMetric("b", 1)}, onFailed))
+	assert.Equal(t, []string{"a", "b"}, p.written)
+	assert.Empty(t, failed)
+
+	// Некорректная метрика не отбрасывает остальные
+	p = &batchProvider{}
+	require.NoError(t, UpdateBatchOrEach(ctx, p, []models.Metrics{gaugeMetric("a", 1), gaugeMetric("invalid", 1), counterMetric("b", 1)}, onFailed))
+	assert.Equal(t, []string{"a", "b"}, p.written)
+	assert.Equal(t, []string{"invalid"}, failed)
+
+	// Прочие ошибки пакета возвращаются
+	p = &batchProvider{err: ErrUnavailable}
+	assert.ErrorIs(t, UpdateBatchOrEach(ctx, p, []models.Metrics{gaugeMetric("a", 1)}, onFailed), ErrUnavailable)
+	assert.Empty(t, p.written)
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeleteFilter выбирает метрики для массового удаления. Серия соответствует
+// фильтру, если ее имя начинается с Prefix и она содержит все метки Labels.
+// Хотя бы одно из условий Prefix и Labels должно быть задано, чтобы
+// пустой фильтр случайно не удалил все метрики.
+//
+// Пример использования:
+//
+//	// Удаление всех серий хоста web-1
+//	n, err := sp.DeleteMatching(ctx, storage.DeleteFilter{
+//		Labels: map[string]string{"host": "web-1"},
+//	})
+type DeleteFilter struct {
+	Type   MetricType        // тип метрик; пустое значение - метрики всех типов
+	Prefix string            // префикс имени метрики
+	Labels map[string]string // метки, которые должна содержать серия
+}
+
+// Validate проверяет фильтр. Возвращает ErrInvalid для неизвестного типа
+// метрики или если не задано ни одно условие.
+func (f DeleteFilter) Validate() error {
+	switch f.Type {
+	case "", MetricTypeGauge, MetricTypeCounter, MetricTypeHistogram:
+	default:
+		return fmt.Errorf("%w: unsupported metric type: %s", ErrInvalid, f.Type)
+	}
+	if f.Prefix == "" && len(f.Labels) == 0 {
+		return fmt.Errorf("%w: empty delete filter", ErrInvalid)
+	}
+	return nil
+}
+
+// Types возвращает типы метрик, к которым применяется фильтр.
+func (f DeleteFilter) Types() []MetricType {
+	if f.Type != "" {
+		return []MetricType{f.Type}
+	}
+	return MetricTypes()
+}
+
+// Match проверяет, соответствует ли серия с ключом key фильтру.
+// Ключи, которые не удается разобрать, считаются сериями без меток.
+func (f DeleteFilter) Match(key string) bool {
+	name, labels, err := ParseSeriesKey(key)
+	if err != nil {
+		name, labels = key, nil
+	}
+	return strings.HasPrefix(name, f.Prefix) && MatchLabels(labels, f.Labels)
+}
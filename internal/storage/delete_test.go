@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteFilter_Validate(t *testing.T) {
+	assert.NoError(t, DeleteFilter{Prefix: "disk_"}.Validate())
+	assert.NoError(t, DeleteFilter{Type: MetricTypeGauge, Labels: map[string]string{"host": "web-1"}}.Validate())
+	assert.ErrorIs(t, DeleteFilter{}.Validate(), ErrInvalid)
+	assert.ErrorIs(t, DeleteFilter{Type: MetricTypeGauge}.Validate(), ErrInvalid)
+	assert.ErrorIs(t, DeleteFilter{Type: "unknown", Prefix: "disk_"}.Validate(), ErrInvalid)
+}
+
+func TestDeleteFilter_Match(t *testing.T) {
+	f := DeleteFilter{Prefix: "disk_", Labels: map[string]string{"host": "web-1"}}
+
+	assert.True(t, f.Match(`disk_free{host="web-1"}`))
+	assert.True(t, f.Match(`disk_used{dev="sda",host="web-1"}`))
+	assert.False(t, f.Match(`disk_free{host="web-2"}`))
+	assert.False(t, f.Match(`cpu{host="web-1"}`))
+	assert.False(t, f.Match("disk_free"))
+
+	assert.True(t, DeleteFilter{Prefix: "disk_"}.Match("disk_free"))
+	assert.Equal(t, []MetricType{MetricTypeCounter}, DeleteFilter{Type: MetricTypeCounter}.Types())
+	assert.Equal(t, MetricTypes(), DeleteFilter{}.Types())
+	assert.NoError(t, DeleteFilter{Type: MetricTypeHistogram, Prefix: "x"}.Validate())
+}
@@ -0,0 +1,28 @@
+package storage
+
+import "errors"
+
+// Ошибки, возвращаемые реализациями StorageProvider. Реализации могут
+// оборачивать их, добавляя подробности, поэтому проверять ошибки следует
+// через errors.Is.
+//
+// Пример использования:
+//
+//	v, err := sp.GetGauge(ctx, "Alloc")
+//	switch {
+//	case errors.Is(err, storage.ErrNotFound):
+//		// метрика еще не записывалась
+//	case errors.Is(err, storage.ErrUnavailable):
+//		// хранилище временно недоступно, запрос можно повторить
+//	}
+var (
+	// ErrNotFound - метрика с указанным ключом отсутствует.
+	ErrNotFound = errors.New("metric not found")
+
+	// ErrUnavailable - хранилище недоступно, операция не выполнена.
+	// Для операций записи означает, что значение не сохранено.
+	ErrUnavailable = errors.New("storage unavailable")
+
+	// ErrInvalid - недопустимые аргументы: тип метрики, ключ или значение.
+	ErrInvalid = errors.New("invalid metric")
+)
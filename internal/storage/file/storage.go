@@ -3,9 +3,14 @@ package file
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
 	memstorage "github.com/am0xff/metrics/internal/storage/memory"
 	"github.com/am0xff/metrics/internal/utils"
@@ -15,126 +20,464 @@ type Config struct {
 	Restore         bool
 	FileStoragePath string
 	StoreInterval   int
+	WALSync         SyncMode      // режим fsync журнала, по умолчанию DefaultSyncMode
+	WALSyncInterval time.Duration // период fsync в режиме SyncInterval, по умолчанию 1с
 }
 
 type DumpStorage struct {
-	Gauges   map[string]storage.Gauge   `json:"gauges,omitempty"`
-	Counters map[string]storage.Counter `json:"counters,omitempty"`
+	Gauges            map[string]storage.Gauge     `json:"gauges,omitempty"`
+	Counters          map[string]storage.Counter   `json:"counters,omitempty"`
+	Histograms        map[string]storage.Histogram `json:"histograms,omitempty"`
+	GaugesUpdated     map[string]time.Time         `json:"gauges_updated,omitempty"`     // время последнего обновления gauge
+	CountersUpdated   map[string]time.Time         `json:"counters_updated,omitempty"`   // время последнего обновления counter
+	HistogramsUpdated map[string]time.Time         `json:"histograms_updated,omitempty"` // время последнего обновления histogram
+	WALSeq            uint64                       `json:"wal_seq,omitempty"`            // номер последней записи журнала, учтенной в снимке
 }
 
+// FileStorage хранит метрики в памяти и сохраняет их на диск: каждое изменение
+// сначала дописывается в журнал FileStoragePath + ".wal", а снимок
+// FileStoragePath периодически перезаписывается атомарно, после чего
+// учтенные в нем записи удаляются из журнала.
 type FileStorage struct {
 	ms  *memstorage.MemStorage
 	cfg Config
-	ctx context.Context
+
+	saveMu sync.Mutex // сериализует запись снимка из обработчиков и тикера
+	walMu  sync.Mutex // сериализует запись в журнал вместе с применением в памяти
+	wal    *wal       // nil, если путь к файлу не задан
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
 }
 
+// NewStorage создает хранилище. При cfg.Restore загружает снимок и повторно
+// применяет записи журнала, сделанные после него; иначе журнал очищается.
+// Оборванная при сбое последняя запись журнала отбрасывается.
 func NewStorage(ctx context.Context, cfg Config) (*FileStorage, error) {
 	fs := &FileStorage{
-		cfg: cfg,
-		ms:  memstorage.NewStorage(),
-		ctx: ctx,
+		cfg:  cfg,
+		ms:   memstorage.NewStorage(),
+		done: make(chan struct{}),
 	}
-
-	if !cfg.Restore {
+	if cfg.FileStoragePath == "" {
 		return fs, nil
 	}
 
-	//data, err := os.ReadFile(cfg.FileStoragePath)
-	//if os.IsNotExist(err) {
-	//	return fs, nil
-	//}
-	//if err != nil {
-	//	return nil, err
-	//}
+	dump, err := readSnapshot(ctx, cfg.FileStoragePath)
+	if err != nil && cfg.Restore {
+		return nil, err
+	}
+
+	w, records, err := openWAL(cfg.FileStoragePath + ".wal")
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	fs.wal = w
+
+	if cfg.Restore {
+		for k, v := range dump.Gauges {
+			if err := fs.ms.SetGauge(ctx, k, v); err != nil {
+				w.close()
+				return nil, err
+			}
+		}
+		for k, v := range dump.Counters {
+			if err := fs.ms.SetCounter(ctx, k, v); err != nil {
+				w.close()
+				return nil, err
+			}
+		}
+		for k, h := range dump.Histograms {
+			fs.ms.Histograms.Set(k, h)
+			fs.ms.HistogramsUpdated.Set(k, time.Now())
+		}
+		// Серии из снимков, сделанных до появления времени обновления,
+		// считаются обновленными в момент восстановления
+		for k, ts := range dump.GaugesUpdated {
+			if _, ok := dump.Gauges[k]; ok {
+				fs.ms.GaugesUpdated.Set(k, ts)
+			}
+		}
+		for k, ts := range dump.CountersUpdated {
+			if _, ok := dump.Counters[k]; ok {
+				fs.ms.CountersUpdated.Set(k, ts)
+			}
+		}
+		for k, ts := range dump.HistogramsUpdated {
+			if _, ok := dump.Histograms[k]; ok {
+				fs.ms.HistogramsUpdated.Set(k, ts)
+			}
+		}
+		for _, rec := range records {
+			if rec.Seq <= dump.WALSeq {
+				continue
+			}
+			ts := rec.Time
+			if ts.IsZero() {
+				ts = time.Now()
+			}
+			if err := fs.ms.ApplyUpdatesAt(rec.Updates, ts); err != nil {
+				log.Printf("Skip WAL record %d: %v", rec.Seq, err)
+			}
+		}
+	} else if err := w.reset(); err != nil {
+		w.close()
+		return nil, fmt.Errorf("reset wal: %w", err)
+	}
+
+	// Номера записей продолжают нумерацию снимка, иначе после очистки
+	// журнала новые записи были бы пропущены при восстановлении
+	if w.seq < dump.WALSeq {
+		w.seq = dump.WALSeq
+	}
+
+	if fs.syncMode() == SyncInterval {
+		fs.wg.Add(1)
+		go fs.syncLoop(ctx)
+	}
+
+	return fs, nil
+}
+
+func (fs *FileStorage) syncMode() SyncMode {
+	if fs.cfg.WALSync == "" {
+		return DefaultSyncMode
+	}
+	return fs.cfg.WALSync
+}
+
+// readSnapshot читает снимок хранилища. Отсутствующий файл означает пустой снимок.
+func readSnapshot(ctx context.Context, path string) (DumpStorage, error) {
+	var d DumpStorage
+
 	var data []byte
 	if err := utils.Call(ctx, func() error {
 		var err error
-		data, err = os.ReadFile(cfg.FileStoragePath)
+		data, err = os.ReadFile(path)
 		return err
 	}); err != nil {
 		if os.IsNotExist(err) {
-			return fs, nil
+			return d, nil
 		}
-		return nil, err
+		return d, err
 	}
 
-	var d DumpStorage
 	if err := json.Unmarshal(data, &d); err != nil {
-		return nil, err
+		return DumpStorage{}, err
 	}
+	return d, nil
+}
 
-	for k, v := range d.Gauges {
-		fs.ms.SetGauge(ctx, k, v)
-	}
-	for k, v := range d.Counters {
-		fs.ms.SetCounter(ctx, k, v)
+// syncLoop периодически сбрасывает журнал на диск в режиме SyncInterval.
+func (fs *FileStorage) syncLoop(ctx context.Context) {
+	defer fs.wg.Done()
+
+	interval := fs.cfg.WALSyncInterval
+	if interval <= 0 {
+		interval = time.Second
 	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	return fs, nil
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fs.done:
+			return
+		case <-ticker.C:
+			fs.walMu.Lock()
+			if !fs.closed {
+				if err := fs.wal.sync(); err != nil {
+					log.Printf("Sync WAL: %v", err)
+				}
+			}
+			fs.walMu.Unlock()
+		}
+	}
 }
 
-func (fs *FileStorage) GetGauge(ctx context.Context, key string) (storage.Gauge, bool) {
+func (fs *FileStorage) GetGauge(ctx context.Context, key string) (storage.Gauge, error) {
 	return fs.ms.GetGauge(ctx, key)
 }
 
-func (fs *FileStorage) KeysGauge(ctx context.Context) []string {
+func (fs *FileStorage) KeysGauge(ctx context.Context) ([]string, error) {
 	return fs.ms.KeysGauge(ctx)
 }
 
-func (fs *FileStorage) GetCounter(ctx context.Context, key string) (storage.Counter, bool) {
+func (fs *FileStorage) GetCounter(ctx context.Context, key string) (storage.Counter, error) {
 	return fs.ms.GetCounter(ctx, key)
 }
 
-func (fs *FileStorage) KeysCounter(ctx context.Context) []string {
+func (fs *FileStorage) KeysCounter(ctx context.Context) ([]string, error) {
 	return fs.ms.KeysCounter(ctx)
 }
 
-func (fs *FileStorage) SetGauge(ctx context.Context, key string, value storage.Gauge) {
-	fs.ms.SetGauge(ctx, key, value)
-	if fs.cfg.StoreInterval == 0 {
-		if err := fs.Save(); err != nil {
-			log.Printf("SetGauge %v", err)
-		}
+func (fs *FileStorage) GetHistogram(ctx context.Context, key string) (storage.Histogram, error) {
+	return fs.ms.GetHistogram(ctx, key)
+}
+
+func (fs *FileStorage) KeysHistogram(ctx context.Context) ([]string, error) {
+	return fs.ms.KeysHistogram(ctx)
+}
+
+// SetGauge устанавливает значение gauge метрики. Ошибка записи в журнал
+// возвращается как ErrUnavailable, значение при этом не изменяется.
+func (fs *FileStorage) SetGauge(_ context.Context, key string, value storage.Gauge) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
 	}
+	return fs.apply([]storage.Update{{Type: storage.MetricTypeGauge, Key: key, Value: value}})
 }
 
-func (fs *FileStorage) SetCounter(ctx context.Context, key string, value storage.Counter) {
-	fs.ms.SetCounter(ctx, key, value)
-	if fs.cfg.StoreInterval == 0 {
-		if err := fs.Save(); err != nil {
-			log.Printf("SetCounter %v", err)
+// SetCounter увеличивает значение counter метрики. Ошибка записи в журнал
+// возвращается как ErrUnavailable, значение при этом не изменяется.
+func (fs *FileStorage) SetCounter(_ context.Context, key string, value storage.Counter) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
+	}
+	return fs.apply([]storage.Update{{Type: storage.MetricTypeCounter, Key: key, Delta: value}})
+}
+
+// apply записывает изменения в журнал и только затем применяет их в памяти.
+func (fs *FileStorage) apply(updates []storage.Update) error {
+	return fs.applyFunc(func() ([]storage.Update, error) {
+		return updates, nil
+	})
+}
+
+// applyFunc вызывает build и записывает полученные изменения в журнал,
+// а затем применяет их в памяти. Журнал и память изменяются под одной
+// блокировкой, поэтому порядок записей в журнале совпадает с порядком
+// применения, а build видит состояние, к которому изменения будут применены.
+// Изменения histogram проверяются до записи в журнал, поэтому в журнал
+// не попадают изменения, которые нельзя применить.
+func (fs *FileStorage) applyFunc(build func() ([]storage.Update, error)) error {
+	if fs.wal == nil {
+		updates, err := build()
+		if err != nil {
+			return err
 		}
+		return fs.ms.ApplyUpdates(updates)
+	}
+
+	fs.walMu.Lock()
+	if fs.closed {
+		fs.walMu.Unlock()
+		return fmt.Errorf("%w: storage closed", storage.ErrUnavailable)
+	}
+	updates, err := build()
+	if err != nil {
+		fs.walMu.Unlock()
+		return err
+	}
+	if len(updates) == 0 {
+		fs.walMu.Unlock()
+		return nil
+	}
+	if err := fs.ms.Histograms.Check(updates); err != nil {
+		fs.walMu.Unlock()
+		return err
 	}
+	now := time.Now()
+	if _, err := fs.wal.append(updates, now, fs.syncMode()); err != nil {
+		fs.walMu.Unlock()
+		return fmt.Errorf("%w: write wal: %w", storage.ErrUnavailable, err)
+	}
+	err = fs.ms.ApplyUpdatesAt(updates, now)
+	fs.walMu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fs.saveSync()
+	return nil
 }
 
-func (fs *FileStorage) MarshalJSON() ([]byte, error) {
-	gauges := make(map[string]storage.Gauge)
-	for _, k := range fs.ms.KeysGauge(fs.ctx) {
-		if v, ok := fs.ms.GetGauge(fs.ctx, k); ok {
-			gauges[k] = v
+// Delete удаляет метрику типа mtype по ключу вместе с ее историей.
+func (fs *FileStorage) Delete(_ context.Context, mtype storage.MetricType, key string) error {
+	return fs.applyFunc(func() ([]storage.Update, error) {
+		var ok bool
+		switch mtype {
+		case storage.MetricTypeGauge:
+			_, ok = fs.ms.Gauges.Get(key)
+		case storage.MetricTypeCounter:
+			_, ok = fs.ms.Counters.Get(key)
+		case storage.MetricTypeHistogram:
+			_, ok = fs.ms.Histograms.Get(key)
+		default:
+			return nil, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+		}
+		if !ok {
+			return nil, storage.ErrNotFound
 		}
+		return []storage.Update{{Type: mtype, Key: key, Op: storage.OpDelete}}, nil
+	})
+}
+
+// DeleteMatching удаляет все метрики, соответствующие фильтру f,
+// одной записью журнала.
+func (fs *FileStorage) DeleteMatching(_ context.Context, f storage.DeleteFilter) (int, error) {
+	var n int
+	err := fs.applyFunc(func() ([]storage.Update, error) {
+		updates, err := fs.ms.MatchingDeletes(f)
+		n = len(updates)
+		return updates, err
+	})
+	if err != nil {
+		return 0, err
 	}
-	counters := make(map[string]storage.Counter)
-	for _, k := range fs.ms.KeysCounter(fs.ctx) {
-		if v, ok := fs.ms.GetCounter(fs.ctx, k); ok {
-			counters[k] = v
+	return n, nil
+}
+
+// ResetCounter сбрасывает значение counter метрики в 0.
+func (fs *FileStorage) ResetCounter(_ context.Context, key string) error {
+	return fs.applyFunc(func() ([]storage.Update, error) {
+		if _, ok := fs.ms.Counters.Get(key); !ok {
+			return nil, storage.ErrNotFound
 		}
+		return []storage.Update{{Type: storage.MetricTypeCounter, Key: key, Op: storage.OpReset}}, nil
+	})
+}
+
+// LastUpdated возвращает время последнего обновления серий типа mtype.
+func (fs *FileStorage) LastUpdated(ctx context.Context, mtype storage.MetricType, keys ...string) (map[string]time.Time, error) {
+	return fs.ms.LastUpdated(ctx, mtype, keys...)
+}
+
+// DeleteStale удаляет серии keys типа mtype, не обновлявшиеся с момента before,
+// одной записью журнала. Проверка и удаление выполняются под блокировкой
+// журнала, без журнала - методом DeleteStale хранилища в памяти.
+func (fs *FileStorage) DeleteStale(ctx context.Context, mtype storage.MetricType, keys []string, before time.Time) (int, error) {
+	if fs.wal == nil {
+		return fs.ms.DeleteStale(ctx, mtype, keys, before)
+	}
+
+	var n int
+	err := fs.applyFunc(func() ([]storage.Update, error) {
+		updates, err := fs.ms.StaleDeletes(mtype, keys, before)
+		n = len(updates)
+		return updates, err
+	})
+	if err != nil {
+		return 0, err
 	}
+	return n, nil
+}
+
+// saveSync сохраняет снимок, если включена синхронная запись (StoreInterval == 0).
+// Изменения к этому моменту уже записаны в журнал, поэтому ошибка только логируется.
+func (fs *FileStorage) saveSync() {
+	if fs.cfg.StoreInterval != 0 {
+		return
+	}
+	if err := fs.Save(); err != nil {
+		log.Printf("Save storage to the file: %v", err)
+	}
+}
 
-	return json.Marshal(DumpStorage{gauges, counters})
+func (fs *FileStorage) QueryRange(ctx context.Context, mtype storage.MetricType, key string, from, to time.Time) ([]storage.Sample, error) {
+	return fs.ms.QueryRange(ctx, mtype, key, from, to)
 }
 
+func (fs *FileStorage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fs.dump(0))
+}
+
+// dump возвращает снимок значений и времени обновления всех серий.
+// Согласованность с журналом обеспечивает вызывающий, удерживая walMu.
+func (fs *FileStorage) dump(seq uint64) DumpStorage {
+	snap := fs.ms.Snapshot()
+	return DumpStorage{
+		Gauges:            snap.Gauges,
+		Counters:          snap.Counters,
+		Histograms:        fs.ms.Histograms.Snapshot(),
+		GaugesUpdated:     fs.ms.GaugesUpdated.Snapshot(),
+		CountersUpdated:   fs.ms.CountersUpdated.Snapshot(),
+		HistogramsUpdated: fs.ms.HistogramsUpdated.Snapshot(),
+		WALSeq:            seq,
+	}
+}
+
+// Save атомарно перезаписывает снимок: данные пишутся во временный файл,
+// сбрасываются на диск и переименовываются поверх снимка. После этого
+// записи журнала, учтенные в снимке, удаляются.
 func (fs *FileStorage) Save() error {
-	data, err := json.Marshal(fs)
+	fs.saveMu.Lock()
+	defer fs.saveMu.Unlock()
+
+	if fs.wal == nil {
+		return fs.write(fs.dump(0))
+	}
+
+	fs.walMu.Lock()
+	if fs.closed {
+		fs.walMu.Unlock()
+		return errors.New("storage closed")
+	}
+	d := fs.dump(fs.wal.seq)
+	offset := fs.wal.size
+	fs.walMu.Unlock()
+
+	if err := fs.write(d); err != nil {
+		return err
+	}
+
+	fs.walMu.Lock()
+	defer fs.walMu.Unlock()
+	if fs.closed {
+		return nil
+	}
+	if err := fs.wal.compact(offset); err != nil {
+		return fmt.Errorf("compact wal: %w", err)
+	}
+	return nil
+}
+
+// UpdateBatch применяет пакет метрик целиком: пакет проверяется,
+// записывается в журнал одной записью и затем применяется в памяти.
+// Ошибка записи в журнал возвращается как ErrUnavailable, хранилище
+// при этом не изменяется.
+func (fs *FileStorage) UpdateBatch(_ context.Context, metrics []models.Metrics) error {
+	updates, err := storage.NewUpdates(metrics)
+	if err != nil {
+		return err
+	}
+	return fs.apply(updates)
+}
+
+// write атомарно записывает снимок в файл хранилища.
+func (fs *FileStorage) write(d DumpStorage) error {
+	data, err := json.Marshal(d)
 	if err != nil {
 		return err
 	}
 
 	return utils.Call(context.Background(), func() error {
-		return os.WriteFile(fs.cfg.FileStoragePath, data, 0666)
+		return writeFileAtomic(fs.cfg.FileStoragePath, data)
 	})
 }
 
+// Close останавливает фоновый сброс журнала, сбрасывает журнал на диск
+// и закрывает его. После Close запись в хранилище возвращает ErrUnavailable.
+func (fs *FileStorage) Close() error {
+	fs.walMu.Lock()
+	if fs.closed {
+		fs.walMu.Unlock()
+		return nil
+	}
+	fs.closed = true
+	close(fs.done)
+	fs.walMu.Unlock()
+
+	fs.wg.Wait()
+	if fs.wal == nil {
+		return nil
+	}
+	return fs.wal.close()
+}
+
 func (fs *FileStorage) Ping(_ context.Context) error {
 	return nil
 }
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,13 +18,14 @@ func TestNewStorage_NoRestore(t *testing.T) {
 	ctx := context.Background()
 	cfg := Config{
 		Restore:         false,
-		FileStoragePath: "/tmp/test.json",
+		FileStoragePath: filepath.Join(t.TempDir(), "test.json"),
 		StoreInterval:   1,
 	}
 
 	fs, err := NewStorage(ctx, cfg)
 
 	require.NoError(t, err)
+	defer fs.Close()
 	assert.NotNil(t, fs)
 	assert.NotNil(t, fs.ms)
 	assert.Equal(t, cfg, fs.cfg)
@@ -67,12 +69,12 @@ func TestNewStorage_RestoreWithData(t *testing.T) {
 	require.NoError(t, err)
 
 	// Verify data was loaded
-	gauge, exists := fs.GetGauge(ctx, "gauge1")
-	assert.True(t, exists)
+	gauge, err := fs.GetGauge(ctx, "gauge1")
+	require.NoError(t, err)
 	assert.Equal(t, storage.Gauge(123.45), gauge)
 
-	counter, exists := fs.GetCounter(ctx, "counter1")
-	assert.True(t, exists)
+	counter, err := fs.GetCounter(ctx, "counter1")
+	require.NoError(t, err)
 	assert.Equal(t, storage.Counter(100), counter)
 }
 
@@ -106,13 +108,22 @@ func TestFileStorage_SetAndGetGauge(t *testing.T) {
 	fs.SetGauge(ctx, "test_gauge", storage.Gauge(42.5))
 
 	// Get gauge
-	value, exists := fs.GetGauge(ctx, "test_gauge")
-	assert.True(t, exists)
+	value, err := fs.GetGauge(ctx, "test_gauge")
+	require.NoError(t, err)
 	assert.Equal(t, storage.Gauge(42.5), value)
 
 	// Get non-existing gauge
-	_, exists = fs.GetGauge(ctx, "nonexistent")
-	assert.False(t, exists)
+	_, err = fs.GetGauge(ctx, "nonexistent")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestFileStorage_SetEmptyKey(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewStorage(ctx, Config{StoreInterval: 1})
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, fs.SetGauge(ctx, "", storage.Gauge(1)), storage.ErrInvalid)
+	assert.ErrorIs(t, fs.SetCounter(ctx, "", storage.Counter(1)), storage.ErrInvalid)
 }
 
 func TestFileStorage_SetAndGetCounter(t *testing.T) {
@@ -126,13 +137,13 @@ func TestFileStorage_SetAndGetCounter(t *testing.T) {
 	fs.SetCounter(ctx, "test_counter", storage.Counter(150))
 
 	// Get counter
-	value, exists := fs.GetCounter(ctx, "test_counter")
-	assert.True(t, exists)
+	value, err := fs.GetCounter(ctx, "test_counter")
+	require.NoError(t, err)
 	assert.Equal(t, storage.Counter(150), value)
 
 	// Get non-existing counter
-	_, exists = fs.GetCounter(ctx, "nonexistent")
-	assert.False(t, exists)
+	_, err = fs.GetCounter(ctx, "nonexistent")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
 
 func TestFileStorage_Keys(t *testing.T) {
@@ -149,13 +160,15 @@ func TestFileStorage_Keys(t *testing.T) {
 	fs.SetCounter(ctx, "counter2", storage.Counter(20))
 
 	// Check gauge keys
-	gaugeKeys := fs.KeysGauge(ctx)
+	gaugeKeys, err := fs.KeysGauge(ctx)
+	require.NoError(t, err)
 	assert.Len(t, gaugeKeys, 2)
 	assert.Contains(t, gaugeKeys, "gauge1")
 	assert.Contains(t, gaugeKeys, "gauge2")
 
 	// Check counter keys
-	counterKeys := fs.KeysCounter(ctx)
+	counterKeys, err := fs.KeysCounter(ctx)
+	require.NoError(t, err)
 	assert.Len(t, counterKeys, 2)
 	assert.Contains(t, counterKeys, "counter1")
 	assert.Contains(t, counterKeys, "counter2")
@@ -261,3 +274,48 @@ func TestFileStorage_Ping(t *testing.T) {
 	err = fs.Ping(ctx)
 	assert.NoError(t, err)
 }
+
+func TestFileStorage_UpdateBatch(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path})
+	require.NoError(t, err)
+
+	value, delta := 1.5, int64(2)
+	err = fs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: &delta},
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var d DumpStorage
+	require.NoError(t, json.Unmarshal(data, &d))
+	assert.Equal(t, map[string]storage.Gauge{"Alloc": 1.5}, d.Gauges)
+	assert.Equal(t, map[string]storage.Counter{"PollCount": 2}, d.Counters)
+
+	err = fs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &value},
+		{ID: "PollCount", MType: storage.MetricTypeGauge},
+	})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+}
+
+func TestFileStorage_UpdateBatch_WriteError(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 1})
+	require.NoError(t, err)
+	require.NoError(t, fs.Close())
+
+	delta := int64(1)
+	err = fs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: &delta},
+	})
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+
+	// Пакет не применен в памяти
+	_, err = fs.GetCounter(ctx, "PollCount")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
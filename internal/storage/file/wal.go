@@ -0,0 +1,352 @@
+package file
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+)
+
+// Журнал упреждающей записи (WAL) хранит изменения, примененные после
+// последнего снимка. Файл состоит из записей, по одной на операцию записи
+// (одиночное обновление или пакет целиком):
+//
+//	length   4 байта  длина seq и payload, big endian
+//	crc      4 байта  CRC-32C от seq и payload
+//	seq      8 байт   порядковый номер записи, big endian
+//	payload  ...      JSON-массив изменений
+//
+// Запись, которая не прочиталась целиком или не совпала с контрольной суммой,
+// считается оборванной при сбое: файл обрезается до последней целой записи.
+// Снимок хранит номер последней учтенной записи, поэтому при восстановлении
+// повторно применяются только записи с большим номером, даже если журнал
+// не был сжат после снимка.
+
+// SyncMode определяет, когда журнал сбрасывается на диск (fsync).
+type SyncMode string
+
+const (
+	// SyncAlways - fsync после каждой записи. Подтвержденная запись
+	// переживает сбой питания.
+	SyncAlways SyncMode = "always"
+	// SyncInterval - fsync не реже одного раза в WALSyncInterval.
+	// При сбое теряются записи за последний интервал.
+	SyncInterval SyncMode = "interval"
+	// SyncNever - fsync выполняет только операционная система.
+	SyncNever SyncMode = "never"
+
+	// DefaultSyncMode - режим fsync сервера по умолчанию (WAL_SYNC, -wal-sync).
+	DefaultSyncMode = SyncInterval
+)
+
+// ParseSyncMode проверяет название режима fsync. Пустая строка означает
+// DefaultSyncMode - тот же режим, что и при незаданном параметре сервера.
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch m := SyncMode(s); m {
+	case "":
+		return DefaultSyncMode, nil
+	case SyncAlways, SyncInterval, SyncNever:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown wal sync mode %q", s)
+	}
+}
+
+const walHeaderSize = 4 + 4 + 8
+
+var walTable = crc32.MakeTable(crc32.Castagnoli)
+
+// walEntry - изменение серии в записи журнала. TS - время получения
+// изменения в наносекундах Unix; в записях, сделанных до появления
+// поля, отсутствует. Hist - наблюдения histogram.
+type walEntry struct {
+	Type  storage.MetricType `json:"t"`
+	Key   string             `json:"k"`
+	Value storage.Gauge      `json:"v,omitempty"`
+	Delta storage.Counter    `json:"d,omitempty"`
+	Hist  *storage.Histogram `json:"h,omitempty"`
+	Op    storage.UpdateOp   `json:"o,omitempty"`
+	TS    int64              `json:"ts,omitempty"`
+}
+
+// walRecord - прочитанная из журнала запись.
+type walRecord struct {
+	Seq     uint64
+	Time    time.Time // время получения изменений; нулевое для старых записей
+	Updates []storage.Update
+}
+
+// wal - открытый на дозапись журнал. Методы не потокобезопасны,
+// вызывающий сериализует доступ.
+type wal struct {
+	path  string
+	f     *os.File
+	seq   uint64 // номер последней записи
+	size  int64  // размер файла до последней целой записи
+	dirty bool   // есть записи, не сброшенные на диск
+}
+
+// openWAL открывает журнал по пути path, создавая его при необходимости,
+// читает все целые записи и обрезает оборванный хвост.
+func openWAL(path string) (*wal, []walRecord, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	records, size, err := readWAL(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if info.Size() != size {
+		log.Printf("WAL %s: truncating torn tail at offset %d (%d bytes)", path, size, info.Size()-size)
+		if err := f.Truncate(size); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		if err := f.Sync(); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	w := &wal{path: path, f: f, size: size}
+	if len(records) > 0 {
+		w.seq = records[len(records)-1].Seq
+	}
+	return w, records, nil
+}
+
+// readWAL читает записи с начала файла до первой неполной или поврежденной
+// записи и возвращает их вместе со смещением ее начала.
+func readWAL(f *os.File) ([]walRecord, int64, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	r := bufio.NewReader(f)
+
+	var records []walRecord
+	var offset int64
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return records, offset, nil
+		}
+		length := binary.BigEndian.Uint32(header[:4])
+		sum := binary.BigEndian.Uint32(header[4:])
+		if length < 8 {
+			return records, offset, nil
+		}
+
+		body := make([]byte, length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return records, offset, nil
+		}
+		if crc32.Checksum(body, walTable) != sum {
+			return records, offset, nil
+		}
+
+		var entries []walEntry
+		if err := json.Unmarshal(body[8:], &entries); err != nil {
+			return records, offset, nil
+		}
+		rec := walRecord{Seq: binary.BigEndian.Uint64(body[:8]), Updates: make([]storage.Update, len(entries))}
+		for i, e := range entries {
+			rec.Updates[i] = storage.Update{Type: e.Type, Key: e.Key, Value: e.Value, Delta: e.Delta, Op: e.Op}
+			if e.Hist != nil {
+				rec.Updates[i].Hist = *e.Hist
+			}
+			if e.TS != 0 {
+				rec.Time = time.Unix(0, e.TS)
+			}
+		}
+		records = append(records, rec)
+		offset += int64(len(header)) + int64(length)
+	}
+}
+
+// append дописывает изменения, полученные в момент ts, одной записью
+// и возвращает ее номер. При ошибке файл обрезается до прежнего размера,
+// чтобы частичная запись не оказалась перед следующими.
+func (w *wal) append(updates []storage.Update, ts time.Time, mode SyncMode) (uint64, error) {
+	entries := make([]walEntry, len(updates))
+	for i, u := range updates {
+		entries[i] = walEntry{Type: u.Type, Key: u.Key, Value: u.Value, Delta: u.Delta, Op: u.Op, TS: ts.UnixNano()}
+		if u.Type == storage.MetricTypeHistogram && u.Op == storage.OpSet {
+			entries[i].Hist = &u.Hist
+		}
+	}
+	payload, err := json.Marshal(entries)
+	if err != nil {
+		return 0, err
+	}
+
+	seq := w.seq + 1
+	buf := make([]byte, walHeaderSize, walHeaderSize+len(payload))
+	binary.BigEndian.PutUint64(buf[8:], seq)
+	buf = append(buf, payload...)
+	binary.BigEndian.PutUint32(buf[:4], uint32(len(buf)-8))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.Checksum(buf[8:], walTable))
+
+	if _, err := w.f.Write(buf); err != nil {
+		w.rollback()
+		return 0, err
+	}
+	if mode == SyncAlways {
+		if err := w.f.Sync(); err != nil {
+			w.rollback()
+			return 0, err
+		}
+	} else {
+		w.dirty = true
+	}
+
+	w.seq = seq
+	w.size += int64(len(buf))
+	return seq, nil
+}
+
+func (w *wal) rollback() {
+	if err := w.f.Truncate(w.size); err != nil {
+		log.Printf("WAL %s: truncate after failed write: %v", w.path, err)
+	}
+	if _, err := w.f.Seek(w.size, io.SeekStart); err != nil {
+		log.Printf("WAL %s: seek after failed write: %v", w.path, err)
+	}
+}
+
+// sync сбрасывает журнал на диск, если после прошлого сброса были записи.
+func (w *wal) sync() error {
+	if !w.dirty {
+		return nil
+	}
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+// compact удаляет из журнала записи до смещения offset, уже учтенные в снимке:
+// оставшиеся записи копируются во временный файл, который атомарно заменяет журнал.
+func (w *wal) compact(offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+
+	tail := make([]byte, w.size-offset)
+	if _, err := w.f.ReadAt(tail, offset); err != nil {
+		return err
+	}
+
+	tmp := w.path + ".tmp"
+	if err := writeFileSync(tmp, tail); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(w.path)
+
+	f, err := os.OpenFile(w.path, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+	w.f.Close()
+	w.f = f
+	w.size = int64(len(tail))
+	w.dirty = false
+	return nil
+}
+
+// reset очищает журнал, сохраняя нумерацию записей.
+func (w *wal) reset() error {
+	if err := w.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.size = 0
+	return w.f.Sync()
+}
+
+func (w *wal) close() error {
+	if err := w.sync(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// writeFileSync записывает данные в файл и сбрасывает его на диск.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeFileAtomic заменяет файл path данными data так, что при сбое
+// на диске остается либо старое, либо новое содержимое целиком.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(path)
+	return nil
+}
+
+// syncDir сбрасывает на диск каталог файла, чтобы переименование пережило сбой.
+// Не все платформы поддерживают fsync каталога, поэтому ошибка только логируется.
+func syncDir(path string) {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		log.Printf("open dir of %s: %v", path, err)
+		return
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		log.Printf("sync dir of %s: %v", path, err)
+	}
+}
@@ -0,0 +1,325 @@
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSyncMode(t *testing.T) {
+	for in, want := range map[string]SyncMode{
+		"":         SyncInterval,
+		"always":   SyncAlways,
+		"interval": SyncInterval,
+		"never":    SyncNever,
+	} {
+		got, err := ParseSyncMode(in)
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+
+	_, err := ParseSyncMode("sometimes")
+	assert.Error(t, err)
+
+	// Незаданный режим в Config совпадает с режимом сервера по умолчанию
+	fs := &FileStorage{}
+	assert.Equal(t, DefaultSyncMode, fs.syncMode())
+}
+
+func TestFileStorage_WALReplay(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetGauge(ctx, "Alloc", 1.5))
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, fs.Save())
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 3))
+	require.NoError(t, fs.SetGauge(ctx, "Alloc", 2.5))
+	// Сбой: хранилище не закрыто, снимок не обновлен
+
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	g, err := restored.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(2.5), g)
+	c, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(5), c)
+}
+
+func TestFileStorage_WALReplayDeletes(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetGauge(ctx, "Alloc", 1.5))
+	require.NoError(t, fs.SetGauge(ctx, `disk_free{host="web-1"}`, 1))
+	require.NoError(t, fs.SetGauge(ctx, `disk_free{host="web-2"}`, 2))
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, fs.SetCounter(ctx, "Errors", 1))
+	require.NoError(t, fs.Save())
+
+	require.NoError(t, fs.Delete(ctx, storage.MetricTypeGauge, "Alloc"))
+	n, err := fs.DeleteMatching(ctx, storage.DeleteFilter{Prefix: "disk_", Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, fs.ResetCounter(ctx, "PollCount"))
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 3))
+	assert.ErrorIs(t, fs.Delete(ctx, storage.MetricTypeCounter, "Unknown"), storage.ErrNotFound)
+	assert.ErrorIs(t, fs.ResetCounter(ctx, "Unknown"), storage.ErrNotFound)
+	// Сбой: хранилище не закрыто, снимок не обновлен
+
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	snap := restored.ms.Snapshot()
+	assert.Equal(t, map[string]storage.Gauge{`disk_free{host="web-2"}`: 2}, snap.Gauges)
+	assert.Equal(t, map[string]storage.Counter{"PollCount": 3, "Errors": 1}, snap.Counters)
+}
+
+func TestFileStorage_RestoresLastUpdated(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, fs.Save())
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, fs.Close())
+
+	gauges, err := fs.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	counters, err := fs.LastUpdated(ctx, storage.MetricTypeCounter)
+	require.NoError(t, err)
+
+	// Время обновления восстанавливается из снимка (Alloc) и из журнала (PollCount)
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	got, err := restored.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.True(t, gauges["Alloc"].Equal(got["Alloc"]))
+	got, err = restored.LastUpdated(ctx, storage.MetricTypeCounter)
+	require.NoError(t, err)
+	assert.True(t, counters["PollCount"].Equal(got["PollCount"]))
+
+	n, err := restored.DeleteStale(ctx, storage.MetricTypeCounter, []string{"PollCount"}, got["PollCount"].Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestFileStorage_WALReplayHistograms(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	latency := func(obs ...float64) []models.Metrics {
+		return []models.Metrics{{ID: "latency", MType: storage.MetricTypeHistogram, Histogram: &models.Histogram{
+			Buckets:      []models.Bucket{{Le: 0.1}, {Le: 1}},
+			Observations: obs,
+		}}}
+	}
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.UpdateBatch(ctx, latency(0.05)))
+	require.NoError(t, fs.Save())
+	require.NoError(t, fs.UpdateBatch(ctx, latency(0.5, 3)))
+
+	// Несовпадающие границы корзин отклоняются и не попадают в журнал
+	err = fs.UpdateBatch(ctx, []models.Metrics{{ID: "latency", MType: storage.MetricTypeHistogram, Histogram: &models.Histogram{
+		Buckets: []models.Bucket{{Le: 5}}, Observations: []float64{1},
+	}}})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	// Сбой: хранилище не закрыто, снимок не обновлен
+
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	h, err := restored.GetHistogram(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{1, 1, 1}, h.Counts)
+	assert.Equal(t, uint64(3), h.Count)
+	assert.InDelta(t, 3.55, h.Sum, 1e-9)
+}
+
+func TestFileStorage_SaveCompactsWAL(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	defer fs.Close()
+
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Positive(t, info.Size())
+
+	require.NoError(t, fs.Save())
+	info, err = os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+	assert.NoFileExists(t, path+".tmp")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var dump DumpStorage
+	require.NoError(t, json.Unmarshal(data, &dump))
+	assert.Equal(t, uint64(1), dump.WALSeq)
+	assert.Equal(t, storage.Counter(1), dump.Counters["PollCount"])
+}
+
+func TestFileStorage_SkipsRecordsInSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, fs.Close())
+
+	// Сбой после записи снимка, но до сжатия журнала: снимок учитывает
+	// первую запись, журнал содержит обе
+	dump, err := json.Marshal(DumpStorage{Counters: map[string]storage.Counter{"PollCount": 1}, WALSeq: 1})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, dump, 0666))
+
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	c, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(3), c)
+
+	// Нумерация продолжается после перезапуска
+	require.NoError(t, restored.SetCounter(ctx, "PollCount", 4))
+	assert.Equal(t, uint64(3), restored.wal.seq)
+}
+
+func TestFileStorage_TornTail(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+	require.NoError(t, fs.Close())
+
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	full := info.Size()
+
+	testCases := []struct {
+		name   string
+		damage func(t *testing.T)
+	}{
+		{"partial_record", func(t *testing.T) {
+			require.NoError(t, os.Truncate(path+".wal", full-3))
+		}},
+		{"partial_header", func(t *testing.T) {
+			f, err := os.OpenFile(path+".wal", os.O_WRONLY|os.O_APPEND, 0666)
+			require.NoError(t, err)
+			_, err = f.Write([]byte{0, 0})
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}},
+		{"bad_checksum", func(t *testing.T) {
+			f, err := os.OpenFile(path+".wal", os.O_WRONLY, 0666)
+			require.NoError(t, err)
+			_, err = f.WriteAt([]byte("x"), full-1)
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+			require.NoError(t, err)
+			require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+			require.NoError(t, fs.SetCounter(ctx, "PollCount", 2))
+			require.NoError(t, fs.Close())
+
+			tc.damage(t)
+
+			restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+			require.NoError(t, err)
+
+			c, err := restored.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			want := storage.Counter(1)
+			if tc.name == "partial_header" {
+				want = 3
+			}
+			assert.Equal(t, want, c)
+
+			// Хвост обрезан, новые записи читаются после перезапуска
+			require.NoError(t, restored.SetCounter(ctx, "PollCount", 10))
+			require.NoError(t, restored.Close())
+
+			again, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+			require.NoError(t, err)
+			defer again.Close()
+			c, err = again.GetCounter(ctx, "PollCount")
+			require.NoError(t, err)
+			assert.Equal(t, want+10, c)
+		})
+	}
+}
+
+func TestFileStorage_NoRestoreResetsWAL(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+	require.NoError(t, fs.Close())
+
+	fresh, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300})
+	require.NoError(t, err)
+	defer fresh.Close()
+
+	_, err = fresh.GetCounter(ctx, "PollCount")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	info, err := os.Stat(path + ".wal")
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+}
+
+func TestFileStorage_SyncInterval(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	fs, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, WALSync: SyncInterval})
+	require.NoError(t, err)
+	require.NoError(t, fs.SetCounter(ctx, "PollCount", 1))
+	assert.True(t, fs.wal.dirty)
+	require.NoError(t, fs.Close())
+
+	restored, err := NewStorage(ctx, Config{FileStoragePath: path, StoreInterval: 300, Restore: true})
+	require.NoError(t, err)
+	defer restored.Close()
+	c, err := restored.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(1), c)
+}
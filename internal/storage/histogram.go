@@ -0,0 +1,462 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/am0xff/metrics/internal/models"
+)
+
+// MetricTypes возвращает все поддерживаемые типы метрик.
+func MetricTypes() []MetricType {
+	return []MetricType{MetricTypeGauge, MetricTypeCounter, MetricTypeHistogram}
+}
+
+// DefaultBuckets - границы корзин histogram по умолчанию, как в клиентах Prometheus.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram - значение histogram метрики. Counts[i] - число наблюдений
+// в корзине (Bounds[i-1], Bounds[i]], последний элемент Counts - число
+// наблюдений больше последней границы. В отличие от models.Bucket
+// числа не накопленные.
+//
+// Пример использования:
+//
+//	h := storage.NewHistogram([]float64{0.1, 0.5, 1})
+//	h.Observe(0.3)
+//	h.Observe(2)
+//	h.Quantile(0.5) // 0.5
+type Histogram struct {
+	Bounds []float64 `json:"bounds"` // верхние границы корзин по возрастанию
+	Counts []uint64  `json:"counts"` // число наблюдений в корзинах, len(Bounds)+1
+	Sum    float64   `json:"sum"`    // сумма наблюдений
+	Count  uint64    `json:"count"`  // общее число наблюдений
+}
+
+// NewHistogram создает пустую histogram с границами корзин bounds.
+func NewHistogram(bounds []float64) Histogram {
+	return Histogram{
+		Bounds: append([]float64(nil), bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// ValidateBounds проверяет границы корзин: хотя бы одна граница,
+// конечные значения по строгому возрастанию.
+func ValidateBounds(bounds []float64) error {
+	if len(bounds) == 0 {
+		return errors.New("no histogram buckets")
+	}
+	for i, b := range bounds {
+		if math.IsNaN(b) || math.IsInf(b, 0) {
+			return fmt.Errorf("bucket bound %v is not finite", b)
+		}
+		if i > 0 && b <= bounds[i-1] {
+			return errors.New("bucket bounds are not increasing")
+		}
+	}
+	return nil
+}
+
+// Observe добавляет наблюдение v.
+func (h *Histogram) Observe(v float64) {
+	h.ObserveN(v, 1)
+}
+
+// ObserveN добавляет n одинаковых наблюдений v.
+func (h *Histogram) ObserveN(v float64, n uint64) {
+	i := sort.SearchFloat64s(h.Bounds, v)
+	h.Counts[i] += n
+	h.Count += n
+	h.Sum += v * float64(n)
+}
+
+// SameBounds сообщает, совпадают ли границы корзин h и o.
+func (h Histogram) SameBounds(o Histogram) bool {
+	if len(h.Bounds) != len(o.Bounds) {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != o.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge прибавляет к h наблюдения d. Пустая h без границ принимает границы d.
+// Если границы корзин различаются, возвращает ErrInvalid и не изменяет h.
+func (h *Histogram) Merge(d Histogram) error {
+	if h.Bounds == nil && h.Count == 0 {
+		*h = NewHistogram(d.Bounds)
+	}
+	if !h.SameBounds(d) {
+		return fmt.Errorf("%w: histogram bucket bounds do not match", ErrInvalid)
+	}
+	for i, c := range d.Counts {
+		h.Counts[i] += c
+	}
+	h.Count += d.Count
+	h.Sum += d.Sum
+	return nil
+}
+
+// Clone возвращает копию h, не разделяющую с ней срезы.
+func (h Histogram) Clone() Histogram {
+	h.Bounds = append([]float64(nil), h.Bounds...)
+	h.Counts = append([]uint64(nil), h.Counts...)
+	return h
+}
+
+// Cumulative возвращает накопленное число наблюдений для каждой границы
+// Bounds, как в корзинах Prometheus.
+func (h Histogram) Cumulative() []uint64 {
+	cum := make([]uint64, len(h.Bounds))
+	var total uint64
+	for i := range h.Bounds {
+		total += h.Counts[i]
+		cum[i] = total
+	}
+	return cum
+}
+
+// Quantile оценивает квантиль q (0 <= q <= 1) линейной интерполяцией внутри
+// корзины, как histogram_quantile в Prometheus: нижняя граница первой корзины
+// считается равной 0 (или самой границе, если она отрицательна), а для
+// наблюдений больше последней границы возвращается последняя граница.
+// Для пустой histogram и q вне [0, 1] возвращает NaN.
+func (h Histogram) Quantile(q float64) float64 {
+	if h.Count == 0 || q < 0 || q > 1 || math.IsNaN(q) {
+		return math.NaN()
+	}
+
+	rank := q * float64(h.Count)
+	var total uint64
+	for i, upper := range h.Bounds {
+		prev := total
+		total += h.Counts[i]
+		if float64(total) < rank || h.Counts[i] == 0 {
+			continue
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = h.Bounds[i-1]
+		} else if upper < 0 {
+			lower = upper
+		}
+		return lower + (upper-lower)*(rank-float64(prev))/float64(h.Counts[i])
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// NewHistogramDelta преобразует значение histogram метрики из запроса
+// в прибавляемые к серии наблюдения. Границы корзин берутся из m.Buckets,
+// отдельные наблюдения m.Observations раскладываются по этим корзинам
+// и складываются с предварительно агрегированными m.Count и m.Sum.
+// Возвращает ошибку для недопустимых границ, накопленных чисел и наблюдений.
+func NewHistogramDelta(m *models.Histogram) (Histogram, error) {
+	if m == nil {
+		return Histogram{}, errors.New("missing histogram")
+	}
+
+	bounds := make([]float64, len(m.Buckets))
+	for i, b := range m.Buckets {
+		bounds[i] = b.Le
+	}
+	if err := ValidateBounds(bounds); err != nil {
+		return Histogram{}, err
+	}
+
+	h := NewHistogram(bounds)
+	var prev uint64
+	for i, b := range m.Buckets {
+		if b.Count < prev {
+			return Histogram{}, errors.New("bucket counts are not cumulative")
+		}
+		h.Counts[i] = b.Count - prev
+		prev = b.Count
+	}
+	if m.Count < prev {
+		return Histogram{}, errors.New("histogram count is less than bucket count")
+	}
+	h.Counts[len(bounds)] = m.Count - prev
+	h.Count = m.Count
+	h.Sum = m.Sum
+
+	for _, v := range m.Observations {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return Histogram{}, fmt.Errorf("observation %v is not finite", v)
+		}
+		h.Observe(v)
+	}
+	return h, nil
+}
+
+// Model возвращает значение histogram в виде models.Histogram
+// с накопленными числами в корзинах.
+func (h Histogram) Model() *models.Histogram {
+	m := &models.Histogram{
+		Buckets: make([]models.Bucket, len(h.Bounds)),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+	for i, c := range h.Cumulative() {
+		m.Buckets[i] = models.Bucket{Le: h.Bounds[i], Count: c}
+	}
+	return m
+}
+
+// BucketPolicy определяет границы корзин histogram метрик. Default
+// применяется ко всем метрикам (пустое значение - DefaultBuckets),
+// Overrides переопределяет границы для метрик, имя которых начинается
+// с префикса; при нескольких подходящих префиксах выбирается самый длинный.
+//
+// Пример использования:
+//
+//	p := storage.BucketPolicy{
+//		Overrides: map[string][]float64{"db_": {1, 5, 10}},
+//	}
+//	p.Buckets("db_query_seconds") // [1 5 10]
+//	p.Buckets("http_latency")     // DefaultBuckets
+type BucketPolicy struct {
+	Default   []float64
+	Overrides map[string][]float64
+}
+
+// Buckets возвращает границы корзин для серии с ключом key.
+func (p BucketPolicy) Buckets(key string) []float64 {
+	name, _, err := ParseSeriesKey(key)
+	if err != nil {
+		name = key
+	}
+
+	bounds, matched := p.Default, -1
+	for prefix, b := range p.Overrides {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			bounds, matched = b, len(prefix)
+		}
+	}
+	if len(bounds) == 0 {
+		return DefaultBuckets
+	}
+	return bounds
+}
+
+// ParseBuckets разбирает границы корзин через запятую, например "0.1,0.5,1".
+// Пустая строка означает границы по умолчанию (nil).
+func ParseBuckets(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	var bounds []float64
+	for _, item := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bucket bound %q", item)
+		}
+		bounds = append(bounds, v)
+	}
+	if err := ValidateBounds(bounds); err != nil {
+		return nil, err
+	}
+	return bounds, nil
+}
+
+// ParseBucketOverrides разбирает границы корзин по префиксу имени метрики
+// в формате "префикс=границы" через точку с запятой, например
+// "db_=1,5,10;http_=0.1,0.5". Пустая строка означает отсутствие переопределений.
+func ParseBucketOverrides(s string) (map[string][]float64, error) {
+	overrides := make(map[string][]float64)
+	for _, item := range strings.Split(s, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(item, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("invalid bucket override %q: expected prefix=bounds", item)
+		}
+		bounds, err := ParseBuckets(value)
+		if err != nil || bounds == nil {
+			return nil, fmt.Errorf("invalid bucket override %q: bad bounds", item)
+		}
+		overrides[prefix] = bounds
+	}
+	return overrides, nil
+}
+
+// Histograms хранит значения histogram метрик. Серии распределены
+// по сегментам с отдельными блокировками, как и значения в Storage.
+//
+// Histograms безопасен для конкурентного использования.
+type Histograms struct {
+	shards [shardCount]histogramShard
+}
+
+type histogramShard struct {
+	mu   sync.RWMutex
+	data map[string]*Histogram
+	_    [32]byte
+}
+
+// NewHistograms создает пустое хранилище histogram метрик.
+func NewHistograms() *Histograms {
+	hs := &Histograms{}
+	for i := range hs.shards {
+		hs.shards[i].data = make(map[string]*Histogram)
+	}
+	return hs
+}
+
+// Get возвращает копию значения серии key.
+func (hs *Histograms) Get(key string) (Histogram, bool) {
+	sh := &hs.shards[shardIndex(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	h, ok := sh.data[key]
+	if !ok {
+		return Histogram{}, false
+	}
+	return h.Clone(), true
+}
+
+// Set заменяет значение серии key.
+func (hs *Histograms) Set(key string, h Histogram) {
+	sh := &hs.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	h = h.Clone()
+	sh.data[key] = &h
+}
+
+// Delete удаляет серию key. Возвращает false, если серия не найдена.
+func (hs *Histograms) Delete(key string) bool {
+	sh := &hs.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.data[key]; !ok {
+		return false
+	}
+	delete(sh.data, key)
+	return true
+}
+
+// Keys возвращает ключи всех серий. Порядок ключей не гарантируется.
+func (hs *Histograms) Keys() []string {
+	keys := make([]string, 0)
+	for i := range hs.shards {
+		sh := &hs.shards[i]
+		sh.mu.RLock()
+		for k := range sh.data {
+			keys = append(keys, k)
+		}
+		sh.mu.RUnlock()
+	}
+	return keys
+}
+
+// Snapshot возвращает копию значений всех серий.
+func (hs *Histograms) Snapshot() map[string]Histogram {
+	result := make(map[string]Histogram)
+	for i := range hs.shards {
+		sh := &hs.shards[i]
+		sh.mu.RLock()
+		for k, h := range sh.data {
+			result[k] = h.Clone()
+		}
+		sh.mu.RUnlock()
+	}
+	return result
+}
+
+// Check проверяет, что histogram изменения updates можно применить:
+// границы корзин совпадают с границами существующих серий и между собой.
+func (hs *Histograms) Check(updates []Update) error {
+	shards := hs.lock(updates)
+	defer hs.unlock(shards)
+	return hs.checkLocked(updates)
+}
+
+// Apply применяет histogram изменения updates как одну операцию: если
+// хотя бы одно из них недопустимо (см. Check), возвращает ErrInvalid
+// и не изменяет хранилище. Изменения других типов пропускаются.
+func (hs *Histograms) Apply(updates []Update) error {
+	shards := hs.lock(updates)
+	defer hs.unlock(shards)
+
+	if err := hs.checkLocked(updates); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		if u.Type != MetricTypeHistogram {
+			continue
+		}
+		sh := &hs.shards[shardIndex(u.Key)]
+		if u.Op == OpDelete {
+			delete(sh.data, u.Key)
+			continue
+		}
+		h, ok := sh.data[u.Key]
+		if !ok {
+			h = &Histogram{}
+			sh.data[u.Key] = h
+		}
+		_ = h.Merge(u.Hist)
+	}
+	return nil
+}
+
+// checkLocked проверяет изменения; сегменты их серий должны быть заблокированы.
+func (hs *Histograms) checkLocked(updates []Update) error {
+	bounds := make(map[string]Histogram)
+	for _, u := range updates {
+		if u.Type != MetricTypeHistogram {
+			continue
+		}
+		if u.Op == OpDelete {
+			bounds[u.Key] = Histogram{}
+			continue
+		}
+		cur, seen := bounds[u.Key]
+		if !seen {
+			if h, ok := hs.shards[shardIndex(u.Key)].data[u.Key]; ok {
+				cur = *h
+			}
+		}
+		if cur.Bounds != nil && !cur.SameBounds(u.Hist) {
+			return fmt.Errorf("%w: %s: histogram bucket bounds do not match", ErrInvalid, u.Key)
+		}
+		bounds[u.Key] = Histogram{Bounds: u.Hist.Bounds}
+	}
+	return nil
+}
+
+// lock блокирует на запись сегменты histogram серий updates в порядке
+// возрастания номеров и возвращает их номера.
+func (hs *Histograms) lock(updates []Update) []int {
+	var shards []int
+	for _, u := range updates {
+		if u.Type == MetricTypeHistogram {
+			shards = append(shards, shardIndex(u.Key))
+		}
+	}
+	shards = uniqueSorted(shards)
+	for _, i := range shards {
+		hs.shards[i].mu.Lock()
+	}
+	return shards
+}
+
+func (hs *Histograms) unlock(shards []int) {
+	for _, i := range shards {
+		hs.shards[i].mu.Unlock()
+	}
+}
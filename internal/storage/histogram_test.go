@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 0.5, 1})
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 2} {
+		h.Observe(v)
+	}
+
+	// Граница корзины включается в нее
+	assert.Equal(t, []uint64{2, 1, 1, 1}, h.Counts)
+	assert.Equal(t, []uint64{2, 3, 4}, h.Cumulative())
+	assert.Equal(t, uint64(5), h.Count)
+	assert.InDelta(t, 3.15, h.Sum, 1e-9)
+}
+
+func TestHistogram_ObserveN(t *testing.T) {
+	h := NewHistogram([]float64{1})
+	h.ObserveN(0.5, 1000)
+	h.ObserveN(2, 1)
+
+	assert.Equal(t, []uint64{1000, 1}, h.Counts)
+	assert.Equal(t, uint64(1001), h.Count)
+	assert.InDelta(t, 502, h.Sum, 1e-9)
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	h := NewHistogram([]float64{1, 2, 4})
+	assert.True(t, math.IsNaN(h.Quantile(0.5)))
+
+	// 4 наблюдения в (0, 1], 4 - в (2, 4], 2 - больше 4
+	h.Counts = []uint64{4, 0, 4, 2}
+	h.Count = 10
+
+	assert.InDelta(t, 0.5, h.Quantile(0.2), 1e-9)
+	assert.InDelta(t, 1, h.Quantile(0.4), 1e-9)
+	assert.InDelta(t, 3, h.Quantile(0.6), 1e-9)
+	assert.InDelta(t, 4, h.Quantile(0.95), 1e-9)
+	assert.True(t, math.IsNaN(h.Quantile(1.5)))
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	var h Histogram
+	d := NewHistogram([]float64{1, 2})
+	d.Observe(1.5)
+	require.NoError(t, h.Merge(d))
+	require.NoError(t, h.Merge(d))
+	assert.Equal(t, Histogram{Bounds: []float64{1, 2}, Counts: []uint64{0, 2, 0}, Sum: 3, Count: 2}, h)
+
+	err := h.Merge(NewHistogram([]float64{1, 3}))
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, uint64(2), h.Count)
+}
+
+func TestNewHistogramDelta(t *testing.T) {
+	h, err := NewHistogramDelta(&models.Histogram{
+		Buckets:      []models.Bucket{{Le: 0.1, Count: 2}, {Le: 1, Count: 5}},
+		Count:        6,
+		Sum:          4.2,
+		Observations: []float64{0.05},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{3, 3, 1}, Sum: 4.25, Count: 7}, h)
+	assert.Equal(t, &models.Histogram{
+		Buckets: []models.Bucket{{Le: 0.1, Count: 3}, {Le: 1, Count: 6}},
+		Count:   7,
+		Sum:     4.25,
+	}, h.Model())
+
+	invalid := []*models.Histogram{
+		nil,
+		{Observations: []float64{1}},
+		{Buckets: []models.Bucket{{Le: 1}, {Le: 1}}},
+		{Buckets: []models.Bucket{{Le: 1, Count: 3}, {Le: 2, Count: 1}}, Count: 3},
+		{Buckets: []models.Bucket{{Le: 1, Count: 3}}, Count: 2},
+		{Buckets: []models.Bucket{{Le: 1}}, Observations: []float64{math.Inf(1)}},
+	}
+	for _, m := range invalid {
+		_, err := NewHistogramDelta(m)
+		assert.Error(t, err, "%+v", m)
+	}
+}
+
+func TestBucketPolicy(t *testing.T) {
+	p := BucketPolicy{Overrides: map[string][]float64{"db_": {1, 5}, "db_slow_": {10, 60}}}
+	assert.Equal(t, DefaultBuckets, p.Buckets("http_latency"))
+	assert.Equal(t, []float64{1, 5}, p.Buckets(`db_query{table="users"}`))
+	assert.Equal(t, []float64{10, 60}, p.Buckets("db_slow_query"))
+
+	p.Default = []float64{0.5}
+	assert.Equal(t, []float64{0.5}, p.Buckets("http_latency"))
+}
+
+func TestParseBuckets(t *testing.T) {
+	bounds, err := ParseBuckets(" 0.1, 0.5 ,1")
+	require.NoError(t, err)
+	assert.Equal(t, []float64{0.1, 0.5, 1}, bounds)
+
+	bounds, err = ParseBuckets("")
+	require.NoError(t, err)
+	assert.Nil(t, bounds)
+
+	for _, s := range []string{"1,x", "1,1", "2,1"} {
+		_, err := ParseBuckets(s)
+		assert.Error(t, err, s)
+	}
+
+	overrides, err := ParseBucketOverrides("db_=1,5,10; http_=0.1,0.5;")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]float64{"db_": {1, 5, 10}, "http_": {0.1, 0.5}}, overrides)
+
+	for _, s := range []string{"db_", "=1,2", "db_=", "db_=2,1"} {
+		_, err := ParseBucketOverrides(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHistograms_Apply(t *testing.T) {
+	hs := NewHistograms()
+	d := NewHistogram([]float64{1, 2})
+	d.Observe(0.5)
+
+	require.NoError(t, hs.Apply([]Update{
+		{Type: MetricTypeHistogram, Key: "a", Hist: d},
+		{Type: MetricTypeGauge, Key: "a", Value: 1},
+		{Type: MetricTypeHistogram, Key: "a", Hist: d},
+	}))
+	h, ok := hs.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, uint64(2), h.Count)
+
+	// Пакет с несовпадающими границами не применяется целиком
+	err := hs.Apply([]Update{
+		{Type: MetricTypeHistogram, Key: "b", Hist: d},
+		{Type: MetricTypeHistogram, Key: "a", Hist: NewHistogram([]float64{5})},
+	})
+	assert.ErrorIs(t, err, ErrInvalid)
+	_, ok = hs.Get("b")
+	assert.False(t, ok)
+
+	// После удаления серия может быть создана с другими границами
+	require.NoError(t, hs.Apply([]Update{
+		{Type: MetricTypeHistogram, Key: "a", Op: OpDelete},
+		{Type: MetricTypeHistogram, Key: "a", Hist: NewHistogram([]float64{5})},
+	}))
+	h, _ = hs.Get("a")
+	assert.Equal(t, []float64{5}, h.Bounds)
+	assert.ElementsMatch(t, []string{"a"}, hs.Keys())
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultHistorySize - количество последних значений, которое хранится в памяти для каждой серии.
+const DefaultHistorySize = 1000
+
+// Sample представляет значение метрики в определенный момент времени.
+// Для gauge хранится установленное значение, для counter - накопленное
+// значение счетчика после применения приращения.
+type Sample struct {
+	Timestamp time.Time
+	Value     float64
+}
+
+// History хранит историю значений метрик в кольцевых буферах фиксированного размера.
+// На каждую серию (ключ метрики) приходится отдельный буфер, при переполнении
+// которого вытесняются самые старые значения. Серии распределены по сегментам
+// с отдельными блокировками, как и значения в Storage.
+//
+// History безопасен для конкурентного использования.
+//
+// Пример использования:
+//
+//	h := NewHistory(DefaultHistorySize)
+//	h.Append("HeapAlloc", time.Now(), 1024)
+//	samples := h.Range("HeapAlloc", time.Now().Add(-10*time.Minute), time.Now())
+type History struct {
+	size   int
+	shards [shardCount]historyShard
+}
+
+type historyShard struct {
+	mu     sync.RWMutex
+	series map[string]*ring
+	_      [32]byte
+}
+
+// NewHistory создает историю, хранящую не более size значений на серию.
+// Если size не положителен, используется DefaultHistorySize.
+func NewHistory(size int) *History {
+	if size <= 0 {
+		size = DefaultHistorySize
+	}
+	h := &History{size: size}
+	for i := range h.shards {
+		h.shards[i].series = make(map[string]*ring)
+	}
+	return h
+}
+
+// Append добавляет значение серии key в момент времени ts.
+func (h *History) Append(key string, ts time.Time, value float64) {
+	sh := &h.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	r, ok := sh.series[key]
+	if !ok {
+		r = &ring{size: h.size}
+		sh.series[key] = r
+	}
+	r.push(Sample{Timestamp: ts, Value: value})
+}
+
+// Delete удаляет историю серии key.
+func (h *History) Delete(key string) {
+	sh := &h.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.series, key)
+}
+
+// Range возвращает значения серии key с временными метками в интервале [from, to]
+// в порядке их добавления. Если серия не найдена, возвращает пустой срез.
+func (h *History) Range(key string, from, to time.Time) []Sample {
+	sh := &h.shards[shardIndex(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	r, ok := sh.series[key]
+	if !ok {
+		return []Sample{}
+	}
+
+	samples := make([]Sample, 0, len(r.buf))
+	r.each(func(s Sample) {
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			return
+		}
+		samples = append(samples, s)
+	})
+	return samples
+}
+
+// ring - кольцевой буфер значений одной серии.
+type ring struct {
+	buf   []Sample
+	start int
+	size  int
+}
+
+func (r *ring) push(s Sample) {
+	if len(r.buf) < r.size {
+		r.buf = append(r.buf, s)
+		return
+	}
+	r.buf[r.start] = s
+	r.start = (r.start + 1) % r.size
+}
+
+func (r *ring) each(f func(s Sample)) {
+	for i := 0; i < len(r.buf); i++ {
+		f(r.buf[(r.start+i)%len(r.buf)])
+	}
+}
+
+// Downsample приводит значения к сетке с шагом step на интервале [from, to].
+// Для каждой точки сетки t берется последнее значение из полуинтервала (t-step, t],
+// точки без значений пропускаются. Если step не положителен, значения
+// возвращаются без изменений.
+//
+// Ожидается, что samples упорядочены по времени.
+//
+// Пример использования:
+//
+//	// Одна точка на каждую минуту последнего часа
+//	points := Downsample(samples, now.Add(-time.Hour), now, time.Minute)
+func Downsample(samples []Sample, from, to time.Time, step time.Duration) []Sample {
+	if step <= 0 {
+		return samples
+	}
+
+	result := make([]Sample, 0)
+	i := 0
+	for t := from; !t.After(to); t = t.Add(step) {
+		var (
+			last  Sample
+			found bool
+		)
+		for i < len(samples) && !samples[i].Timestamp.After(t) {
+			if samples[i].Timestamp.After(t.Add(-step)) {
+				last = samples[i]
+				found = true
+			}
+			i++
+		}
+		if found {
+			result = append(result, Sample{Timestamp: t, Value: last.Value})
+		}
+	}
+	return result
+}
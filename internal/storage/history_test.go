@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory_AppendAndRange(t *testing.T) {
+	h := NewHistory(10)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		h.Append("cpu", base.Add(time.Duration(i)*time.Minute), float64(i))
+	}
+
+	samples := h.Range("cpu", base.Add(time.Minute), base.Add(3*time.Minute))
+	assert.Equal(t, []Sample{
+		{Timestamp: base.Add(time.Minute), Value: 1},
+		{Timestamp: base.Add(2 * time.Minute), Value: 2},
+		{Timestamp: base.Add(3 * time.Minute), Value: 3},
+	}, samples)
+
+	assert.Empty(t, h.Range("unknown", base, base.Add(time.Hour)))
+}
+
+func TestHistory_Overflow(t *testing.T) {
+	h := NewHistory(3)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 5; i++ {
+		h.Append("cpu", base.Add(time.Duration(i)*time.Second), float64(i))
+	}
+
+	samples := h.Range("cpu", base, base.Add(time.Minute))
+	assert.Len(t, samples, 3)
+	assert.Equal(t, 2.0, samples[0].Value)
+	assert.Equal(t, 3.0, samples[1].Value)
+	assert.Equal(t, 4.0, samples[2].Value)
+}
+
+func TestNewHistory_DefaultSize(t *testing.T) {
+	h := NewHistory(0)
+	assert.Equal(t, DefaultHistorySize, h.size)
+}
+
+func TestDownsample(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Timestamp: base.Add(10 * time.Second), Value: 1},
+		{Timestamp: base.Add(50 * time.Second), Value: 2},
+		{Timestamp: base.Add(70 * time.Second), Value: 3},
+		{Timestamp: base.Add(200 * time.Second), Value: 4},
+	}
+
+	points := Downsample(samples, base, base.Add(4*time.Minute), time.Minute)
+	assert.Equal(t, []Sample{
+		{Timestamp: base.Add(time.Minute), Value: 2},
+		{Timestamp: base.Add(2 * time.Minute), Value: 3},
+		{Timestamp: base.Add(4 * time.Minute), Value: 4},
+	}, points)
+
+	assert.Equal(t, samples, Downsample(samples, base, base.Add(time.Hour), 0))
+}
@@ -2,46 +2,331 @@ package memory
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
 )
 
 type MemStorage struct {
 	Gauges   *storage.Storage[storage.Gauge]
 	Counters *storage.Storage[storage.Counter]
+
+	GaugesHistory   *storage.History
+	CountersHistory *storage.History
+
+	Histograms *storage.Histograms
+
+	GaugesUpdated     *storage.Timestamps
+	CountersUpdated   *storage.Timestamps
+	HistogramsUpdated *storage.Timestamps
+
+	// locks упорядочивает изменения серий с удалением устаревших серий
+	locks storage.SeriesLocks
 }
 
 func NewStorage() *MemStorage {
 	return &MemStorage{
-		Gauges:   storage.NewStorage[storage.Gauge](),
-		Counters: storage.NewStorage[storage.Counter](),
+		Gauges:            storage.NewStorage[storage.Gauge](),
+		Counters:          storage.NewStorage[storage.Counter](),
+		GaugesHistory:     storage.NewHistory(storage.DefaultHistorySize),
+		CountersHistory:   storage.NewHistory(storage.DefaultHistorySize),
+		Histograms:        storage.NewHistograms(),
+		GaugesUpdated:     storage.NewTimestamps(),
+		CountersUpdated:   storage.NewTimestamps(),
+		HistogramsUpdated: storage.NewTimestamps(),
 	}
 }
 
-func (m *MemStorage) GetGauge(_ context.Context, key string) (storage.Gauge, bool) {
-	return m.Gauges.Get(key)
+func (m *MemStorage) GetGauge(_ context.Context, key string) (storage.Gauge, error) {
+	v, ok := m.Gauges.Get(key)
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	return v, nil
 }
 
-func (m *MemStorage) GetCounter(_ context.Context, key string) (storage.Counter, bool) {
-	return m.Counters.Get(key)
+func (m *MemStorage) GetCounter(_ context.Context, key string) (storage.Counter, error) {
+	v, ok := m.Counters.Get(key)
+	if !ok {
+		return 0, storage.ErrNotFound
+	}
+	return v, nil
 }
 
-func (m *MemStorage) SetGauge(_ context.Context, key string, value storage.Gauge) {
+func (m *MemStorage) SetGauge(_ context.Context, key string, value storage.Gauge) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
+	}
+	unlock := m.locks.RLock(key)
+	defer unlock()
+
+	now := time.Now()
 	m.Gauges.Set(key, value)
+	m.GaugesHistory.Append(key, now, float64(value))
+	m.GaugesUpdated.Set(key, now)
+	return nil
+}
+
+func (m *MemStorage) SetCounter(_ context.Context, key string, value storage.Counter) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
+	}
+	unlock := m.locks.RLock(key)
+	defer unlock()
+
+	now := time.Now()
+	v := m.Counters.Count(key, value)
+	m.CountersHistory.Append(key, now, float64(v))
+	m.CountersUpdated.Set(key, now)
+	return nil
+}
+
+func (m *MemStorage) GetHistogram(_ context.Context, key string) (storage.Histogram, error) {
+	h, ok := m.Histograms.Get(key)
+	if !ok {
+		return storage.Histogram{}, storage.ErrNotFound
+	}
+	return h, nil
+}
+
+func (m *MemStorage) KeysHistogram(_ context.Context) ([]string, error) {
+	return m.Histograms.Keys(), nil
 }
 
-func (m *MemStorage) SetCounter(_ context.Context, key string, value storage.Counter) {
-	m.Counters.Count(key, value)
+func (m *MemStorage) KeysGauge(_ context.Context) ([]string, error) {
+	return m.Gauges.Keys(), nil
 }
 
-func (m *MemStorage) KeysGauge(_ context.Context) []string {
-	return m.Gauges.Keys()
+func (m *MemStorage) KeysCounter(_ context.Context) ([]string, error) {
+	return m.Counters.Keys(), nil
 }
 
-func (m *MemStorage) KeysCounter(_ context.Context) []string {
-	return m.Counters.Keys()
+func (m *MemStorage) QueryRange(_ context.Context, mtype storage.MetricType, key string, from, to time.Time) ([]storage.Sample, error) {
+	switch mtype {
+	case storage.MetricTypeGauge:
+		return m.GaugesHistory.Range(key, from, to), nil
+	case storage.MetricTypeCounter:
+		return m.CountersHistory.Range(key, from, to), nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+}
+
+// Snapshot возвращает согласованный снимок значений всех метрик.
+func (m *MemStorage) Snapshot() storage.Snapshot {
+	return storage.TakeSnapshot(m.Gauges, m.Counters)
 }
 
 func (m *MemStorage) Ping(_ context.Context) error {
 	return nil
 }
+
+// UpdateBatch применяет пакет метрик целиком: пакет проверяется до изменения
+// хранилища и применяется методом ApplyUpdates.
+func (m *MemStorage) UpdateBatch(_ context.Context, metrics []models.Metrics) error {
+	updates, err := storage.NewUpdates(metrics)
+	if err != nil {
+		return err
+	}
+	return m.ApplyUpdates(updates)
+}
+
+// Delete удаляет метрику типа mtype по ключу вместе с ее историей.
+func (m *MemStorage) Delete(_ context.Context, mtype storage.MetricType, key string) error {
+	var deleted bool
+	switch mtype {
+	case storage.MetricTypeGauge:
+		deleted = m.Gauges.Delete(key)
+		m.GaugesHistory.Delete(key)
+		m.GaugesUpdated.Delete(key)
+	case storage.MetricTypeCounter:
+		deleted = m.Counters.Delete(key)
+		m.CountersHistory.Delete(key)
+		m.CountersUpdated.Delete(key)
+	case storage.MetricTypeHistogram:
+		deleted = m.Histograms.Delete(key)
+		m.HistogramsUpdated.Delete(key)
+	default:
+		return fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+	if !deleted {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// DeleteMatching удаляет все метрики, соответствующие фильтру f.
+func (m *MemStorage) DeleteMatching(ctx context.Context, f storage.DeleteFilter) (int, error) {
+	updates, err := m.MatchingDeletes(f)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.ApplyUpdates(updates); err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+// MatchingDeletes возвращает изменения OpDelete для всех метрик,
+// соответствующих фильтру f.
+func (m *MemStorage) MatchingDeletes(f storage.DeleteFilter) ([]storage.Update, error) {
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+
+	var updates []storage.Update
+	for _, mtype := range f.Types() {
+		for _, k := range m.keys(mtype) {
+			if f.Match(k) {
+				updates = append(updates, storage.Update{Type: mtype, Key: k, Op: storage.OpDelete})
+			}
+		}
+	}
+	return updates, nil
+}
+
+// ResetCounter сбрасывает значение counter метрики в 0.
+func (m *MemStorage) ResetCounter(_ context.Context, key string) error {
+	if _, ok := m.Counters.Get(key); !ok {
+		return storage.ErrNotFound
+	}
+	return m.ApplyUpdates([]storage.Update{{Type: storage.MetricTypeCounter, Key: key, Op: storage.OpReset}})
+}
+
+// LastUpdated возвращает время последнего обновления серий типа mtype.
+func (m *MemStorage) LastUpdated(_ context.Context, mtype storage.MetricType, keys ...string) (map[string]time.Time, error) {
+	updated, err := m.timestamps(mtype)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return updated.Snapshot(), nil
+	}
+
+	result := make(map[string]time.Time, len(keys))
+	for _, k := range keys {
+		if ts, ok := updated.Get(k); ok {
+			result[k] = ts
+		}
+	}
+	return result, nil
+}
+
+// DeleteStale удаляет серии keys типа mtype, не обновлявшиеся с момента before.
+// Время обновления проверяется под блокировкой серий на запись, поэтому
+// серия, обновленная конкурентно, не удаляется.
+func (m *MemStorage) DeleteStale(_ context.Context, mtype storage.MetricType, keys []string, before time.Time) (int, error) {
+	unlock := m.locks.Lock(keys...)
+	defer unlock()
+
+	updates, err := m.StaleDeletes(mtype, keys, before)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.applyUpdatesLocked(updates, time.Now()); err != nil {
+		return 0, err
+	}
+	return len(updates), nil
+}
+
+// StaleDeletes возвращает изменения OpDelete для серий keys типа mtype,
+// последний раз обновленных раньше before. Проверка не блокирует серии:
+// вызывающий должен исключить конкурентные изменения до применения
+// результата, как это делает DeleteStale.
+func (m *MemStorage) StaleDeletes(mtype storage.MetricType, keys []string, before time.Time) ([]storage.Update, error) {
+	updated, err := m.timestamps(mtype)
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []storage.Update
+	for _, k := range keys {
+		if ts, ok := updated.Get(k); ok && ts.Before(before) {
+			updates = append(updates, storage.Update{Type: mtype, Key: k, Op: storage.OpDelete})
+		}
+	}
+	return updates, nil
+}
+
+func (m *MemStorage) timestamps(mtype storage.MetricType) (*storage.Timestamps, error) {
+	switch mtype {
+	case storage.MetricTypeGauge:
+		return m.GaugesUpdated, nil
+	case storage.MetricTypeCounter:
+		return m.CountersUpdated, nil
+	case storage.MetricTypeHistogram:
+		return m.HistogramsUpdated, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+}
+
+// keys возвращает ключи серий типа mtype.
+func (m *MemStorage) keys(mtype storage.MetricType) []string {
+	switch mtype {
+	case storage.MetricTypeGauge:
+		return m.Gauges.Keys()
+	case storage.MetricTypeCounter:
+		return m.Counters.Keys()
+	default:
+		return m.Histograms.Keys()
+	}
+}
+
+// ApplyUpdates применяет проверенные изменения и добавляет новые значения
+// gauge и counter в историю. Изменения histogram применяются первыми
+// методом storage.Histograms.Apply: если границы корзин не совпадают
+// с сохраненными, возвращается ErrInvalid и хранилище не изменяется.
+// Изменения gauge и counter применяются одной операцией storage.ApplyBatch.
+func (m *MemStorage) ApplyUpdates(updates []storage.Update) error {
+	return m.ApplyUpdatesAt(updates, time.Now())
+}
+
+// ApplyUpdatesAt применяет изменения как ApplyUpdates, считая моментом
+// их получения now. Используется при восстановлении изменений из журнала.
+func (m *MemStorage) ApplyUpdatesAt(updates []storage.Update, now time.Time) error {
+	keys := make([]string, len(updates))
+	for i, u := range updates {
+		keys[i] = u.Key
+	}
+	unlock := m.locks.RLock(keys...)
+	defer unlock()
+
+	return m.applyUpdatesLocked(updates, now)
+}
+
+// applyUpdatesLocked применяет изменения как ApplyUpdatesAt. Серии изменений
+// должны быть заблокированы в m.locks.
+func (m *MemStorage) applyUpdatesLocked(updates []storage.Update, now time.Time) error {
+	if err := m.Histograms.Apply(updates); err != nil {
+		return err
+	}
+	values := storage.ApplyBatch(m.Gauges, m.Counters, updates)
+
+	for i, u := range updates {
+		var history *storage.History
+		var updated *storage.Timestamps
+		switch u.Type {
+		case storage.MetricTypeGauge:
+			history, updated = m.GaugesHistory, m.GaugesUpdated
+		case storage.MetricTypeCounter:
+			history, updated = m.CountersHistory, m.CountersUpdated
+		default:
+			updated = m.HistogramsUpdated
+		}
+		if u.Op == storage.OpDelete {
+			if history != nil {
+				history.Delete(u.Key)
+			}
+			updated.Delete(u.Key)
+			continue
+		}
+		if history != nil {
+			history.Append(u.Key, now, values[i])
+		}
+		updated.Set(u.Key, now)
+	}
+	return nil
+}
@@ -2,10 +2,15 @@ package memory
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewStorage(t *testing.T) {
@@ -28,13 +33,21 @@ func TestMemStorage_Gauge_Operations(t *testing.T) {
 	store.SetGauge(ctx, testKey, testValue)
 
 	// Get gauge
-	value, exists := store.GetGauge(ctx, testKey)
-	assert.True(t, exists)
+	value, err := store.GetGauge(ctx, testKey)
+	require.NoError(t, err)
 	assert.Equal(t, testValue, value)
 
 	// Test non-existing gauge
-	_, exists = store.GetGauge(ctx, "non_existing")
-	assert.False(t, exists)
+	_, err = store.GetGauge(ctx, "non_existing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestMemStorage_SetEmptyKey(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	assert.ErrorIs(t, store.SetGauge(ctx, "", storage.Gauge(1)), storage.ErrInvalid)
+	assert.ErrorIs(t, store.SetCounter(ctx, "", storage.Counter(1)), storage.ErrInvalid)
 }
 
 func TestMemStorage_Counter_Operations(t *testing.T) {
@@ -49,20 +62,20 @@ func TestMemStorage_Counter_Operations(t *testing.T) {
 	store.SetCounter(ctx, testKey, testValue)
 
 	// Get counter
-	value, exists := store.GetCounter(ctx, testKey)
-	assert.True(t, exists)
+	value, err := store.GetCounter(ctx, testKey)
+	require.NoError(t, err)
 	assert.Equal(t, testValue, value)
 
 	// Test counter accumulation (if Count method adds values)
 	store.SetCounter(ctx, testKey, storage.Counter(50))
-	value, exists = store.GetCounter(ctx, testKey)
-	assert.True(t, exists)
+	value, err = store.GetCounter(ctx, testKey)
+	require.NoError(t, err)
 	// Assuming Count method accumulates values
 	assert.Equal(t, storage.Counter(150), value)
 
 	// Test non-existing counter
-	_, exists = store.GetCounter(ctx, "non_existing")
-	assert.False(t, exists)
+	_, err = store.GetCounter(ctx, "non_existing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
 }
 
 func TestMemStorage_Keys(t *testing.T) {
@@ -70,8 +83,10 @@ func TestMemStorage_Keys(t *testing.T) {
 	ctx := context.Background()
 
 	// Initially no keys
-	gaugeKeys := store.KeysGauge(ctx)
-	counterKeys := store.KeysCounter(ctx)
+	gaugeKeys, err := store.KeysGauge(ctx)
+	require.NoError(t, err)
+	counterKeys, err := store.KeysCounter(ctx)
+	require.NoError(t, err)
 	assert.Empty(t, gaugeKeys)
 	assert.Empty(t, counterKeys)
 
@@ -82,8 +97,10 @@ func TestMemStorage_Keys(t *testing.T) {
 	store.SetCounter(ctx, "counter2", storage.Counter(20))
 
 	// Check keys
-	gaugeKeys = store.KeysGauge(ctx)
-	counterKeys = store.KeysCounter(ctx)
+	gaugeKeys, err = store.KeysGauge(ctx)
+	require.NoError(t, err)
+	counterKeys, err = store.KeysCounter(ctx)
+	require.NoError(t, err)
 
 	assert.Len(t, gaugeKeys, 2)
 	assert.Contains(t, gaugeKeys, "gauge1")
@@ -120,8 +137,8 @@ func TestMemStorage_Multiple_Operations(t *testing.T) {
 
 	// Verify all gauges
 	for key, expectedValue := range gauges {
-		value, exists := store.GetGauge(ctx, key)
-		assert.True(t, exists, "Gauge %s should exist", key)
+		value, err := store.GetGauge(ctx, key)
+		require.NoError(t, err, "Gauge %s should exist", key)
 		assert.Equal(t, expectedValue, value, "Gauge %s value mismatch", key)
 	}
 
@@ -138,8 +155,343 @@ func TestMemStorage_Multiple_Operations(t *testing.T) {
 
 	// Verify all counters
 	for key, expectedValue := range counters {
-		value, exists := store.GetCounter(ctx, key)
-		assert.True(t, exists, "Counter %s should exist", key)
+		value, err := store.GetCounter(ctx, key)
+		require.NoError(t, err, "Counter %s should exist", key)
 		assert.Equal(t, expectedValue, value, "Counter %s value mismatch", key)
 	}
 }
+
+func TestMemStorage_QueryRange(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	from := time.Now().Add(-time.Minute)
+	store.SetGauge(ctx, "gauge", storage.Gauge(1.5))
+	store.SetGauge(ctx, "gauge", storage.Gauge(2.5))
+	store.SetCounter(ctx, "counter", storage.Counter(10))
+	store.SetCounter(ctx, "counter", storage.Counter(5))
+	to := time.Now().Add(time.Minute)
+
+	gauges, err := store.QueryRange(ctx, storage.MetricTypeGauge, "gauge", from, to)
+	require.NoError(t, err)
+	require.Len(t, gauges, 2)
+	assert.Equal(t, 1.5, gauges[0].Value)
+	assert.Equal(t, 2.5, gauges[1].Value)
+
+	// Для counter в истории хранится накопленное значение
+	counters, err := store.QueryRange(ctx, storage.MetricTypeCounter, "counter", from, to)
+	require.NoError(t, err)
+	require.Len(t, counters, 2)
+	assert.Equal(t, 10.0, counters[0].Value)
+	assert.Equal(t, 15.0, counters[1].Value)
+
+	empty, err := store.QueryRange(ctx, storage.MetricTypeGauge, "unknown", from, to)
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	_, err = store.QueryRange(ctx, "unknown", "gauge", from, to)
+	assert.Error(t, err)
+}
+
+func TestMemStorage_LabeledSeries(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	web1 := storage.SeriesKey("Alloc", map[string]string{"host": "web-1"})
+	web2 := storage.SeriesKey("Alloc", map[string]string{"host": "web-2"})
+
+	store.SetGauge(ctx, web1, storage.Gauge(1))
+	store.SetGauge(ctx, web2, storage.Gauge(2))
+	store.SetGauge(ctx, "Alloc", storage.Gauge(3))
+
+	v, err := store.GetGauge(ctx, web1)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(1), v)
+
+	v, err = store.GetGauge(ctx, web2)
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(2), v)
+
+	keys, err := store.KeysGauge(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Alloc", web1, web2}, keys)
+}
+
+func TestMemStorage_UpdateBatch(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+	gauge := func(v float64) *float64 { return &v }
+	delta := func(d int64) *int64 { return &d }
+
+	err := store.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: gauge(1)},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: delta(2)},
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: gauge(3)},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: delta(5)},
+	})
+	require.NoError(t, err)
+
+	snap := store.Snapshot()
+	assert.Equal(t, map[string]storage.Gauge{"Alloc": 3}, snap.Gauges)
+	assert.Equal(t, map[string]storage.Counter{"PollCount": 7}, snap.Counters)
+
+	// Недопустимая метрика отклоняет весь пакет
+	err = store.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: gauge(10)},
+		{ID: "PollCount", MType: storage.MetricTypeCounter},
+	})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	assert.Equal(t, snap, store.Snapshot())
+}
+
+func TestMemStorage_Delete(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	require.NoError(t, store.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, store.SetCounter(ctx, "PollCount", 2))
+
+	assert.ErrorIs(t, store.Delete(ctx, storage.MetricTypeCounter, "Alloc"), storage.ErrNotFound)
+	assert.ErrorIs(t, store.Delete(ctx, "unknown", "Alloc"), storage.ErrInvalid)
+	require.NoError(t, store.Delete(ctx, storage.MetricTypeGauge, "Alloc"))
+	assert.ErrorIs(t, store.Delete(ctx, storage.MetricTypeGauge, "Alloc"), storage.ErrNotFound)
+
+	_, err := store.GetGauge(ctx, "Alloc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	samples, err := store.QueryRange(ctx, storage.MetricTypeGauge, "Alloc", time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+
+	v, err := store.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(2), v)
+}
+
+func TestMemStorage_DeleteMatching(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	require.NoError(t, store.SetGauge(ctx, `disk_free{host="web-1"}`, 1))
+	require.NoError(t, store.SetGauge(ctx, `disk_free{host="web-2"}`, 2))
+	require.NoError(t, store.SetCounter(ctx, `disk_errors{host="web-1"}`, 3))
+	require.NoError(t, store.SetGauge(ctx, "Alloc", 4))
+
+	_, err := store.DeleteMatching(ctx, storage.DeleteFilter{})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+
+	n, err := store.DeleteMatching(ctx, storage.DeleteFilter{Prefix: "disk_", Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	snap := store.Snapshot()
+	assert.Equal(t, map[string]storage.Gauge{`disk_free{host="web-2"}`: 2, "Alloc": 4}, snap.Gauges)
+	assert.Empty(t, snap.Counters)
+}
+
+func TestMemStorage_ResetCounter(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	assert.ErrorIs(t, store.ResetCounter(ctx, "PollCount"), storage.ErrNotFound)
+
+	require.NoError(t, store.SetCounter(ctx, "PollCount", 5))
+	require.NoError(t, store.ResetCounter(ctx, "PollCount"))
+	require.NoError(t, store.SetCounter(ctx, "PollCount", 2))
+
+	v, err := store.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(2), v)
+
+	samples, err := store.QueryRange(ctx, storage.MetricTypeCounter, "PollCount", time.Time{}, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 3)
+	assert.Equal(t, 0.0, samples[1].Value)
+}
+
+func TestMemStorage_LastUpdated(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	before := time.Now()
+	require.NoError(t, store.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, store.UpdateBatch(ctx, []models.Metrics{
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: func() *int64 { d := int64(1); return &d }()},
+	}))
+
+	updated, err := store.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	require.Contains(t, updated, "Alloc")
+	assert.False(t, updated["Alloc"].Before(before))
+
+	updated, err = store.LastUpdated(ctx, storage.MetricTypeCounter, "PollCount", "Unknown")
+	require.NoError(t, err)
+	assert.Len(t, updated, 1)
+	assert.Contains(t, updated, "PollCount")
+
+	_, err = store.LastUpdated(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+
+	require.NoError(t, store.Delete(ctx, storage.MetricTypeGauge, "Alloc"))
+	updated, err = store.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Empty(t, updated)
+}
+
+func TestMemStorage_DeleteStale(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+	now := time.Now()
+
+	require.NoError(t, store.SetGauge(ctx, "Old", 1))
+	require.NoError(t, store.SetGauge(ctx, "Fresh", 2))
+	store.GaugesUpdated.Set("Old", now.Add(-time.Hour))
+
+	// Fresh обновлена после момента before и не удаляется, хотя передана
+	n, err := store.DeleteStale(ctx, storage.MetricTypeGauge, []string{"Old", "Fresh", "Unknown"}, now.Add(-time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	keys, err := store.KeysGauge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Fresh"}, keys)
+	samples, err := store.QueryRange(ctx, storage.MetricTypeGauge, "Old", time.Time{}, time.Now())
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+}
+
+func TestMemStorage_DeleteStaleConcurrentWrite(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	keys := make([]string, 20000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("gauge_%d", i)
+		require.NoError(t, store.SetGauge(ctx, keys[i], 1))
+		store.GaugesUpdated.Set(keys[i], time.Now().Add(-time.Hour))
+	}
+	before := time.Now()
+
+	// Запись во время удаления: каждая серия либо не удалена,
+	// либо удалена до записи и создана ею заново
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := len(keys) - 1; i >= 0; i-- {
+			store.SetGauge(ctx, keys[i], 2)
+		}
+	}()
+	_, err := store.DeleteStale(ctx, storage.MetricTypeGauge, keys, before)
+	require.NoError(t, err)
+	wg.Wait()
+
+	updated, err := store.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	for _, k := range keys {
+		g, err := store.GetGauge(ctx, k)
+		require.NoError(t, err, k)
+		assert.Equal(t, storage.Gauge(2), g)
+		assert.False(t, updated[k].Before(before), k)
+	}
+}
+
+func TestMemStorage_Histogram(t *testing.T) {
+	ctx := context.Background()
+	store := NewStorage()
+	v := 1.0
+	latency := func(bounds []float64, obs ...float64) models.Metrics {
+		h := &models.Histogram{Observations: obs}
+		for _, b := range bounds {
+			h.Buckets = append(h.Buckets, models.Bucket{Le: b})
+		}
+		return models.Metrics{ID: "latency", MType: storage.MetricTypeHistogram, Histogram: h}
+	}
+
+	require.NoError(t, store.UpdateBatch(ctx, []models.Metrics{latency([]float64{0.1, 1}, 0.05, 0.5)}))
+	require.NoError(t, store.UpdateBatch(ctx, []models.Metrics{latency([]float64{0.1, 1}, 2)}))
+
+	h, err := store.GetHistogram(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 1, 1}, Sum: 2.55, Count: 3}, h)
+
+	// Пакет с несовпадающими границами не применяется целиком
+	err = store.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v},
+		latency([]float64{5}, 1),
+	})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	_, err = store.GetGauge(ctx, "Alloc")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	keys, err := store.KeysHistogram(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"latency"}, keys)
+	updated, err := store.LastUpdated(ctx, storage.MetricTypeHistogram)
+	require.NoError(t, err)
+	assert.Contains(t, updated, "latency")
+
+	require.NoError(t, store.Delete(ctx, storage.MetricTypeHistogram, "latency"))
+	_, err = store.GetHistogram(ctx, "latency")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestMemStorage_Concurrent(t *testing.T) {
+	store := NewStorage()
+	ctx := context.Background()
+
+	const workers, iterations = 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				store.SetCounter(ctx, "PollCount", 1)
+				store.SetGauge(ctx, fmt.Sprintf("gauge_%d", w), storage.Gauge(i))
+				store.KeysGauge(ctx)
+				store.Snapshot()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	snap := store.Snapshot()
+	assert.Equal(t, storage.Counter(workers*iterations), snap.Counters["PollCount"])
+	assert.Len(t, snap.Gauges, workers)
+}
+
+func BenchmarkMemStorage_SetCounterParallel(b *testing.B) {
+	store := NewStorage()
+	ctx := context.Background()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("counter_%d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.SetCounter(ctx, keys[i%len(keys)], 1)
+			i++
+		}
+	})
+}
+
+func BenchmarkMemStorage_SetGaugeParallel(b *testing.B) {
+	store := NewStorage()
+	ctx := context.Background()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("gauge_%d", i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			store.SetGauge(ctx, keys[i%len(keys)], storage.Gauge(i))
+			i++
+		}
+	})
+}
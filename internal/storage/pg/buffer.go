@@ -0,0 +1,312 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/am0xff/metrics/internal/utils"
+)
+
+// Option настраивает PGStorage.
+type Option func(*PGStorage)
+
+// WithWriteBehind включает отложенную запись: изменения накапливаются в памяти
+// (для gauge остается последнее значение, приращения counter суммируются)
+// и записываются в базу одной транзакцией каждые interval или как только
+// в буфере наберется maxSeries серий. Чтение учитывает еще не записанные
+// изменения. При interval <= 0 отложенная запись не включается.
+//
+// В историю (samples) при сбросе попадает одно значение на серию, поэтому
+// промежуточные значения между сбросами в ней не сохраняются.
+func WithWriteBehind(interval time.Duration, maxSeries int) Option {
+	return func(pgs *PGStorage) {
+		if interval <= 0 {
+			return
+		}
+		if maxSeries <= 0 {
+			maxSeries = maxBatchRows
+		}
+		pgs.buf = &writeBuffer{
+			interval:  interval,
+			maxSeries: maxSeries,
+			pending:   newPending(),
+			kick:      make(chan struct{}, 1),
+			done:      make(chan struct{}),
+			stopped:   make(chan struct{}),
+		}
+	}
+}
+
+// writeBuffer - буфер отложенной записи.
+type writeBuffer struct {
+	interval  time.Duration
+	maxSeries int
+
+	mu      sync.Mutex
+	pending *pending
+	oldest  time.Time // время самого старого не записанного изменения
+
+	// flushMu удерживается на запись на время сброса в базу, а на чтение -
+	// чтениями, которые складывают значение из базы с буфером. Иначе чтение
+	// могло бы учесть приращение counter и в базе, и в буфере.
+	flushMu sync.RWMutex
+
+	kick      chan struct{}
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+}
+
+// add добавляет изменения в буфер и запрашивает досрочный сброс,
+// если буфер заполнен.
+func (b *writeBuffer) add(updates []storage.Update) {
+	b.mu.Lock()
+	if b.pending.len() == 0 {
+		b.oldest = time.Now()
+	}
+	b.pending.add(updates)
+	full := b.pending.len() >= b.maxSeries
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// take забирает накопленные изменения вместе со временем самого старого
+// из них, оставляя буфер пустым.
+func (b *writeBuffer) take() (*pending, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	p, oldest := b.pending, b.oldest
+	b.pending = newPending()
+	b.oldest = time.Time{}
+	return p, oldest
+}
+
+// restore возвращает в буфер изменения, которые не удалось записать.
+// Значения gauge, измененные после take, новее возвращаемых и сохраняются.
+func (b *writeBuffer) restore(p *pending, oldest time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for k, v := range p.gauges {
+		if _, ok := b.pending.gauges[k]; !ok {
+			b.pending.gauges[k] = v
+		}
+	}
+	for k, v := range p.counters {
+		b.pending.counters[k] += v
+	}
+	if b.oldest.IsZero() || oldest.Before(b.oldest) {
+		b.oldest = oldest
+	}
+}
+
+func (b *writeBuffer) gauge(key string) (float64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.pending.gauges[key]
+	return v, ok
+}
+
+func (b *writeBuffer) counter(key string) (int64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	v, ok := b.pending.counters[key]
+	return v, ok
+}
+
+// keys дополняет ключи из базы ключами из буфера.
+func (b *writeBuffer) keys(keys []string, mtype storage.MetricType) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		seen[k] = true
+	}
+	add := func(k string) {
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	for k := range b.series(mtype) {
+		add(k)
+	}
+	return keys
+}
+
+// series возвращает множество ключей серий типа mtype в буфере. Вызывается под b.mu.
+// Histogram метрики не буферизуются.
+func (b *writeBuffer) series(mtype storage.MetricType) map[string]bool {
+	keys := make(map[string]bool)
+	switch mtype {
+	case storage.MetricTypeGauge:
+		for k := range b.pending.gauges {
+			keys[k] = true
+		}
+	case storage.MetricTypeCounter:
+		for k := range b.pending.counters {
+			keys[k] = true
+		}
+	}
+	return keys
+}
+
+// has сообщает, есть ли в буфере изменения серии key типа mtype.
+func (b *writeBuffer) has(mtype storage.MetricType, key string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.series(mtype)[key]
+}
+
+// matching возвращает ключи серий типа mtype в буфере, соответствующие фильтру f.
+func (b *writeBuffer) matching(mtype storage.MetricType, f storage.DeleteFilter) []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var keys []string
+	for k := range b.series(mtype) {
+		if f.Match(k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// remove удаляет из буфера изменения серий keys типа mtype.
+func (b *writeBuffer) remove(mtype storage.MetricType, keys ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, k := range keys {
+		switch mtype {
+		case storage.MetricTypeGauge:
+			delete(b.pending.gauges, k)
+		case storage.MetricTypeCounter:
+			delete(b.pending.counters, k)
+		}
+	}
+	if b.pending.len() == 0 {
+		b.oldest = time.Time{}
+	}
+}
+
+// resetCounter заменяет накопленное приращение counter нулем: при сбросе
+// буфера серия будет создана со значением 0, если ее еще нет в базе.
+func (b *writeBuffer) resetCounter(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending.counters[key] = 0
+}
+
+// lastUpdated дополняет время обновления из базы сериями из буфера: их время
+// обновления не раньше самого старого не записанного изменения. Если keys
+// не пуст, учитываются только серии из keys.
+func (b *writeBuffer) lastUpdated(updated map[string]time.Time, mtype storage.MetricType, keys []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	series := b.series(mtype)
+	if len(keys) > 0 {
+		wanted := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			wanted[k] = series[k]
+		}
+		series = wanted
+	}
+	for k, buffered := range series {
+		if buffered && updated[k].Before(b.oldest) {
+			updated[k] = b.oldest
+		}
+	}
+}
+
+// FlushLag возвращает возраст самого старого изменения, еще не записанного
+// в базу. Без отложенной записи и при пустом буфере возвращает 0.
+func (pgs *PGStorage) FlushLag() time.Duration {
+	if pgs.buf == nil {
+		return 0
+	}
+	pgs.buf.mu.Lock()
+	defer pgs.buf.mu.Unlock()
+	if pgs.buf.oldest.IsZero() {
+		return 0
+	}
+	return time.Since(pgs.buf.oldest)
+}
+
+// Flush записывает накопленные изменения в базу одной транзакцией.
+// При ошибке изменения остаются в буфере и будут записаны при следующем сбросе.
+func (pgs *PGStorage) Flush(ctx context.Context) error {
+	if pgs.buf == nil {
+		return nil
+	}
+	b := pgs.buf
+
+	b.flushMu.Lock()
+	defer b.flushMu.Unlock()
+
+	p, oldest := b.take()
+	if p.len() == 0 {
+		return nil
+	}
+
+	gauges, counters := p.rows()
+	err := utils.Call(ctx, func() error {
+		return pgs.updateBatch(ctx, gauges, counters, nil)
+	})
+	if err != nil {
+		b.restore(p, oldest)
+		return unavailable("flush", err)
+	}
+	return nil
+}
+
+// flushLoop сбрасывает буфер по таймеру и при заполнении до вызова Close.
+func (pgs *PGStorage) flushLoop() {
+	b := pgs.buf
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+		case <-b.kick:
+		}
+		if err := pgs.Flush(context.Background()); err != nil {
+			log.Printf("Flush write-behind buffer: %v", err)
+		}
+	}
+}
+
+// Close останавливает фоновый сброс и записывает оставшиеся в буфере изменения.
+// Без отложенной записи ничего не делает.
+func (pgs *PGStorage) Close(ctx context.Context) error {
+	if pgs.buf == nil {
+		return nil
+	}
+	pgs.buf.closeOnce.Do(func() {
+		close(pgs.buf.done)
+	})
+	<-pgs.buf.stopped
+
+	if err := pgs.Flush(ctx); err != nil {
+		return fmt.Errorf("drain write-behind buffer: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/am0xff/metrics/internal/models"
+	"github.com/am0xff/metrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPGStorage_WriteBehind_Merge(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Сброс по таймеру не срабатывает в течение теста
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+
+	require.NoError(t, pgs.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, pgs.SetGauge(ctx, "Alloc", 2))
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 3))
+	v := 5.0
+	require.NoError(t, pgs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v},
+	}))
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 4))
+	assert.Positive(t, pgs.FlushLag())
+
+	// Чтение учитывает буфер: gauge из буфера без запроса, counter - база плюс буфер
+	g, err := pgs.GetGauge(ctx, "Alloc")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Gauge(5), g)
+
+	mock.ExpectQuery("SELECT value FROM counters").
+		WithArgs("PollCount").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(10))
+	c, err := pgs.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(17), c)
+
+	mock.ExpectQuery("SELECT key FROM gauges").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("Alloc").AddRow("HeapSys"))
+	keys, err := pgs.KeysGauge(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"Alloc", "HeapSys"}, keys)
+
+	// Close записывает буфер одной транзакцией: одна строка на серию
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").
+		WithArgs(sqlmock.AnyArg(), "Alloc", 5.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO counters").
+		WithArgs(sqlmock.AnyArg(), "PollCount", int64(7)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, pgs.Close(ctx))
+	assert.Zero(t, pgs.FlushLag())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_CounterOnlyInBuffer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 3))
+
+	mock.ExpectQuery("SELECT value FROM counters").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	c, err := pgs.GetCounter(ctx, "PollCount")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Counter(3), c)
+
+	mock.ExpectQuery("SELECT value FROM counters").WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	_, err = pgs.GetCounter(ctx, "Unknown")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_FlushError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 3))
+
+	dbErr := errors.New("constraint violation")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO counters").WillReturnError(dbErr)
+	mock.ExpectRollback()
+	assert.ErrorIs(t, pgs.Flush(ctx), storage.ErrUnavailable)
+
+	// Изменения возвращены в буфер и объединены с новыми
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 2))
+	assert.Positive(t, pgs.FlushLag())
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO counters").
+		WithArgs(sqlmock.AnyArg(), "PollCount", int64(5)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, pgs.Close(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_SizeThreshold(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 2))
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").WillReturnResult(sqlmock.NewResult(2, 2))
+	mock.ExpectCommit()
+
+	require.NoError(t, pgs.SetGauge(ctx, "a", 1))
+	require.NoError(t, pgs.SetGauge(ctx, "b", 2))
+
+	assert.Eventually(t, func() bool {
+		return mock.ExpectationsWereMet() == nil
+	}, time.Second, 5*time.Millisecond)
+	require.NoError(t, pgs.Close(ctx))
+}
+
+func TestWithWriteBehind_Disabled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(0, 100))
+	assert.Nil(t, pgs.buf)
+
+	mock.ExpectExec("INSERT INTO gauges").WillReturnResult(sqlmock.NewResult(1, 1))
+	require.NoError(t, pgs.SetGauge(context.Background(), "Alloc", 1))
+	assert.Zero(t, pgs.FlushLag())
+	assert.NoError(t, pgs.Close(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_DeleteAndReset(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+
+	require.NoError(t, pgs.SetGauge(ctx, "Alloc", 1))
+	require.NoError(t, pgs.SetCounter(ctx, "PollCount", 3))
+	require.NoError(t, pgs.SetCounter(ctx, "Errors", 2))
+
+	// Серия только в буфере: в базе строк нет, но удаление успешно
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM gauges").WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectCommit()
+	require.NoError(t, pgs.Delete(ctx, storage.MetricTypeGauge, "Alloc"))
+
+	// Сброс обнуляет и значение в базе, и накопленное приращение
+	mock.ExpectExec("UPDATE counters SET value = 0").
+		WithArgs("PollCount", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, pgs.ResetCounter(ctx, "PollCount"))
+
+	mock.ExpectQuery("SELECT key FROM counters").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	n, err := pgs.DeleteMatching(ctx, storage.DeleteFilter{Type: storage.MetricTypeCounter, Prefix: "Err"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// В базу записывается только обнуленный counter
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO counters").
+		WithArgs(sqlmock.AnyArg(), "PollCount", int64(0)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	require.NoError(t, pgs.Close(ctx))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_LastUpdated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour)
+
+	require.NoError(t, pgs.SetGauge(ctx, "Alloc", 1))
+
+	mock.ExpectQuery("SELECT key, updated_at FROM gauges").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "updated_at"}).
+			AddRow("Alloc", old).
+			AddRow("HeapSys", old))
+	updated, err := pgs.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.True(t, updated["Alloc"].After(old))
+	assert.Equal(t, old, updated["HeapSys"])
+
+	// Серия с изменениями в буфере не удаляется как устаревшая
+	n, err := pgs.DeleteStale(ctx, storage.MetricTypeGauge, []string{"Alloc"}, time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_WriteBehind_QueryRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db, WithWriteBehind(time.Hour, 100))
+	ctx := context.Background()
+	from := time.Now().Add(-time.Hour)
+	to := time.Now().Add(time.Hour)
+
+	require.NoError(t, pgs.SetGauge(ctx, "Alloc", 5))
+
+	// Изменения серии в буфере записываются в базу до чтения истории
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").
+		WithArgs(sqlmock.AnyArg(), "Alloc", 5.0).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT ts, value FROM samples").
+		WithArgs("gauge", "Alloc", from.UTC(), to.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"ts", "value"}).AddRow(time.Now(), 5.0))
+
+	samples, err := pgs.QueryRange(ctx, storage.MetricTypeGauge, "Alloc", from, to)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 5.0, samples[0].Value)
+	assert.Zero(t, pgs.FlushLag())
+
+	// Без изменений в буфере сброс не выполняется
+	mock.ExpectQuery("SELECT ts, value FROM samples").
+		WithArgs("gauge", "HeapSys", from.UTC(), to.UTC()).
+		WillReturnRows(sqlmock.NewRows([]string{"ts", "value"}))
+	samples, err = pgs.QueryRange(ctx, storage.MetricTypeGauge, "HeapSys", from, to)
+	require.NoError(t, err)
+	assert.Empty(t, samples)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
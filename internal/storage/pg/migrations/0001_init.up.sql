@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS gauges (
+	key TEXT PRIMARY KEY,
+	value DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS counters (
+	key TEXT PRIMARY KEY,
+	value BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS samples (
+	type TEXT NOT NULL,
+	key TEXT NOT NULL,
+	ts TIMESTAMPTZ NOT NULL,
+	value DOUBLE PRECISION NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS samples_type_key_ts_idx ON samples (type, key, ts);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS counters_updated_at_idx;
+DROP INDEX IF EXISTS gauges_updated_at_idx;
+
+ALTER TABLE counters DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE gauges DROP COLUMN IF EXISTS updated_at;
@@ -0,0 +1,5 @@
+ALTER TABLE gauges ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+ALTER TABLE counters ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS gauges_updated_at_idx ON gauges (updated_at);
+CREATE INDEX IF NOT EXISTS counters_updated_at_idx ON counters (updated_at);
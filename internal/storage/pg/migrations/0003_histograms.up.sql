@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS histograms (
+	key TEXT PRIMARY KEY,
+	value JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS histograms_updated_at_idx ON histograms (updated_at);
@@ -3,97 +3,650 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
-	memstorage "github.com/am0xff/metrics/internal/storage/memory"
 	"github.com/am0xff/metrics/internal/utils"
 )
 
 type PGStorage struct {
-	ms *memstorage.MemStorage
-	db *sql.DB
+	db  *sql.DB
+	buf *writeBuffer // nil, если отложенная запись выключена
 }
 
-func NewStorage(db *sql.DB) *PGStorage {
-	return &PGStorage{
+// NewStorage создает хранилище в базе данных db. С опцией WithWriteBehind
+// запускает фоновый сброс буфера, который останавливается вызовом Close.
+func NewStorage(db *sql.DB, opts ...Option) *PGStorage {
+	pgs := &PGStorage{
 		db: db,
-		ms: memstorage.NewStorage(),
 	}
+	for _, opt := range opts {
+		opt(pgs)
+	}
+	if pgs.buf != nil {
+		go pgs.flushLoop()
+	}
+	return pgs
 }
 
+// Bootstrap приводит схему базы данных к последней версии, применяя
+// встроенные миграции (см. Migrator). Вызов безопасен при одновременном
+// запуске нескольких серверов и при уже актуальной схеме.
 func (pgs *PGStorage) Bootstrap(ctx context.Context) error {
-	tx, err := pgs.db.BeginTx(ctx, nil)
+	m, err := NewMigrator(pgs.db)
 	if err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	return utils.Call(ctx, func() error {
+		return m.Up(ctx)
+	})
+}
+
+func (pgs *PGStorage) SetGauge(ctx context.Context, key string, value storage.Gauge) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
+	}
 
-	if err := utils.Call(ctx, func() error {
+	if pgs.buf != nil {
+		pgs.buf.add([]storage.Update{{Type: storage.MetricTypeGauge, Key: key, Value: value}})
+		return nil
+	}
+
+	err := utils.Call(ctx, func() error {
 		_, err := pgs.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS gauges (
-			key TEXT PRIMARY KEY,
-			value DOUBLE PRECISION NOT NULL
-		)
-	`)
-		return err
-	}); err != nil {
+			WITH g AS (
+				INSERT INTO gauges (key, value, updated_at)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+				RETURNING value
+			)
+			INSERT INTO samples (type, key, ts, value)
+			SELECT 'gauge', $1, $3, value FROM g
+		`, key, float64(value), time.Now().UTC())
 		return err
+	})
+	if err != nil {
+		return unavailable("set gauge", err)
+	}
+	return nil
+}
+
+func (pgs *PGStorage) GetGauge(ctx context.Context, key string) (storage.Gauge, error) {
+	if pgs.buf != nil {
+		pgs.buf.flushMu.RLock()
+		defer pgs.buf.flushMu.RUnlock()
+		if v, ok := pgs.buf.gauge(key); ok {
+			return storage.Gauge(v), nil
+		}
+	}
+
+	var v float64
+	err := utils.Call(ctx, func() error {
+		return pgs.db.QueryRowContext(ctx, `
+			SELECT value FROM gauges WHERE key = $1
+		`, key).Scan(&v)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, unavailable("get gauge", err)
+	}
+	return storage.Gauge(v), nil
+}
+
+func (pgs *PGStorage) KeysGauge(ctx context.Context) ([]string, error) {
+	return pgs.keys(ctx, storage.MetricTypeGauge, `SELECT key FROM gauges`)
+}
+
+func (pgs *PGStorage) SetCounter(ctx context.Context, key string, value storage.Counter) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty key", storage.ErrInvalid)
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.add([]storage.Update{{Type: storage.MetricTypeCounter, Key: key, Delta: value}})
+		return nil
 	}
 
-	if err = utils.Call(ctx, func() error {
+	err := utils.Call(ctx, func() error {
 		_, err := pgs.db.ExecContext(ctx, `
-		CREATE TABLE IF NOT EXISTS counters (
-			key TEXT PRIMARY KEY,
-			value BIGINT NOT NULL
+			WITH c AS (
+				INSERT INTO counters (key, value, updated_at)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (key) DO UPDATE SET value = counters.value + EXCLUDED.value, updated_at = EXCLUDED.updated_at
+				RETURNING value
+			)
+			INSERT INTO samples (type, key, ts, value)
+			SELECT 'counter', $1, $3, value FROM c
+		`, key, int64(value), time.Now().UTC())
+		return err
+	})
+	if err != nil {
+		return unavailable("set counter", err)
+	}
+	return nil
+}
+
+func (pgs *PGStorage) GetCounter(ctx context.Context, key string) (storage.Counter, error) {
+	var delta int64
+	var buffered bool
+	if pgs.buf != nil {
+		pgs.buf.flushMu.RLock()
+		defer pgs.buf.flushMu.RUnlock()
+		delta, buffered = pgs.buf.counter(key)
+	}
+
+	var v int64
+	err := utils.Call(ctx, func() error {
+		return pgs.db.QueryRowContext(ctx, `
+			SELECT value FROM counters WHERE key = $1
+		`, key).Scan(&v)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		if buffered {
+			return storage.Counter(delta), nil
+		}
+		return 0, storage.ErrNotFound
+	}
+	if err != nil {
+		return 0, unavailable("get counter", err)
+	}
+	return storage.Counter(v + delta), nil
+}
+
+func (pgs *PGStorage) KeysCounter(ctx context.Context) ([]string, error) {
+	return pgs.keys(ctx, storage.MetricTypeCounter, `SELECT key FROM counters`)
+}
+
+// GetHistogram возвращает значение histogram метрики. Histogram метрики
+// не буферизуются и читаются из базы.
+func (pgs *PGStorage) GetHistogram(ctx context.Context, key string) (storage.Histogram, error) {
+	var data []byte
+	err := utils.Call(ctx, func() error {
+		return pgs.db.QueryRowContext(ctx, `
+			SELECT value FROM histograms WHERE key = $1
+		`, key).Scan(&data)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return storage.Histogram{}, storage.ErrNotFound
+	}
+	if err != nil {
+		return storage.Histogram{}, unavailable("get histogram", err)
+	}
+
+	var h storage.Histogram
+	if err := json.Unmarshal(data, &h); err != nil {
+		return storage.Histogram{}, unavailable("get histogram", err)
+	}
+	return h, nil
+}
+
+func (pgs *PGStorage) KeysHistogram(ctx context.Context) ([]string, error) {
+	return pgs.keys(ctx, storage.MetricTypeHistogram, `SELECT key FROM histograms`)
+}
+
+// QueryRange возвращает историю значений серии из таблицы samples.
+// При отложенной записи изменения серии, еще не записанные в базу,
+// сначала сбрасываются, чтобы попасть в историю.
+func (pgs *PGStorage) QueryRange(ctx context.Context, mtype storage.MetricType, key string, from, to time.Time) ([]storage.Sample, error) {
+	if mtype != storage.MetricTypeGauge && mtype != storage.MetricTypeCounter {
+		return nil, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+
+	if pgs.buf != nil && pgs.buf.has(mtype, key) {
+		if err := pgs.Flush(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	samples := make([]storage.Sample, 0)
+	err := utils.Call(ctx, func() error {
+		rows, err := pgs.db.QueryContext(ctx, `
+			SELECT ts, value FROM samples
+			WHERE type = $1 AND key = $2 AND ts >= $3 AND ts <= $4
+			ORDER BY ts
+		`, string(mtype), key, from.UTC(), to.UTC())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		samples = samples[:0]
+		for rows.Next() {
+			var s storage.Sample
+			if err := rows.Scan(&s.Timestamp, &s.Value); err != nil {
+				return err
+			}
+			samples = append(samples, s)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, unavailable("query range", err)
+	}
+
+	return samples, nil
+}
+
+// maxBatchRows - максимальное количество строк в одном многострочном INSERT.
+// Ограничивает число параметров запроса (в PostgreSQL не более 65535).
+const maxBatchRows = 1000
+
+// UpdateBatch применяет пакет метрик в одной транзакции: при любой ошибке
+// транзакция откатывается и ни одна метрика пакета не сохраняется.
+// При отложенной записи пакет, кроме histogram метрик, добавляется в буфер;
+// histogram метрики записываются сразу, до добавления в буфер.
+//
+// Значения gauge (последнее в пакете) и приращения counter (сумма)
+// предварительно агрегируются по ключу серии и записываются многострочными
+// upsert-запросами. Строки упорядочены по ключу, поэтому параллельные
+// транзакции блокируют строки в одном порядке и не взаимоблокируются.
+func (pgs *PGStorage) UpdateBatch(ctx context.Context, metrics []models.Metrics) error {
+	updates, err := storage.NewUpdates(metrics)
+	if err != nil {
+		return err
+	}
+	histograms, err := aggregateHistograms(updates)
+	if err != nil {
+		return err
+	}
+
+	var gauges, counters []batchRow
+	if pgs.buf == nil {
+		gauges, counters = aggregate(updates)
+	}
+	if pgs.buf == nil || len(histograms) > 0 {
+		err = utils.Call(ctx, func() error {
+			return pgs.updateBatch(ctx, gauges, counters, histograms)
+		})
+		if errors.Is(err, storage.ErrInvalid) {
+			return err
+		}
+		if err != nil {
+			return unavailable("update batch", err)
+		}
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.add(updates)
+	}
+	return nil
+}
+
+func (pgs *PGStorage) updateBatch(ctx context.Context, gauges, counters []batchRow, histograms []histogramRow) error {
+	tx, err := pgs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	if err := upsertRows(ctx, tx, `
+		WITH g AS (
+			INSERT INTO gauges (key, value, updated_at)
+			VALUES %s
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+			RETURNING key, value
 		)
-	`)
+		INSERT INTO samples (type, key, ts, value)
+		SELECT 'gauge', key, $1, value FROM g
+	`, gauges, now); err != nil {
 		return err
-	}); err != nil {
+	}
+	if err := upsertRows(ctx, tx, `
+		WITH c AS (
+			INSERT INTO counters (key, value, updated_at)
+			VALUES %s
+			ON CONFLICT (key) DO UPDATE SET value = counters.value + EXCLUDED.value, updated_at = EXCLUDED.updated_at
+			RETURNING key, value
+		)
+		INSERT INTO samples (type, key, ts, value)
+		SELECT 'counter', key, $1, value FROM c
+	`, counters, now); err != nil {
 		return err
 	}
+	for _, r := range histograms {
+		if err := mergeHistogram(ctx, tx, r, now); err != nil {
+			return err
+		}
+	}
 
 	return tx.Commit()
 }
 
-func (pgs *PGStorage) SetGauge(ctx context.Context, key string, value storage.Gauge) {
-	pgs.ms.SetGauge(ctx, key, value)
+// histogramRow - наблюдения histogram, прибавляемые к серии.
+type histogramRow struct {
+	key  string
+	hist storage.Histogram
+}
+
+// aggregateHistograms сводит изменения histogram пакета к одной строке
+// на серию, упорядоченной по ключу. Если границы корзин одной серии
+// в пакете различаются, возвращает ErrInvalid.
+func aggregateHistograms(updates []storage.Update) ([]histogramRow, error) {
+	merged := make(map[string]*storage.Histogram)
+	for _, u := range updates {
+		if u.Type != storage.MetricTypeHistogram {
+			continue
+		}
+		h, ok := merged[u.Key]
+		if !ok {
+			h = &storage.Histogram{}
+			merged[u.Key] = h
+		}
+		if err := h.Merge(u.Hist); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, u.Key)
+		}
+	}
+
+	rows := make([]histogramRow, 0, len(merged))
+	for k, h := range merged {
+		rows = append(rows, histogramRow{key: k, hist: *h})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].key < rows[j].key })
+	return rows, nil
+}
+
+// mergeHistogram прибавляет наблюдения r к серии в транзакции tx. Новая серия
+// вставляется сразу; существующая блокируется, складывается с наблюдениями
+// и перезаписывается. Если границы корзин не совпадают с сохраненными,
+// возвращает ErrInvalid.
+func mergeHistogram(ctx context.Context, tx *sql.Tx, r histogramRow, ts time.Time) error {
+	data, err := json.Marshal(r.hist)
+	if err != nil {
+		return err
+	}
+
+	// Вставка ждет завершения конкурентной транзакции, вставляющей ту же
+	// серию, поэтому ее наблюдения не теряются
+	inserted, err := queryTxKeys(ctx, tx, `
+		INSERT INTO histograms (key, value, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (key) DO NOTHING
+		RETURNING key
+	`, r.key, data, ts)
+	if err != nil || len(inserted) > 0 {
+		return err
+	}
 
+	var stored []byte
+	if err := tx.QueryRowContext(ctx, `
+		SELECT value FROM histograms WHERE key = $1 FOR UPDATE
+	`, r.key).Scan(&stored); err != nil {
+		return err
+	}
+	var h storage.Histogram
+	if err := json.Unmarshal(stored, &h); err != nil {
+		return err
+	}
+	if err := h.Merge(r.hist); err != nil {
+		return fmt.Errorf("%w: %s", err, r.key)
+	}
+	if data, err = json.Marshal(h); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE histograms SET value = $2, updated_at = $3 WHERE key = $1
+	`, r.key, data, ts)
+	return err
+}
+
+// batchRow - строка многострочного upsert.
+type batchRow struct {
+	key   string
+	value any
+}
+
+// upsertRows выполняет запрос query для строк rows частями не более
+// maxBatchRows строк. В query вместо %s подставляется список VALUES
+// (ключ, значение, время записи), параметр $1 - время записи ts.
+func upsertRows(ctx context.Context, tx *sql.Tx, query string, rows []batchRow, ts time.Time) error {
+	for start := 0; start < len(rows); start += maxBatchRows {
+		chunk := rows[start:min(start+maxBatchRows, len(rows))]
+
+		values := make([]string, len(chunk))
+		args := make([]any, 0, 2*len(chunk)+1)
+		args = append(args, ts)
+		for i, r := range chunk {
+			values[i] = fmt.Sprintf("($%d, $%d, $1)", 2*i+2, 2*i+3)
+			args = append(args, r.key, r.value)
+		}
+
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(query, strings.Join(values, ", ")), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// aggregate сводит изменения пакета к одной строке на серию, упорядоченной по ключу.
+func aggregate(updates []storage.Update) (gauges, counters []batchRow) {
+	p := newPending()
+	p.add(updates)
+	return p.rows()
+}
+
+// pending - изменения, сведенные по ключу серии: последнее значение gauge
+// и сумма приращений counter.
+type pending struct {
+	gauges   map[string]float64
+	counters map[string]int64
+}
+
+func newPending() *pending {
+	return &pending{
+		gauges:   make(map[string]float64),
+		counters: make(map[string]int64),
+	}
+}
+
+// add сводит изменения gauge и counter; изменения histogram не буферизуются
+// и пропускаются.
+func (p *pending) add(updates []storage.Update) {
+	for _, u := range updates {
+		switch u.Type {
+		case storage.MetricTypeGauge:
+			p.gauges[u.Key] = float64(u.Value)
+		case storage.MetricTypeCounter:
+			p.counters[u.Key] += int64(u.Delta)
+		}
+	}
+}
+
+func (p *pending) len() int {
+	return len(p.gauges) + len(p.counters)
+}
+
+// rows возвращает строки upsert, упорядоченные по ключу.
+func (p *pending) rows() (gauges, counters []batchRow) {
+	for k, v := range p.gauges {
+		gauges = append(gauges, batchRow{key: k, value: v})
+	}
+	for k, v := range p.counters {
+		counters = append(counters, batchRow{key: k, value: v})
+	}
+	sort.Slice(gauges, func(i, j int) bool { return gauges[i].key < gauges[j].key })
+	sort.Slice(counters, func(i, j int) bool { return counters[i].key < counters[j].key })
+	return gauges, counters
+}
+
+// metricTables - таблицы текущих значений по типу метрики.
+var metricTables = map[storage.MetricType]string{
+	storage.MetricTypeGauge:     "gauges",
+	storage.MetricTypeCounter:   "counters",
+	storage.MetricTypeHistogram: "histograms",
+}
+
+// Delete удаляет метрику типа mtype по ключу вместе с ее историей (samples).
+// При отложенной записи удаляются и еще не записанные изменения серии.
+func (pgs *PGStorage) Delete(ctx context.Context, mtype storage.MetricType, key string) error {
+	if _, ok := metricTables[mtype]; !ok {
+		return fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+
+	// Сброс буфера не выполняется, пока удаление не завершено,
+	// иначе он мог бы вернуть удаленную серию в базу
+	var buffered bool
+	if pgs.buf != nil {
+		pgs.buf.flushMu.Lock()
+		defer pgs.buf.flushMu.Unlock()
+		buffered = pgs.buf.has(mtype, key)
+	}
+
+	var n int
 	err := utils.Call(ctx, func() error {
-		_, err := pgs.db.ExecContext(ctx, `
-			INSERT INTO gauges (key, value)
-			VALUES ($1, $2)
-			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value
-		`, key, float64(value))
+		var err error
+		n, err = pgs.deleteKeys(ctx, map[storage.MetricType][]string{mtype: {key}}, time.Time{})
 		return err
 	})
-
 	if err != nil {
-		log.Printf("DBStorage.SetGauge exec error: %v", err)
+		return unavailable("delete", err)
 	}
+
+	if buffered {
+		pgs.buf.remove(mtype, key)
+	}
+	if n == 0 && !buffered {
+		return storage.ErrNotFound
+	}
+	return nil
 }
 
-func (pgs *PGStorage) GetGauge(ctx context.Context, key string) (storage.Gauge, bool) {
-	var v float64
+// DeleteMatching удаляет все метрики, соответствующие фильтру f, в одной транзакции.
+// Фильтр проверяется по ключам серий на стороне сервера.
+func (pgs *PGStorage) DeleteMatching(ctx context.Context, f storage.DeleteFilter) (int, error) {
+	if err := f.Validate(); err != nil {
+		return 0, err
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.flushMu.Lock()
+		defer pgs.buf.flushMu.Unlock()
+	}
+
+	matched := make(map[storage.MetricType][]string)
+	for _, mtype := range f.Types() {
+		keys, err := pgs.queryKeys(ctx, fmt.Sprintf(`SELECT key FROM %s`, metricTables[mtype]))
+		if err != nil {
+			return 0, err
+		}
+		for _, k := range keys {
+			if f.Match(k) {
+				matched[mtype] = append(matched[mtype], k)
+			}
+		}
+	}
+
+	// Серии, которые есть только в буфере, тоже считаются удаленными
+	bufferOnly := make(map[storage.MetricType][]string)
+	if pgs.buf != nil {
+		for _, mtype := range f.Types() {
+			inDB := make(map[string]bool, len(matched[mtype]))
+			for _, k := range matched[mtype] {
+				inDB[k] = true
+			}
+			for _, k := range pgs.buf.matching(mtype, f) {
+				if !inDB[k] {
+					bufferOnly[mtype] = append(bufferOnly[mtype], k)
+				}
+			}
+		}
+	}
+
 	err := utils.Call(ctx, func() error {
-		return pgs.db.QueryRowContext(ctx, `
-			SELECT value FROM gauges WHERE key = $1
-		`, key).Scan(&v)
+		_, err := pgs.deleteKeys(ctx, matched, time.Time{})
+		return err
 	})
+	if err != nil {
+		return 0, unavailable("delete matching", err)
+	}
+
+	n := 0
+	for _, mtype := range f.Types() {
+		if pgs.buf != nil {
+			pgs.buf.remove(mtype, matched[mtype]...)
+			pgs.buf.remove(mtype, bufferOnly[mtype]...)
+		}
+		n += len(matched[mtype]) + len(bufferOnly[mtype])
+	}
+	return n, nil
+}
 
+// deleteKeys удаляет серии keys и их историю в одной транзакции
+// и возвращает количество удаленных серий. Если before не нулевое,
+// удаляются только серии, обновленные раньше before.
+func (pgs *PGStorage) deleteKeys(ctx context.Context, keys map[storage.MetricType][]string, before time.Time) (int, error) {
+	tx, err := pgs.db.BeginTx(ctx, nil)
 	if err != nil {
-		log.Printf("DBStorage.GetGauge query error: %v", err)
-		// fallback to memory
-		return pgs.ms.GetGauge(ctx, key)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n := 0
+	for _, mtype := range storage.MetricTypes() {
+		list := keys[mtype]
+		for start := 0; start < len(list); start += maxBatchRows {
+			chunk := list[start:min(start+maxBatchRows, len(list))]
+
+			args := make([]any, 0, len(chunk)+1)
+			for _, k := range chunk {
+				args = append(args, k)
+			}
+			query := fmt.Sprintf(`DELETE FROM %s WHERE key IN (%s)`, metricTables[mtype], placeholders(1, len(chunk)))
+			if !before.IsZero() {
+				args = append(args, before.UTC())
+				query += fmt.Sprintf(` AND updated_at < $%d`, len(args))
+			}
+
+			deleted, err := queryTxKeys(ctx, tx, query+` RETURNING key`, args...)
+			if err != nil {
+				return 0, err
+			}
+			if len(deleted) == 0 {
+				continue
+			}
+			n += len(deleted)
+
+			// $1 - тип метрики, ключи начинаются с $2
+			args = append(args[:0], string(mtype))
+			for _, k := range deleted {
+				args = append(args, k)
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
+				DELETE FROM samples WHERE type = $1 AND key IN (%s)
+			`, placeholders(2, len(deleted))), args...); err != nil {
+				return 0, err
+			}
+		}
 	}
-	return storage.Gauge(v), true
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// placeholders возвращает список из n параметров запроса, начиная с $from.
+func placeholders(from, n int) string {
+	list := make([]string, n)
+	for i := range list {
+		list[i] = fmt.Sprintf("$%d", from+i)
+	}
+	return strings.Join(list, ", ")
 }
 
-func (pgs *PGStorage) KeysGauge(ctx context.Context) []string {
-	rows, err := pgs.db.QueryContext(ctx, `SELECT key FROM gauges`)
+func queryTxKeys(ctx context.Context, tx *sql.Tx, query string, args ...any) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
 	if err != nil {
-		log.Printf("DBStorage.KeysGauge query error: %v", err)
-		return pgs.ms.KeysGauge(ctx)
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -101,73 +654,204 @@ func (pgs *PGStorage) KeysGauge(ctx context.Context) []string {
 	for rows.Next() {
 		var k string
 		if err := rows.Scan(&k); err != nil {
-			log.Printf("DBStorage.KeysGauge scan error: %v", err)
-			continue
+			return nil, err
 		}
 		keys = append(keys, k)
 	}
+	return keys, rows.Err()
+}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("DBStorage.KeysGauge rows iteration error: %v", err)
-		return pgs.ms.KeysGauge(ctx)
+// ResetCounter сбрасывает значение counter метрики в 0 и добавляет
+// нулевое значение в историю.
+func (pgs *PGStorage) ResetCounter(ctx context.Context, key string) error {
+	var buffered bool
+	if pgs.buf != nil {
+		pgs.buf.flushMu.Lock()
+		defer pgs.buf.flushMu.Unlock()
+		buffered = pgs.buf.has(storage.MetricTypeCounter, key)
 	}
 
-	return keys
+	var n int64
+	err := utils.Call(ctx, func() error {
+		res, err := pgs.db.ExecContext(ctx, `
+			WITH c AS (
+				UPDATE counters SET value = 0, updated_at = $2 WHERE key = $1
+				RETURNING key, value
+			)
+			INSERT INTO samples (type, key, ts, value)
+			SELECT 'counter', key, $2, value FROM c
+		`, key, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return unavailable("reset counter", err)
+	}
+
+	if buffered {
+		pgs.buf.resetCounter(key)
+	}
+	if n == 0 && !buffered {
+		return storage.ErrNotFound
+	}
+	return nil
 }
 
-func (pgs *PGStorage) SetCounter(ctx context.Context, key string, value storage.Counter) {
-	pgs.ms.SetCounter(ctx, key, value)
+// LastUpdated возвращает время последнего обновления серий типа mtype.
+// Серии с изменениями в буфере отложенной записи считаются обновленными
+// не раньше самого старого не записанного изменения.
+func (pgs *PGStorage) LastUpdated(ctx context.Context, mtype storage.MetricType, keys ...string) (map[string]time.Time, error) {
+	table, ok := metricTables[mtype]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.flushMu.RLock()
+		defer pgs.buf.flushMu.RUnlock()
+	}
 
-	_, err := pgs.db.ExecContext(ctx, `
-		INSERT INTO counters (key, value)
-		VALUES ($1, $2)
-		ON CONFLICT (key) DO UPDATE SET value = counters.value + EXCLUDED.value
-	`, key, int64(value))
+	// Ключи запрашиваются частями, как и в deleteKeys, чтобы не превысить
+	// ограничение на число параметров запроса
+	query := fmt.Sprintf(`SELECT key, updated_at FROM %s`, table)
+	updated := make(map[string]time.Time)
+	if len(keys) == 0 {
+		if err := pgs.queryUpdated(ctx, updated, query); err != nil {
+			return nil, unavailable("last updated", err)
+		}
+	}
+	for start := 0; start < len(keys); start += maxBatchRows {
+		chunk := keys[start:min(start+maxBatchRows, len(keys))]
 
+		args := make([]any, 0, len(chunk))
+		for _, k := range chunk {
+			args = append(args, k)
+		}
+		chunkQuery := query + fmt.Sprintf(` WHERE key IN (%s)`, placeholders(1, len(chunk)))
+		if err := pgs.queryUpdated(ctx, updated, chunkQuery, args...); err != nil {
+			return nil, unavailable("last updated", err)
+		}
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.lastUpdated(updated, mtype, keys)
+	}
+	return updated, nil
+}
+
+// queryUpdated выполняет запрос, возвращающий ключи и время обновления серий,
+// и добавляет результат в updated.
+func (pgs *PGStorage) queryUpdated(ctx context.Context, updated map[string]time.Time, query string, args ...any) error {
+	return utils.Call(ctx, func() error {
+		rows, err := pgs.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var k string
+			var ts time.Time
+			if err := rows.Scan(&k, &ts); err != nil {
+				return err
+			}
+			updated[k] = ts
+		}
+		return rows.Err()
+	})
+}
+
+// DeleteStale удаляет серии keys типа mtype, не обновлявшиеся с момента before.
+// Условие проверяется в том же запросе, что и удаление, поэтому серия,
+// обновленная конкурентно, не удаляется. Серии с изменениями в буфере
+// отложенной записи не удаляются.
+func (pgs *PGStorage) DeleteStale(ctx context.Context, mtype storage.MetricType, keys []string, before time.Time) (int, error) {
+	if _, ok := metricTables[mtype]; !ok {
+		return 0, fmt.Errorf("%w: unsupported metric type: %s", storage.ErrInvalid, mtype)
+	}
+
+	if pgs.buf != nil {
+		pgs.buf.flushMu.Lock()
+		defer pgs.buf.flushMu.Unlock()
+
+		unbuffered := make([]string, 0, len(keys))
+		for _, k := range keys {
+			if !pgs.buf.has(mtype, k) {
+				unbuffered = append(unbuffered, k)
+			}
+		}
+		keys = unbuffered
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	var n int
+	err := utils.Call(ctx, func() error {
+		var err error
+		n, err = pgs.deleteKeys(ctx, map[storage.MetricType][]string{mtype: keys}, before)
+		return err
+	})
 	if err != nil {
-		log.Printf("DBStorage.SetCounter exec error: %v", err)
+		return 0, unavailable("delete stale", err)
 	}
+	return n, nil
 }
 
-func (pgs *PGStorage) GetCounter(ctx context.Context, key string) (storage.Counter, bool) {
-	var v int64
-	err := pgs.db.QueryRowContext(ctx, `
-		SELECT value FROM counters WHERE key = $1
-	`, key).Scan(&v)
+func (pgs *PGStorage) Ping(ctx context.Context) error {
+	if err := pgs.db.PingContext(ctx); err != nil {
+		return unavailable("ping", err)
+	}
+	return nil
+}
+
+// keys выполняет запрос, возвращающий ключи метрик типа mtype,
+// и добавляет к ним ключи еще не записанных изменений.
+func (pgs *PGStorage) keys(ctx context.Context, mtype storage.MetricType, query string) ([]string, error) {
+	if pgs.buf != nil {
+		pgs.buf.flushMu.RLock()
+		defer pgs.buf.flushMu.RUnlock()
+	}
 
+	keys, err := pgs.queryKeys(ctx, query)
 	if err != nil {
-		log.Printf("DBStorage.GetCounter query error: %v", err)
-		return pgs.ms.GetCounter(ctx, key)
+		return nil, err
+	}
+
+	if pgs.buf != nil {
+		keys = pgs.buf.keys(keys, mtype)
 	}
-	return storage.Counter(v), true
+	return keys, nil
 }
 
-func (pgs *PGStorage) KeysCounter(ctx context.Context) []string {
-	rows, err := pgs.db.QueryContext(ctx, `SELECT key FROM counters`)
+// queryKeys выполняет запрос, возвращающий ключи метрик из базы.
+func (pgs *PGStorage) queryKeys(ctx context.Context, query string) ([]string, error) {
+	rows, err := pgs.db.QueryContext(ctx, query)
 	if err != nil {
-		log.Printf("DBStorage.KeysCounter query error: %v", err)
-		return pgs.ms.KeysCounter(ctx)
+		return nil, unavailable("list keys", err)
 	}
 	defer rows.Close()
 
-	var keys []string
+	keys := make([]string, 0)
 	for rows.Next() {
 		var k string
 		if err := rows.Scan(&k); err != nil {
-			log.Printf("DBStorage.KeysCounter scan error: %v", err)
-			continue
+			return nil, unavailable("list keys", err)
 		}
 		keys = append(keys, k)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("DBStorage.KeysGauge rows iteration error: %v", err)
-		return pgs.ms.KeysCounter(ctx)
+		return nil, unavailable("list keys", err)
 	}
 
-	return keys
+	return keys, nil
 }
 
-func (pgs *PGStorage) Ping(ctx context.Context) error {
-	return pgs.db.PingContext(ctx)
+// unavailable оборачивает ошибку базы данных в storage.ErrUnavailable.
+func unavailable(op string, err error) error {
+	return fmt.Errorf("%w: %s: %w", storage.ErrUnavailable, op, err)
 }
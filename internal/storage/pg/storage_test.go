@@ -3,9 +3,14 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/am0xff/metrics/internal/models"
 	"github.com/am0xff/metrics/internal/storage"
 	_ "github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
@@ -21,7 +26,6 @@ func TestNewStorage(t *testing.T) {
 
 	assert.NotNil(t, pgs)
 	assert.NotNil(t, pgs.db)
-	assert.NotNil(t, pgs.ms)
 }
 
 func TestPGStorage_Bootstrap(t *testing.T) {
@@ -32,11 +36,27 @@ func TestPGStorage_Bootstrap(t *testing.T) {
 	pgs := NewStorage(db)
 	ctx := context.Background()
 
-	// Expect transaction and table creation
+	// Применяются встроенные миграции под advisory lock
+	expectLock(mock)
 	mock.ExpectBegin()
 	mock.ExpectExec("CREATE TABLE IF NOT EXISTS gauges").WillReturnResult(sqlmock.NewResult(0, 0))
-	mock.ExpectExec("CREATE TABLE IF NOT EXISTS counters").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(int64(1), "init").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("ALTER TABLE gauges ADD COLUMN IF NOT EXISTS updated_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(int64(2), "updated_at").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS histograms").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(int64(3), "histograms").
+		WillReturnResult(sqlmock.NewResult(1, 1))
 	mock.ExpectCommit()
+	expectUnlock(mock)
 
 	err = pgs.Bootstrap(ctx)
 	assert.NoError(t, err)
@@ -53,11 +73,26 @@ func TestPGStorage_SetGauge(t *testing.T) {
 
 	// Expect upsert query
 	mock.ExpectExec("INSERT INTO gauges").
-		WithArgs("test_gauge", float64(123.45)).
+		WithArgs("test_gauge", float64(123.45), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	pgs.SetGauge(ctx, "test_gauge", storage.Gauge(123.45))
+	err = pgs.SetGauge(ctx, "test_gauge", storage.Gauge(123.45))
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_SetEmptyKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
 
+	ctx := context.Background()
+	// Пустой ключ отклоняется одинаково с буфером записи и без него
+	for _, pgs := range []*PGStorage{NewStorage(db), NewStorage(db, WithWriteBehind(time.Hour, 100))} {
+		assert.ErrorIs(t, pgs.SetGauge(ctx, "", storage.Gauge(1)), storage.ErrInvalid)
+		assert.ErrorIs(t, pgs.SetCounter(ctx, "", storage.Counter(1)), storage.ErrInvalid)
+	}
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -74,14 +109,14 @@ func TestPGStorage_GetGauge_Success(t *testing.T) {
 		WithArgs("test_gauge").
 		WillReturnRows(rows)
 
-	value, exists := pgs.GetGauge(ctx, "test_gauge")
+	value, err := pgs.GetGauge(ctx, "test_gauge")
 
-	assert.True(t, exists)
+	assert.NoError(t, err)
 	assert.Equal(t, storage.Gauge(123.45), value)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestPGStorage_GetGauge_Fallback(t *testing.T) {
+func TestPGStorage_GetGauge_NotFound(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
@@ -89,18 +124,53 @@ func TestPGStorage_GetGauge_Fallback(t *testing.T) {
 	pgs := NewStorage(db)
 	ctx := context.Background()
 
-	// Set value in memory storage first
-	pgs.ms.SetGauge(ctx, "test_gauge", storage.Gauge(99.99))
-
-	// Mock DB error
 	mock.ExpectQuery("SELECT value FROM gauges WHERE key = \\$1").
 		WithArgs("test_gauge").
 		WillReturnError(sql.ErrNoRows)
 
-	value, exists := pgs.GetGauge(ctx, "test_gauge")
+	_, err = pgs.GetGauge(ctx, "test_gauge")
 
-	assert.True(t, exists)
-	assert.Equal(t, storage.Gauge(99.99), value)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_Unavailable(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	dbErr := errors.New("connection refused")
+
+	mock.ExpectExec("INSERT INTO gauges").WillReturnError(dbErr)
+	mock.ExpectExec("INSERT INTO counters").WillReturnError(dbErr)
+	mock.ExpectQuery("SELECT value FROM gauges").WillReturnError(dbErr)
+	mock.ExpectQuery("SELECT value FROM counters").WillReturnError(dbErr)
+	mock.ExpectQuery("SELECT key FROM gauges").WillReturnError(dbErr)
+	mock.ExpectQuery("SELECT key FROM counters").WillReturnError(dbErr)
+	mock.ExpectQuery("SELECT ts, value FROM samples").WillReturnError(dbErr)
+	mock.ExpectPing().WillReturnError(dbErr)
+
+	err = pgs.SetGauge(ctx, "g", 1)
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	assert.ErrorIs(t, err, dbErr)
+
+	assert.ErrorIs(t, pgs.SetCounter(ctx, "c", 1), storage.ErrUnavailable)
+
+	_, err = pgs.GetGauge(ctx, "g")
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	_, err = pgs.GetCounter(ctx, "c")
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	_, err = pgs.KeysGauge(ctx)
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	_, err = pgs.KeysCounter(ctx)
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	_, err = pgs.QueryRange(ctx, storage.MetricTypeGauge, "g", time.Now(), time.Now())
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	assert.ErrorIs(t, pgs.Ping(ctx), storage.ErrUnavailable)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestPGStorage_SetCounter(t *testing.T) {
@@ -112,11 +182,323 @@ func TestPGStorage_SetCounter(t *testing.T) {
 	ctx := context.Background()
 
 	mock.ExpectExec("INSERT INTO counters").
-		WithArgs("test_counter", int64(100)).
+		WithArgs("test_counter", int64(100), sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	pgs.SetCounter(ctx, "test_counter", storage.Counter(100))
+	err = pgs.SetCounter(ctx, "test_counter", storage.Counter(100))
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_UpdateBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	v1, v2, d1, d2 := 1.5, 2.5, int64(2), int64(3)
+
+	// Повторы серии сворачиваются: gauge - последнее значение, counter - сумма
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").
+		WithArgs(sqlmock.AnyArg(), "Alloc", 2.5).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO counters").
+		WithArgs(sqlmock.AnyArg(), "PollCount", int64(5)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	err = pgs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v1},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: &d1},
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v2},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: &d2},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_UpdateBatch_Rollback(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	v, d := 1.5, int64(2)
+	dbErr := errors.New("constraint violation")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO gauges").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO counters").WillReturnError(dbErr)
+	mock.ExpectRollback()
+
+	err = pgs.UpdateBatch(ctx, []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v},
+		{ID: "PollCount", MType: storage.MetricTypeCounter, Delta: &d},
+	})
+	assert.ErrorIs(t, err, storage.ErrUnavailable)
+	assert.ErrorIs(t, err, dbErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_UpdateBatch_Invalid(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	v := 1.5
 
+	err = pgs.UpdateBatch(context.Background(), []models.Metrics{
+		{ID: "Alloc", MType: storage.MetricTypeGauge, Value: &v},
+		{ID: "PollCount", MType: storage.MetricTypeCounter},
+	})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_UpdateBatch_Histogram(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	latency := func(obs ...float64) models.Metrics {
+		return models.Metrics{ID: "latency", MType: storage.MetricTypeHistogram, Histogram: &models.Histogram{
+			Buckets:      []models.Bucket{{Le: 0.1}, {Le: 1}},
+			Observations: obs,
+		}}
+	}
+
+	// Серия уже есть в базе: наблюдения складываются с сохраненными
+	stored := `{"bounds":[0.1,1],"counts":[1,0,0],"sum":0.05,"count":1}`
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO histograms .* ON CONFLICT \\(key\\) DO NOTHING").
+		WithArgs("latency", sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectQuery("SELECT value FROM histograms WHERE key = \\$1 FOR UPDATE").
+		WithArgs("latency").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow([]byte(stored)))
+	mock.ExpectExec("UPDATE histograms SET value").
+		WithArgs("latency", []byte(`{"bounds":[0.1,1],"counts":[1,1,1],"sum":5.55,"count":3}`), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = pgs.UpdateBatch(ctx, []models.Metrics{latency(0.5), latency(5)})
+	require.NoError(t, err)
+
+	// Границы корзин не совпадают с сохраненными: транзакция откатывается
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO histograms").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectQuery("SELECT value FROM histograms").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow([]byte(`{"bounds":[1,2],"counts":[0,0,0],"sum":0,"count":0}`)))
+	mock.ExpectRollback()
+
+	err = pgs.UpdateBatch(ctx, []models.Metrics{latency(0.5)})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_GetHistogram(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+
+	mock.ExpectQuery("SELECT value FROM histograms WHERE key = \\$1").
+		WithArgs("latency").
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).
+			AddRow([]byte(`{"bounds":[0.1,1],"counts":[1,2,0],"sum":1.2,"count":3}`)))
+	mock.ExpectQuery("SELECT value FROM histograms WHERE key = \\$1").
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+
+	h, err := pgs.GetHistogram(ctx, "latency")
+	require.NoError(t, err)
+	assert.Equal(t, storage.Histogram{Bounds: []float64{0.1, 1}, Counts: []uint64{1, 2, 0}, Sum: 1.2, Count: 3}, h)
+
+	_, err = pgs.GetHistogram(ctx, "missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_Delete(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM gauges WHERE key IN").
+		WithArgs("Alloc").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("Alloc"))
+	mock.ExpectExec("DELETE FROM samples").
+		WithArgs("gauge", "Alloc").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+	assert.NoError(t, pgs.Delete(ctx, storage.MetricTypeGauge, "Alloc"))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM counters WHERE key IN").
+		WithArgs("Unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}))
+	mock.ExpectCommit()
+	assert.ErrorIs(t, pgs.Delete(ctx, storage.MetricTypeCounter, "Unknown"), storage.ErrNotFound)
+
+	assert.ErrorIs(t, pgs.Delete(ctx, "unknown", "Alloc"), storage.ErrInvalid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_DeleteMatching(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+
+	_, err = pgs.DeleteMatching(ctx, storage.DeleteFilter{})
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+
+	web1 := []string{`disk_free{host="web-1"}`, `disk_used{host="web-1"}`}
+	mock.ExpectQuery("SELECT key FROM gauges").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).
+			AddRow(web1[0]).
+			AddRow(`disk_free{host="web-2"}`).
+			AddRow(web1[1]))
+	mock.ExpectQuery("SELECT key FROM counters").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("PollCount"))
+	mock.ExpectQuery("SELECT key FROM histograms").
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(`disk_latency{host="web-2"}`))
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM gauges WHERE key IN").
+		WithArgs(web1[0], web1[1]).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow(web1[0]).AddRow(web1[1]))
+	mock.ExpectExec("DELETE FROM samples").
+		WithArgs("gauge", web1[0], web1[1]).
+		WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectCommit()
+
+	n, err := pgs.DeleteMatching(ctx, storage.DeleteFilter{Prefix: "disk_", Labels: map[string]string{"host": "web-1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_LastUpdated(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT key, updated_at FROM gauges$").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "updated_at"}).AddRow("Alloc", ts))
+	updated, err := pgs.LastUpdated(ctx, storage.MetricTypeGauge)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{"Alloc": ts}, updated)
+
+	mock.ExpectQuery("SELECT key, updated_at FROM counters WHERE key IN").
+		WithArgs("PollCount").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "updated_at"}))
+	updated, err = pgs.LastUpdated(ctx, storage.MetricTypeCounter, "PollCount")
+	require.NoError(t, err)
+	assert.Empty(t, updated)
+
+	_, err = pgs.LastUpdated(ctx, "unknown")
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_LastUpdated_Chunks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	keys := make([]string, maxBatchRows+1)
+	first := make([]driver.Value, maxBatchRows)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("gauge_%d", i)
+		if i < maxBatchRows {
+			first[i] = keys[i]
+		}
+	}
+
+	// Ключи запрашиваются частями не более maxBatchRows
+	mock.ExpectQuery(fmt.Sprintf(`SELECT key, updated_at FROM gauges WHERE key IN \(\$1, .*\$%d\)$`, maxBatchRows)).
+		WithArgs(first...).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "updated_at"}).AddRow(keys[0], ts))
+	mock.ExpectQuery(`SELECT key, updated_at FROM gauges WHERE key IN \(\$1\)$`).
+		WithArgs(keys[maxBatchRows]).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "updated_at"}).AddRow(keys[maxBatchRows], ts))
+
+	updated, err := pgs.LastUpdated(ctx, storage.MetricTypeGauge, keys...)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Time{keys[0]: ts, keys[maxBatchRows]: ts}, updated)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_DeleteStale(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+	before := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	// Условие по updated_at проверяется в запросе удаления
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM gauges WHERE key IN \(\$1, \$2\) AND updated_at < \$3 RETURNING key`).
+		WithArgs("Old", "Fresh", before).
+		WillReturnRows(sqlmock.NewRows([]string{"key"}).AddRow("Old"))
+	mock.ExpectExec("DELETE FROM samples").
+		WithArgs("gauge", "Old").
+		WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectCommit()
+
+	n, err := pgs.DeleteStale(ctx, storage.MetricTypeGauge, []string{"Old", "Fresh"}, before)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_ResetCounter(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+
+	mock.ExpectExec("UPDATE counters SET value = 0").
+		WithArgs("PollCount", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, pgs.ResetCounter(ctx, "PollCount"))
+
+	mock.ExpectExec("UPDATE counters SET value = 0").
+		WithArgs("Unknown", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, pgs.ResetCounter(ctx, "Unknown"), storage.ErrNotFound)
+
+	mock.ExpectExec("UPDATE counters SET value = 0").
+		WillReturnError(errors.New("connection reset"))
+	assert.ErrorIs(t, pgs.ResetCounter(ctx, "PollCount"), storage.ErrUnavailable)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -133,9 +515,9 @@ func TestPGStorage_GetCounter_Success(t *testing.T) {
 		WithArgs("test_counter").
 		WillReturnRows(rows)
 
-	value, exists := pgs.GetCounter(ctx, "test_counter")
+	value, err := pgs.GetCounter(ctx, "test_counter")
 
-	assert.True(t, exists)
+	assert.NoError(t, err)
 	assert.Equal(t, storage.Counter(100), value)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -153,8 +535,9 @@ func TestPGStorage_KeysGauge(t *testing.T) {
 		AddRow("gauge2")
 	mock.ExpectQuery("SELECT key FROM gauges").WillReturnRows(rows)
 
-	keys := pgs.KeysGauge(ctx)
+	keys, err := pgs.KeysGauge(ctx)
 
+	require.NoError(t, err)
 	assert.Len(t, keys, 2)
 	assert.Contains(t, keys, "gauge1")
 	assert.Contains(t, keys, "gauge2")
@@ -174,14 +557,54 @@ func TestPGStorage_KeysCounter(t *testing.T) {
 		AddRow("counter2")
 	mock.ExpectQuery("SELECT key FROM counters").WillReturnRows(rows)
 
-	keys := pgs.KeysCounter(ctx)
+	keys, err := pgs.KeysCounter(ctx)
 
+	require.NoError(t, err)
 	assert.Len(t, keys, 2)
 	assert.Contains(t, keys, "counter1")
 	assert.Contains(t, keys, "counter2")
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestPGStorage_QueryRange(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+	ctx := context.Background()
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+
+	rows := sqlmock.NewRows([]string{"ts", "value"}).
+		AddRow(from.Add(time.Minute), 1.5).
+		AddRow(from.Add(2*time.Minute), 2.5)
+	mock.ExpectQuery("SELECT ts, value FROM samples").
+		WithArgs("gauge", "test_gauge", from, to).
+		WillReturnRows(rows)
+
+	samples, err := pgs.QueryRange(ctx, storage.MetricTypeGauge, "test_gauge", from, to)
+
+	require.NoError(t, err)
+	assert.Equal(t, []storage.Sample{
+		{Timestamp: from.Add(time.Minute), Value: 1.5},
+		{Timestamp: from.Add(2 * time.Minute), Value: 2.5},
+	}, samples)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPGStorage_QueryRange_UnknownType(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	pgs := NewStorage(db)
+
+	_, err = pgs.QueryRange(context.Background(), "unknown", "key", time.Now(), time.Now())
+	assert.ErrorIs(t, err, storage.ErrInvalid)
+}
+
 func TestPGStorage_Ping(t *testing.T) {
 	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	require.NoError(t, err)
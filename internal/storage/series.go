@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Серия метрики идентифицируется именем и набором меток. В хранилищах серия
+// хранится под ключом, который строится функцией SeriesKey:
+//
+//	Alloc                          - метрика без меток
+//	Alloc{dc="eu",host="web-1"}    - метрика с метками
+//
+// Метки в ключе отсортированы по имени, поэтому один и тот же набор меток
+// всегда дает один и тот же ключ независимо от порядка их передачи.
+
+var (
+	// ErrInvalidMetricName возвращается для пустого имени метрики или имени,
+	// содержащего недопустимые символы.
+	ErrInvalidMetricName = errors.New("invalid metric name")
+
+	// ErrInvalidLabel возвращается для метки с недопустимым именем.
+	ErrInvalidLabel = errors.New("invalid label")
+
+	// ErrInvalidSeriesKey возвращается при разборе некорректного ключа серии.
+	ErrInvalidSeriesKey = errors.New("invalid series key")
+)
+
+// ValidateSeries проверяет имя метрики и имена меток.
+// Имя метрики не должно быть пустым и содержать символы '{', '}' и перевод строки,
+// имена меток должны соответствовать [a-zA-Z_][a-zA-Z0-9_]*.
+func ValidateSeries(name string, labels map[string]string) error {
+	if name == "" || strings.ContainsAny(name, "{}\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidMetricName, name)
+	}
+	for k := range labels {
+		if !isLabelName(k) {
+			return fmt.Errorf("%w: %q", ErrInvalidLabel, k)
+		}
+	}
+	return nil
+}
+
+// SeriesKey возвращает ключ серии для имени метрики и набора меток.
+// Для пустого набора меток ключ совпадает с именем метрики.
+//
+// Пример использования:
+//
+//	SeriesKey("Alloc", nil)                                      // Alloc
+//	SeriesKey("Alloc", map[string]string{"host": "web-1"})       // Alloc{host="web-1"}
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, k := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabelValue(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// ParseSeriesKey разбирает ключ серии, построенный SeriesKey, на имя метрики и метки.
+// Для ключа без меток возвращает nil в качестве набора меток.
+func ParseSeriesKey(key string) (string, map[string]string, error) {
+	i := strings.IndexByte(key, '{')
+	if i < 0 {
+		return key, nil, nil
+	}
+	if i == 0 || !strings.HasSuffix(key, "}") {
+		return "", nil, fmt.Errorf("%w: %q", ErrInvalidSeriesKey, key)
+	}
+
+	name := key[:i]
+	rest := key[i+1 : len(key)-1]
+	labels := make(map[string]string)
+
+	for rest != "" {
+		eq := strings.Index(rest, `="`)
+		if eq <= 0 || !isLabelName(rest[:eq]) {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidSeriesKey, key)
+		}
+		labelName := rest[:eq]
+		rest = rest[eq+2:]
+
+		var (
+			value  strings.Builder
+			closed bool
+			j      int
+		)
+		for j = 0; j < len(rest); j++ {
+			c := rest[j]
+			if c == '\\' && j+1 < len(rest) {
+				j++
+				switch rest[j] {
+				case 'n':
+					value.WriteByte('\n')
+				default:
+					value.WriteByte(rest[j])
+				}
+				continue
+			}
+			if c == '"' {
+				closed = true
+				break
+			}
+			value.WriteByte(c)
+		}
+		if !closed {
+			return "", nil, fmt.Errorf("%w: %q", ErrInvalidSeriesKey, key)
+		}
+		labels[labelName] = value.String()
+
+		rest = rest[j+1:]
+		if rest != "" {
+			if rest[0] != ',' {
+				return "", nil, fmt.Errorf("%w: %q", ErrInvalidSeriesKey, key)
+			}
+			rest = rest[1:]
+		}
+	}
+
+	if len(labels) == 0 {
+		labels = nil
+	}
+	return name, labels, nil
+}
+
+// MatchLabels проверяет, что серия с метками labels содержит все метки matchers
+// с теми же значениями. Пустой набор matchers соответствует любой серии.
+func MatchLabels(labels, matchers map[string]string) bool {
+	for k, v := range matchers {
+		if lv, ok := labels[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchSeriesKey разбирает ключ серии и проверяет его метки с помощью MatchLabels.
+// Ключи, которые не удается разобрать, считаются сериями без меток.
+func MatchSeriesKey(key string, matchers map[string]string) bool {
+	if len(matchers) == 0 {
+		return true
+	}
+	_, labels, err := ParseSeriesKey(key)
+	if err != nil {
+		return false
+	}
+	return MatchLabels(labels, matchers)
+}
+
+func escapeLabelValue(v string) string {
+	if !strings.ContainsAny(v, "\\\"\n") {
+		return v
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return r.Replace(v)
+}
+
+func isLabelName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
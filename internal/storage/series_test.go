@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesKey(t *testing.T) {
+	assert.Equal(t, "Alloc", SeriesKey("Alloc", nil))
+	assert.Equal(t, `Alloc{dc="eu",host="web-1"}`, SeriesKey("Alloc", map[string]string{"host": "web-1", "dc": "eu"}))
+	assert.Equal(t, `Alloc{path="C:\\tmp \"x\"\n"}`, SeriesKey("Alloc", map[string]string{"path": "C:\\tmp \"x\"\n"}))
+}
+
+func TestParseSeriesKey(t *testing.T) {
+	testCases := []map[string]string{
+		nil,
+		{"host": "web-1"},
+		{"host": "web-1", "dc": "eu", "empty": ""},
+		{"path": "C:\\tmp \"x\"\n", "comma": "a,b", "brace": "}{"},
+	}
+
+	for _, labels := range testCases {
+		key := SeriesKey("Alloc", labels)
+		name, parsed, err := ParseSeriesKey(key)
+		require.NoError(t, err, key)
+		assert.Equal(t, "Alloc", name)
+		assert.Equal(t, labels, parsed)
+	}
+}
+
+func TestParseSeriesKey_Invalid(t *testing.T) {
+	invalid := []string{
+		`{host="web-1"}`,
+		`Alloc{host="web-1"`,
+		`Alloc{host=web-1}`,
+		`Alloc{host="web-1}`,
+		`Alloc{1host="web-1"}`,
+		`Alloc{host="web-1";dc="eu"}`,
+	}
+
+	for _, key := range invalid {
+		_, _, err := ParseSeriesKey(key)
+		assert.ErrorIs(t, err, ErrInvalidSeriesKey, key)
+	}
+}
+
+func TestValidateSeries(t *testing.T) {
+	assert.NoError(t, ValidateSeries("Alloc", nil))
+	assert.NoError(t, ValidateSeries("cpu.usage", map[string]string{"_host1": "web"}))
+	assert.ErrorIs(t, ValidateSeries("", nil), ErrInvalidMetricName)
+	assert.ErrorIs(t, ValidateSeries("Alloc{}", nil), ErrInvalidMetricName)
+	assert.ErrorIs(t, ValidateSeries("Alloc", map[string]string{"host-name": "web"}), ErrInvalidLabel)
+	assert.ErrorIs(t, ValidateSeries("Alloc", map[string]string{"": "web"}), ErrInvalidLabel)
+}
+
+func TestMatchSeriesKey(t *testing.T) {
+	key := SeriesKey("Alloc", map[string]string{"host": "web-1", "dc": "eu"})
+
+	assert.True(t, MatchSeriesKey(key, nil))
+	assert.True(t, MatchSeriesKey(key, map[string]string{"host": "web-1"}))
+	assert.True(t, MatchSeriesKey(key, map[string]string{"host": "web-1", "dc": "eu"}))
+	assert.False(t, MatchSeriesKey(key, map[string]string{"host": "web-2"}))
+	assert.False(t, MatchSeriesKey(key, map[string]string{"rack": "1"}))
+	assert.False(t, MatchSeriesKey("Alloc", map[string]string{"host": "web-1"}))
+}
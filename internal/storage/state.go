@@ -0,0 +1,101 @@
+package storage
+
+import "sync"
+
+// SeriesState хранит последнее значение каждой серии (например, накопленное
+// значение счетчика источника) и изменяет его в транзакциях.
+//
+// Транзакция блокирует свои серии через SeriesLocks до Commit или Rollback,
+// поэтому транзакции с общими сериями выполняются по очереди, а с разными -
+// параллельно. Изменения транзакции видны другим только после Commit: если
+// запись в хранилище не удалась, Rollback оставляет прежние значения.
+//
+// Пример использования:
+//
+//	tx := state.Begin(keys...)
+//	defer tx.Rollback()
+//	prev, ok := tx.Get(key)
+//	tx.Set(key, value)
+//	// запись в хранилище
+//	tx.Commit()
+type SeriesState[T any] struct {
+	locks SeriesLocks
+
+	mu     sync.Mutex
+	values map[string]T
+}
+
+// NewSeriesState создает пустое хранилище значений серий.
+func NewSeriesState[T any]() *SeriesState[T] {
+	return &SeriesState[T]{values: make(map[string]T)}
+}
+
+// SeriesStateTx - транзакция изменения значений серий.
+type SeriesStateTx[T any] struct {
+	s       *SeriesState[T]
+	unlock  func()
+	changed map[string]T
+	done    bool
+}
+
+// Begin начинает транзакцию для серий keys и блокирует их. Серии, с которыми
+// работает транзакция, должны входить в keys.
+func (s *SeriesState[T]) Begin(keys ...string) *SeriesStateTx[T] {
+	return &SeriesStateTx[T]{
+		s:       s,
+		unlock:  s.locks.Lock(keys...),
+		changed: make(map[string]T),
+	}
+}
+
+// Get возвращает значение серии key: заданное в транзакции, а если его
+// нет - сохраненное. Возвращает false, если значения нет.
+func (tx *SeriesStateTx[T]) Get(key string) (T, bool) {
+	if v, ok := tx.changed[key]; ok {
+		return v, true
+	}
+	tx.s.mu.Lock()
+	defer tx.s.mu.Unlock()
+	v, ok := tx.s.values[key]
+	return v, ok
+}
+
+// Changed сообщает, задано ли значение серии key в транзакции.
+func (tx *SeriesStateTx[T]) Changed(key string) bool {
+	_, ok := tx.changed[key]
+	return ok
+}
+
+// Set задает значение серии key в транзакции.
+func (tx *SeriesStateTx[T]) Set(key string, v T) {
+	tx.changed[key] = v
+}
+
+// Discard отменяет изменение серии key в транзакции.
+func (tx *SeriesStateTx[T]) Discard(key string) {
+	delete(tx.changed, key)
+}
+
+// Commit сохраняет значения, заданные в транзакции, и снимает блокировку.
+func (tx *SeriesStateTx[T]) Commit() {
+	if tx.done {
+		return
+	}
+	tx.s.mu.Lock()
+	for k, v := range tx.changed {
+		tx.s.values[k] = v
+	}
+	tx.s.mu.Unlock()
+	tx.done = true
+	tx.unlock()
+}
+
+// Rollback отменяет изменения транзакции и снимает блокировку. После Commit
+// ничего не делает.
+func (tx *SeriesStateTx[T]) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.done = true
+	tx.unlock()
+}
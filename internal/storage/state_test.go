@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeriesState(t *testing.T) {
+	s := NewSeriesState[int64]()
+
+	tx := s.Begin("a", "b")
+	_, ok := tx.Get("a")
+	assert.False(t, ok)
+	tx.Set("a", 10)
+	tx.Set("b", 20)
+	tx.Discard("b")
+	assert.True(t, tx.Changed("a"))
+	assert.False(t, tx.Changed("b"))
+	tx.Commit()
+
+	// Изменения отмененной транзакции не сохраняются
+	tx = s.Begin("a")
+	v, ok := tx.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), v)
+	assert.False(t, tx.Changed("a"))
+	tx.Set("a", 15)
+	v, _ = tx.Get("a")
+	assert.Equal(t, int64(15), v)
+	tx.Rollback()
+	tx.Rollback()
+
+	tx = s.Begin("a", "b")
+	defer tx.Rollback()
+	v, _ = tx.Get("a")
+	assert.Equal(t, int64(10), v)
+	_, ok = tx.Get("b")
+	assert.False(t, ok)
+}
+
+func TestSeriesState_Locks(t *testing.T) {
+	s := NewSeriesState[int64]()
+	other := "b"
+	for i := 0; shardIndex(other) == shardIndex("a"); i++ {
+		other = string(rune('b' + i))
+	}
+
+	tx := s.Begin("a")
+
+	// Транзакция с другими сериями не ждет
+	s.Begin(other).Commit()
+
+	began := make(chan struct{})
+	go func() {
+		s.Begin("a").Rollback()
+		close(began)
+	}()
+
+	select {
+	case <-began:
+		t.Fatal("transaction began while series are locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	tx.Commit()
+	<-began
+}
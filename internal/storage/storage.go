@@ -1,10 +1,16 @@
 // Package storage предоставляет интерфейсы и типы для хранения метрик.
-// Пакет поддерживает работу с двумя типами метрик: gauge и counter.
+// Пакет поддерживает работу с тремя типами метрик: gauge, counter и histogram.
 // Предоставляет универсальный интерфейс StorageProvider для различных реализаций хранилища.
 package storage
 
 import (
 	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/am0xff/metrics/internal/models"
 )
 
 // Gauge представляет тип метрики для измерения текущего значения показателя.
@@ -21,22 +27,32 @@ type Gauge float64
 // Пример: количество HTTP запросов 1234, количество ошибок 5
 type Counter int64
 
-// MetricType определяет тип метрики.
-type MetricType string
+// MetricType определяет тип метрики. Тип объявлен в пакете models,
+// чтобы пакет storage мог принимать models.Metrics без циклического импорта.
+type MetricType = models.MetricType
 
 const (
 	// MetricTypeGauge представляет тип метрики gauge.
 	// Используется для измеряемых показателей, которые могут увеличиваться и уменьшаться.
-	MetricTypeGauge MetricType = "gauge"
+	MetricTypeGauge = models.MetricTypeGauge
 
 	// MetricTypeCounter представляет тип метрики counter.
 	// Используется для счетчиков, которые только увеличиваются.
-	MetricTypeCounter MetricType = "counter"
+	MetricTypeCounter = models.MetricTypeCounter
+
+	// MetricTypeHistogram представляет тип метрики histogram.
+	// Используется для распределений значений, например времени ответа.
+	MetricTypeHistogram = models.MetricTypeHistogram
 )
 
 // StorageProvider определяет интерфейс для работы с хранилищем метрик.
 // Интерфейс поддерживает операции получения, установки значений метрик
-// и получения списка ключей для обоих типов метрик.
+// и получения списка ключей для всех типов метрик.
+//
+// Все операции возвращают ошибку. Реализации используют ErrNotFound,
+// ErrUnavailable и ErrInvalid (возможно, обернутые), чтобы вызывающий код
+// мог отличить отсутствующую метрику от недоступного хранилища и от
+// некорректного запроса.
 //
 // Пример реализации может использовать память, файловую систему,
 // базу данных или другие системы хранения.
@@ -44,39 +60,101 @@ const (
 // Пример использования:
 //
 //	var storage StorageProvider
-//	storage.SetGauge(ctx, "value", Gauge(85.5))
-//	if value, ok := storage.GetGauge(ctx, "value"); ok {
+//	if err := storage.SetGauge(ctx, "value", Gauge(85.5)); err != nil {
+//		return err
+//	}
+//	if value, err := storage.GetGauge(ctx, "value"); err == nil {
 //		fmt.Printf("Value: %.1f%%", float64(value))
 //	}
 type StorageProvider interface {
 	// GetGauge возвращает значение gauge метрики по ключу.
-	// Возвращает значение и флаг существования метрики.
-	GetGauge(ctx context.Context, key string) (Gauge, bool)
+	// Если метрика не найдена, возвращает ErrNotFound.
+	GetGauge(ctx context.Context, key string) (Gauge, error)
 
 	// GetCounter возвращает значение counter метрики по ключу.
-	// Возвращает значение и флаг существования метрики.
-	GetCounter(ctx context.Context, key string) (Counter, bool)
+	// Если метрика не найдена, возвращает ErrNotFound.
+	GetCounter(ctx context.Context, key string) (Counter, error)
 
 	// KeysGauge возвращает список всех ключей gauge метрик.
-	KeysGauge(ctx context.Context) []string
+	KeysGauge(ctx context.Context) ([]string, error)
 
 	// SetGauge устанавливает значение gauge метрики.
-	SetGauge(ctx context.Context, key string, value Gauge)
+	// Ошибка означает, что значение не сохранено.
+	SetGauge(ctx context.Context, key string, value Gauge) error
 
-	// SetCounter устанавливает значение counter метрики.
-	SetCounter(ctx context.Context, key string, value Counter)
+	// SetCounter увеличивает значение counter метрики на value.
+	// Ошибка означает, что значение не сохранено.
+	SetCounter(ctx context.Context, key string, value Counter) error
 
 	// KeysCounter возвращает список всех ключей counter метрик.
-	KeysCounter(ctx context.Context) []string
+	KeysCounter(ctx context.Context) ([]string, error)
+
+	// GetHistogram возвращает значение histogram метрики по ключу.
+	// Если метрика не найдена, возвращает ErrNotFound.
+	GetHistogram(ctx context.Context, key string) (Histogram, error)
+
+	// KeysHistogram возвращает список всех ключей histogram метрик.
+	KeysHistogram(ctx context.Context) ([]string, error)
+
+	// QueryRange возвращает историю значений метрики типа mtype по ключу
+	// за интервал [from, to], упорядоченную по времени.
+	// Если история метрики отсутствует, возвращает пустой срез.
+	// Для неизвестного типа метрики возвращает ErrInvalid.
+	QueryRange(ctx context.Context, mtype MetricType, key string, from, to time.Time) ([]Sample, error)
+
+	// UpdateBatch применяет пакет метрик целиком: либо сохраняются все
+	// метрики пакета, либо ни одна. Для gauge применяется последнее значение
+	// серии в пакете, приращения counter и наблюдения histogram суммируются.
+	// Если хотя бы одна метрика недопустима (в том числе границы корзин
+	// histogram не совпадают с границами сохраненной серии), возвращает
+	// ErrInvalid и не изменяет хранилище.
+	UpdateBatch(ctx context.Context, metrics []models.Metrics) error
+
+	// Delete удаляет метрику типа mtype по ключу вместе с ее историей.
+	// Если метрика не найдена, возвращает ErrNotFound, для неизвестного
+	// типа метрики - ErrInvalid.
+	Delete(ctx context.Context, mtype MetricType, key string) error
+
+	// DeleteMatching удаляет все метрики, соответствующие фильтру f,
+	// и возвращает их количество. Для недопустимого фильтра возвращает ErrInvalid.
+	DeleteMatching(ctx context.Context, f DeleteFilter) (int, error)
+
+	// ResetCounter сбрасывает значение counter метрики в 0.
+	// Если метрика не найдена, возвращает ErrNotFound.
+	ResetCounter(ctx context.Context, key string) error
+
+	// LastUpdated возвращает время последнего обновления серий типа mtype
+	// с ключами keys, а без keys - всех серий этого типа. Серии, которые
+	// не найдены, в результат не попадают. Для неизвестного типа метрики
+	// возвращает ErrInvalid.
+	LastUpdated(ctx context.Context, mtype MetricType, keys ...string) (map[string]time.Time, error)
+
+	// DeleteStale удаляет из серий keys типа mtype те, что последний раз
+	// обновлялись раньше before, вместе с их историей, и возвращает их количество.
+	// Серия, обновленная после проверки, не удаляется.
+	DeleteStale(ctx context.Context, mtype MetricType, keys []string, before time.Time) (int, error)
 
 	// Ping проверяет доступность хранилища.
-	// Возвращает ошибку, если хранилище недоступно.
+	// Возвращает ErrUnavailable, если хранилище недоступно.
 	Ping(ctx context.Context) error
 }
 
+// shardCount - количество сегментов (shards) хранилища. Ключи распределяются
+// по сегментам по хешу, каждый сегмент защищен собственной блокировкой,
+// поэтому запись в разные серии не конкурирует за одну блокировку.
+const shardCount = 64
+
 // Storage представляет универсальное хранилище для метрик типа T.
 // Использует дженерики для типобезопасной работы с Gauge или Counter.
 //
+// Хранилище разделено на shardCount сегментов с отдельными блокировками
+// (lock striping). Значения хранятся в атомарных ячейках: изменение значения
+// существующей серии выполняется атомарной операцией под блокировкой сегмента
+// на чтение, блокировка на запись нужна только при добавлении новой серии
+// и при снятии снимка.
+//
+// Storage безопасен для конкурентного использования.
+//
 // Пример использования:
 //
 //	gaugeStorage := NewStorage[Gauge]()
@@ -85,7 +163,17 @@ type StorageProvider interface {
 //	counterStorage := NewStorage[Counter]()
 //	counterStorage.Set("requests", Counter(1234))
 type Storage[T interface{ Gauge | Counter }] struct {
-	data map[string]T
+	shards [shardCount]shard
+}
+
+// shard - сегмент хранилища. Значения хранятся в виде битового представления:
+// для Gauge - math.Float64bits, для Counter - двоичное представление int64.
+type shard struct {
+	mu   sync.RWMutex
+	data map[string]*atomic.Uint64
+	// Выравнивание, чтобы блокировки соседних сегментов не попадали
+	// в одну кеш-линию
+	_ [32]byte
 }
 
 // NewStorage создает новый экземпляр Storage для указанного типа метрики.
@@ -99,9 +187,11 @@ type Storage[T interface{ Gauge | Counter }] struct {
 //	// Создание хранилища для counter метрик
 //	counters := NewStorage[Counter]()
 func NewStorage[T interface{ Gauge | Counter }]() *Storage[T] {
-	return &Storage[T]{
-		data: make(map[string]T),
+	s := &Storage[T]{}
+	for i := range s.shards {
+		s.shards[i].data = make(map[string]*atomic.Uint64)
 	}
+	return s
 }
 
 // Get возвращает значение метрики по ключу.
@@ -115,8 +205,16 @@ func NewStorage[T interface{ Gauge | Counter }]() *Storage[T] {
 //		fmt.Printf("Value: %.1f", float64(value))
 //	}
 func (s *Storage[T]) Get(key string) (T, bool) {
-	val, ok := s.data[key]
-	return val, ok
+	sh := s.shard(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	cell, ok := sh.data[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return decode[T](cell.Load()), true
 }
 
 // Set устанавливает значение метрики по ключу.
@@ -126,7 +224,9 @@ func (s *Storage[T]) Get(key string) (T, bool) {
 //	storage := NewStorage[Counter]()
 //	storage.Set("requests_total", Counter(1000))
 func (s *Storage[T]) Set(key string, val T) {
-	s.data[key] = val
+	s.update(key, func(cell *atomic.Uint64) {
+		cell.Store(encode(val))
+	})
 }
 
 // Keys возвращает срез всех ключей в хранилище.
@@ -139,21 +239,190 @@ func (s *Storage[T]) Set(key string, val T) {
 //	storage.Set("memory", Gauge(67.2))
 //	keys := storage.Keys() // ["cpu", "memory"] или ["memory", "cpu"]
 func (s *Storage[T]) Keys() []string {
-	keys := make([]string, 0, len(s.data))
-	for k := range s.data {
-		keys = append(keys, k)
+	keys := make([]string, 0)
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		for k := range sh.data {
+			keys = append(keys, k)
+		}
+		sh.mu.RUnlock()
 	}
 	return keys
 }
 
-// Count увеличивает значение метрики на указанную величину.
-// Если ключ не существует, создает новую запись со значением value.
+// Count атомарно увеличивает значение метрики на указанную величину
+// и возвращает новое значение. Если ключ не существует, создает новую
+// запись со значением value.
 //
 // Пример использования:
 //
 //	storage := NewStorage[Counter]()
 //	storage.Count("requests", Counter(1))  // Устанавливает значение 1
 //	storage.Count("requests", Counter(5))  // Увеличивает до 6
-func (s *Storage[T]) Count(key string, value T) {
-	s.data[key] += value
+func (s *Storage[T]) Count(key string, value T) T {
+	var result T
+	s.update(key, func(cell *atomic.Uint64) {
+		result = add(cell, value)
+	})
+	return result
+}
+
+// Delete удаляет метрику по ключу. Возвращает false, если ключ не найден.
+func (s *Storage[T]) Delete(key string) bool {
+	sh := s.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if _, ok := sh.data[key]; !ok {
+		return false
+	}
+	delete(sh.data, key)
+	return true
+}
+
+// Len возвращает количество ключей в хранилище.
+func (s *Storage[T]) Len() int {
+	n := 0
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += len(sh.data)
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Snapshot возвращает копию всех значений хранилища на один момент времени:
+// на время копирования блокируются все сегменты, поэтому в снимок не попадают
+// частично примененные конкурентные изменения.
+func (s *Storage[T]) Snapshot() map[string]T {
+	s.lockAll()
+	defer s.unlockAll()
+	return s.copyLocked()
+}
+
+// update вызывает f для ячейки ключа key под блокировкой сегмента на чтение,
+// при необходимости создавая ячейку.
+func (s *Storage[T]) update(key string, f func(cell *atomic.Uint64)) {
+	sh := s.shard(key)
+
+	sh.mu.RLock()
+	cell, ok := sh.data[key]
+	if ok {
+		f(cell)
+		sh.mu.RUnlock()
+		return
+	}
+	sh.mu.RUnlock()
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	cell, ok = sh.data[key]
+	if !ok {
+		cell = new(atomic.Uint64)
+		sh.data[key] = cell
+	}
+	f(cell)
+}
+
+func (s *Storage[T]) shard(key string) *shard {
+	return &s.shards[shardIndex(key)]
+}
+
+func (s *Storage[T]) lockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+}
+
+func (s *Storage[T]) unlockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+func (s *Storage[T]) copyLocked() map[string]T {
+	n := 0
+	for i := range s.shards {
+		n += len(s.shards[i].data)
+	}
+	result := make(map[string]T, n)
+	for i := range s.shards {
+		for k, cell := range s.shards[i].data {
+			result[k] = decode[T](cell.Load())
+		}
+	}
+	return result
+}
+
+// Snapshot - согласованный снимок значений всех метрик.
+type Snapshot struct {
+	Gauges   map[string]Gauge
+	Counters map[string]Counter
+}
+
+// TakeSnapshot возвращает снимок значений gauge и counter метрик на один
+// момент времени. На время копирования блокируются оба хранилища.
+//
+// Пример использования:
+//
+//	snap := storage.TakeSnapshot(gauges, counters)
+//	data, _ := json.Marshal(snap)
+func TakeSnapshot(gauges *Storage[Gauge], counters *Storage[Counter]) Snapshot {
+	gauges.lockAll()
+	defer gauges.unlockAll()
+	counters.lockAll()
+	defer counters.unlockAll()
+
+	return Snapshot{
+		Gauges:   gauges.copyLocked(),
+		Counters: counters.copyLocked(),
+	}
+}
+
+// shardIndex возвращает номер сегмента для ключа (FNV-1a).
+func shardIndex(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % shardCount)
+}
+
+func encode[T interface{ Gauge | Counter }](v T) uint64 {
+	switch x := any(v).(type) {
+	case Gauge:
+		return math.Float64bits(float64(x))
+	case Counter:
+		return uint64(x)
+	}
+	return 0
+}
+
+func decode[T interface{ Gauge | Counter }](bits uint64) T {
+	var v T
+	switch p := any(&v).(type) {
+	case *Gauge:
+		*p = Gauge(math.Float64frombits(bits))
+	case *Counter:
+		*p = Counter(int64(bits))
+	}
+	return v
+}
+
+// add атомарно прибавляет delta к значению ячейки и возвращает результат.
+// Для Counter используется атомарное сложение, для Gauge - цикл CAS.
+func add[T interface{ Gauge | Counter }](cell *atomic.Uint64, delta T) T {
+	if d, ok := any(delta).(Counter); ok {
+		return decode[T](cell.Add(uint64(d)))
+	}
+	for {
+		old := cell.Load()
+		next := encode(decode[T](old) + delta)
+		if cell.CompareAndSwap(old, next) {
+			return decode[T](next)
+		}
+	}
 }
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorage_Operations(t *testing.T) {
+	gauges := NewStorage[Gauge]()
+	gauges.Set("cpu", 1.5)
+	gauges.Set("cpu", -2.25)
+
+	v, ok := gauges.Get("cpu")
+	assert.True(t, ok)
+	assert.Equal(t, Gauge(-2.25), v)
+
+	_, ok = gauges.Get("unknown")
+	assert.False(t, ok)
+
+	assert.Equal(t, Gauge(-1.25), gauges.Count("cpu", 1))
+
+	counters := NewStorage[Counter]()
+	assert.Equal(t, Counter(5), counters.Count("requests", 5))
+	assert.Equal(t, Counter(2), counters.Count("requests", -3))
+	counters.Set("errors", 7)
+
+	assert.ElementsMatch(t, []string{"requests", "errors"}, counters.Keys())
+	assert.Equal(t, 2, counters.Len())
+	assert.Equal(t, map[string]Counter{"requests": 2, "errors": 7}, counters.Snapshot())
+
+	assert.True(t, counters.Delete("errors"))
+	assert.False(t, counters.Delete("errors"))
+	assert.Equal(t, []string{"requests"}, counters.Keys())
+}
+
+func TestStorage_ConcurrentCount(t *testing.T) {
+	counters := NewStorage[Counter]()
+	gauges := NewStorage[Gauge]()
+
+	const workers, iterations = 16, 1000
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				counters.Count("shared", 1)
+				counters.Count("worker_"+strconv.Itoa(w), 1)
+				gauges.Count("shared", 0.5)
+				gauges.Set("worker_"+strconv.Itoa(w), Gauge(i))
+				_ = counters.Keys()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	v, ok := counters.Get("shared")
+	require.True(t, ok)
+	assert.Equal(t, Counter(workers*iterations), v)
+
+	g, ok := gauges.Get("shared")
+	require.True(t, ok)
+	assert.Equal(t, Gauge(workers*iterations/2), g)
+
+	for w := 0; w < workers; w++ {
+		v, _ := counters.Get("worker_" + strconv.Itoa(w))
+		assert.Equal(t, Counter(iterations), v)
+	}
+}
+
+func TestTakeSnapshot_Consistent(t *testing.T) {
+	gauges := NewStorage[Gauge]()
+	counters := NewStorage[Counter]()
+
+	// Писатели увеличивают все счетчики на одну и ту же величину; в согласованном
+	// снимке сумма по всем сериям всегда кратна количеству серий.
+	const series = 100
+	keys := make([]string, series)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("c%d", i)
+		counters.Set(keys[i], 0)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			counters.lockAll()
+			for _, k := range keys {
+				cell := counters.shard(k).data[k]
+				cell.Add(1)
+			}
+			counters.unlockAll()
+			gauges.Set("g", 1)
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		snap := TakeSnapshot(gauges, counters)
+		var sum Counter
+		for _, v := range snap.Counters {
+			sum += v
+		}
+		assert.Zero(t, sum%series)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkStorage_CountParallel(b *testing.B) {
+	for _, nkeys := range []int{1, 64, 10000} {
+		b.Run(fmt.Sprintf("keys=%d", nkeys), func(b *testing.B) {
+			s := NewStorage[Counter]()
+			keys := make([]string, nkeys)
+			for i := range keys {
+				keys[i] = "counter_" + strconv.Itoa(i)
+				s.Set(keys[i], 0)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					s.Count(keys[i%nkeys], 1)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStorage_SetGaugeParallel(b *testing.B) {
+	s := NewStorage[Gauge]()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "gauge_" + strconv.Itoa(i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			s.Set(keys[i%len(keys)], Gauge(i))
+			i++
+		}
+	})
+}
+
+func BenchmarkStorage_MixedParallel(b *testing.B) {
+	s := NewStorage[Counter]()
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "counter_" + strconv.Itoa(i)
+		s.Set(keys[i], 0)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			// 9 записей на 1 чтение
+			if i%10 == 0 {
+				s.Get(keys[i%len(keys)])
+			} else {
+				s.Count(keys[i%len(keys)], 1)
+			}
+			i++
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TTLPolicy определяет, через какое время без обновлений серия считается
+// устаревшей (stale). Default применяется ко всем сериям, Overrides
+// переопределяет время для метрик, имя которых начинается с префикса;
+// при нескольких подходящих префиксах выбирается самый длинный.
+// Нулевое время означает, что серия не устаревает.
+//
+// Пример использования:
+//
+//	p := storage.TTLPolicy{
+//		Default:   10 * time.Minute,
+//		Overrides: map[string]time.Duration{"disk_": time.Hour, "build_": 0},
+//	}
+//	p.TTL(`disk_free{host="web-1"}`) // 1h
+//	p.TTL("build_info")              // 0, не устаревает
+type TTLPolicy struct {
+	Default   time.Duration
+	Overrides map[string]time.Duration
+}
+
+// Enabled сообщает, может ли по политике устареть хотя бы одна серия.
+func (p TTLPolicy) Enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, ttl := range p.Overrides {
+		if ttl > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TTL возвращает время жизни серии с ключом key без обновлений.
+func (p TTLPolicy) TTL(key string) time.Duration {
+	name, _, err := ParseSeriesKey(key)
+	if err != nil {
+		name = key
+	}
+
+	ttl, matched := p.Default, -1
+	for prefix, d := range p.Overrides {
+		if strings.HasPrefix(name, prefix) && len(prefix) > matched {
+			ttl, matched = d, len(prefix)
+		}
+	}
+	return ttl
+}
+
+// Stale сообщает, устарела ли к моменту now серия key, последний раз
+// обновленная в момент updated.
+func (p TTLPolicy) Stale(key string, updated, now time.Time) bool {
+	ttl := p.TTL(key)
+	return ttl > 0 && now.Sub(updated) > ttl
+}
+
+// ParseTTLOverrides разбирает переопределения времени жизни серий
+// в формате "префикс=длительность" через запятую, например
+// "disk_=1h,build_=0s". Пустая строка означает отсутствие переопределений.
+func ParseTTLOverrides(s string) (map[string]time.Duration, error) {
+	overrides := make(map[string]time.Duration)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		prefix, value, ok := strings.Cut(item, "=")
+		prefix = strings.TrimSpace(prefix)
+		if !ok || prefix == "" {
+			return nil, fmt.Errorf("invalid ttl override %q: expected prefix=duration", item)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid ttl override %q: bad duration", item)
+		}
+		overrides[prefix] = d
+	}
+	return overrides, nil
+}
+
+// Timestamps хранит время последнего обновления серий. Серии распределены
+// по сегментам с отдельными блокировками, как и значения в Storage.
+//
+// Timestamps безопасен для конкурентного использования.
+type Timestamps struct {
+	shards [shardCount]timestampShard
+}
+
+type timestampShard struct {
+	mu   sync.RWMutex
+	data map[string]time.Time
+	_    [32]byte
+}
+
+// NewTimestamps создает пустое хранилище времени обновления.
+func NewTimestamps() *Timestamps {
+	t := &Timestamps{}
+	for i := range t.shards {
+		t.shards[i].data = make(map[string]time.Time)
+	}
+	return t
+}
+
+// Set запоминает время последнего обновления серии key.
+func (t *Timestamps) Set(key string, ts time.Time) {
+	sh := &t.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.data[key] = ts
+}
+
+// Get возвращает время последнего обновления серии key.
+func (t *Timestamps) Get(key string) (time.Time, bool) {
+	sh := &t.shards[shardIndex(key)]
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	ts, ok := sh.data[key]
+	return ts, ok
+}
+
+// Delete удаляет время обновления серии key.
+func (t *Timestamps) Delete(key string) {
+	sh := &t.shards[shardIndex(key)]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.data, key)
+}
+
+// Snapshot возвращает копию времени обновления всех серий.
+func (t *Timestamps) Snapshot() map[string]time.Time {
+	result := make(map[string]time.Time)
+	for i := range t.shards {
+		sh := &t.shards[i]
+		sh.mu.RLock()
+		for k, ts := range sh.data {
+			result[k] = ts
+		}
+		sh.mu.RUnlock()
+	}
+	return result
+}
+
+// SeriesLocks - блокировки серий, распределенных по сегментам так же, как
+// в Storage. Изменения серий выполняются под блокировкой сегментов на чтение
+// и не мешают друг другу, удаление устаревших серий - под блокировкой
+// на запись: время последнего обновления перепроверяется и серия удаляется
+// без конкурентных изменений. Сегменты блокируются в порядке возрастания
+// номеров.
+//
+// Нулевое значение готово к использованию.
+type SeriesLocks struct {
+	shards [shardCount]seriesLock
+}
+
+type seriesLock struct {
+	mu sync.RWMutex
+	_  [40]byte
+}
+
+// RLock блокирует на чтение сегменты серий keys и возвращает функцию,
+// снимающую блокировку.
+func (l *SeriesLocks) RLock(keys ...string) (unlock func()) {
+	shards := l.indexes(keys)
+	for _, i := range shards {
+		l.shards[i].mu.RLock()
+	}
+	return func() {
+		for _, i := range shards {
+			l.shards[i].mu.RUnlock()
+		}
+	}
+}
+
+// Lock блокирует на запись сегменты серий keys и возвращает функцию,
+// снимающую блокировку.
+func (l *SeriesLocks) Lock(keys ...string) (unlock func()) {
+	shards := l.indexes(keys)
+	for _, i := range shards {
+		l.shards[i].mu.Lock()
+	}
+	return func() {
+		for _, i := range shards {
+			l.shards[i].mu.Unlock()
+		}
+	}
+}
+
+func (l *SeriesLocks) indexes(keys []string) []int {
+	shards := make([]int, len(keys))
+	for i, k := range keys {
+		shards[i] = shardIndex(k)
+	}
+	return uniqueSorted(shards)
+}
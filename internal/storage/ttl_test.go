@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLPolicy(t *testing.T) {
+	p := TTLPolicy{
+		Default:   10 * time.Minute,
+		Overrides: map[string]time.Duration{"disk_": time.Hour, "disk_io_": time.Minute, "build_": 0},
+	}
+	assert.True(t, p.Enabled())
+
+	assert.Equal(t, 10*time.Minute, p.TTL("Alloc"))
+	assert.Equal(t, time.Hour, p.TTL(`disk_free{host="web-1"}`))
+	assert.Equal(t, time.Minute, p.TTL("disk_io_time"))
+	assert.Equal(t, time.Duration(0), p.TTL("build_info"))
+
+	now := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	assert.True(t, p.Stale("Alloc", now.Add(-11*time.Minute), now))
+	assert.False(t, p.Stale("Alloc", now.Add(-9*time.Minute), now))
+	assert.False(t, p.Stale("build_info", now.Add(-24*time.Hour), now))
+
+	assert.False(t, TTLPolicy{}.Enabled())
+	assert.True(t, TTLPolicy{Overrides: map[string]time.Duration{"disk_": time.Hour}}.Enabled())
+}
+
+func TestParseTTLOverrides(t *testing.T) {
+	overrides, err := ParseTTLOverrides(" disk_=1h, build_=0s ,")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{"disk_": time.Hour, "build_": 0}, overrides)
+
+	overrides, err = ParseTTLOverrides("")
+	require.NoError(t, err)
+	assert.Empty(t, overrides)
+
+	for _, s := range []string{"disk_", "=1h", "disk_=soon", "disk_=-1m"} {
+		_, err := ParseTTLOverrides(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	ts := NewTimestamps()
+	now := time.Now()
+
+	ts.Set("Alloc", now)
+	got, ok := ts.Get("Alloc")
+	assert.True(t, ok)
+	assert.Equal(t, now, got)
+
+	ts.Set("PollCount", now)
+	ts.Delete("PollCount")
+	_, ok = ts.Get("PollCount")
+	assert.False(t, ok)
+
+	assert.Equal(t, map[string]time.Time{"Alloc": now}, ts.Snapshot())
+}
+
+func TestSeriesLocks(t *testing.T) {
+	var locks SeriesLocks
+
+	// Повторяющиеся ключи и ключи одного сегмента блокируются один раз
+	unlock := locks.RLock("Alloc", "Alloc", "PollCount")
+	locks.RLock("Alloc")()
+
+	locked := make(chan struct{})
+	go func() {
+		locks.Lock("PollCount", "Alloc")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Lock acquired while series are read-locked")
+	case <-time.After(10 * time.Millisecond):
+	}
+	unlock()
+	<-locked
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Формат конверта (envelope) для шифрования данных произвольного размера.
+// Тело шифруется случайным ключом AES-256-GCM, а сам ключ - RSA-OAEP (SHA-256).
+//
+// Версия 1:
+//
+//	magic       4 байта  "MENV"
+//	version     1 байт   1
+//	keyLen      2 байта  длина зашифрованного ключа, big endian
+//	wrappedKey  keyLen   ключ AES, зашифрованный RSA-OAEP
+//	nonce       12 байт  nonce AES-GCM
+//	ciphertext  ...      данные, зашифрованные AES-GCM, вместе с тегом
+//
+// Заголовок (все поля до ciphertext) передается в AES-GCM как дополнительные
+// данные, поэтому его изменение обнаруживается при расшифровке.
+
+// EnvelopeVersion1 - версия конверта RSA-OAEP + AES-256-GCM.
+const EnvelopeVersion1 = 1
+
+// EnvelopeVersion - текущая версия конверта, используемая при шифровании.
+const EnvelopeVersion = EnvelopeVersion1
+
+var envelopeMagic = []byte("MENV")
+
+const (
+	envelopeKeySize   = 32 // AES-256
+	envelopeFixedSize = 4 + 1 + 2
+)
+
+var (
+	// ErrNotEnvelope возвращается, если данные не начинаются с заголовка конверта.
+	ErrNotEnvelope = errors.New("not an envelope")
+	// ErrEnvelopeVersion возвращается для неподдерживаемой версии конверта.
+	ErrEnvelopeVersion = errors.New("unsupported envelope version")
+)
+
+// EncryptEnvelope шифрует данные любого размера в конверт текущей версии.
+func EncryptEnvelope(data []byte, publicKey *rsa.PublicKey) ([]byte, error) {
+	key := make([]byte, envelopeKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, envelopeFixedSize+len(wrappedKey)+len(nonce))
+	header = append(header, envelopeMagic...)
+	header = append(header, EnvelopeVersion1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrappedKey)))
+	header = append(header, wrappedKey...)
+	header = append(header, nonce...)
+
+	return gcm.Seal(header, nonce, data, header), nil
+}
+
+// DecryptEnvelope расшифровывает конверт, созданный EncryptEnvelope.
+func DecryptEnvelope(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	version, err := EnvelopeVersionOf(data)
+	if err != nil {
+		return nil, err
+	}
+	if version != EnvelopeVersion1 {
+		return nil, fmt.Errorf("%w: %d", ErrEnvelopeVersion, version)
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(data[5:envelopeFixedSize]))
+	nonceEnd := envelopeFixedSize + keyLen + 12
+	if len(data) < nonceEnd {
+		return nil, errors.New("truncated envelope")
+	}
+	header := data[:nonceEnd]
+	wrappedKey := data[envelopeFixedSize : envelopeFixedSize+keyLen]
+	nonce := data[envelopeFixedSize+keyLen : nonceEnd]
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: %w", err)
+	}
+	if len(key) != envelopeKeySize {
+		return nil, errors.New("invalid envelope key size")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, nonce, data[nonceEnd:], header)
+}
+
+// EnvelopeVersionOf возвращает версию конверта из заголовка данных
+// или ErrNotEnvelope, если данные не являются конвертом.
+func EnvelopeVersionOf(data []byte) (int, error) {
+	if len(data) < envelopeFixedSize || !bytes.Equal(data[:4], envelopeMagic) {
+		return 0, ErrNotEnvelope
+	}
+	return int(data[4]), nil
+}
+
+// DecryptPayload расшифровывает данные в конверте или, для совместимости
+// со старыми агентами, данные, зашифрованные напрямую RSA-OAEP.
+func DecryptPayload(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	if _, err := EnvelopeVersionOf(data); err == nil {
+		plain, err := DecryptEnvelope(data, privateKey)
+		if err == nil {
+			return plain, nil
+		}
+		// Шифротекст RSA может случайно начинаться с magic,
+		// поэтому пробуем и старый формат
+		if legacy, lerr := DecryptRSA(data, privateKey); lerr == nil {
+			return legacy, nil
+		}
+		return nil, err
+	}
+	return DecryptRSA(data, privateKey)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
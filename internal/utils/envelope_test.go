@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelope_RoundTrip(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	// Данные заметно больше предела RSA-OAEP для ключа 2048 бит
+	data := bytes.Repeat([]byte("metrics batch "), 10000)
+
+	encrypted, err := EncryptEnvelope(data, &privateKey.PublicKey)
+	require.NoError(t, err)
+
+	version, err := EnvelopeVersionOf(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion1, version)
+
+	decrypted, err := DecryptEnvelope(encrypted, privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	decrypted, err = DecryptPayload(encrypted, privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+}
+
+func TestEnvelope_Tampered(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	encrypted, err := EncryptEnvelope([]byte("payload"), &privateKey.PublicKey)
+	require.NoError(t, err)
+
+	// Изменение шифротекста
+	tampered := bytes.Clone(encrypted)
+	tampered[len(tampered)-1] ^= 0xff
+	_, err = DecryptEnvelope(tampered, privateKey)
+	assert.Error(t, err)
+
+	// Изменение версии
+	tampered = bytes.Clone(encrypted)
+	tampered[4] = 2
+	_, err = DecryptEnvelope(tampered, privateKey)
+	assert.ErrorIs(t, err, ErrEnvelopeVersion)
+
+	// Обрезанный конверт
+	_, err = DecryptEnvelope(encrypted[:20], privateKey)
+	assert.Error(t, err)
+
+	// Другой ключ
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = DecryptEnvelope(encrypted, otherKey)
+	assert.Error(t, err)
+}
+
+func TestDecryptPayload_Legacy(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	encrypted, err := EncryptRSA([]byte("legacy payload"), &privateKey.PublicKey)
+	require.NoError(t, err)
+
+	_, err = EnvelopeVersionOf(encrypted)
+	assert.ErrorIs(t, err, ErrNotEnvelope)
+
+	decrypted, err := DecryptPayload(encrypted, privateKey)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("legacy payload"), decrypted)
+}
@@ -13,6 +13,19 @@ func CreateHash(data []byte, key string) string {
 	return hex.EncodeToString(mac.Sum(nil))
 }
 
+// RequestHashData возвращает данные, подписываемые для запроса, который
+// удаляет или сбрасывает метрики: метод, путь с параметрами запроса и тело.
+// Подпись таких запросов привязана к цели, поэтому ее нельзя повторно
+// использовать для другой метрики.
+func RequestHashData(method, uri string, body []byte) []byte {
+	data := make([]byte, 0, len(method)+len(uri)+len(body)+2)
+	data = append(data, method...)
+	data = append(data, ' ')
+	data = append(data, uri...)
+	data = append(data, '\n')
+	return append(data, body...)
+}
+
 func ValidateHash(data []byte, key, headerHash string) error {
 	sig, err := hex.DecodeString(headerHash)
 	if err != nil {
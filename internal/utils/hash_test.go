@@ -73,3 +73,8 @@ func TestValidateHashInvalidHex(t *testing.T) {
 	err := ValidateHash(data, key, "not-a-hex-string")
 	assert.Error(t, err)
 }
+
+func TestRequestHashData(t *testing.T) {
+	assert.Equal(t, []byte("DELETE /value/gauge/cpu?host=web-1\n"), RequestHashData("DELETE", "/value/gauge/cpu?host=web-1", nil))
+	assert.Equal(t, []byte("POST /reset/\n{}"), RequestHashData("POST", "/reset/", []byte("{}")))
+}